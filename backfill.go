@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/backfill"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Parses a command line timestamp, which can be either RFC3339 or a date.
+// Dates are interpreted as midnight local time.
+func parseTimeFlag(name string, value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t
+	}
+
+	t, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		log.Fatalf("Invalid --%s %q: expected a date (YYYY-MM-DD) or RFC3339 timestamp", name, value)
+	}
+	return t
+}
+
+// Runs the backfill command, which fetches historic telemetry into the DB.
+//
+//	backfill --from 2025-01-01 [--to 2025-01-08] [--fuel gas] [--export] [--meter DEVICE_ID] [--grouping TEN_SECONDS] [--settled]
+func runBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fromFlag := flags.String("from", "", "start of the period to backfill (YYYY-MM-DD or RFC3339)")
+	toFlag := flags.String("to", "", "end of the period to backfill (YYYY-MM-DD or RFC3339); defaults to now")
+	fuelFlag := flags.String("fuel", string(octopus.FuelElectricity), "which meter to backfill: electricity or gas")
+	exportFlag := flags.Bool("export", false, "backfill the electricity export meter")
+	meterFlag := flags.String("meter", "", "device ID of the meter to backfill; defaults to every meter of the chosen fuel and direction")
+	groupingFlag := flags.String("grouping", "", "telemetry grouping, e.g. TEN_SECONDS or ONE_MINUTE; defaults to the finest available for the meter")
+	settledFlag := flags.Bool("settled", false, "fetch the settled half-hourly consumption used for billing, instead of telemetry")
+	flags.Parse(args)
+
+	if *fromFlag == "" {
+		log.Fatalln("backfill: --from is required")
+	}
+	from := parseTimeFlag("from", *fromFlag)
+
+	to := time.Now()
+	if *toFlag != "" {
+		to = parseTimeFlag("to", *toFlag)
+	}
+
+	if !from.Before(to) {
+		log.Fatalln("backfill: --from must be before --to")
+	}
+
+	fuel, err := octopus.ParseFuel(*fuelFlag)
+	if err != nil {
+		log.Fatalln("backfill:", err)
+	}
+
+	meter := octopus.Meter{Fuel: fuel, Direction: octopus.DirectionImport}
+	if *exportFlag {
+		meter.Direction = octopus.DirectionExport
+	}
+
+	grouping := backfill.DefaultGrouping(meter)
+	if *groupingFlag != "" {
+		grouping, err = octopus.ParseTelemetryGrouping(*groupingFlag)
+		if err != nil {
+			log.Fatalln("backfill:", err)
+		}
+	}
+
+	s := store.NewStore()
+	defer s.Close()
+
+	octo := newOctopus(s)
+
+	// Stop cleanly on Ctrl-C, keeping what has been stored so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	devices, err := octo.Devices(ctx)
+	if err != nil {
+		log.Fatalln("backfill:", err)
+	}
+
+	found := false
+	total := 0
+	for _, device := range devices {
+		if device.Meter != meter || (*meterFlag != "" && device.Id != *meterFlag) {
+			continue
+		}
+		found = true
+
+		var count int
+		if *settledFlag {
+			count, err = backfill.BackfillSettled(ctx, octo, s, device, from, to)
+		} else {
+			count, err = backfill.Backfill(ctx, octo, s, device, from, to, grouping)
+		}
+		total += count
+		if err != nil {
+			log.Fatalf("backfill: stored %v %v before failing: %v", total, backfillUnit(*settledFlag), err)
+		}
+	}
+
+	if !found {
+		log.Fatalf("backfill: no %v meter found", meter)
+	}
+
+	log.Printf("Backfill complete: fetched %v %v", total, backfillUnit(*settledFlag))
+}
+
+// Describes what a backfill fetches, for log messages.
+func backfillUnit(settled bool) string {
+	if settled {
+		return "settled intervals"
+	}
+	return "readings"
+}
@@ -3,11 +3,15 @@ module martin-walls/octopus-energy-tracker
 go 1.23.4
 
 require (
-	github.com/coder/websocket v1.8.12 // indirect
-	github.com/golang-migrate/migrate/v4 v4.18.3 // indirect
+	github.com/coder/websocket v1.8.12
+	github.com/golang-migrate/migrate/v4 v4.18.3
+	github.com/mattn/go-sqlite3 v1.14.28
+	golang.org/x/crypto v0.36.0
+)
+
+require (
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
 	github.com/yuin/goldmark v1.4.13 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	golang.org/x/mod v0.24.0 // indirect
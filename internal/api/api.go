@@ -0,0 +1,109 @@
+// This package provides the JSON HTTP API used by the web dashboard.
+//
+// Response types in this package are converted to TypeScript with tygo, so
+// they should only use types that tygo can map.
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"net/http"
+	"time"
+)
+
+// An error response body.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Serves the JSON API, backed by a [store.Store].
+type Handler struct {
+	store *store.Store
+	mux   *http.ServeMux
+	// The export rate, in pence per kWh.
+	exportRate float64
+	// The smart meters being tracked.
+	devices []octopus.Device
+	// How the stored tariffs are applied when costing energy.
+	tariffOptions []cost.Option
+	// Returns the current time.
+	clock func() time.Time
+}
+
+// Configures a [Handler] created with [NewHandler].
+type Option func(*Handler)
+
+// Values exported energy at the given rate, in pence per kWh.
+func WithExportRate(exportRate float64) Option {
+	return func(h *Handler) {
+		h.exportRate = exportRate
+	}
+}
+
+// Lists the given smart meters on /api/meters.
+func WithDevices(devices []octopus.Device) Option {
+	return func(h *Handler) {
+		h.devices = devices
+	}
+}
+
+// Applies the given options to the stored tariffs when costing energy on
+// /api/cost and planning on /api/plan.
+func WithTariffOptions(opts ...cost.Option) Option {
+	return func(h *Handler) {
+		h.tariffOptions = opts
+	}
+}
+
+// Creates a new [Handler] serving data from s. The handler expects to be
+// mounted at the root of the server; all routes are under /api/.
+func NewHandler(s *store.Store, opts ...Option) *Handler {
+	h := &Handler{
+		store: s,
+		mux:   http.NewServeMux(),
+		clock: time.Now,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.mux.HandleFunc("GET /api/readings", h.handleReadings)
+	h.mux.HandleFunc("GET /api/flow", h.handleFlow)
+	h.mux.HandleFunc("GET /api/meters", h.handleMeters)
+	h.mux.HandleFunc("GET /api/cost", h.handleCost)
+	h.mux.HandleFunc("GET /api/plan", h.handlePlan)
+	h.mux.HandleFunc("GET /api/reconciliation", h.handleReconciliation)
+	h.mux.HandleFunc("GET /api/billing", h.handleBilling)
+	h.mux.HandleFunc("GET /api/dispatches", h.handleDispatches)
+	h.mux.HandleFunc("GET /api/events", h.handleEvents)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Writes v to the response as JSON.
+func writeJson(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to JSON encode API response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Writes an [ErrorResponse] with the given status code.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, ErrorResponse{
+		Error: err.Error(),
+	})
+}
@@ -0,0 +1,539 @@
+package api
+
+import (
+	"encoding/json"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/flow"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"martin-walls/octopus-energy-tracker/internal/store/storetest"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Creates a [Handler] backed by a fresh store containing one reading every
+// 10 seconds for an hour from testStart.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	s := storetest.Open(t)
+	storetest.AddReadings(t, s, "", testStart, time.Hour-storetest.ReadingInterval, 1)
+
+	return NewHandler(s)
+}
+
+// Sends a GET request to the handler and decodes the JSON response into v.
+func get(t *testing.T, h http.Handler, url string, v any) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("Failed to decode response %q: %v", w.Body.String(), err)
+	}
+
+	return w.Code
+}
+
+func TestReadingsRaw(t *testing.T) {
+	h := newTestHandler(t)
+
+	var response ReadingsResponse
+	status := get(t, h, "/api/readings?from=2025-01-01T00:00:00Z&to=2025-01-01T00:01:00Z&limit=4", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if len(response.Readings) != 4 {
+		t.Fatalf("Expected 4 readings, got %v", len(response.Readings))
+	}
+	if response.Readings[0].MinDemand != nil {
+		t.Errorf("Expected raw readings to have no demand range")
+	}
+	if response.Next == nil || !response.Next.Equal(testStart.Add(30*time.Second)) {
+		t.Errorf("Next = %v, want %v", response.Next, testStart.Add(30*time.Second))
+	}
+}
+
+func TestReadingsDefaultWindow(t *testing.T) {
+	h := newTestHandler(t)
+	h.clock = func() time.Time { return testStart.Add(time.Hour) }
+
+	var response ReadingsResponse
+	status := get(t, h, "/api/readings?resolution=30m", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if !response.To.Equal(testStart.Add(time.Hour)) || !response.From.Equal(testStart.Add(-2*time.Hour)) {
+		t.Errorf("Window = %v to %v, want the three hours to %v", response.From, response.To, testStart.Add(time.Hour))
+	}
+	if len(response.Readings) != 2 {
+		t.Errorf("Expected 2 buckets, got %v", len(response.Readings))
+	}
+}
+
+func TestReadingsBucketed(t *testing.T) {
+	h := newTestHandler(t)
+
+	var response ReadingsResponse
+	status := get(t, h, "/api/readings?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z&resolution=30m", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if len(response.Readings) != 2 {
+		t.Fatalf("Expected 2 buckets, got %v", len(response.Readings))
+	}
+
+	b := response.Readings[1]
+	if b.Consumption == nil || *b.Consumption != 180 {
+		t.Errorf("Consumption = %v, want 180", b.Consumption)
+	}
+	if b.Demand != 360 {
+		t.Errorf("Demand = %v, want 360", b.Demand)
+	}
+}
+
+func TestReadingsByFuel(t *testing.T) {
+	h := newTestHandler(t)
+
+	var response ReadingsResponse
+	status := get(t, h, "/api/readings?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z&fuel=gas", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if response.Meter != octopus.GasImport {
+		t.Errorf("Meter = %v, want %v", response.Meter, octopus.GasImport)
+	}
+	if len(response.Readings) != 0 {
+		t.Errorf("Expected no gas readings, got %v", len(response.Readings))
+	}
+}
+
+func TestReadingsByMeter(t *testing.T) {
+	h := newTestHandler(t)
+
+	// A second meter using 1Wh every 10 seconds for the first half hour
+	rs := []*octopus.ConsumptionReading{}
+	for i := range 180 {
+		rs = append(rs, &octopus.ConsumptionReading{
+			Timestamp:        testStart.Add(time.Duration(i) * 10 * time.Second),
+			MeterId:          "second",
+			TotalConsumption: i,
+			Demand:           360,
+		})
+	}
+	err := h.store.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	var response ReadingsResponse
+	get(t, h, "/api/readings?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z&resolution=30m", &response)
+	if len(response.Readings) != 2 || response.Readings[0].Demand != 720 {
+		t.Errorf("Summed readings = %+v, want 720W in the first bucket", response.Readings)
+	}
+
+	response = ReadingsResponse{}
+	get(t, h, "/api/readings?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z&meter=second", &response)
+	if response.MeterId != "second" || len(response.Readings) != 180 || response.Readings[0].MeterId != "second" {
+		t.Errorf("Expected 180 raw readings from the second meter, got %v", len(response.Readings))
+	}
+}
+
+func TestMeters(t *testing.T) {
+	h := newTestHandler(t)
+	h.devices = []octopus.Device{{Id: "device", AccountNumber: "A-12345678", Meter: octopus.ElectricityImport}}
+
+	var response MetersResponse
+	status := get(t, h, "/api/meters", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if len(response.Meters) != 1 || response.Meters[0].Id != "device" {
+		t.Errorf("Meters = %+v, want the one device", response.Meters)
+	}
+}
+
+func TestFlow(t *testing.T) {
+	h := newTestHandler(t)
+	h.exportRate = 15
+
+	// Export 2Wh every 10 seconds for the first half hour
+	rs := []*octopus.ConsumptionReading{}
+	for i := range 180 {
+		rs = append(rs, &octopus.ConsumptionReading{
+			Timestamp:        testStart.Add(time.Duration(i) * 10 * time.Second),
+			Direction:        octopus.DirectionExport,
+			TotalConsumption: 2 * i,
+			Demand:           720,
+		})
+	}
+	err := h.store.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	var response FlowResponse
+	status := get(t, h, "/api/flow?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if response.Resolution != "30m" || len(response.Buckets) != 2 {
+		t.Fatalf("Expected 2 half-hour buckets, got %+v", response)
+	}
+
+	b := response.Buckets[0]
+	if b.Import != 179 || b.Export != 358 || b.Net != -179 {
+		t.Errorf("First bucket = %+v, want 179Wh imported and 358Wh exported", b)
+	}
+	if response.Total.ExportEarnings != flow.ExportEarnings(358, 15) {
+		t.Errorf("Total export earnings = %v", response.Total.ExportEarnings)
+	}
+}
+
+func TestCost(t *testing.T) {
+	h := newTestHandler(t)
+	h.devices = []octopus.Device{{Meter: octopus.ElectricityImport, MeterPoint: "1000000000001"}}
+	h.tariffOptions = []cost.Option{cost.WithLocation(time.UTC)}
+
+	err := h.store.SaveAgreements([]*octopus.Agreement{{
+		Id:                   1,
+		MeterPoint:           "1000000000001",
+		ValidFrom:            testStart,
+		StandingCharge:       48,
+		PreVatStandingCharge: 48,
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandStandard, ValidFrom: testStart, Value: 20, PreVatValue: 20},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("SaveAgreements: %v", err)
+	}
+
+	var response CostResponse
+	status := get(t, h, "/api/cost?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if response.Resolution != "30m" || len(response.Periods) != 2 {
+		t.Fatalf("Expected 2 half-hour periods, got %+v", response)
+	}
+
+	// 359Wh at 20p/kWh, plus an hour of standing charge
+	total := response.Total
+	if total.Consumption != 359 || math.Abs(total.Total-(0.359*20+2)) > 1e-9 {
+		t.Errorf("Total = %+v, want 359Wh costing %vp", total, 0.359*20+2)
+	}
+
+	status = get(t, h, "/api/cost?resolution=raw", &ErrorResponse{})
+	if status != http.StatusBadRequest {
+		t.Errorf("Status = %v, want %v for raw resolution", status, http.StatusBadRequest)
+	}
+
+	status = get(t, h, "/api/cost?from=2000-01-01T00:00:00Z&resolution=1m", &ErrorResponse{})
+	if status != http.StatusBadRequest {
+		t.Errorf("Status = %v, want %v for too many periods", status, http.StatusBadRequest)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	h := newTestHandler(t)
+	h.devices = []octopus.Device{{Id: "import", Meter: octopus.ElectricityImport, MeterPoint: "1000000000001"}}
+	h.clock = func() time.Time { return testStart }
+
+	// Agile rates for the next two hours, cheapest in the second hour
+	rates := []octopus.Rate{}
+	for i, value := range []float64{30, 25, 10, 12} {
+		from := testStart.Add(time.Duration(i) * 30 * time.Minute)
+		to := from.Add(30 * time.Minute)
+		rates = append(rates, octopus.Rate{Band: octopus.BandStandard, ValidFrom: from, ValidTo: &to, Value: value})
+	}
+	err := h.store.SaveAgreements([]*octopus.Agreement{{
+		Id:         1,
+		MeterPoint: "1000000000001",
+		ValidFrom:  testStart,
+		UnitRates:  rates,
+	}})
+	if err != nil {
+		t.Fatalf("SaveAgreements: %v", err)
+	}
+
+	var response PlanResponse
+	status := get(t, h, "/api/plan?hours=1&power=2000", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if len(response.Slots) != 4 {
+		t.Errorf("Expected 4 slots, got %v", len(response.Slots))
+	}
+	cheapest := response.Plan.Cheapest
+	if !cheapest.Start.Equal(testStart.Add(time.Hour)) || math.Abs(cheapest.Cost-22) > 1e-9 {
+		t.Errorf("Cheapest = %+v, want 2kWh from %v costing 22p", cheapest, testStart.Add(time.Hour))
+	}
+	if response.Plan.Now == nil || math.Abs(response.Plan.Saving-33) > 1e-9 {
+		t.Errorf("Plan = %+v, want a saving of 33p on running now", response.Plan)
+	}
+
+	// Too long for the known rates
+	status = get(t, h, "/api/plan?hours=3", &ErrorResponse{})
+	if status != http.StatusNotFound {
+		t.Errorf("Status = %v, want %v", status, http.StatusNotFound)
+	}
+
+	status = get(t, h, "/api/plan?profile=kettle", &ErrorResponse{})
+	if status != http.StatusBadRequest {
+		t.Errorf("Status = %v, want %v for an unknown profile", status, http.StatusBadRequest)
+	}
+
+	for _, query := range []string{
+		"hours=1&before=" + testStart.Format(time.RFC3339),
+		"hours=1&before=" + testStart.Add(planHorizon+time.Second).Format(time.RFC3339),
+		"hours=49",
+		"hours=1e300",
+		"hours=NaN",
+	} {
+		status = get(t, h, "/api/plan?"+query, &ErrorResponse{})
+		if status != http.StatusBadRequest {
+			t.Errorf("Status = %v, want %v for %v", status, http.StatusBadRequest, query)
+		}
+	}
+}
+
+func TestReconciliation(t *testing.T) {
+	h := newTestHandler(t)
+	h.devices = []octopus.Device{{Id: "import", Meter: octopus.ElectricityImport, MeterPoint: "1000000000001"}}
+	h.clock = func() time.Time { return testStart.Add(72 * time.Hour) }
+
+	// One matching half hour at midday on the first day, and one flagged
+	// half hour at midday on the second
+	noon := testStart.Add(12 * time.Hour)
+	err := h.store.SaveReconciliation([]*store.ReconciledSlot{
+		{MeterId: "import", Start: noon, End: noon.Add(30 * time.Minute), Readings: 180, Telemetry: 1000, Settled: 1000},
+		{MeterId: "import", Start: noon.Add(24 * time.Hour), End: noon.Add(24*time.Hour + 30*time.Minute), Settled: 1000, Difference: -1000, Drift: -100, Flagged: true},
+	})
+	if err != nil {
+		t.Fatalf("SaveReconciliation: %v", err)
+	}
+
+	var response ReconciliationResponse
+	status := get(t, h, "/api/reconciliation", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if response.MeterId != "import" || len(response.Days) != 2 || len(response.Slots) != 1 {
+		t.Fatalf("Response = %+v, want 2 days and 1 flagged slot of meter import", response)
+	}
+	if response.Days[0].Flagged || !response.Days[1].Flagged || response.Days[1].MissingSlots != 1 {
+		t.Errorf("Days = %+v, want only the second day flagged", response.Days)
+	}
+
+	response = ReconciliationResponse{}
+	get(t, h, "/api/reconciliation?flagged=true", &response)
+	if len(response.Days) != 1 || !response.Days[0].Flagged {
+		t.Errorf("Days = %+v, want only the flagged day", response.Days)
+	}
+
+	status = get(t, h, "/api/reconciliation?meter=unknown", &ErrorResponse{})
+	if status != http.StatusNotFound {
+		t.Errorf("Status = %v, want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestBilling(t *testing.T) {
+	h := newTestHandler(t)
+	h.devices = []octopus.Device{{Id: "import", AccountNumber: "A-12345678", Meter: octopus.ElectricityImport, MeterPoint: "1000000000001"}}
+	h.clock = func() time.Time { return testStart.Add(time.Hour) }
+
+	var response BillingResponse
+	status := get(t, h, "/api/billing", &response)
+
+	// Nothing to project from yet
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if response.AccountNumber != "A-12345678" || response.Projection != nil || len(response.Bills) != 0 {
+		t.Errorf("Response = %+v, want no bills or projection for A-12345678", response)
+	}
+
+	closingBalance := 2000
+	err := h.store.SaveBilling(&octopus.Billing{
+		AccountNumber: "A-12345678",
+		Balance:       2000,
+		Bills: []*octopus.Bill{{
+			Id:             "1",
+			Type:           octopus.BillStatement,
+			From:           testStart.AddDate(0, -1, 0),
+			To:             testStart,
+			ClosingBalance: &closingBalance,
+			Charges:        3100,
+		}},
+		Payments: []*octopus.Payment{},
+	}, testStart)
+	if err != nil {
+		t.Fatalf("SaveBilling: %v", err)
+	}
+
+	response = BillingResponse{}
+	get(t, h, "/api/billing?account=A-12345678", &response)
+
+	if len(response.Trend) != 2 || len(response.Bills) != 1 {
+		t.Errorf("Response = %+v, want the statement and recorded balance in the trend", response)
+	}
+	if response.Projection == nil || !response.Projection.NextBill.Equal(testStart.AddDate(0, 1, 0)) {
+		t.Errorf("Projection = %+v, want the next bill in a month", response.Projection)
+	}
+
+	status = get(t, h, "/api/billing?account=A-87654321", &ErrorResponse{})
+	if status != http.StatusNotFound {
+		t.Errorf("Status = %v, want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestDispatches(t *testing.T) {
+	h := newTestHandler(t)
+	h.devices = []octopus.Device{{AccountNumber: "A-12345678", Meter: octopus.ElectricityImport, MeterPoint: "1000000000001"}}
+	h.clock = func() time.Time { return testStart }
+	h.tariffOptions = []cost.Option{cost.WithLocation(time.UTC)}
+
+	err := h.store.SaveAgreements([]*octopus.Agreement{{
+		Id:            1,
+		AccountNumber: "A-12345678",
+		Fuel:          octopus.FuelElectricity,
+		MeterPoint:    "1000000000001",
+		ValidFrom:     testStart.Add(-24 * time.Hour),
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandDay, ValidFrom: testStart.Add(-24 * time.Hour), Value: 28},
+			{Band: octopus.BandNight, ValidFrom: testStart.Add(-24 * time.Hour), Value: 7},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("SaveAgreements: %v", err)
+	}
+
+	// A dispatch in the first half hour of readings, which is otherwise at
+	// the day rate
+	dispatch := &octopus.Dispatch{
+		AccountNumber: "A-12345678",
+		Start:         testStart,
+		End:           testStart.Add(15 * time.Minute),
+		Energy:        7000,
+		Source:        "smart-charge",
+		Completed:     true,
+	}
+	err = h.store.SaveDispatches([]*octopus.Dispatch{dispatch})
+	if err != nil {
+		t.Fatalf("SaveDispatches: %v", err)
+	}
+
+	var response DispatchesResponse
+	status := get(t, h, "/api/dispatches", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if len(response.Dispatches) != 1 || response.Dispatches[0].Energy != 7000 {
+		t.Errorf("Dispatches = %+v, want the stored dispatch", response.Dispatches)
+	}
+
+	response = DispatchesResponse{}
+	get(t, h, "/api/dispatches?account=A-87654321", &response)
+	if len(response.Dispatches) != 0 {
+		t.Errorf("Dispatches = %+v, want none for another account", response.Dispatches)
+	}
+
+	// The whole hour is billed at the night rate
+	var costResponse CostResponse
+	get(t, h, "/api/cost?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z", &costResponse)
+	if math.Abs(costResponse.Total.Energy-0.359*7) > 1e-9 {
+		t.Errorf("Total = %+v, want 359Wh costing %vp", costResponse.Total, 0.359*7)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	h := newTestHandler(t)
+	h.devices = []octopus.Device{{AccountNumber: "A-12345678", Meter: octopus.ElectricityImport, MeterPoint: "1000000000001"}}
+	h.clock = func() time.Time { return testStart }
+
+	past := &octopus.FlexibilityEvent{
+		AccountNumber: "A-12345678",
+		Type:          octopus.EventSavingSession,
+		Code:          "EVENT_1",
+		Start:         testStart.Add(-7 * time.Hour),
+		End:           testStart.Add(-6 * time.Hour),
+		RewardPerKwh:  1800,
+		Joined:        true,
+	}
+	upcoming := &octopus.FlexibilityEvent{
+		AccountNumber: "A-12345678",
+		Type:          octopus.EventFreeElectricity,
+		Code:          "FREE_1",
+		Start:         testStart.Add(13 * time.Hour),
+		End:           testStart.Add(14 * time.Hour),
+		Joined:        true,
+	}
+	err := h.store.SaveFlexibilityEvents([]*octopus.FlexibilityEvent{past, upcoming})
+	if err != nil {
+		t.Fatalf("SaveFlexibilityEvents: %v", err)
+	}
+	err = h.store.SaveEventResult(past, &store.EventResult{
+		EvaluatedAt: testStart.Add(-5 * time.Hour),
+		Baseline:    1500,
+		Actual:      1000,
+		Saved:       500,
+		Points:      900,
+		Savings:     112.5,
+	})
+	if err != nil {
+		t.Fatalf("SaveEventResult: %v", err)
+	}
+
+	var response EventsResponse
+	status := get(t, h, "/api/events", &response)
+
+	if status != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", status, http.StatusOK)
+	}
+	if response.AccountNumber != "A-12345678" || len(response.History.Events) != 2 {
+		t.Fatalf("Response = %+v, want both events of the account", response)
+	}
+	if !response.History.Events[0].Evaluated || response.History.Events[1].Evaluated {
+		t.Errorf("Events = %+v, want only the past event evaluated", response.History.Events)
+	}
+	if response.History.Points != 900 || response.History.Savings != 112.5 {
+		t.Errorf("History = %v points and %vp, want 900 points and 112.5p", response.History.Points, response.History.Savings)
+	}
+
+	status = get(t, h, "/api/events?account=A-87654321", &map[string]any{})
+	if status != http.StatusNotFound {
+		t.Errorf("Status = %v, want %v for another account", status, http.StatusNotFound)
+	}
+}
+
+func TestReadingsBadRequest(t *testing.T) {
+	h := newTestHandler(t)
+
+	var response ErrorResponse
+	status := get(t, h, "/api/readings?resolution=fortnightly", &response)
+
+	if status != http.StatusBadRequest {
+		t.Errorf("Status = %v, want %v", status, http.StatusBadRequest)
+	}
+	if response.Error == "" {
+		t.Errorf("Expected an error message")
+	}
+}
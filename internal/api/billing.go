@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/billing"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"net/http"
+	"time"
+)
+
+// How far back the balance trend is reported by default.
+const defaultBalanceWindow = 365 * 24 * time.Hour
+
+// The response body of GET /api/billing.
+type BillingResponse struct {
+	AccountNumber string `json:"accountNumber"`
+	// The start of the requested window.
+	From time.Time `json:"from"`
+	// The end of the requested window.
+	To time.Time `json:"to"`
+	// The balance over the window, oldest first.
+	Trend []*billing.BalancePoint `json:"trend"`
+	// The stored bills, newest first.
+	Bills []*octopus.Bill `json:"bills"`
+	// The stored payments, newest first.
+	Payments []*octopus.Payment `json:"payments"`
+	// The projected balance at the next bill. Nil if no balance or
+	// statement has been recorded yet.
+	Projection *billing.Projection `json:"projection"`
+}
+
+// Handles GET /api/billing?account=&from=&to=
+//
+// Returns the balance trend, bills and payments of an account, with its
+// projected balance at the next bill. account defaults to the account of
+// the first tracked meter. from and to are RFC3339 timestamps bounding the
+// trend, defaulting to the last year.
+func (h *Handler) handleBilling(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	var err error
+	to := h.clock()
+	if t := params.Get("to"); t != "" {
+		to, err = time.Parse(time.RFC3339, t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'to' parameter: %v", err))
+			return
+		}
+	}
+
+	from := to.Add(-defaultBalanceWindow)
+	if f := params.Get("from"); f != "" {
+		from, err = time.Parse(time.RFC3339, f)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'from' parameter: %v", err))
+			return
+		}
+	}
+
+	accountNumber, ok := h.billedAccount(params.Get("account"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No account %q", params.Get("account")))
+		return
+	}
+
+	trend, err := billing.Trend(h.store, accountNumber, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	bills, err := h.store.Bills(accountNumber)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	payments, err := h.store.Payments(accountNumber)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tariffs, err := cost.StoredTariffs(h.store, h.tariffOptions...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	projection, err := billing.Project(h.store, tariffs, h.devices, accountNumber, h.clock())
+	if err != nil && !errors.Is(err, billing.ErrNoHistory) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, BillingResponse{
+		AccountNumber: accountNumber,
+		From:          from,
+		To:            to,
+		Trend:         trend,
+		Bills:         bills,
+		Payments:      payments,
+		Projection:    projection,
+	})
+}
+
+// Returns the given account number if a tracked meter is on it, or the
+// account of the first tracked meter if it is empty.
+func (h *Handler) billedAccount(accountNumber string) (string, bool) {
+	for _, device := range h.devices {
+		if accountNumber == "" || device.AccountNumber == accountNumber {
+			return device.AccountNumber, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"net/http"
+	"time"
+)
+
+// The most periods that one request can cost: a year of half hours. Each
+// period is costed separately, so this bounds the work of a request.
+const maxCostPeriods = 366 * 48
+
+// The response body of GET /api/cost.
+type CostResponse struct {
+	// The fuel that was costed.
+	Fuel octopus.Fuel `json:"fuel"`
+	// The device ID of the meter that was costed, if one was asked for.
+	// Otherwise the cost is of every import meter of the fuel.
+	MeterId string `json:"meterId,omitempty"`
+	// The start of the requested window.
+	From time.Time `json:"from"`
+	// The end of the requested window.
+	To time.Time `json:"to"`
+	// The size of each period, e.g. "30m".
+	Resolution string `json:"resolution"`
+	// The cost in each period, oldest first.
+	Periods []*cost.Period `json:"periods"`
+	// The sum of all the periods.
+	Total cost.Cost `json:"total"`
+}
+
+// Handles GET /api/cost?fuel=&meter=&from=&to=&resolution=
+//
+// Returns the cost of the energy imported in each period, from the stored
+// tariffs, with standing charges apportioned to the periods. The parameters
+// are the same as for /api/readings, except that direction is ignored since
+// only imported energy costs money; the resolution defaults to 30m and can't
+// be raw. The window can cover at most a year of half hours.
+func (h *Handler) handleCost(w http.ResponseWriter, r *http.Request) {
+	q, err := parseReadingsQuery(r, h.clock())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if r.URL.Query().Get("resolution") == "" {
+		q.Resolution = store.ResolutionHalfHour
+	}
+
+	if q.Resolution == store.ResolutionRaw {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Cost can't be calculated at %q resolution", q.Resolution))
+		return
+	}
+
+	if q.To.Sub(q.From)/q.Resolution.Duration() > maxCostPeriods {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Window is too long: at most %v periods can be costed", maxCostPeriods))
+		return
+	}
+
+	tariffs, err := cost.StoredTariffs(h.store, h.tariffOptions...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	periods, err := cost.Periods(h.store, tariffs, h.devices, q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, CostResponse{
+		Fuel:       q.Meter.OrDefault().Fuel,
+		MeterId:    q.MeterId,
+		From:       q.From,
+		To:         q.To,
+		Resolution: string(q.Resolution),
+		Periods:    periods,
+		Total:      cost.Total(periods),
+	})
+}
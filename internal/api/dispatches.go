@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"net/http"
+	"time"
+)
+
+// How far either side of now dispatches are returned by default, to cover
+// recent history and the dispatches planned for the coming night.
+const defaultDispatchWindow = 24 * time.Hour
+
+// The response body of GET /api/dispatches.
+type DispatchesResponse struct {
+	// The account whose dispatches were asked for. Otherwise the dispatches
+	// are of every account.
+	AccountNumber string `json:"accountNumber,omitempty"`
+	// The start of the requested window.
+	From time.Time `json:"from"`
+	// The end of the requested window.
+	To time.Time `json:"to"`
+	// The dispatches overlapping the window, oldest first.
+	Dispatches []*octopus.Dispatch `json:"dispatches"`
+}
+
+// Handles GET /api/dispatches?account=&from=&to=
+//
+// Returns the stored Intelligent Octopus dispatches, planned and completed,
+// that overlap the window, for overlaying on the demand history. from and
+// to are RFC3339 timestamps, defaulting to the day either side of now.
+func (h *Handler) handleDispatches(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	var err error
+	now := h.clock()
+
+	from := now.Add(-defaultDispatchWindow)
+	if f := params.Get("from"); f != "" {
+		from, err = time.Parse(time.RFC3339, f)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'from' parameter: %v", err))
+			return
+		}
+	}
+
+	to := now.Add(defaultDispatchWindow)
+	if t := params.Get("to"); t != "" {
+		to, err = time.Parse(time.RFC3339, t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'to' parameter: %v", err))
+			return
+		}
+	}
+
+	accountNumber := params.Get("account")
+	dispatches, err := h.store.Dispatches(accountNumber, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, DispatchesResponse{
+		AccountNumber: accountNumber,
+		From:          from,
+		To:            to,
+		Dispatches:    dispatches,
+	})
+}
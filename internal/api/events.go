@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/flexibility"
+	"net/http"
+	"time"
+)
+
+// How far back flexibility events are returned by default.
+const defaultEventHistory = 365 * 24 * time.Hour
+
+// How far ahead flexibility events are returned by default, to include the
+// upcoming ones. Saving Sessions are announced a day or so ahead.
+const defaultEventLookahead = 7 * 24 * time.Hour
+
+// The response body of GET /api/events.
+type EventsResponse struct {
+	AccountNumber string `json:"accountNumber"`
+	// The start of the requested window.
+	From time.Time `json:"from"`
+	// The end of the requested window.
+	To time.Time `json:"to"`
+	// The events overlapping the window, with what they earned.
+	History *flexibility.History `json:"history"`
+}
+
+// Handles GET /api/events?account=&from=&to=
+//
+// Returns the Saving Sessions and free electricity sessions of an account,
+// past and upcoming, with the consumption recorded during each against its
+// baseline and the points and savings earned. account defaults to the
+// account of the first tracked meter. from and to are RFC3339 timestamps,
+// defaulting to the last year and the coming week.
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	var err error
+	now := h.clock()
+
+	from := now.Add(-defaultEventHistory)
+	if f := params.Get("from"); f != "" {
+		from, err = time.Parse(time.RFC3339, f)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'from' parameter: %v", err))
+			return
+		}
+	}
+
+	to := now.Add(defaultEventLookahead)
+	if t := params.Get("to"); t != "" {
+		to, err = time.Parse(time.RFC3339, t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'to' parameter: %v", err))
+			return
+		}
+	}
+
+	accountNumber, ok := h.billedAccount(params.Get("account"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No account %q", params.Get("account")))
+		return
+	}
+
+	history, err := flexibility.LoadHistory(h.store, accountNumber, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, EventsResponse{
+		AccountNumber: accountNumber,
+		From:          from,
+		To:            to,
+		History:       history,
+	})
+}
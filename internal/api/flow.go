@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/flow"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"net/http"
+	"time"
+)
+
+// The response body of GET /api/flow.
+type FlowResponse struct {
+	// The start of the requested window.
+	From time.Time `json:"from"`
+	// The end of the requested window.
+	To time.Time `json:"to"`
+	// The size of each bucket, e.g. "30m".
+	Resolution string `json:"resolution"`
+	// The export rate used to value exported energy, in pence per kWh.
+	ExportRate float64 `json:"exportRate"`
+	// The flow in each bucket, oldest first. Buckets with no readings from
+	// either meter are omitted.
+	Buckets []*flow.Bucket `json:"buckets"`
+	// The sum of all the buckets.
+	Total flow.Bucket `json:"total"`
+}
+
+// Handles GET /api/flow?from=&to=&resolution=
+//
+// Returns the energy imported from and exported to the grid in each bucket,
+// with the net flow and export earnings, summed over all meters. The
+// window parameters are the same as for /api/readings; the resolution
+// defaults to 30m and can't be raw.
+func (h *Handler) handleFlow(w http.ResponseWriter, r *http.Request) {
+	q, err := parseReadingsQuery(r, h.clock())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if r.URL.Query().Get("resolution") == "" {
+		q.Resolution = store.ResolutionHalfHour
+	}
+	if q.Resolution == store.ResolutionRaw {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Flow can't be calculated at %q resolution", q.Resolution))
+		return
+	}
+
+	// Flow is always for every meter
+	q.MeterId = ""
+
+	q.Meter = octopus.ElectricityImport
+	imports, err := h.store.ReadingBuckets(q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	q.Meter = octopus.ElectricityExport
+	exports, err := h.store.ReadingBuckets(q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	buckets := flow.Buckets(imports, exports, h.exportRate)
+
+	writeJson(w, http.StatusOK, FlowResponse{
+		From:       q.From,
+		To:         q.To,
+		Resolution: string(q.Resolution),
+		ExportRate: h.exportRate,
+		Buckets:    buckets,
+		Total:      flow.Total(buckets),
+	})
+}
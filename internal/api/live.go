@@ -0,0 +1,31 @@
+package api
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/billing"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/flexibility"
+	"martin-walls/octopus-energy-tracker/internal/flow"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+)
+
+// The kinds of [LiveMessage].
+const (
+	LiveMessageReading = "reading"
+	LiveMessageNetFlow = "netFlow"
+	LiveMessageCost    = "cost"
+	// Sent when the projected balance of an account goes negative.
+	LiveMessageBalanceAlert = "balanceAlert"
+	// Sent with each reading during a joined flexibility event.
+	LiveMessageEvent = "event"
+)
+
+// A message sent to websocket clients on /ws. Type says which of the other
+// fields is set.
+type LiveMessage struct {
+	Type    string                      `json:"type"`
+	Reading *octopus.ConsumptionReading `json:"reading,omitempty"`
+	NetFlow *flow.NetFlow               `json:"netFlow,omitempty"`
+	Cost    *cost.LiveCost              `json:"cost,omitempty"`
+	Balance *billing.Projection         `json:"balance,omitempty"`
+	Event   *flexibility.Progress       `json:"event,omitempty"`
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"net/http"
+)
+
+// The response body of GET /api/meters.
+type MetersResponse struct {
+	// The smart meters being tracked, on every account.
+	Meters []octopus.Device `json:"meters"`
+}
+
+// Handles GET /api/meters
+//
+// Lists the smart meters being tracked. Their IDs can be passed as the
+// "meter" parameter of /api/readings to view one meter's readings.
+func (h *Handler) handleMeters(w http.ResponseWriter, r *http.Request) {
+	meters := h.devices
+	if meters == nil {
+		meters = []octopus.Device{}
+	}
+
+	writeJson(w, http.StatusOK, MetersResponse{
+		Meters: meters,
+	})
+}
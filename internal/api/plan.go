@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/planner"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How far ahead to look for unit rates when planning. Agile rates are
+// published at most a day and a half ahead.
+const planHorizon = 48 * time.Hour
+
+// The response body of GET /api/plan.
+type PlanResponse struct {
+	// The meter point whose unit rates were used.
+	MeterPoint string `json:"meterPoint"`
+	// The deadline that the run must end by.
+	Before time.Time `json:"before"`
+	// The known unit rates from now until the deadline.
+	Slots []planner.Slot `json:"slots"`
+	// When to run the load, and what it would cost.
+	Plan *planner.Plan `json:"plan"`
+}
+
+// Handles GET /api/plan?profile=&hours=&power=&before=&meter=
+//
+// Finds the cheapest time to run a load before a deadline, using the stored
+// unit rates, and compares it with running the load now. The load is either
+// a named profile (e.g. "washing-machine"), or a constant demand of power W
+// (default 1000) for the given number of hours, up to 48. before is an
+// RFC3339 timestamp within the next 48 hours, defaulting to 48 hours from
+// now. meter is the device ID of an electricity import meter, defaulting to
+// the first one.
+func (h *Handler) handlePlan(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	now := h.clock()
+
+	profile, err := parseProfile(params.Get("profile"), params.Get("hours"), params.Get("power"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	before := now.Add(planHorizon)
+	if b := params.Get("before"); b != "" {
+		before, err = time.Parse(time.RFC3339, b)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'before' parameter: %v", err))
+			return
+		}
+		if !before.After(now) || before.After(now.Add(planHorizon)) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'before' parameter: must be within the next %v", planHorizon))
+			return
+		}
+	}
+
+	device, ok := h.planDevice(params.Get("meter"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No electricity import meter %q", params.Get("meter")))
+		return
+	}
+
+	agreements, err := h.store.Agreements(device.MeterPoint)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tariffs := cost.NewTariffs(agreements, h.tariffOptions...)
+	slots := planner.Slots(tariffs, device.MeterPoint, now, before)
+
+	plan, err := planner.NewPlan(slots, profile, now, before)
+	if errors.Is(err, planner.ErrNoWindow) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, PlanResponse{
+		MeterPoint: device.MeterPoint,
+		Before:     before,
+		Slots:      slots,
+		Plan:       plan,
+	})
+}
+
+// Returns the electricity import meter with the given device ID, or the
+// first one if the ID is empty.
+func (h *Handler) planDevice(meterId string) (octopus.Device, bool) {
+	for _, device := range h.devices {
+		if device.Meter != octopus.ElectricityImport {
+			continue
+		}
+		if meterId == "" || device.Id == meterId {
+			return device, true
+		}
+	}
+	return octopus.Device{}, false
+}
+
+// Returns the load profile described by the profile, hours and power
+// parameters.
+func parseProfile(name string, hours string, power string) (planner.Profile, error) {
+	if name != "" {
+		profile, ok := planner.Profiles[name]
+		if !ok {
+			return planner.Profile{}, fmt.Errorf("Unknown profile %q", name)
+		}
+		return profile, nil
+	}
+
+	if hours == "" {
+		return planner.Profile{}, fmt.Errorf("Either 'profile' or 'hours' is required")
+	}
+	// Written so that NaN is rejected too
+	h, err := strconv.ParseFloat(hours, 64)
+	if err != nil || !(h > 0 && h <= planHorizon.Hours()) {
+		return planner.Profile{}, fmt.Errorf("Invalid 'hours' parameter: %q", hours)
+	}
+
+	watts := 1000
+	if power != "" {
+		watts, err = strconv.Atoi(power)
+		if err != nil || watts <= 0 {
+			return planner.Profile{}, fmt.Errorf("Invalid 'power' parameter: %q", power)
+		}
+	}
+
+	return planner.FlatProfile(watts, time.Duration(h*float64(time.Hour))), nil
+}
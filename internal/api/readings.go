@@ -0,0 +1,190 @@
+package api
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How far back to fetch readings if no start time is given.
+const defaultReadingsWindow = 3 * time.Hour
+
+// One point in a series of readings. For raw readings this has the same
+// shape as [octopus.ConsumptionReading]; aggregated readings also set the
+// demand range and consumption of the bucket.
+type ReadingPoint struct {
+	// The point in time of the reading, or the start of the bucket.
+	Timestamp time.Time `json:"timestamp"`
+	// The device ID of the meter that made the reading. Only set for raw
+	// readings; buckets are summed over the meters in the query.
+	MeterId string `json:"meterId,omitempty"`
+	// The total energy consumption of the meter, in Wh. For buckets, this
+	// is the total at the end of the bucket.
+	TotalConsumption int `json:"totalConsumption"`
+	// The demand at the given timestamp, in W. For buckets, this is the
+	// mean demand over the bucket.
+	Demand int `json:"demand"`
+	// The lowest demand in the bucket, in W. Only set for buckets.
+	MinDemand *int `json:"minDemand,omitempty"`
+	// The highest demand in the bucket, in W. Only set for buckets.
+	MaxDemand *int `json:"maxDemand,omitempty"`
+	// The energy consumed during the bucket, in Wh. Only set for buckets.
+	Consumption *int `json:"consumption,omitempty"`
+}
+
+// The response body of GET /api/readings.
+type ReadingsResponse struct {
+	// The kind of meter the readings are from.
+	Meter octopus.Meter `json:"meter"`
+	// The device ID of the meter the readings are from, if one was asked
+	// for. Otherwise the readings are from every meter of the kind above.
+	MeterId string `json:"meterId,omitempty"`
+	// The start of the requested window.
+	From time.Time `json:"from"`
+	// The end of the requested window.
+	To time.Time `json:"to"`
+	// The resolution of the readings, e.g. "raw" or "5m".
+	Resolution string `json:"resolution"`
+	// The readings, oldest first.
+	Readings []*ReadingPoint `json:"readings"`
+	// If there are more readings than the limit, pass this as the "after"
+	// parameter to fetch the next page.
+	Next *time.Time `json:"next,omitempty"`
+	// With Next, the meter ID of the last raw reading, to pass as the
+	// "afterMeter" parameter. Several meters can have readings at the
+	// same time.
+	NextMeterId string `json:"nextMeterId,omitempty"`
+}
+
+// Handles GET /api/readings?fuel=&direction=&meter=&from=&to=&resolution=&limit=&after=&afterMeter=
+//
+// fuel is "electricity" (the default) or "gas", and direction is "import"
+// (the default) or "export". meter is the device ID of one meter; without
+// it, raw readings from every meter are returned and buckets are summed
+// over the meters. from, to and after are RFC3339 timestamps. If to is not
+// given it defaults to now, and if from is not given it defaults to three
+// hours before to. after and afterMeter are the cursor of the next page.
+func (h *Handler) handleReadings(w http.ResponseWriter, r *http.Request) {
+	q, err := parseReadingsQuery(r, h.clock())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	points := []*ReadingPoint{}
+
+	if q.Resolution == store.ResolutionRaw {
+		readings, err := h.store.Readings(q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		for _, reading := range readings {
+			points = append(points, &ReadingPoint{
+				Timestamp:        reading.Timestamp,
+				MeterId:          reading.MeterId,
+				TotalConsumption: reading.TotalConsumption,
+				Demand:           reading.Demand,
+			})
+		}
+	} else {
+		buckets, err := h.store.ReadingBuckets(q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		for _, b := range buckets {
+			points = append(points, &ReadingPoint{
+				Timestamp:        b.Start,
+				TotalConsumption: b.TotalConsumption,
+				Demand:           int(b.AvgDemand + 0.5),
+				MinDemand:        &b.MinDemand,
+				MaxDemand:        &b.MaxDemand,
+				Consumption:      &b.Consumption,
+			})
+		}
+	}
+
+	response := ReadingsResponse{
+		Meter:      q.Meter,
+		MeterId:    q.MeterId,
+		From:       q.From,
+		To:         q.To,
+		Resolution: string(q.Resolution),
+		Readings:   points,
+	}
+
+	if q.Limit > 0 && len(points) == q.Limit {
+		last := points[len(points)-1]
+		response.Next = &last.Timestamp
+		response.NextMeterId = last.MeterId
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+// Builds a [store.ReadingsQuery] from the request's query parameters. The
+// window ends at now unless it is given.
+func parseReadingsQuery(r *http.Request, now time.Time) (store.ReadingsQuery, error) {
+	params := r.URL.Query()
+	q := store.ReadingsQuery{}
+
+	var err error
+
+	q.Meter.Fuel, err = octopus.ParseFuel(params.Get("fuel"))
+	if err != nil {
+		return q, err
+	}
+	q.Meter.Direction, err = octopus.ParseDirection(params.Get("direction"))
+	if err != nil {
+		return q, err
+	}
+	q.MeterId = params.Get("meter")
+
+	q.To = now
+	if to := params.Get("to"); to != "" {
+		q.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'to' parameter: %v", err)
+		}
+	}
+
+	q.From = q.To.Add(-defaultReadingsWindow)
+	if from := params.Get("from"); from != "" {
+		q.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'from' parameter: %v", err)
+		}
+	}
+
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("'from' must be before 'to'")
+	}
+
+	if after := params.Get("after"); after != "" {
+		q.After, err = time.Parse(time.RFC3339, after)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'after' parameter: %v", err)
+		}
+	}
+	q.AfterMeterId = params.Get("afterMeter")
+
+	if limit := params.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("Invalid 'limit' parameter: %q", limit)
+		}
+	}
+
+	q.Resolution, err = store.ParseResolution(params.Get("resolution"))
+	if err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/reconcile"
+	"net/http"
+	"time"
+)
+
+// How far back reconciliation is reported by default.
+const defaultReconciliationWindow = 30 * 24 * time.Hour
+
+// The response body of GET /api/reconciliation.
+type ReconciliationResponse struct {
+	// The device ID of the meter that was reconciled.
+	MeterId string `json:"meterId"`
+	// The start of the requested window.
+	From time.Time `json:"from"`
+	// The end of the requested window.
+	To time.Time `json:"to"`
+	// Each reconciled day in the window, oldest first, or only the flagged
+	// days if they were asked for.
+	Days []*reconcile.Day `json:"days"`
+	// The flagged half hours in the window, oldest first.
+	Slots []*reconcile.Slot `json:"slots"`
+}
+
+// Handles GET /api/reconciliation?meter=&from=&to=&flagged=
+//
+// Returns how the stored telemetry of a meter compared with its settled
+// consumption on each day, with the half hours that were flagged. meter is a
+// device ID, defaulting to the first electricity import meter. from and to
+// are RFC3339 timestamps, defaulting to the last 30 days. If flagged is
+// true, only flagged days are returned.
+func (h *Handler) handleReconciliation(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	var err error
+	to := h.clock()
+	if t := params.Get("to"); t != "" {
+		to, err = time.Parse(time.RFC3339, t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'to' parameter: %v", err))
+			return
+		}
+	}
+
+	from := to.Add(-defaultReconciliationWindow)
+	if f := params.Get("from"); f != "" {
+		from, err = time.Parse(time.RFC3339, f)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid 'from' parameter: %v", err))
+			return
+		}
+	}
+
+	device, ok := h.reconciledDevice(params.Get("meter"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No meter %q", params.Get("meter")))
+		return
+	}
+
+	slots, err := reconcile.Load(h.store, device.Id, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := ReconciliationResponse{
+		MeterId: device.Id,
+		From:    from,
+		To:      to,
+		Days:    []*reconcile.Day{},
+		Slots:   []*reconcile.Slot{},
+	}
+
+	onlyFlagged := params.Get("flagged") == "true"
+	for _, day := range reconcile.Days(slots, time.Local) {
+		if day.Flagged || !onlyFlagged {
+			response.Days = append(response.Days, day)
+		}
+	}
+	for _, slot := range slots {
+		if slot.Flagged {
+			response.Slots = append(response.Slots, slot)
+		}
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+// Returns the meter with the given device ID, or the first electricity
+// import meter if the ID is empty.
+func (h *Handler) reconciledDevice(meterId string) (octopus.Device, bool) {
+	if meterId == "" {
+		return h.planDevice("")
+	}
+
+	for _, device := range h.devices {
+		if device.Id == meterId {
+			return device, true
+		}
+	}
+	return octopus.Device{}, false
+}
@@ -0,0 +1,100 @@
+// This package fills the readings table with historic smart meter telemetry,
+// so that periods when the live poller wasn't running aren't left empty. It
+// also fetches the settled half-hourly consumption that bills are based on.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"time"
+)
+
+// The length of each window that is fetched and stored in one go. Progress
+// is saved after every window, so an interrupted backfill can be resumed.
+const windowDuration = 6 * time.Hour
+
+// How long to wait before retrying a transient failure if the client isn't
+// already backing off.
+const defaultRetryDelay = time.Minute
+
+// The number of times a window is retried after a transient failure.
+const maxRetries = 10
+
+// Returns the finest telemetry grouping available for the given meter. Gas
+// meters only report every half hour.
+func DefaultGrouping(meter octopus.Meter) octopus.TelemetryGrouping {
+	if meter.Fuel == octopus.FuelGas {
+		return octopus.GroupingThirtyMinutes
+	}
+	return octopus.GroupingTenSeconds
+}
+
+// Fetches telemetry for the given device between from and to and stores it
+// in s. Readings that are already stored are left unchanged. Stops early if
+// ctx is cancelled. Returns the number of readings fetched.
+func Backfill(ctx context.Context, octo *octopus.Octopus, s *store.Store, device octopus.Device, from, to time.Time, grouping octopus.TelemetryGrouping) (int, error) {
+	total := 0
+
+	for start := from; start.Before(to); start = start.Add(windowDuration) {
+		end := start.Add(windowDuration)
+		if end.After(to) {
+			end = to
+		}
+
+		readings, err := fetchWindow(ctx, octo, device, start, end, grouping)
+		if err != nil {
+			return total, fmt.Errorf("Backfill: %w", err)
+		}
+
+		err = s.InsertReadings(readings)
+		if err != nil {
+			return total, fmt.Errorf("Backfill: %w", err)
+		}
+
+		total += len(readings)
+		log.Printf("Backfilled %v %v readings from meter %v from %v to %v", len(readings), device.Meter, device.Id, start, end)
+	}
+
+	return total, nil
+}
+
+// Fetches the telemetry for one window, waiting and retrying after
+// transient failures such as rate limiting.
+func fetchWindow(ctx context.Context, octo *octopus.Octopus, device octopus.Device, start, end time.Time, grouping octopus.TelemetryGrouping) ([]*octopus.ConsumptionReading, error) {
+	return withRetries(ctx, octo, func() ([]*octopus.ConsumptionReading, error) {
+		return octo.Telemetry(ctx, device, start, end, grouping)
+	})
+}
+
+// Calls fetch until it succeeds, waiting and retrying after transient
+// failures such as rate limiting.
+func withRetries[T any](ctx context.Context, octo *octopus.Octopus, fetch func() (T, error)) (T, error) {
+	var zero T
+
+	for retries := 0; ; retries++ {
+		result, err := fetch()
+		if err == nil {
+			return result, nil
+		}
+
+		if !octopus.IsTemporary(err) || retries >= maxRetries {
+			return zero, err
+		}
+
+		delay := time.Until(octo.RetryAfter())
+		if delay <= 0 {
+			delay = defaultRetryDelay
+		}
+
+		log.Printf("Backfill request failed, retrying in %v: %v", delay.Round(time.Second), err)
+
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
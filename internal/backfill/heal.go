@@ -0,0 +1,122 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"time"
+)
+
+// How far back to look for gaps. Octopus only keeps high resolution
+// telemetry for a limited time, so older gaps can't be repaired anyway.
+const defaultHealLookback = 7 * 24 * time.Hour
+
+// How recent a gap can be before we try to fill it. The live poller may
+// not have caught up with the most recent readings yet.
+const healSettleTime = 5 * time.Minute
+
+// A [Healer] finds gaps in the stored readings for one device and
+// backfills them.
+type Healer struct {
+	octo   *octopus.Octopus
+	store  *store.Store
+	device octopus.Device
+	// Gaps shorter than this are expected from the polling cadence and
+	// are not filled.
+	minGap time.Duration
+	// How far back to look for gaps.
+	lookback time.Duration
+	// The ends of gaps that we already tried to fill but the API had no
+	// readings for. These are skipped so that we don't request them over
+	// and over. Gaps are identified by their end, since the start of a gap
+	// at the beginning of the lookback window moves every time.
+	unfillable map[time.Time]struct{}
+	// Whether the account turned out not to have the meter, in which case
+	// there is nothing to heal.
+	missingMeter bool
+}
+
+// Creates a new [Healer] for the readings of the given device. Gaps longer
+// than minGap are filled.
+func NewHealer(octo *octopus.Octopus, s *store.Store, device octopus.Device, minGap time.Duration) *Healer {
+	return &Healer{
+		octo:       octo,
+		store:      s,
+		device:     device,
+		minGap:     minGap,
+		lookback:   defaultHealLookback,
+		unfillable: map[time.Time]struct{}{},
+	}
+}
+
+// Heals gaps immediately, then again at the given interval until ctx is
+// cancelled.
+func (h *Healer) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.Heal(ctx)
+		if h.missingMeter {
+			log.Printf("Meter %v not found; not healing its readings", h.device.Id)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Finds gaps in the readings and fills them from the smart meter telemetry.
+// Failures are logged; gaps that failed to fill are retried on the next
+// call. Returns the number of readings that were repaired.
+func (h *Healer) Heal(ctx context.Context) int {
+	to := time.Now().Add(-healSettleTime)
+	from := to.Add(-h.lookback)
+
+	gaps, err := h.store.Gaps(h.device, from, to, h.minGap)
+	if err != nil {
+		log.Printf("Failed to find gaps in %v readings from meter %v: %v", h.device.Meter, h.device.Id, err)
+		return 0
+	}
+
+	repaired := 0
+
+	for _, gap := range gaps {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if _, ok := h.unfillable[gap.To.UTC()]; ok {
+			continue
+		}
+
+		log.Printf("Found %v gap in %v readings from meter %v from %v to %v", gap.Duration().Round(time.Second), h.device.Meter, h.device.Id, gap.From, gap.To)
+
+		count, err := Backfill(ctx, h.octo, h.store, h.device, gap.From, gap.To, DefaultGrouping(h.device.Meter))
+		repaired += count
+		if errors.Is(err, octopus.ErrMeterNotFound) {
+			h.missingMeter = true
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to fill gap from %v to %v: %v", gap.From, gap.To, err)
+			continue
+		}
+
+		if count == 0 {
+			log.Printf("No telemetry available from %v to %v; not retrying", gap.From, gap.To)
+			h.unfillable[gap.To.UTC()] = struct{}{}
+			continue
+		}
+
+		log.Printf("Repaired gap from %v to %v with %v readings", gap.From, gap.To, count)
+	}
+
+	return repaired
+}
@@ -0,0 +1,45 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"time"
+)
+
+// The length of each window of settled consumption that is fetched and
+// stored in one go. A month of half hours fits in one page of the REST API.
+const settledWindowDuration = 30 * 24 * time.Hour
+
+// Fetches the settled half-hourly consumption of the given device between
+// from and to from the REST API, and stores it in s. Stops early if ctx is
+// cancelled. Returns the number of intervals fetched.
+func BackfillSettled(ctx context.Context, octo *octopus.Octopus, s *store.Store, device octopus.Device, from, to time.Time) (int, error) {
+	total := 0
+
+	for start := from; start.Before(to); start = start.Add(settledWindowDuration) {
+		end := start.Add(settledWindowDuration)
+		if end.After(to) {
+			end = to
+		}
+
+		intervals, err := withRetries(ctx, octo, func() ([]*octopus.ConsumptionInterval, error) {
+			return octo.SettledConsumption(ctx, device, start, end, octopus.GroupByHalfHour)
+		})
+		if err != nil {
+			return total, fmt.Errorf("BackfillSettled: %w", err)
+		}
+
+		err = s.SaveSettledConsumption(intervals)
+		if err != nil {
+			return total, fmt.Errorf("BackfillSettled: %w", err)
+		}
+
+		total += len(intervals)
+		log.Printf("Fetched %v settled %v intervals of meter %v from %v to %v", len(intervals), device.Meter, device.Id, start, end)
+	}
+
+	return total, nil
+}
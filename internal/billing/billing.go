@@ -0,0 +1,258 @@
+// This package follows the balance of each account, and projects what it
+// will be when the next bill is issued, from the cost of the energy used
+// since the last statement.
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Returned by [Project] if there isn't a recorded balance and a statement
+// to project from.
+var ErrNoHistory = errors.New("No balance or statement recorded")
+
+const day = 24 * time.Hour
+
+// Where a point of a balance trend comes from.
+const (
+	// The closing balance of a statement.
+	SourceStatement = "statement"
+	// A balance fetched from the API.
+	SourceRecorded = "recorded"
+)
+
+// The balance of an account at a point in time.
+type BalancePoint struct {
+	At time.Time `json:"at"`
+	// The balance in pence. Positive when the account is in credit.
+	Balance int `json:"balance"`
+	// SourceStatement or SourceRecorded.
+	Source string `json:"source"`
+}
+
+// Returns the balance of the given account between from and to, oldest
+// first, from the closing balances of its statements and the balances
+// recorded since tracking started.
+func Trend(s *store.Store, accountNumber string, from, to time.Time) ([]*BalancePoint, error) {
+	points := []*BalancePoint{}
+
+	bills, err := s.Bills(accountNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load bills: %w", err)
+	}
+	for _, b := range bills {
+		if b.Type != octopus.BillStatement || b.ClosingBalance == nil || b.To.Before(from) || !b.To.Before(to) {
+			continue
+		}
+		points = append(points, &BalancePoint{At: b.To, Balance: *b.ClosingBalance, Source: SourceStatement})
+	}
+
+	balances, err := s.Balances(accountNumber, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load balances: %w", err)
+	}
+	for _, b := range balances {
+		points = append(points, &BalancePoint{At: b.RecordedAt, Balance: b.Balance, Source: SourceRecorded})
+	}
+
+	slices.SortStableFunc(points, func(a, b *BalancePoint) int {
+		return a.At.Compare(b.At)
+	})
+
+	return points, nil
+}
+
+// What the balance of an account is expected to be when its next bill is
+// issued. Amounts are in pence, including VAT.
+type Projection struct {
+	AccountNumber string `json:"accountNumber"`
+	// The latest recorded balance. Positive when the account is in credit.
+	Balance int `json:"balance"`
+	// When the balance was recorded.
+	BalanceAt time.Time `json:"balanceAt"`
+	// The end of the last statement, from when consumption is unbilled.
+	PeriodStart time.Time `json:"periodStart"`
+	// When the next bill is expected, assuming the account is billed over
+	// periods as long as the last statement's.
+	NextBill time.Time `json:"nextBill"`
+	// The cost of the energy used since the last statement, from the
+	// recorded consumption and stored tariffs.
+	Unbilled cost.Cost `json:"unbilled"`
+	// The expected cost of the energy used until the next bill, at the
+	// average daily cost since the last statement.
+	Forecast float64 `json:"forecast"`
+	// The payments expected to reach the balance before the next bill.
+	ExpectedPayments int `json:"expectedPayments"`
+	// The balance after the next bill: the balance less the unbilled and
+	// forecast costs, plus the expected payments.
+	ProjectedBalance float64 `json:"projectedBalance"`
+	// Whether the projected balance is negative, meaning the account is
+	// expected to be in debt.
+	Negative bool `json:"negative"`
+}
+
+// Returns when the bill after the given statement is expected, if bills
+// keep covering the same number of months, or else days.
+func nextBillAfter(statement *octopus.Bill) time.Time {
+	for months := 1; months <= 12; months++ {
+		if statement.From.AddDate(0, months, 0).Equal(statement.To) {
+			return statement.To.AddDate(0, months, 0)
+		}
+	}
+	days := int(statement.To.Sub(statement.From).Round(day) / day)
+	return statement.To.AddDate(0, 0, max(days, 1))
+}
+
+// Returns the payments expected to reach the balance after the start of the
+// billing period: any pending payments, and the amount of the latest
+// payment again if none has been made in the period yet, since regular
+// payments are usually made once per bill.
+func expectedPayments(payments []*octopus.Payment, periodStart time.Time) int {
+	expected := 0
+	paidInPeriod := false
+	var latest *octopus.Payment
+
+	for _, p := range payments {
+		if p.Status == octopus.PaymentFailed {
+			continue
+		}
+		if p.Status == octopus.PaymentPending {
+			expected += p.Amount
+		}
+		if !p.Date.Before(periodStart) {
+			paidInPeriod = true
+		}
+		if p.Status == octopus.PaymentSucceeded && (latest == nil || p.Date.After(latest.Date)) {
+			latest = p
+		}
+	}
+
+	if !paidInPeriod && latest != nil {
+		expected += latest.Amount
+	}
+	return expected
+}
+
+// Projects the balance of the given account at its next bill, from its
+// stored balance, statements and payments, and the cost of the energy its
+// meters have used since the last statement, priced with tariffs. Returns
+// [ErrNoHistory] if no balance or statement has been stored.
+func Project(s *store.Store, tariffs *cost.Tariffs, devices []octopus.Device, accountNumber string, now time.Time) (*Projection, error) {
+	latest, err := s.LatestBalance(accountNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load balance: %w", err)
+	}
+
+	bills, err := s.Bills(accountNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load bills: %w", err)
+	}
+	var statement *octopus.Bill
+	for _, b := range bills {
+		if b.Type == octopus.BillStatement {
+			statement = b
+			break
+		}
+	}
+
+	if latest == nil || statement == nil {
+		return nil, fmt.Errorf("%w: account %v", ErrNoHistory, accountNumber)
+	}
+
+	payments, err := s.Payments(accountNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load payments: %w", err)
+	}
+
+	p := &Projection{
+		AccountNumber: accountNumber,
+		Balance:       latest.Balance,
+		BalanceAt:     latest.RecordedAt,
+		PeriodStart:   statement.To,
+		NextBill:      nextBillAfter(statement),
+	}
+
+	// A late bill is expected at least a day from now
+	if p.NextBill.Before(now) {
+		p.NextBill = now.Add(day)
+	}
+
+	accountDevices := []octopus.Device{}
+	for _, device := range devices {
+		if device.AccountNumber == accountNumber {
+			accountDevices = append(accountDevices, device)
+		}
+	}
+
+	unbilled := []*cost.Period{}
+	for _, fuel := range []octopus.Fuel{octopus.FuelElectricity, octopus.FuelGas} {
+		if !now.After(p.PeriodStart) {
+			// The statement covers up to now
+			break
+		}
+
+		periods, err := cost.Periods(s, tariffs, accountDevices, store.ReadingsQuery{
+			Meter:      octopus.Meter{Fuel: fuel, Direction: octopus.DirectionImport},
+			From:       p.PeriodStart,
+			To:         now,
+			Resolution: store.ResolutionOneDay,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to cost unbilled %v: %w", fuel, err)
+		}
+		unbilled = append(unbilled, periods...)
+	}
+	p.Unbilled = cost.Total(unbilled)
+
+	// Until a day has passed, the last statement is a better guide to the
+	// daily cost than the period so far. A statement without a length
+	// gives no guide, so nothing is forecast.
+	elapsed := now.Sub(p.PeriodStart).Hours() / 24
+	dailyCost := 0.0
+	if days := statement.To.Sub(statement.From).Hours() / 24; days > 0 {
+		dailyCost = float64(statement.Charges) / days
+	}
+	if elapsed >= 1 {
+		dailyCost = p.Unbilled.Total / elapsed
+	}
+	p.Forecast = dailyCost * p.NextBill.Sub(now).Hours() / 24
+
+	p.ExpectedPayments = expectedPayments(payments, p.PeriodStart)
+
+	p.ProjectedBalance = float64(p.Balance) - p.Unbilled.Total - p.Forecast + float64(p.ExpectedPayments)
+	p.Negative = p.ProjectedBalance < 0
+
+	return p, nil
+}
+
+// Raises an alert when the projected balance of an account goes negative,
+// once until it recovers. It is safe to use from several goroutines.
+type Alerts struct {
+	lock sync.Mutex
+	// Whether the last projection of each account was negative, by account
+	// number.
+	negative map[string]bool
+}
+
+// Creates alerts with no accounts alerted yet.
+func NewAlerts() *Alerts {
+	return &Alerts{negative: map[string]bool{}}
+}
+
+// Records a new projection, returning true if its account should be
+// alerted: it is negative, and the account's last projection wasn't.
+func (a *Alerts) Check(p *Projection) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	wasNegative := a.negative[p.AccountNumber]
+	a.negative[p.AccountNumber] = p.Negative
+	return p.Negative && !wasNegative
+}
@@ -0,0 +1,256 @@
+package billing
+
+import (
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"martin-walls/octopus-energy-tracker/internal/store/storetest"
+	"math"
+	"testing"
+	"time"
+)
+
+// The start of the billing period after the test statement.
+var testStart = time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+
+const testAccount = "A-12345678"
+
+var testDevice = octopus.Device{
+	Id:            "00-00-00-00-00-00-00-01",
+	AccountNumber: testAccount,
+	Meter:         octopus.ElectricityImport,
+	MeterPoint:    "1000000000001",
+}
+
+// Opens a fresh store with a January statement closing at £20 in credit,
+// the same balance recorded at testStart, and a payment of £30 made in
+// January. The meter is on a flat tariff of 20p/kWh and 50p/day, and uses
+// 500Wh every half hour for the given number of days from testStart.
+func newTestStore(t *testing.T, days int) *store.Store {
+	t.Helper()
+
+	s := storetest.Open(t)
+
+	closingBalance := 2000
+	err := s.SaveBilling(&octopus.Billing{
+		AccountNumber: testAccount,
+		Balance:       2000,
+		Bills: []*octopus.Bill{{
+			Id:             "1",
+			Type:           octopus.BillStatement,
+			IssuedAt:       testStart.AddDate(0, 0, 2),
+			From:           testStart.AddDate(0, -1, 0),
+			To:             testStart,
+			ClosingBalance: &closingBalance,
+			Charges:        3100,
+			Credits:        3000,
+		}},
+		Payments: []*octopus.Payment{
+			{Id: "1", Date: testStart.AddDate(0, 0, -17), Amount: 3000, Status: octopus.PaymentSucceeded},
+		},
+	}, testStart)
+	if err != nil {
+		t.Fatalf("SaveBilling: %v", err)
+	}
+
+	err = s.SaveAgreements([]*octopus.Agreement{{
+		Id:             1,
+		AccountNumber:  testAccount,
+		MeterPoint:     testDevice.MeterPoint,
+		ValidFrom:      testStart.AddDate(-1, 0, 0),
+		StandingCharge: 50,
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandStandard, ValidFrom: testStart.AddDate(-1, 0, 0), Value: 20},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("SaveAgreements: %v", err)
+	}
+
+	rs := []*octopus.ConsumptionReading{}
+	for i := range days * 48 {
+		rs = append(rs, &octopus.ConsumptionReading{
+			Timestamp:        testStart.Add(time.Duration(i) * 30 * time.Minute),
+			MeterId:          testDevice.Id,
+			TotalConsumption: 500 * i,
+		})
+	}
+	err = s.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	return s
+}
+
+func newTariffs(t *testing.T, s *store.Store) *cost.Tariffs {
+	t.Helper()
+
+	agreements, err := s.Agreements("")
+	if err != nil {
+		t.Fatalf("Agreements: %v", err)
+	}
+	return cost.NewTariffs(agreements, cost.WithLocation(time.UTC))
+}
+
+func TestProject(t *testing.T) {
+	s := newTestStore(t, 10)
+	now := testStart.AddDate(0, 0, 10)
+
+	p, err := Project(s, newTariffs(t, s), []octopus.Device{testDevice}, testAccount, now)
+	if err != nil {
+		t.Fatalf("Project: %v", err)
+	}
+
+	if !p.PeriodStart.Equal(testStart) || !p.NextBill.Equal(testStart.AddDate(0, 1, 0)) {
+		t.Errorf("Period = %v to %v, want February", p.PeriodStart, p.NextBill)
+	}
+
+	// 479 half hours of 500Wh at 20p/kWh, since the first reading has
+	// nothing to count from, and 10 days of standing charges
+	if p.Unbilled.Consumption != 239500 || math.Abs(p.Unbilled.Total-5290) > 1e-6 {
+		t.Errorf("Unbilled = %+v, want 239.5kWh costing 5290p", p.Unbilled)
+	}
+	// The other 18 days of February at 529p a day
+	if math.Abs(p.Forecast-9522) > 1e-6 {
+		t.Errorf("Forecast = %v, want 9522", p.Forecast)
+	}
+	// No payment has been made in February yet
+	if p.ExpectedPayments != 3000 {
+		t.Errorf("ExpectedPayments = %v, want 3000", p.ExpectedPayments)
+	}
+	if math.Abs(p.ProjectedBalance-(2000-5290-9522+3000)) > 1e-6 || !p.Negative {
+		t.Errorf("ProjectedBalance = %v, want %v and negative", p.ProjectedBalance, 2000-5290-9522+3000)
+	}
+}
+
+func TestProjectFromStatement(t *testing.T) {
+	s := newTestStore(t, 0)
+	now := testStart.Add(12 * time.Hour)
+
+	p, err := Project(s, newTariffs(t, s), []octopus.Device{testDevice}, testAccount, now)
+	if err != nil {
+		t.Fatalf("Project: %v", err)
+	}
+
+	// Less than a day in, so the forecast is at January's 100p a day
+	if math.Abs(p.Forecast-27.5*100) > 1e-6 {
+		t.Errorf("Forecast = %v, want 2750", p.Forecast)
+	}
+}
+
+func TestProjectFromEmptyStatement(t *testing.T) {
+	s := newTestStore(t, 0)
+	now := testStart.Add(12 * time.Hour)
+
+	// A statement issued for no time at all
+	err := s.SaveBilling(&octopus.Billing{
+		AccountNumber: testAccount,
+		Balance:       2000,
+		Bills: []*octopus.Bill{{
+			Id:       "2",
+			Type:     octopus.BillStatement,
+			IssuedAt: testStart.AddDate(0, 0, 3),
+			From:     testStart,
+			To:       testStart,
+			Charges:  100,
+		}},
+	}, testStart)
+	if err != nil {
+		t.Fatalf("SaveBilling: %v", err)
+	}
+
+	p, err := Project(s, newTariffs(t, s), []octopus.Device{testDevice}, testAccount, now)
+	if err != nil {
+		t.Fatalf("Project: %v", err)
+	}
+	if p.Forecast != 0 || math.IsNaN(p.ProjectedBalance) || math.IsInf(p.ProjectedBalance, 0) {
+		t.Errorf("Projection = %+v, want no forecast", p)
+	}
+}
+
+func TestProjectNoHistory(t *testing.T) {
+	s := newTestStore(t, 0)
+
+	_, err := Project(s, newTariffs(t, s), []octopus.Device{testDevice}, "A-87654321", testStart)
+	if !errors.Is(err, ErrNoHistory) {
+		t.Errorf("Project() error = %v, want %v", err, ErrNoHistory)
+	}
+}
+
+func TestNextBillAfter(t *testing.T) {
+	tests := []struct {
+		from, to time.Time
+		want     time.Time
+	}{
+		// Monthly
+		{testStart.AddDate(0, -1, 0), testStart, testStart.AddDate(0, 1, 0)},
+		// Quarterly
+		{testStart.AddDate(0, -3, 0), testStart, testStart.AddDate(0, 3, 0)},
+		// Every four weeks
+		{testStart.AddDate(0, 0, -28), testStart, testStart.AddDate(0, 0, 28)},
+	}
+	for _, test := range tests {
+		got := nextBillAfter(&octopus.Bill{From: test.from, To: test.to})
+		if !got.Equal(test.want) {
+			t.Errorf("nextBillAfter(%v to %v) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestExpectedPayments(t *testing.T) {
+	payments := []*octopus.Payment{
+		{Date: testStart.AddDate(0, 0, 5), Amount: 500, Status: octopus.PaymentFailed},
+		{Date: testStart.AddDate(0, 0, -17), Amount: 3000, Status: octopus.PaymentSucceeded},
+	}
+	if got := expectedPayments(payments, testStart); got != 3000 {
+		t.Errorf("expectedPayments() with no payment in the period = %v, want 3000", got)
+	}
+
+	// A pending payment in the period is expected, but not another one
+	payments = append(payments, &octopus.Payment{Date: testStart.AddDate(0, 0, 14), Amount: 3500, Status: octopus.PaymentPending})
+	if got := expectedPayments(payments, testStart); got != 3500 {
+		t.Errorf("expectedPayments() with a pending payment = %v, want 3500", got)
+	}
+}
+
+func TestTrend(t *testing.T) {
+	s := newTestStore(t, 0)
+
+	err := s.SaveBilling(&octopus.Billing{AccountNumber: testAccount, Balance: 5000}, testStart.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatalf("SaveBilling: %v", err)
+	}
+
+	points, err := Trend(s, testAccount, testStart.AddDate(0, -6, 0), testStart.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("Trend: %v", err)
+	}
+
+	// The statement closes at the same time as the first recorded balance
+	if len(points) != 3 || points[0].Source != SourceStatement || points[2].Balance != 5000 {
+		t.Errorf("Trend() = %+v, want the statement then two recorded balances", points)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	alerts := NewAlerts()
+
+	for i, test := range []struct {
+		negative bool
+		want     bool
+	}{
+		{false, false},
+		{true, true},
+		// Only alerted once until it recovers
+		{true, false},
+		{false, false},
+		{true, true},
+	} {
+		got := alerts.Check(&Projection{AccountNumber: testAccount, Negative: test.negative})
+		if got != test.want {
+			t.Errorf("Check() %v = %v, want %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package cost
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"time"
+)
+
+// The size of the buckets that consumption is priced in. Every Octopus
+// tariff changes rate on the half hour, so this is exact.
+const pricingInterval = 30 * time.Minute
+
+// The cost of energy used over some time.
+type Cost struct {
+	// The energy used, in Wh.
+	Consumption int `json:"consumption"`
+	// The energy used at times with no known unit rate, in Wh. It isn't
+	// included in Energy.
+	Unpriced int `json:"unpriced"`
+	// The cost of the energy used, after any daily cap, in pence.
+	Energy float64 `json:"energy"`
+	// The standing charges, in pence.
+	StandingCharge float64 `json:"standingCharge"`
+	// The VAT included in the energy cost and standing charges, in pence.
+	Vat float64 `json:"vat"`
+	// The energy cost plus standing charges, in pence.
+	Total float64 `json:"total"`
+}
+
+// Adds another cost to this one.
+func (c *Cost) add(other Cost) {
+	c.Consumption += other.Consumption
+	c.Unpriced += other.Unpriced
+	c.Energy += other.Energy
+	c.StandingCharge += other.StandingCharge
+	c.Vat += other.Vat
+	c.Total += other.Total
+}
+
+// Adds energy used at the given unit rate, or as unpriced if the rate isn't
+// known.
+func (c *Cost) addEnergy(consumption int, rate octopus.Rate, ok bool) {
+	c.Consumption += consumption
+	if !ok {
+		c.Unpriced += consumption
+		return
+	}
+
+	kWh := float64(consumption) / 1000
+	c.Energy += kWh * rate.Value
+	c.Vat += kWh * (rate.Value - rate.PreVatValue)
+}
+
+// Reduces the energy cost to the cap, if it is over it, along with the VAT
+// on the energy. No cap if the cap is zero.
+func (c *Cost) capEnergy(cap float64) {
+	if cap <= 0 || c.Energy <= cap {
+		return
+	}
+
+	c.Vat *= cap / c.Energy
+	c.Energy = cap
+}
+
+// Returns the cost with its total filled in.
+func (c Cost) withTotal() Cost {
+	c.Total = c.Energy + c.StandingCharge
+	return c
+}
+
+// The cost of energy used during a period.
+type Period struct {
+	// The start of the period (inclusive).
+	Start time.Time `json:"start"`
+	// The end of the period (exclusive).
+	End  time.Time `json:"end"`
+	Cost Cost      `json:"cost"`
+}
+
+// Returns the sum of the given periods.
+func Total(periods []*Period) Cost {
+	total := Cost{}
+	for _, p := range periods {
+		total.add(p.Cost)
+	}
+	return total
+}
+
+// Returns the meters whose consumption is costed for the query: the import
+// meters of the query's fuel, or just the one with the query's meter ID.
+func costedDevices(devices []octopus.Device, q store.ReadingsQuery) []octopus.Device {
+	costed := []octopus.Device{}
+	for _, device := range devices {
+		if device.Meter.Direction != octopus.DirectionImport || device.Meter.Fuel != q.Meter.OrDefault().Fuel {
+			continue
+		}
+		if q.MeterId != "" && device.Id != q.MeterId {
+			continue
+		}
+		costed = append(costed, device)
+	}
+	return costed
+}
+
+// Calculates the cost of the energy imported by the given meters in each
+// period of the query's resolution between its From and To times, oldest
+// first. The meters are those of the query's fuel, or just the one with the
+// query's meter ID. Standing charges are apportioned to the periods, so
+// every period in the window is returned, even if it has no readings.
+// Periods are aligned to the Unix epoch, like [store.Store.ReadingBuckets].
+func Periods(s *store.Store, tariffs *Tariffs, devices []octopus.Device, q store.ReadingsQuery) ([]*Period, error) {
+	size := q.Resolution.Duration()
+	if size == 0 {
+		return nil, fmt.Errorf("Cost can't be calculated at %q resolution", q.Resolution)
+	}
+	if q.From.IsZero() || q.To.IsZero() {
+		return nil, fmt.Errorf("Cost needs a window to be calculated over")
+	}
+
+	periods := []*Period{}
+	byStart := map[time.Time]*Period{}
+	for start := q.From.UTC().Truncate(size); start.Before(q.To); start = start.Add(size) {
+		p := &Period{Start: start, End: start.Add(size)}
+		periods = append(periods, p)
+		byStart[start] = p
+	}
+
+	// Consumption is priced in buckets no longer than the pricing interval
+	priced := q
+	priced.Resolution = store.ResolutionHalfHour
+	if size < pricingInterval {
+		priced.Resolution = q.Resolution
+	}
+	priced.Limit = 0
+	priced.After = time.Time{}
+
+	// The daily cap applies to whole days, so whole days are priced
+	if tariffs.dailyCap > 0 {
+		priced.From = tariffs.startOfDay(q.From)
+		priced.To = tariffs.startOfDay(q.To).AddDate(0, 0, 1)
+	}
+
+	meterPoints := map[string]bool{}
+
+	for _, device := range costedDevices(devices, q) {
+		priced.Meter = device.Meter
+		priced.MeterId = device.Id
+
+		buckets, err := s.ReadingBuckets(priced)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get readings of meter %v: %w", device.Id, err)
+		}
+
+		// The cost of the device's energy on each day, to be capped
+		type dailyCost struct {
+			Cost
+			buckets []*store.ReadingBucket
+			costs   []Cost
+		}
+		days := map[time.Time]*dailyCost{}
+		dayStarts := []time.Time{}
+
+		for _, b := range buckets {
+			c := Cost{}
+			rate, ok := tariffs.UnitRate(device.MeterPoint, b.Start)
+			c.addEnergy(b.Consumption, rate, ok)
+
+			dayStart := tariffs.startOfDay(b.Start)
+			d, exists := days[dayStart]
+			if !exists {
+				d = &dailyCost{}
+				days[dayStart] = d
+				dayStarts = append(dayStarts, dayStart)
+			}
+			d.add(c)
+			d.buckets = append(d.buckets, b)
+			d.costs = append(d.costs, c)
+		}
+
+		for _, dayStart := range dayStarts {
+			d := days[dayStart]
+
+			// Spread any cap over the day's buckets in proportion to their cost
+			capped := d.Cost
+			capped.capEnergy(tariffs.dailyCap)
+			scale := 1.0
+			if capped.Energy != d.Energy {
+				scale = capped.Energy / d.Energy
+			}
+
+			for i, b := range d.buckets {
+				if !b.End.After(q.From) || !b.Start.Before(q.To) {
+					continue
+				}
+
+				c := d.costs[i]
+				c.Energy *= scale
+				c.Vat *= scale
+
+				if p, ok := byStart[b.Start.Truncate(size)]; ok {
+					p.Cost.add(c)
+				}
+			}
+		}
+
+		meterPoints[device.MeterPoint] = true
+	}
+
+	for _, p := range periods {
+		from := p.Start
+		if q.From.After(from) {
+			from = q.From
+		}
+		to := p.End
+		if q.To.Before(to) {
+			to = q.To
+		}
+
+		for meterPoint := range meterPoints {
+			charge, vat := tariffs.StandingCharge(meterPoint, from, to)
+			p.Cost.StandingCharge += charge
+			p.Cost.Vat += vat
+		}
+
+		p.Cost = p.Cost.withTotal()
+	}
+
+	return periods, nil
+}
@@ -0,0 +1,412 @@
+package cost
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"martin-walls/octopus-energy-tracker/internal/store/storetest"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const testMeterPoint = "1000000000001"
+
+var testDevice = octopus.Device{
+	Id:         "00-00-00-00-00-00-00-01",
+	Meter:      octopus.ElectricityImport,
+	MeterPoint: testMeterPoint,
+}
+
+// Returns a flat tariff of 20p/kWh and 48p/day standing charge, with 5%
+// VAT, starting at testStart.
+func flatAgreement() *octopus.Agreement {
+	return &octopus.Agreement{
+		Id:                   1,
+		MeterPoint:           testMeterPoint,
+		ValidFrom:            testStart,
+		StandingCharge:       48,
+		PreVatStandingCharge: 48 / 1.05,
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandStandard, ValidFrom: testStart, Value: 21, PreVatValue: 20},
+		},
+	}
+}
+
+// Opens a fresh store with one reading every 10 seconds for the given
+// duration from testStart, using 10Wh per reading.
+func newTestStore(t *testing.T, d time.Duration) *store.Store {
+	t.Helper()
+
+	s := storetest.Open(t)
+	storetest.AddReadings(t, s, testDevice.Id, testStart, d, 10)
+	return s
+}
+
+func TestParseHours(t *testing.T) {
+	hours, err := ParseHours("23:30-05:30")
+	if err != nil {
+		t.Fatalf("ParseHours: %v", err)
+	}
+	if hours.Start != 23*time.Hour+30*time.Minute || hours.End != 5*time.Hour+30*time.Minute {
+		t.Errorf("ParseHours() = %+v, want 23:30 to 05:30", hours)
+	}
+
+	// Hours that run past midnight
+	if !hours.Contains(testStart) || hours.Contains(testStart.Add(12*time.Hour)) {
+		t.Errorf("Expected 23:30-05:30 to contain midnight but not midday")
+	}
+
+	for _, s := range []string{"", "00:30", "25:00-07:00", "00:60-07:00"} {
+		if _, err := ParseHours(s); err == nil {
+			t.Errorf("ParseHours(%q) succeeded", s)
+		}
+	}
+}
+
+func TestUnitRateBands(t *testing.T) {
+	tariffs := NewTariffs([]*octopus.Agreement{{
+		Id:         1,
+		MeterPoint: testMeterPoint,
+		ValidFrom:  testStart,
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandDay, ValidFrom: testStart, Value: 28},
+			{Band: octopus.BandNight, ValidFrom: testStart, Value: 14},
+			{Band: octopus.BandOffPeak, ValidFrom: testStart, Value: 10},
+		},
+	}}, WithLocation(time.UTC), WithOffPeakHours(Hours{Start: 13 * time.Hour, End: 16 * time.Hour}))
+
+	tests := map[time.Duration]float64{
+		0:                             28,
+		30 * time.Minute:              14,
+		7*time.Hour + 29*time.Minute:  14,
+		7*time.Hour + 30*time.Minute:  28,
+		14 * time.Hour:                10,
+		23*time.Hour + 59*time.Minute: 28,
+		24*time.Hour + 45*time.Minute: 14,
+	}
+	for sinceStart, expected := range tests {
+		rate, ok := tariffs.UnitRate(testMeterPoint, testStart.Add(sinceStart))
+		if !ok || rate.Value != expected {
+			t.Errorf("UnitRate() at %v = %v, %v, want %v", sinceStart, rate.Value, ok, expected)
+		}
+	}
+
+	// Before the agreement
+	if _, ok := tariffs.UnitRate(testMeterPoint, testStart.Add(-time.Hour)); ok {
+		t.Errorf("Expected no unit rate before the agreement")
+	}
+}
+
+func TestUnitRateDispatches(t *testing.T) {
+	// Intelligent Octopus Go, off-peak from 23:30 to 05:30, with a dispatch
+	// from 14:15 to 15:00
+	tariffs := NewTariffs([]*octopus.Agreement{{
+		Id:            1,
+		AccountNumber: "A-12345678",
+		Fuel:          octopus.FuelElectricity,
+		MeterPoint:    testMeterPoint,
+		ValidFrom:     testStart,
+		ProductCode:   "INTELLI-VAR-22-10-14",
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandDay, ValidFrom: testStart, Value: 28},
+			{Band: octopus.BandNight, ValidFrom: testStart, Value: 7},
+		},
+	}}, WithLocation(time.UTC),
+		WithDispatches([]*octopus.Dispatch{{
+			AccountNumber: "A-12345678",
+			Start:         testStart.Add(14*time.Hour + 15*time.Minute),
+			End:           testStart.Add(15 * time.Hour),
+		}, {
+			// Another account's dispatch
+			AccountNumber: "A-87654321",
+			Start:         testStart.Add(18 * time.Hour),
+			End:           testStart.Add(19 * time.Hour),
+		}}))
+
+	tests := map[time.Duration]float64{
+		14*time.Hour - time.Minute: 28,
+		// The whole half hour that the dispatch starts in is off-peak
+		14 * time.Hour:                7,
+		14*time.Hour + 45*time.Minute: 7,
+		15 * time.Hour:                28,
+		18 * time.Hour:                28,
+		23*time.Hour + 45*time.Minute: 7,
+		// Off-peak ends at 05:30, not at 07:30 like Economy 7
+		29 * time.Hour:                7,
+		29*time.Hour + 30*time.Minute: 28,
+	}
+	for sinceStart, expected := range tests {
+		rate, ok := tariffs.UnitRate(testMeterPoint, testStart.Add(sinceStart))
+		if !ok || rate.Value != expected {
+			t.Errorf("UnitRate() at %v = %v, %v, want %v", sinceStart, rate.Value, ok, expected)
+		}
+	}
+}
+
+func TestUnitRateDispatchesHalfHourly(t *testing.T) {
+	// Intelligent Octopus Go billed with half-hourly rates, which are all in
+	// the standard band: 7p from 23:30 to 05:30, and 28p otherwise
+	rates := []octopus.Rate{}
+	for start := testStart.Add(-time.Hour); start.Before(testStart.Add(day)); start = start.Add(30 * time.Minute) {
+		end := start.Add(30 * time.Minute)
+		value := 28.0
+		if IntelligentOffPeakHours.Contains(start.In(time.UTC)) {
+			value = 7
+		}
+		rates = append(rates, octopus.Rate{Band: octopus.BandStandard, ValidFrom: start, ValidTo: &end, Value: value})
+	}
+
+	tariffs := NewTariffs([]*octopus.Agreement{{
+		Id:            1,
+		AccountNumber: "A-12345678",
+		Fuel:          octopus.FuelElectricity,
+		MeterPoint:    testMeterPoint,
+		ValidFrom:     testStart.Add(-time.Hour),
+		ProductCode:   "INTELLI-VAR-22-10-14",
+		UnitRates:     rates,
+	}}, WithLocation(time.UTC), WithDispatches([]*octopus.Dispatch{{
+		AccountNumber: "A-12345678",
+		Start:         testStart.Add(14 * time.Hour),
+		End:           testStart.Add(15 * time.Hour),
+	}}))
+
+	tests := map[time.Duration]float64{
+		13*time.Hour + 30*time.Minute: 28,
+		// Billed at the rate of the previous night's off-peak hours
+		14*time.Hour + 30*time.Minute: 7,
+		15 * time.Hour:                28,
+	}
+	for sinceStart, expected := range tests {
+		rate, ok := tariffs.UnitRate(testMeterPoint, testStart.Add(sinceStart))
+		if !ok || rate.Value != expected {
+			t.Errorf("UnitRate() at %v = %v, %v, want %v", sinceStart, rate.Value, ok, expected)
+		}
+	}
+}
+
+func TestUnitRateHalfHourly(t *testing.T) {
+	slotEnd := testStart.Add(30 * time.Minute)
+	tariffs := NewTariffs([]*octopus.Agreement{{
+		Id:         1,
+		MeterPoint: testMeterPoint,
+		ValidFrom:  testStart,
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandStandard, ValidFrom: testStart, ValidTo: &slotEnd, Value: 15},
+		},
+	}})
+
+	rate, ok := tariffs.UnitRate(testMeterPoint, testStart.Add(29*time.Minute))
+	if !ok || rate.Value != 15 {
+		t.Errorf("UnitRate() = %v, %v, want 15", rate.Value, ok)
+	}
+
+	// The next slot's rate hasn't been published
+	if _, ok := tariffs.UnitRate(testMeterPoint, slotEnd); ok {
+		t.Errorf("Expected no unit rate after the last slot")
+	}
+}
+
+func TestStandingCharge(t *testing.T) {
+	switchedAt := testStart.Add(12 * time.Hour)
+	first := flatAgreement()
+	first.ValidTo = &switchedAt
+	second := flatAgreement()
+	second.Id = 2
+	second.ValidFrom = switchedAt
+	second.StandingCharge = 60
+	second.PreVatStandingCharge = 60
+
+	tariffs := NewTariffs([]*octopus.Agreement{first, second})
+
+	// Half a day on each agreement
+	charge, vat := tariffs.StandingCharge(testMeterPoint, testStart, testStart.Add(24*time.Hour))
+	if !storetest.ApproxEqual(charge, 54) {
+		t.Errorf("StandingCharge() = %v, want 54", charge)
+	}
+	if !storetest.ApproxEqual(vat, 24-24/1.05) {
+		t.Errorf("VAT = %v, want %v", vat, 24-24/1.05)
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	tariffs := NewTariffs([]*octopus.Agreement{flatAgreement()}, WithLocation(time.UTC))
+
+	periods, err := Periods(s, tariffs, []octopus.Device{testDevice}, store.ReadingsQuery{
+		From:       testStart,
+		To:         testStart.Add(2 * time.Hour),
+		Resolution: store.ResolutionOneHour,
+	})
+	if err != nil {
+		t.Fatalf("Periods: %v", err)
+	}
+	if len(periods) != 2 {
+		t.Fatalf("Periods() returned %v periods, want 2", len(periods))
+	}
+
+	// 3.6kWh in the first hour at 21p/kWh, plus an hour of standing charge
+	first := periods[0].Cost
+	if first.Consumption != 3590 {
+		t.Errorf("Consumption = %v, want 3590", first.Consumption)
+	}
+	if !storetest.ApproxEqual(first.Energy, 3.59*21) {
+		t.Errorf("Energy = %v, want %v", first.Energy, 3.59*21)
+	}
+	if !storetest.ApproxEqual(first.StandingCharge, 2) {
+		t.Errorf("StandingCharge = %v, want 2", first.StandingCharge)
+	}
+	if !storetest.ApproxEqual(first.Total, 3.59*21+2) {
+		t.Errorf("Total = %v, want %v", first.Total, 3.59*21+2)
+	}
+
+	// The second hour only has the last reading, and standing charges
+	second := periods[1].Cost
+	if second.Consumption != 10 || !storetest.ApproxEqual(second.StandingCharge, 2) {
+		t.Errorf("second period = %+v, want 10Wh and 2p standing charge", second)
+	}
+
+	total := Total(periods)
+	if total.Consumption != 3600 || !storetest.ApproxEqual(total.Vat, 3.6*1+(4-4/1.05)) {
+		t.Errorf("Total() = %+v, want 3600Wh and %vp VAT", total, 3.6+(4-4/1.05))
+	}
+}
+
+func TestPeriodsDailyCap(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	tariffs := NewTariffs([]*octopus.Agreement{flatAgreement()}, WithLocation(time.UTC), WithDailyCap(50))
+
+	periods, err := Periods(s, tariffs, []octopus.Device{testDevice}, store.ReadingsQuery{
+		From:       testStart,
+		To:         testStart.Add(time.Hour),
+		Resolution: store.ResolutionHalfHour,
+	})
+	if err != nil {
+		t.Fatalf("Periods: %v", err)
+	}
+
+	total := Total(periods)
+	// The day's last reading is outside the window, so the window's share
+	// of the cap is a little less than the whole cap
+	if total.Energy > 50 || total.Energy < 49.8 {
+		t.Errorf("Energy = %v, want just under 50", total.Energy)
+	}
+}
+
+func TestPeriodsUnpriced(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	tariffs := NewTariffs(nil)
+
+	periods, err := Periods(s, tariffs, []octopus.Device{testDevice}, store.ReadingsQuery{
+		From:       testStart,
+		To:         testStart.Add(time.Hour),
+		Resolution: store.ResolutionOneHour,
+	})
+	if err != nil {
+		t.Fatalf("Periods: %v", err)
+	}
+
+	total := Total(periods)
+	if total.Unpriced != total.Consumption || total.Total != 0 {
+		t.Errorf("Total() = %+v, want all energy unpriced", total)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	tracker := NewTracker(s, []octopus.Device{testDevice})
+	tracker.tariffs = NewTariffs([]*octopus.Agreement{flatAgreement()}, WithLocation(time.UTC))
+
+	// 3.59kWh is stored before the end of the first hour of the day
+	loadedAt := testStart.Add(time.Hour)
+	err := tracker.reload(loadedAt)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	live := tracker.Update(&octopus.ConsumptionReading{
+		Timestamp:        loadedAt.Add(10 * time.Second),
+		MeterId:          testDevice.Id,
+		TotalConsumption: 3610,
+		Demand:           2000,
+	})
+	if live == nil {
+		t.Fatalf("Expected a live cost")
+	}
+	// Live readings are costed from the last stored reading
+	if live.Today.Consumption != 3610 {
+		t.Errorf("Consumption = %v, want 3610", live.Today.Consumption)
+	}
+	if !storetest.ApproxEqual(live.CostPerHour, 42) {
+		t.Errorf("CostPerHour = %v, want 42", live.CostPerHour)
+	}
+
+	live = tracker.Update(&octopus.ConsumptionReading{
+		Timestamp:        loadedAt.Add(time.Hour),
+		MeterId:          testDevice.Id,
+		TotalConsumption: 4610,
+		Demand:           1000,
+	})
+	if live.Today.Consumption != 4610 {
+		t.Errorf("Consumption = %v, want 4610", live.Today.Consumption)
+	}
+	// 4.61kWh at 21p, plus two hours of standing charge
+	if !storetest.ApproxEqual(live.Today.Total, 4.61*21+4) {
+		t.Errorf("Total = %v, want %v", live.Today.Total, 4.61*21+4)
+	}
+
+	// Export readings are ignored
+	live = tracker.Update(&octopus.ConsumptionReading{
+		Timestamp: loadedAt.Add(time.Hour),
+		Direction: octopus.DirectionExport,
+		MeterId:   "export",
+	})
+	if live != nil {
+		t.Errorf("Expected no live cost from an export reading, got %+v", live)
+	}
+
+	// The total starts again the next day
+	live = tracker.Update(&octopus.ConsumptionReading{
+		Timestamp:        testStart.AddDate(0, 0, 1).Add(time.Hour),
+		MeterId:          testDevice.Id,
+		TotalConsumption: 5610,
+		Demand:           1000,
+	})
+	if live.Today.Consumption != 1000 {
+		t.Errorf("Consumption = %v, want 1000 since midnight", live.Today.Consumption)
+	}
+	if !storetest.ApproxEqual(live.Today.StandingCharge, 2) {
+		t.Errorf("StandingCharge = %v, want 2", live.Today.StandingCharge)
+	}
+}
+
+func TestTrackerSetTariffs(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	tracker := NewTracker(s, []octopus.Device{testDevice})
+	tariffs := NewTariffs([]*octopus.Agreement{flatAgreement()}, WithLocation(time.UTC))
+
+	err := tracker.SetTariffs(tariffs, testStart.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SetTariffs: %v", err)
+	}
+	tracker.Update(&octopus.ConsumptionReading{
+		Timestamp:        testStart.Add(time.Hour + 10*time.Second),
+		MeterId:          testDevice.Id,
+		TotalConsumption: 3610,
+	})
+
+	// Reloading costs the stored readings up to 3.6kWh again, and the next
+	// live reading is costed from there
+	err = tracker.SetTariffs(tariffs, testStart.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("SetTariffs: %v", err)
+	}
+	live := tracker.Update(&octopus.ConsumptionReading{
+		Timestamp:        testStart.Add(2 * time.Hour),
+		MeterId:          testDevice.Id,
+		TotalConsumption: 4610,
+	})
+	if live.Today.Consumption != 4610 {
+		t.Errorf("Consumption = %v, want 4610", live.Today.Consumption)
+	}
+}
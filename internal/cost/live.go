@@ -0,0 +1,209 @@
+package cost
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"sync"
+	"time"
+)
+
+// Readings older than this compared to the newest reading are left out of
+// the cost per hour. Gas readings are half-hourly, so this allows for them.
+const maxReadingAge = time.Hour
+
+// The running cost of today's energy.
+type LiveCost struct {
+	// The time of the latest reading.
+	Timestamp time.Time `json:"timestamp"`
+	// The cost of the energy imported since midnight, with the standing
+	// charges apportioned up to the latest reading.
+	Today Cost `json:"today"`
+	// How quickly energy is costing money at the latest demand of each
+	// meter and the current unit rates, in pence per hour.
+	CostPerHour float64 `json:"costPerHour"`
+}
+
+// Keeps a running total of the cost of today's energy from live readings.
+// Readings from export meters are ignored. It is safe to use from several
+// goroutines.
+type Tracker struct {
+	lock sync.Mutex
+	// Where today's cost so far is loaded from.
+	store   *store.Store
+	tariffs *Tariffs
+	// The import meters being costed, by device ID.
+	devices map[string]octopus.Device
+	// Midnight at the start of today.
+	today time.Time
+	// The total consumption that each meter's energy has been costed up to,
+	// by device ID. Live readings are costed from here, so that energy
+	// loaded from the store isn't counted twice, and none is missed when
+	// today's cost is reloaded.
+	costedTotal map[string]int
+	// The cost of each meter point's energy today, by MPAN or MPRN, before
+	// the daily cap.
+	energy map[string]*Cost
+	// The latest reading from each meter, by device ID.
+	latest map[string]*octopus.ConsumptionReading
+}
+
+// Creates a new [Tracker] for the import meters among devices. Call
+// [Tracker.SetTariffs] to load today's cost so far from s.
+func NewTracker(s *store.Store, devices []octopus.Device) *Tracker {
+	t := &Tracker{
+		store:   s,
+		tariffs: NewTariffs(nil),
+		devices: map[string]octopus.Device{},
+		energy:  map[string]*Cost{},
+		latest:  map[string]*octopus.ConsumptionReading{},
+
+		costedTotal: map[string]int{},
+	}
+
+	for _, device := range devices {
+		if device.Meter.Direction == octopus.DirectionImport {
+			t.devices[device.Id] = device
+		}
+	}
+
+	return t
+}
+
+// Uses the given tariffs from now on, and recalculates today's cost so far
+// from the readings stored before now.
+func (t *Tracker) SetTariffs(tariffs *Tariffs, now time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.tariffs = tariffs
+	return t.reload(now)
+}
+
+// Loads the cost of the energy used since midnight up to now from the
+// store. Live readings are costed from the last stored reading of each
+// meter, or from the last live reading if none is stored. The lock must be
+// held.
+func (t *Tracker) reload(now time.Time) error {
+	t.startDay(now)
+
+	for _, device := range t.devices {
+		q := store.ReadingsQuery{
+			Meter:      device.Meter,
+			MeterId:    device.Id,
+			From:       t.today,
+			To:         now,
+			Resolution: store.ResolutionHalfHour,
+		}
+
+		buckets, err := t.store.ReadingBuckets(q)
+		if err != nil {
+			return fmt.Errorf("Failed to load today's readings of meter %v: %w", device.Id, err)
+		}
+
+		c := t.energyOf(device.MeterPoint)
+		for _, b := range buckets {
+			rate, ok := t.tariffs.UnitRate(device.MeterPoint, b.Start)
+			c.addEnergy(b.Consumption, rate, ok)
+			if b.Count > 0 {
+				t.costedTotal[device.Id] = b.TotalConsumption
+			}
+		}
+	}
+
+	return nil
+}
+
+// Starts counting a new day from midnight before the given time, with no
+// energy used yet. The lock must be held.
+func (t *Tracker) startDay(at time.Time) {
+	t.today = t.tariffs.startOfDay(at)
+	t.energy = map[string]*Cost{}
+
+	// Every meter point has standing charges, even with no energy used
+	for _, device := range t.devices {
+		t.energyOf(device.MeterPoint)
+	}
+}
+
+// Returns the cost of the meter point's energy today. The lock must be
+// held.
+func (t *Tracker) energyOf(meterPoint string) *Cost {
+	c, ok := t.energy[meterPoint]
+	if !ok {
+		c = &Cost{}
+		t.energy[meterPoint] = c
+	}
+	return c
+}
+
+// Records a live reading. Returns today's running cost, or nil if the
+// reading isn't from a tracked import meter.
+func (t *Tracker) Update(r *octopus.ConsumptionReading) *LiveCost {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	device, ok := t.devices[r.MeterId]
+	if !ok || r.Meter() != device.Meter {
+		return nil
+	}
+
+	if t.tariffs.startOfDay(r.Timestamp).After(t.today) {
+		t.startDay(r.Timestamp)
+	}
+
+	previous := t.latest[r.MeterId]
+	if previous != nil && !r.Timestamp.After(previous.Timestamp) {
+		// Already counted, e.g. when gas readings are fetched again
+		return t.liveCost()
+	}
+	t.latest[r.MeterId] = r
+
+	// The first reading of a meter is only a starting point
+	costed, ok := t.costedTotal[r.MeterId]
+	t.costedTotal[r.MeterId] = r.TotalConsumption
+	if ok && !r.Timestamp.Before(t.today) {
+		if delta := r.TotalConsumption - costed; delta > 0 {
+			rate, ok := t.tariffs.UnitRate(device.MeterPoint, r.Timestamp)
+			t.energyOf(device.MeterPoint).addEnergy(delta, rate, ok)
+		}
+	}
+
+	return t.liveCost()
+}
+
+// Returns today's running cost. The lock must be held.
+func (t *Tracker) liveCost() *LiveCost {
+	live := &LiveCost{}
+
+	for _, latest := range t.latest {
+		if latest.Timestamp.After(live.Timestamp) {
+			live.Timestamp = latest.Timestamp
+		}
+	}
+
+	for _, latest := range t.latest {
+		if live.Timestamp.Sub(latest.Timestamp) > maxReadingAge {
+			continue
+		}
+
+		meterPoint := t.devices[latest.MeterId].MeterPoint
+		if rate, ok := t.tariffs.UnitRate(meterPoint, latest.Timestamp); ok {
+			// Demand in W is the energy in Wh that an hour at that demand uses
+			live.CostPerHour += float64(latest.Demand) / 1000 * rate.Value
+		}
+	}
+
+	for meterPoint, energy := range t.energy {
+		c := *energy
+		c.capEnergy(t.tariffs.dailyCap)
+
+		charge, vat := t.tariffs.StandingCharge(meterPoint, t.today, live.Timestamp)
+		c.StandingCharge += charge
+		c.Vat += vat
+
+		live.Today.add(c.withTotal())
+	}
+
+	return live
+}
@@ -0,0 +1,337 @@
+// This package turns energy consumption into money, using the unit rates
+// and standing charges of the tariffs that the meters were on at the time.
+//
+// All amounts are in pence and include VAT, with the VAT part given
+// separately. Export earnings are handled by the flow package instead.
+package cost
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"slices"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// The hours of each day during which a time-of-use band applies, in the
+// tariffs' time zone.
+type Hours struct {
+	// The start of the band, as the time since midnight.
+	Start time.Duration
+	// The end of the band, as the time since midnight. If it is before
+	// Start, the band runs past midnight.
+	End time.Duration
+}
+
+// The night hours of an Economy 7 meter. These vary by region and meter,
+// so they can be changed with [WithNightHours].
+var DefaultNightHours = Hours{
+	Start: 30 * time.Minute,
+	End:   7*time.Hour + 30*time.Minute,
+}
+
+// The off-peak hours of Intelligent Octopus Go, which are the same in every
+// region.
+var IntelligentOffPeakHours = Hours{
+	Start: 23*time.Hour + 30*time.Minute,
+	End:   5*time.Hour + 30*time.Minute,
+}
+
+// Parses hours in the form "00:30-07:30".
+func ParseHours(s string) (Hours, error) {
+	var startHour, startMinute, endHour, endMinute int
+
+	_, err := fmt.Sscanf(s, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	if err != nil || startHour > 24 || endHour > 24 || startMinute >= 60 || endMinute >= 60 {
+		return Hours{}, fmt.Errorf("Invalid hours %q: expected e.g. 00:30-07:30", s)
+	}
+
+	return Hours{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute,
+	}, nil
+}
+
+// Checks if the hours are set.
+func (h Hours) IsZero() bool {
+	return h.Start == h.End
+}
+
+// Checks if the time of day of t falls within the hours.
+func (h Hours) Contains(t time.Time) bool {
+	if h.IsZero() {
+		return false
+	}
+
+	sinceMidnight := t.Sub(startOfDay(t))
+	if h.Start < h.End {
+		return sinceMidnight >= h.Start && sinceMidnight < h.End
+	}
+	return sinceMidnight >= h.Start || sinceMidnight < h.End
+}
+
+// Returns midnight at the start of t's day, in t's location.
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// The tariffs of a set of meter points, used to look up the unit rate and
+// standing charge in force at any time.
+type Tariffs struct {
+	// The agreements of each meter point, by MPAN or MPRN, oldest first.
+	agreements map[string][]*octopus.Agreement
+	// The time zone that days and time-of-use hours are in.
+	location *time.Location
+	// When the night rate of day/night and three-rate tariffs applies.
+	nightHours Hours
+	// When the off-peak rate of three-rate tariffs applies.
+	offPeakHours Hours
+	// The most that the energy used on a meter point in one day can cost,
+	// in pence. No cap if zero.
+	dailyCap float64
+	// The Intelligent Octopus dispatches of each account, by account number.
+	dispatches map[string][]*octopus.Dispatch
+}
+
+// Configures [Tariffs] created with [NewTariffs].
+type Option func(*Tariffs)
+
+// Sets the time zone of days and time-of-use hours. Defaults to the local
+// time zone.
+func WithLocation(location *time.Location) Option {
+	return func(t *Tariffs) {
+		t.location = location
+	}
+}
+
+// Sets when the night rate applies. Defaults to [DefaultNightHours].
+// Intelligent Octopus Go tariffs always use [IntelligentOffPeakHours].
+func WithNightHours(hours Hours) Option {
+	return func(t *Tariffs) {
+		t.nightHours = hours
+	}
+}
+
+// Sets when the off-peak rate of three-rate tariffs applies. The off-peak
+// rate is unused unless this is set.
+func WithOffPeakHours(hours Hours) Option {
+	return func(t *Tariffs) {
+		t.offPeakHours = hours
+	}
+}
+
+// Caps the cost of the energy used on each meter point in one day, in pence
+// including VAT. Standing charges are not capped.
+func WithDailyCap(cap float64) Option {
+	return func(t *Tariffs) {
+		t.dailyCap = cap
+	}
+}
+
+// Bills the electricity used in any half hour that overlaps one of the
+// given Intelligent Octopus dispatches at the off-peak rate, as Octopus
+// does, whatever the time of day.
+func WithDispatches(dispatches []*octopus.Dispatch) Option {
+	return func(t *Tariffs) {
+		for _, d := range dispatches {
+			t.dispatches[d.AccountNumber] = append(t.dispatches[d.AccountNumber], d)
+		}
+	}
+}
+
+// Creates [Tariffs] from the given agreements.
+func NewTariffs(agreements []*octopus.Agreement, opts ...Option) *Tariffs {
+	t := &Tariffs{
+		agreements: map[string][]*octopus.Agreement{},
+		location:   time.Local,
+		nightHours: DefaultNightHours,
+		dispatches: map[string][]*octopus.Dispatch{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	for _, a := range agreements {
+		t.agreements[a.MeterPoint] = append(t.agreements[a.MeterPoint], a)
+	}
+	for _, as := range t.agreements {
+		slices.SortFunc(as, func(a, b *octopus.Agreement) int {
+			return a.ValidFrom.Compare(b.ValidFrom)
+		})
+	}
+
+	return t
+}
+
+// Returns the agreement of the meter point in force at the given time, or
+// nil if there is none.
+func (t *Tariffs) agreementAt(meterPoint string, at time.Time) *octopus.Agreement {
+	for _, a := range t.agreements[meterPoint] {
+		if a.ActiveAt(at) {
+			return a
+		}
+	}
+	return nil
+}
+
+// Creates [Tariffs] from the agreements and dispatches in the store.
+func StoredTariffs(s *store.Store, opts ...Option) (*Tariffs, error) {
+	agreements, err := s.Agreements("")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load tariffs: %w", err)
+	}
+	dispatches, err := s.Dispatches("", time.Time{}, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load dispatches: %w", err)
+	}
+
+	return NewTariffs(agreements, append(slices.Clone(opts), WithDispatches(dispatches))...), nil
+}
+
+// Checks if the half hour containing the given time overlaps a dispatch of
+// the given account.
+func (t *Tariffs) inDispatch(accountNumber string, at time.Time) bool {
+	slotStart := at.Truncate(pricingInterval)
+	slotEnd := slotStart.Add(pricingInterval)
+
+	for _, d := range t.dispatches[accountNumber] {
+		if d.Start.Before(slotEnd) && d.End.After(slotStart) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the agreement is on Intelligent Octopus Go, going by its product
+// code, e.g. INTELLI-VAR-22-10-14.
+func isIntelligentGo(a *octopus.Agreement) bool {
+	return strings.HasPrefix(a.ProductCode, "INTELLI-") && !strings.Contains(a.ProductCode, "FLUX")
+}
+
+// Returns when the night rate of the agreement applies.
+func (t *Tariffs) nightHoursOf(a *octopus.Agreement) Hours {
+	if isIntelligentGo(a) {
+		return IntelligentOffPeakHours
+	}
+	return t.nightHours
+}
+
+// Checks if the given time falls in a half hour that the agreement bills
+// at its off-peak rate because of an Intelligent Octopus dispatch.
+func (t *Tariffs) dispatchedAt(a *octopus.Agreement, at time.Time) bool {
+	return a.Fuel != octopus.FuelGas && t.inDispatch(a.AccountNumber, at)
+}
+
+// Returns the time-of-use band that applies at the given time under the
+// given agreement.
+func (t *Tariffs) bandAt(a *octopus.Agreement, at time.Time) octopus.RateBand {
+	hasBand := func(band octopus.RateBand) bool {
+		return slices.ContainsFunc(a.UnitRates, func(r octopus.Rate) bool {
+			return r.Band == band
+		})
+	}
+
+	// Intelligent Octopus tariffs are day/night tariffs whose night rate is
+	// the off-peak rate
+	if t.dispatchedAt(a, at) {
+		if hasBand(octopus.BandNight) {
+			return octopus.BandNight
+		}
+		if hasBand(octopus.BandOffPeak) {
+			return octopus.BandOffPeak
+		}
+	}
+
+	if hasBand(octopus.BandStandard) {
+		return octopus.BandStandard
+	}
+
+	at = at.In(t.location)
+	if hasBand(octopus.BandOffPeak) && t.offPeakHours.Contains(at) {
+		return octopus.BandOffPeak
+	}
+	if hasBand(octopus.BandNight) && t.nightHoursOf(a).Contains(at) {
+		return octopus.BandNight
+	}
+	return octopus.BandDay
+}
+
+// Returns the rate of the given band of the agreement in force at the given
+// time, or false if there is none.
+func rateAt(a *octopus.Agreement, band octopus.RateBand, at time.Time) (octopus.Rate, bool) {
+	for _, r := range a.UnitRates {
+		if r.Band != band {
+			continue
+		}
+		if !at.Before(r.ValidFrom) && (r.ValidTo == nil || at.Before(*r.ValidTo)) {
+			return r, true
+		}
+	}
+	return octopus.Rate{}, false
+}
+
+// Returns the start of the latest night hours of the agreement that began
+// at or before the given time.
+func (t *Tariffs) lastNightStart(a *octopus.Agreement, at time.Time) time.Time {
+	at = at.In(t.location)
+	start := startOfDay(at).Add(t.nightHoursOf(a).Start)
+	if start.After(at) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+// Returns the unit rate that the meter point paid at the given time, or
+// false if it isn't known.
+func (t *Tariffs) UnitRate(meterPoint string, at time.Time) (octopus.Rate, bool) {
+	a := t.agreementAt(meterPoint, at)
+	if a == nil {
+		return octopus.Rate{}, false
+	}
+
+	band := t.bandAt(a, at)
+
+	// Single-rate tariffs, e.g. the half-hourly rates of Intelligent
+	// Octopus Go, have no off-peak band, so dispatches are billed at the
+	// rate of the last night's off-peak hours
+	if band == octopus.BandStandard && t.dispatchedAt(a, at) {
+		if r, ok := rateAt(a, band, t.lastNightStart(a, at)); ok {
+			return r, true
+		}
+	}
+
+	return rateAt(a, band, at)
+}
+
+// Returns the standing charges of the meter point between from and to,
+// apportioned by time from the daily charge of each agreement in force,
+// and the VAT included in them.
+func (t *Tariffs) StandingCharge(meterPoint string, from, to time.Time) (charge float64, vat float64) {
+	for _, a := range t.agreements[meterPoint] {
+		start := a.ValidFrom
+		if from.After(start) {
+			start = from
+		}
+		end := to
+		if a.ValidTo != nil && a.ValidTo.Before(end) {
+			end = *a.ValidTo
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		days := float64(end.Sub(start)) / float64(day)
+		charge += days * a.StandingCharge
+		vat += days * (a.StandingCharge - a.PreVatStandingCharge)
+	}
+	return charge, vat
+}
+
+// Returns midnight at the start of t's day in the tariffs' time zone.
+func (t *Tariffs) startOfDay(at time.Time) time.Time {
+	return startOfDay(at.In(t.location))
+}
@@ -0,0 +1,344 @@
+// This package works out what each Saving Session and free electricity
+// session earned, by comparing the electricity used during it with a
+// baseline of what the account usually uses at that time, and follows
+// events live as they happen.
+package flexibility
+
+import (
+	"errors"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"math"
+	"time"
+)
+
+// Returned when there aren't enough recorded days before an event to work
+// out its baseline.
+var ErrNoBaseline = errors.New("Not enough consumption recorded before the event for a baseline")
+
+// The number of Octopoints that are worth a penny.
+const PointsPerPenny = 8
+
+// The size of the slots that events are measured in.
+const slotLength = 30 * time.Minute
+
+// Like Octopus, the baseline of a weekday event is the average of the same
+// half hours on the previous 10 weekdays, and of a weekend event the
+// previous 4 weekend days, leaving out days with other Saving Sessions.
+const (
+	baselineWeekdays    = 10
+	baselineWeekendDays = 4
+)
+
+// How far back days are looked for to make up a baseline.
+const maxBaselineLookback = 60
+
+// How long after an event ends it is evaluated again, in case readings
+// missed at the time have since been backfilled.
+const reevaluateWindow = 7 * 24 * time.Hour
+
+// What a flexibility event earned, from the electricity recorded during it.
+// Energy is in Wh and money in pence.
+type Result struct {
+	Event *octopus.FlexibilityEvent `json:"event"`
+	// Whether the event has been evaluated. The figures below are zero
+	// until it has, e.g. while it is upcoming.
+	Evaluated bool `json:"evaluated"`
+	// When the event was evaluated.
+	EvaluatedAt time.Time `json:"evaluatedAt"`
+	// The energy the account usually uses over the event.
+	Baseline int `json:"baseline"`
+	// The energy the account used over the event.
+	Actual int `json:"actual"`
+	// The energy used below the baseline, counted in each half hour, so
+	// that a half hour over the baseline doesn't cancel out the others.
+	Saved int `json:"saved"`
+	// The Octopoints expected for a Saving Session. Octopus also adjusts
+	// the baseline by the usage on the day, so this is an estimate.
+	Points int `json:"points"`
+	// The money earned: the Octopoints of a Saving Session, or the cost of
+	// the electricity used in a free electricity session at the usual unit
+	// rates.
+	Savings float64 `json:"savings"`
+}
+
+// Returns the points and savings that count towards the totals of the
+// result: the Octopoints awarded by Octopus once they are known, or else
+// the estimate.
+func (r *Result) earned() (points int, savings float64) {
+	if r.Event.Type == octopus.EventSavingSession && r.Event.PointsAwarded != nil {
+		points = *r.Event.PointsAwarded
+		return points, float64(points) / PointsPerPenny
+	}
+	return r.Points, r.Savings
+}
+
+// Returns the half-hourly slots of the event.
+func slots(e *octopus.FlexibilityEvent) int {
+	return int(math.Ceil(float64(e.End.Sub(e.Start)) / float64(slotLength)))
+}
+
+// Returns the electricity import meters of the account among devices.
+func accountMeters(devices []octopus.Device, accountNumber string) []octopus.Device {
+	meters := []octopus.Device{}
+	for _, device := range devices {
+		if device.AccountNumber == accountNumber && device.Meter == octopus.ElectricityImport {
+			meters = append(meters, device)
+		}
+	}
+	return meters
+}
+
+// Returns the energy the meter imported in each of the n half hours from
+// from, and whether every half hour has readings.
+func meterSlots(s *store.Store, device octopus.Device, from time.Time, n int) ([]int, bool, error) {
+	buckets, err := s.ReadingBuckets(store.ReadingsQuery{
+		Meter:      device.Meter,
+		MeterId:    device.Id,
+		From:       from,
+		To:         from.Add(time.Duration(n) * slotLength),
+		Resolution: store.ResolutionHalfHour,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to load readings of meter %v: %w", device.Id, err)
+	}
+
+	consumption := make([]int, n)
+	recorded := 0
+	for _, b := range buckets {
+		i := int(b.Start.Sub(from) / slotLength)
+		if i < 0 || i >= n || b.Count == 0 {
+			continue
+		}
+		consumption[i] = b.Consumption
+		recorded++
+	}
+	return consumption, recorded == n, nil
+}
+
+// Returns the energy the meters imported in each of the n half hours from
+// from, and whether every half hour has readings from every meter.
+func accountSlots(s *store.Store, meters []octopus.Device, from time.Time, n int) ([]int, bool, error) {
+	total := make([]int, n)
+	complete := true
+	for _, device := range meters {
+		consumption, ok, err := meterSlots(s, device, from, n)
+		if err != nil {
+			return nil, false, err
+		}
+		for i, c := range consumption {
+			total[i] += c
+		}
+		complete = complete && ok
+	}
+	return total, complete, nil
+}
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+// Returns the baseline of each half hour of the event: the average energy
+// the meters imported in the same half hour on the previous 10 weekdays,
+// or 4 weekend days for an event at the weekend. Days with another Saving
+// Session joined by the account, or without readings in every half hour,
+// are skipped. Returns [ErrNoBaseline] if no day has complete readings.
+func baseline(s *store.Store, meters []octopus.Device, event *octopus.FlexibilityEvent) ([]int, error) {
+	start := event.Start.Local()
+	weekend := isWeekend(start)
+	want := baselineWeekdays
+	if weekend {
+		want = baselineWeekendDays
+	}
+
+	sessions, err := s.FlexibilityEvents(event.AccountNumber, start.AddDate(0, 0, -maxBaselineLookback), start)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load previous events: %w", err)
+	}
+	sessionDays := map[string]bool{}
+	for _, r := range sessions {
+		if r.Event.Type == octopus.EventSavingSession && r.Event.Joined {
+			sessionDays[r.Event.Start.Local().Format(time.DateOnly)] = true
+		}
+	}
+
+	n := slots(event)
+	total := make([]int, n)
+	days := 0
+
+	for d := 1; d <= maxBaselineLookback && days < want; d++ {
+		from := start.AddDate(0, 0, -d)
+		if isWeekend(from) != weekend || sessionDays[from.Format(time.DateOnly)] {
+			continue
+		}
+
+		consumption, complete, err := accountSlots(s, meters, from, n)
+		if err != nil {
+			return nil, err
+		}
+		if !complete {
+			continue
+		}
+
+		for i, c := range consumption {
+			total[i] += c
+		}
+		days++
+	}
+
+	if days == 0 {
+		return nil, ErrNoBaseline
+	}
+
+	for i := range total {
+		total[i] = int(math.Round(float64(total[i]) / float64(days)))
+	}
+	return total, nil
+}
+
+// Returns the Octopoints earned by saving energy in a Saving Session.
+func points(event *octopus.FlexibilityEvent, saved int) int {
+	if event.Type != octopus.EventSavingSession {
+		return 0
+	}
+	return int(math.Round(float64(saved) / 1000 * float64(event.RewardPerKwh)))
+}
+
+// Works out what the event earned from the electricity that the account's
+// import meters among devices used during it, up to now. Free electricity
+// is valued with tariffs. Returns [ErrNoBaseline] if there isn't enough
+// consumption recorded before the event.
+func Evaluate(s *store.Store, tariffs *cost.Tariffs, devices []octopus.Device, event *octopus.FlexibilityEvent, now time.Time) (*Result, error) {
+	meters := accountMeters(devices, event.AccountNumber)
+
+	base, err := baseline(s, meters, event)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to work out the baseline of event %v: %w", event.Code, err)
+	}
+
+	r := &Result{Event: event, Evaluated: true, EvaluatedAt: now}
+
+	// Only the half hours that have started so far
+	n := min(slots(event), max(0, int(math.Ceil(float64(now.Sub(event.Start))/float64(slotLength)))))
+
+	actual := make([]int, n)
+	for _, device := range meters {
+		consumption, _, err := meterSlots(s, device, event.Start, n)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, c := range consumption {
+			actual[i] += c
+
+			if event.Type == octopus.EventFreeElectricity {
+				rate, ok := tariffs.UnitRate(device.MeterPoint, event.Start.Add(time.Duration(i)*slotLength))
+				if ok {
+					r.Savings += float64(c) / 1000 * rate.Value
+				}
+			}
+		}
+	}
+
+	for i, c := range actual {
+		r.Baseline += base[i]
+		r.Actual += c
+		if c < base[i] {
+			r.Saved += base[i] - c
+		}
+	}
+
+	r.Points = points(event, r.Saved)
+	if event.Type == octopus.EventSavingSession {
+		r.Savings = float64(r.Points) / PointsPerPenny
+	}
+
+	return r, nil
+}
+
+// Evaluates the joined events that have ended recently, or that have never
+// been evaluated, and saves their results, returning them. Events that
+// can't be evaluated for want of a baseline are skipped.
+func EvaluateEnded(s *store.Store, tariffs *cost.Tariffs, devices []octopus.Device, now time.Time) ([]*Result, error) {
+	records, err := s.FlexibilityEvents("", time.Time{}, now)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load events: %w", err)
+	}
+
+	results := []*Result{}
+	for _, record := range records {
+		e := record.Event
+		if !e.Joined || e.End.After(now) {
+			continue
+		}
+		if record.Result != nil && now.Sub(e.End) > reevaluateWindow {
+			continue
+		}
+
+		r, err := Evaluate(s, tariffs, devices, e, now)
+		if errors.Is(err, ErrNoBaseline) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.SaveEventResult(e, &store.EventResult{
+			EvaluatedAt: r.EvaluatedAt,
+			Baseline:    r.Baseline,
+			Actual:      r.Actual,
+			Saved:       r.Saved,
+			Points:      r.Points,
+			Savings:     r.Savings,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to save result of event %v: %w", e.Code, err)
+		}
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// The events of an account and what they earned.
+type History struct {
+	// The events, oldest first, including upcoming ones.
+	Events []*Result `json:"events"`
+	// The Octopoints earned across the events, counting those awarded by
+	// Octopus where they are known, and the estimates otherwise.
+	Points int `json:"points"`
+	// The money earned across the events, in pence.
+	Savings float64 `json:"savings"`
+}
+
+// Returns the stored events of the given account that overlap the window
+// between from and to, with their results and totals.
+func LoadHistory(s *store.Store, accountNumber string, from, to time.Time) (*History, error) {
+	records, err := s.FlexibilityEvents(accountNumber, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load events: %w", err)
+	}
+
+	h := &History{Events: []*Result{}}
+	for _, record := range records {
+		r := &Result{Event: record.Event}
+		if record.Result != nil {
+			r.Evaluated = true
+			r.EvaluatedAt = record.Result.EvaluatedAt
+			r.Baseline = record.Result.Baseline
+			r.Actual = record.Result.Actual
+			r.Saved = record.Result.Saved
+			r.Points = record.Result.Points
+			r.Savings = record.Result.Savings
+		}
+
+		points, savings := r.earned()
+		h.Points += points
+		h.Savings += savings
+		h.Events = append(h.Events, r)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,252 @@
+package flexibility
+
+import (
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"martin-walls/octopus-energy-tracker/internal/store/storetest"
+	"math"
+	"testing"
+	"time"
+)
+
+// Readings are recorded from the start of Wednesday 1st January.
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+
+const testAccount = "A-12345678"
+
+var testDevice = octopus.Device{
+	Id:            "00-00-00-00-00-00-00-01",
+	AccountNumber: testAccount,
+	Meter:         octopus.ElectricityImport,
+	MeterPoint:    "1000000000001",
+}
+
+// Returns the start of the given hour on the given day of January.
+func at(day, hour int) time.Time {
+	return time.Date(2025, 1, day, hour, 0, 0, 0, time.Local)
+}
+
+// A Saving Session on Tuesday 21st, from 5pm to 6pm.
+var testSession = &octopus.FlexibilityEvent{
+	AccountNumber: testAccount,
+	Type:          octopus.EventSavingSession,
+	Code:          "EVENT_2",
+	Start:         at(21, 17),
+	End:           at(21, 18),
+	RewardPerKwh:  1800,
+	Joined:        true,
+}
+
+// A Saving Session on Monday 20th, in which nothing was used.
+var previousSession = &octopus.FlexibilityEvent{
+	AccountNumber: testAccount,
+	Type:          octopus.EventSavingSession,
+	Code:          "EVENT_1",
+	Start:         at(20, 17),
+	End:           at(20, 18),
+	RewardPerKwh:  1800,
+	Joined:        true,
+}
+
+// A free electricity session on Sunday 19th, from 1pm to 2pm.
+var freeSession = &octopus.FlexibilityEvent{
+	AccountNumber: testAccount,
+	Type:          octopus.EventFreeElectricity,
+	Code:          "FREE_1",
+	Start:         at(19, 13),
+	End:           at(19, 14),
+	Joined:        true,
+}
+
+// Opens a fresh store with the test events, and half-hourly readings up to
+// the given time from testStart. Each reading adds 500Wh, except during
+// the Saving Sessions, when 200Wh is used on the 21st and none on the
+// 20th, and the free session, when 1000Wh is used. The meter is on a flat
+// tariff of 20p/kWh.
+func newTestStore(t *testing.T, until time.Time) *store.Store {
+	t.Helper()
+
+	s := storetest.Open(t)
+
+	err := s.SaveFlexibilityEvents([]*octopus.FlexibilityEvent{previousSession, testSession, freeSession})
+	if err != nil {
+		t.Fatalf("SaveFlexibilityEvents: %v", err)
+	}
+
+	err = s.SaveAgreements([]*octopus.Agreement{{
+		Id:            1,
+		AccountNumber: testAccount,
+		MeterPoint:    testDevice.MeterPoint,
+		ValidFrom:     testStart,
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandStandard, ValidFrom: testStart, Value: 20},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("SaveAgreements: %v", err)
+	}
+
+	within := func(ts time.Time, e *octopus.FlexibilityEvent) bool {
+		return !ts.Before(e.Start) && ts.Before(e.End)
+	}
+
+	rs := []*octopus.ConsumptionReading{}
+	total := 0
+	for ts := testStart; !ts.After(until); ts = ts.Add(30 * time.Minute) {
+		switch {
+		case within(ts, testSession):
+			total += 200
+		case within(ts, previousSession):
+		case within(ts, freeSession):
+			total += 1000
+		default:
+			total += 500
+		}
+		rs = append(rs, &octopus.ConsumptionReading{
+			Timestamp:        ts,
+			MeterId:          testDevice.Id,
+			TotalConsumption: total,
+		})
+	}
+	err = s.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	return s
+}
+
+func newTariffs(t *testing.T, s *store.Store) *cost.Tariffs {
+	t.Helper()
+
+	agreements, err := s.Agreements("")
+	if err != nil {
+		t.Fatalf("Agreements: %v", err)
+	}
+	return cost.NewTariffs(agreements)
+}
+
+func TestEvaluate(t *testing.T) {
+	s := newTestStore(t, at(22, 0))
+
+	r, err := Evaluate(s, newTariffs(t, s), []octopus.Device{testDevice}, testSession, at(22, 0))
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+
+	// Monday's session is left out of the baseline
+	if r.Baseline != 1000 || r.Actual != 400 || r.Saved != 600 {
+		t.Errorf("Evaluate() = %+v, want 600Wh saved against a baseline of 1000Wh", r)
+	}
+	// 0.6kWh at 1800 points/kWh, at 8 points a penny
+	if r.Points != 1080 || math.Abs(r.Savings-135) > 1e-6 {
+		t.Errorf("Evaluate() = %v points worth %vp, want 1080 worth 135p", r.Points, r.Savings)
+	}
+}
+
+func TestEvaluateFreeElectricity(t *testing.T) {
+	s := newTestStore(t, at(20, 0))
+
+	r, err := Evaluate(s, newTariffs(t, s), []octopus.Device{testDevice}, freeSession, at(20, 0))
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+
+	// The baseline is from the previous weekend days
+	if r.Baseline != 1000 || r.Actual != 2000 || r.Saved != 0 || r.Points != 0 {
+		t.Errorf("Evaluate() = %+v, want 2000Wh used against a baseline of 1000Wh", r)
+	}
+	// 2kWh at 20p/kWh, for free
+	if math.Abs(r.Savings-40) > 1e-6 {
+		t.Errorf("Savings = %v, want 40", r.Savings)
+	}
+}
+
+func TestEvaluateNoBaseline(t *testing.T) {
+	s := newTestStore(t, at(2, 0))
+
+	event := &octopus.FlexibilityEvent{AccountNumber: testAccount, Type: octopus.EventSavingSession, Start: at(1, 17), End: at(1, 18)}
+	_, err := Evaluate(s, newTariffs(t, s), []octopus.Device{testDevice}, event, at(2, 0))
+	if !errors.Is(err, ErrNoBaseline) {
+		t.Errorf("Evaluate() error = %v, want %v", err, ErrNoBaseline)
+	}
+}
+
+func TestEvaluateEnded(t *testing.T) {
+	s := newTestStore(t, at(22, 0))
+
+	results, err := EvaluateEnded(s, newTariffs(t, s), []octopus.Device{testDevice}, at(22, 0))
+	if err != nil {
+		t.Fatalf("EvaluateEnded: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("EvaluateEnded() returned %v results, want 3", len(results))
+	}
+
+	// Octopus awards the points of Tuesday's session
+	awarded := 1000
+	session := *testSession
+	session.PointsAwarded = &awarded
+	err = s.SaveFlexibilityEvents([]*octopus.FlexibilityEvent{&session})
+	if err != nil {
+		t.Fatalf("SaveFlexibilityEvents: %v", err)
+	}
+
+	h, err := LoadHistory(s, testAccount, at(19, 0), at(22, 0))
+	if err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+	if len(h.Events) != 3 || !h.Events[2].Evaluated || h.Events[2].Points != 1080 {
+		t.Fatalf("LoadHistory() = %+v, want the three evaluated events", h.Events)
+	}
+	// Monday's session saved 1000Wh, and Tuesday's points were awarded
+	if h.Points != 1800+1000 || math.Abs(h.Savings-(40+2800.0/8)) > 1e-6 {
+		t.Errorf("LoadHistory() = %v points and %vp, want 2800 points and 390p", h.Points, h.Savings)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	s := newTestStore(t, at(21, 17))
+
+	tracker := NewTracker(s, []octopus.Device{testDevice})
+	tracker.SetEvents([]*octopus.FlexibilityEvent{previousSession, testSession})
+
+	reading := func(ts time.Time, total int, demand int) *octopus.ConsumptionReading {
+		return &octopus.ConsumptionReading{Timestamp: ts, MeterId: testDevice.Id, TotalConsumption: total, Demand: demand}
+	}
+
+	// Before the session
+	p, err := tracker.Update(reading(at(21, 16), 0, 1000))
+	if err != nil || p != nil {
+		t.Errorf("Update() before the session = %+v, %v, want nil", p, err)
+	}
+
+	// A quarter hour in, the 200Wh stored since 5pm is loaded
+	p, err = tracker.Update(reading(at(21, 17).Add(15*time.Minute), 100000, 400))
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if p == nil || p.BaselineDemand != 1000 || p.Demand != 400 || p.Difference != 600 {
+		t.Fatalf("Update() = %+v, want 600W under a baseline of 1000W", p)
+	}
+	if p.Baseline != 250 || p.Actual != 200 || p.Saved != 50 || p.Points != 90 {
+		t.Errorf("Update() = %+v, want 50Wh saved so far", p)
+	}
+
+	// Later readings are counted live
+	p, err = tracker.Update(reading(at(21, 17).Add(20*time.Minute), 100100, 1200))
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if p.Difference != -200 || p.Actual != 300 || p.Saved != 33 {
+		t.Errorf("Update() = %+v, want 200W over the baseline with 33Wh saved", p)
+	}
+
+	// After the session
+	p, err = tracker.Update(reading(at(21, 18), 100200, 400))
+	if err != nil || p != nil {
+		t.Errorf("Update() after the session = %+v, %v, want nil", p, err)
+	}
+}
@@ -0,0 +1,239 @@
+package flexibility
+
+import (
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"math"
+	"sync"
+	"time"
+)
+
+// Readings older than this compared to the newest reading are left out of
+// the account's demand.
+const maxReadingAge = 5 * time.Minute
+
+// How an event in progress is going, compared with its baseline. Energy is
+// in Wh.
+type Progress struct {
+	Event *octopus.FlexibilityEvent `json:"event"`
+	// The time of the latest reading.
+	Timestamp time.Time `json:"timestamp"`
+	// The average demand of the baseline over the current half hour, in W.
+	BaselineDemand int `json:"baselineDemand"`
+	// The latest demand of the account's meters, in W.
+	Demand int `json:"demand"`
+	// The baseline demand less the demand, in W. Positive when under the
+	// baseline.
+	Difference int `json:"difference"`
+	// The baseline energy of the event so far, with the current half hour
+	// apportioned up to the latest reading.
+	Baseline int `json:"baseline"`
+	// The energy used since the event started.
+	Actual int `json:"actual"`
+	// The energy used below the baseline so far.
+	Saved int `json:"saved"`
+	// The Octopoints expected so far for a Saving Session.
+	Points int `json:"points"`
+}
+
+// An event being followed by a [Tracker].
+type liveEvent struct {
+	event *octopus.FlexibilityEvent
+	// The baseline of each half hour of the event. Nil if there isn't
+	// enough history for one.
+	baseline []int
+	// The energy used in each half hour of the event so far.
+	actual []int
+	// When the energy used was loaded up to from the store. Only readings
+	// after this are counted live.
+	loadedUntil time.Time
+}
+
+// Follows joined flexibility events as they happen, from live readings of
+// the electricity import meters. It is safe to use from several goroutines.
+type Tracker struct {
+	lock sync.Mutex
+	// Where baselines and the energy used so far are loaded from.
+	store *store.Store
+	// The electricity import meters being followed, by device ID.
+	devices map[string]octopus.Device
+	// The joined events, oldest first. Set these with [Tracker.SetEvents].
+	events []*octopus.FlexibilityEvent
+	// The events that have started, by account number and start time.
+	live map[eventKey]*liveEvent
+	// The latest reading from each meter, by device ID.
+	latest map[string]*octopus.ConsumptionReading
+}
+
+type eventKey struct {
+	accountNumber string
+	eventType     octopus.EventType
+	start         time.Time
+}
+
+func keyOf(e *octopus.FlexibilityEvent) eventKey {
+	return eventKey{e.AccountNumber, e.Type, e.Start.UTC()}
+}
+
+// Creates a new [Tracker] for the electricity import meters among devices,
+// following no events until [Tracker.SetEvents] is called.
+func NewTracker(s *store.Store, devices []octopus.Device) *Tracker {
+	t := &Tracker{
+		store:   s,
+		devices: map[string]octopus.Device{},
+		live:    map[eventKey]*liveEvent{},
+		latest:  map[string]*octopus.ConsumptionReading{},
+	}
+
+	for _, device := range devices {
+		if device.Meter == octopus.ElectricityImport {
+			t.devices[device.Id] = device
+		}
+	}
+
+	return t
+}
+
+// Follows the joined events among events from now on. Events that have
+// already started keep their progress.
+func (t *Tracker) SetEvents(events []*octopus.FlexibilityEvent) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.events = []*octopus.FlexibilityEvent{}
+	live := map[eventKey]*liveEvent{}
+	for _, e := range events {
+		if !e.Joined {
+			continue
+		}
+		t.events = append(t.events, e)
+		if l, ok := t.live[keyOf(e)]; ok {
+			l.event = e
+			live[keyOf(e)] = l
+		}
+	}
+	t.live = live
+}
+
+// Returns the event of the account in progress at the given time, or nil if
+// there is none. The lock must be held.
+func (t *Tracker) eventAt(accountNumber string, at time.Time) *octopus.FlexibilityEvent {
+	for _, e := range t.events {
+		if e.AccountNumber == accountNumber && !at.Before(e.Start) && at.Before(e.End) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Starts following an event, loading its baseline and the energy used so
+// far up to the given time from the store. The lock must be held.
+func (t *Tracker) start(e *octopus.FlexibilityEvent, at time.Time) (*liveEvent, error) {
+	meters := []octopus.Device{}
+	for _, device := range t.devices {
+		if device.AccountNumber == e.AccountNumber {
+			meters = append(meters, device)
+		}
+	}
+
+	l := &liveEvent{event: e, actual: make([]int, slots(e)), loadedUntil: at}
+	t.live[keyOf(e)] = l
+
+	base, err := baseline(t.store, meters, e)
+	if errors.Is(err, ErrNoBaseline) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	l.baseline = base
+
+	// The half hours that have started, including the current one
+	n := min(slots(e), int(at.Sub(e.Start)/slotLength)+1)
+	actual, _, err := accountSlots(t.store, meters, e.Start, n)
+	if err != nil {
+		return l, err
+	}
+	copy(l.actual, actual)
+
+	return l, nil
+}
+
+// Records a live reading. Returns the progress of the event in progress on
+// the reading's account, or nil if there is none, the reading isn't from
+// an electricity import meter, or the event has no baseline. The error is
+// only returned when the event is first seen.
+func (t *Tracker) Update(r *octopus.ConsumptionReading) (*Progress, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	device, ok := t.devices[r.MeterId]
+	if !ok || r.Meter() != device.Meter {
+		return nil, nil
+	}
+
+	previous := t.latest[r.MeterId]
+	if previous != nil && !r.Timestamp.After(previous.Timestamp) {
+		return nil, nil
+	}
+	t.latest[r.MeterId] = r
+
+	e := t.eventAt(device.AccountNumber, r.Timestamp)
+	if e == nil {
+		return nil, nil
+	}
+
+	l, ok := t.live[keyOf(e)]
+	if !ok {
+		var err error
+		l, err = t.start(e, r.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+	} else if previous != nil && r.Timestamp.After(l.loadedUntil) {
+		if delta := r.TotalConsumption - previous.TotalConsumption; delta > 0 {
+			l.actual[int(r.Timestamp.Sub(e.Start)/slotLength)] += delta
+		}
+	}
+
+	if l.baseline == nil {
+		return nil, nil
+	}
+	return t.progress(l, device.AccountNumber, r.Timestamp), nil
+}
+
+// Returns the progress of the event at the given time. The lock must be
+// held.
+func (t *Tracker) progress(l *liveEvent, accountNumber string, at time.Time) *Progress {
+	p := &Progress{Event: l.event, Timestamp: at}
+
+	for id, latest := range t.latest {
+		if t.devices[id].AccountNumber == accountNumber && at.Sub(latest.Timestamp) <= maxReadingAge {
+			p.Demand += latest.Demand
+		}
+	}
+
+	current := int(at.Sub(l.event.Start) / slotLength)
+	// A half hour at a demand of 1W uses 0.5Wh
+	p.BaselineDemand = l.baseline[current] * int(time.Hour/slotLength)
+	p.Difference = p.BaselineDemand - p.Demand
+
+	for i := 0; i <= current; i++ {
+		base := float64(l.baseline[i])
+		if i == current {
+			elapsed := at.Sub(l.event.Start.Add(time.Duration(i) * slotLength))
+			base *= float64(elapsed) / float64(slotLength)
+		}
+		b := int(math.Round(base))
+
+		p.Baseline += b
+		p.Actual += l.actual[i]
+		if l.actual[i] < b {
+			p.Saved += b - l.actual[i]
+		}
+	}
+	p.Points = points(l.event, p.Saved)
+
+	return p
+}
@@ -0,0 +1,184 @@
+// This package combines the readings of the electricity import and export
+// meters into the net flow of energy to and from the grid, and the money
+// earned from exporting.
+//
+// Neither meter measures generation, so self-consumption of solar power
+// can't be derived here; energy used directly in the home never reaches
+// either meter.
+package flow
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How far apart the latest readings can be and still be combined into a
+// [NetFlow]. Readings older than this compared to the newest reading are
+// left out.
+const maxReadingSkew = time.Minute
+
+// The flow of power to and from the grid at a point in time.
+type NetFlow struct {
+	// The time of the latest reading.
+	Timestamp time.Time `json:"timestamp"`
+	// Power drawn from the grid, in W.
+	ImportDemand int `json:"importDemand"`
+	// Power sent to the grid, in W.
+	ExportDemand int `json:"exportDemand"`
+	// Import minus export, in W. Negative while exporting.
+	NetDemand int `json:"netDemand"`
+	// How quickly exporting is earning money, in pence per hour. Zero if
+	// there is no export rate.
+	ExportEarningsPerHour float64 `json:"exportEarningsPerHour"`
+}
+
+// Returns the money earned for exporting the given energy in Wh, at a rate
+// in pence per kWh.
+func ExportEarnings(exported int, exportRate float64) float64 {
+	return float64(exported) / 1000 * exportRate
+}
+
+// Keeps the latest live reading from each electricity meter and combines
+// them into a [NetFlow]. The demand of several import or export meters is
+// summed. It is safe to use from several goroutines.
+type Tracker struct {
+	lock sync.Mutex
+	// The export rate, in pence per kWh.
+	exportRate float64
+	// The latest reading from each meter.
+	latest map[meterKey]*octopus.ConsumptionReading
+}
+
+// Identifies a meter. Readings stored before meters had IDs have an empty
+// meter ID, so the kind of meter is needed too.
+type meterKey struct {
+	meter octopus.Meter
+	id    string
+}
+
+// Creates a new [Tracker]. Export is paid at exportRate, in pence per kWh.
+func NewTracker(exportRate float64) *Tracker {
+	return &Tracker{
+		exportRate: exportRate,
+		latest:     map[meterKey]*octopus.ConsumptionReading{},
+	}
+}
+
+// Records a live reading. Returns the net flow if there are recent readings
+// from both an import and an export meter, or nil otherwise. Gas readings
+// are ignored.
+func (t *Tracker) Update(r *octopus.ConsumptionReading) *NetFlow {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if r.Meter().Fuel != octopus.FuelElectricity {
+		return nil
+	}
+	t.latest[meterKey{meter: r.Meter(), id: r.MeterId}] = r
+
+	timestamp := r.Timestamp
+	for _, latest := range t.latest {
+		if latest.Timestamp.After(timestamp) {
+			timestamp = latest.Timestamp
+		}
+	}
+
+	netFlow := &NetFlow{Timestamp: timestamp}
+	hasImport, hasExport := false, false
+
+	for _, latest := range t.latest {
+		if timestamp.Sub(latest.Timestamp) > maxReadingSkew {
+			continue
+		}
+
+		if latest.Meter() == octopus.ElectricityExport {
+			netFlow.ExportDemand += latest.Demand
+			hasExport = true
+		} else {
+			netFlow.ImportDemand += latest.Demand
+			hasImport = true
+		}
+	}
+
+	if !hasImport || !hasExport {
+		return nil
+	}
+
+	netFlow.NetDemand = netFlow.ImportDemand - netFlow.ExportDemand
+	// Demand in W is the energy in Wh that an hour at that demand uses
+	netFlow.ExportEarningsPerHour = ExportEarnings(netFlow.ExportDemand, t.exportRate)
+
+	return netFlow
+}
+
+// The energy that flowed to and from the grid during a time bucket.
+type Bucket struct {
+	// The start of the bucket (inclusive).
+	Start time.Time `json:"start"`
+	// The end of the bucket (exclusive).
+	End time.Time `json:"end"`
+	// Energy drawn from the grid, in Wh.
+	Import int `json:"import"`
+	// Energy sent to the grid, in Wh.
+	Export int `json:"export"`
+	// Import minus export, in Wh. Negative if more was exported.
+	Net int `json:"net"`
+	// The money earned for the exported energy, in pence.
+	ExportEarnings float64 `json:"exportEarnings"`
+}
+
+// Combines buckets of import and export readings into flow buckets, oldest
+// first. Buckets that only one meter has readings for count as zero for the
+// other meter. Export is paid at exportRate, in pence per kWh.
+func Buckets(imports, exports []*store.ReadingBucket, exportRate float64) []*Bucket {
+	byStart := map[time.Time]*Bucket{}
+	buckets := []*Bucket{}
+
+	bucketAt := func(b *store.ReadingBucket) *Bucket {
+		if existing, ok := byStart[b.Start]; ok {
+			return existing
+		}
+		flowBucket := &Bucket{Start: b.Start, End: b.End}
+		byStart[b.Start] = flowBucket
+		buckets = append(buckets, flowBucket)
+		return flowBucket
+	}
+
+	for _, b := range imports {
+		bucketAt(b).Import = b.Consumption
+	}
+	for _, b := range exports {
+		bucketAt(b).Export = b.Consumption
+	}
+
+	for _, b := range buckets {
+		b.Net = b.Import - b.Export
+		b.ExportEarnings = ExportEarnings(b.Export, exportRate)
+	}
+
+	// Buckets only in the export list were appended after the rest
+	slices.SortFunc(buckets, func(a, b *Bucket) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	return buckets
+}
+
+// Returns the sum of the given buckets, covering all of them.
+func Total(buckets []*Bucket) Bucket {
+	total := Bucket{}
+	for i, b := range buckets {
+		if i == 0 {
+			total.Start = b.Start
+		}
+		total.End = b.End
+		total.Import += b.Import
+		total.Export += b.Export
+		total.Net += b.Net
+		total.ExportEarnings += b.ExportEarnings
+	}
+	return total
+}
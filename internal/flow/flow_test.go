@@ -0,0 +1,124 @@
+package flow
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTrackerCombinesImportAndExport(t *testing.T) {
+	tracker := NewTracker(15)
+
+	netFlow := tracker.Update(&octopus.ConsumptionReading{
+		Timestamp: testStart,
+		Demand:    300,
+	})
+	if netFlow != nil {
+		t.Fatalf("Expected no net flow without an export reading, got %+v", netFlow)
+	}
+
+	netFlow = tracker.Update(&octopus.ConsumptionReading{
+		Timestamp: testStart.Add(10 * time.Second),
+		Fuel:      octopus.FuelElectricity,
+		Direction: octopus.DirectionExport,
+		Demand:    2000,
+	})
+	if netFlow == nil {
+		t.Fatalf("Expected a net flow")
+	}
+	if netFlow.NetDemand != -1700 {
+		t.Errorf("NetDemand = %v, want -1700", netFlow.NetDemand)
+	}
+	if netFlow.ExportEarningsPerHour != 30 {
+		t.Errorf("ExportEarningsPerHour = %v, want 30", netFlow.ExportEarningsPerHour)
+	}
+	if !netFlow.Timestamp.Equal(testStart.Add(10 * time.Second)) {
+		t.Errorf("Timestamp = %v, want the later reading", netFlow.Timestamp)
+	}
+
+	// Readings too far apart aren't combined
+	netFlow = tracker.Update(&octopus.ConsumptionReading{
+		Timestamp: testStart.Add(5 * time.Minute),
+		Demand:    300,
+	})
+	if netFlow != nil {
+		t.Errorf("Expected no net flow from stale export reading, got %+v", netFlow)
+	}
+}
+
+func TestTrackerSumsMeters(t *testing.T) {
+	tracker := NewTracker(10)
+
+	readings := []*octopus.ConsumptionReading{
+		{Timestamp: testStart, MeterId: "home", Demand: 300},
+		{Timestamp: testStart, MeterId: "cottage", Demand: 200},
+		{Timestamp: testStart, MeterId: "solar", Direction: octopus.DirectionExport, Demand: 1000},
+	}
+
+	var netFlow *NetFlow
+	for _, r := range readings {
+		netFlow = tracker.Update(r)
+	}
+
+	if netFlow == nil {
+		t.Fatalf("Expected a net flow")
+	}
+	if netFlow.ImportDemand != 500 || netFlow.ExportDemand != 1000 || netFlow.NetDemand != -500 {
+		t.Errorf("Net flow = %+v, want 500W imported and 1000W exported", netFlow)
+	}
+
+	// A meter that has stopped reporting is left out
+	netFlow = tracker.Update(&octopus.ConsumptionReading{
+		Timestamp: testStart.Add(2 * time.Minute),
+		MeterId:   "solar",
+		Direction: octopus.DirectionExport,
+		Demand:    800,
+	})
+	if netFlow != nil {
+		t.Errorf("Expected no net flow without recent import readings, got %+v", netFlow)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	bucket := func(start time.Time, consumption int) *store.ReadingBucket {
+		return &store.ReadingBucket{
+			Start:       start,
+			End:         start.Add(30 * time.Minute),
+			Consumption: consumption,
+		}
+	}
+
+	imports := []*store.ReadingBucket{
+		bucket(testStart, 500),
+		bucket(testStart.Add(30*time.Minute), 100),
+	}
+	exports := []*store.ReadingBucket{
+		bucket(testStart.Add(-30*time.Minute), 200),
+		bucket(testStart.Add(30*time.Minute), 1100),
+	}
+
+	buckets := Buckets(imports, exports, 10)
+
+	expected := []Bucket{
+		{Start: testStart.Add(-30 * time.Minute), End: testStart, Import: 0, Export: 200, Net: -200, ExportEarnings: 2},
+		{Start: testStart, End: testStart.Add(30 * time.Minute), Import: 500, Export: 0, Net: 500, ExportEarnings: 0},
+		{Start: testStart.Add(30 * time.Minute), End: testStart.Add(time.Hour), Import: 100, Export: 1100, Net: -1000, ExportEarnings: 11},
+	}
+
+	if len(buckets) != len(expected) {
+		t.Fatalf("Buckets() returned %v buckets, want %v", len(buckets), len(expected))
+	}
+	for i := range expected {
+		if *buckets[i] != expected[i] {
+			t.Errorf("Bucket %v = %+v, want %+v", i, *buckets[i], expected[i])
+		}
+	}
+
+	total := Total(buckets)
+	if total.Import != 600 || total.Export != 1300 || total.Net != -700 || total.ExportEarnings != 13 {
+		t.Errorf("Total() = %+v", total)
+	}
+}
@@ -0,0 +1,145 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// An Octopus account visible to the API key.
+type Account struct {
+	// The account number (A-xxxxxxxx).
+	Number string `json:"number"`
+	// The properties supplied on the account.
+	Properties []Property `json:"properties"`
+}
+
+// A property supplied on an account.
+type Property struct {
+	Id       string `json:"id"`
+	Address  string `json:"address"`
+	Postcode string `json:"postcode"`
+}
+
+// Returns the accounts visible to the API key, discovering them if
+// necessary. The result is cached to avoid multiple requests.
+func (octo *Octopus) Accounts(ctx context.Context) ([]Account, error) {
+	octo.loadCachedState()
+
+	octo.lock.Lock()
+	accounts := slices.Clone(octo.accounts)
+	octo.lock.Unlock()
+
+	if len(accounts) > 0 {
+		return accounts, nil
+	}
+
+	return octo.DiscoverAccounts(ctx)
+}
+
+// Sends an API request to find the accounts visible to the API key, and
+// caches the result.
+func (octo *Octopus) DiscoverAccounts(ctx context.Context) ([]Account, error) {
+	data, err := Do[struct {
+		Viewer *struct {
+			Accounts []Account `json:"accounts"`
+		} `json:"viewer"`
+	}](ctx, octo, "Viewer", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to discover accounts: %w", err)
+	}
+	if data.Viewer == nil || len(data.Viewer.Accounts) == 0 {
+		return nil, fmt.Errorf("%w: no accounts are visible to the API key", ErrAccountNotFound)
+	}
+
+	octo.lock.Lock()
+	octo.accounts = data.Viewer.Accounts
+	octo.lock.Unlock()
+	octo.saveCachedState()
+
+	return slices.Clone(data.Viewer.Accounts), nil
+}
+
+// Returns the account numbers given to [WithAccountNumbers] or in the
+// OCTOPUS_ACCOUNT_NUMBER environment variable, which may hold several
+// account numbers separated by commas. Returns nil if there are none.
+func (octo *Octopus) configuredAccountNumbers() []string {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	if len(octo.accountNumbers) > 0 {
+		return octo.accountNumbers
+	}
+
+	for _, accountNumber := range strings.Split(os.Getenv("OCTOPUS_ACCOUNT_NUMBER"), ",") {
+		accountNumber = strings.TrimSpace(accountNumber)
+		if accountNumber != "" {
+			octo.accountNumbers = append(octo.accountNumbers, accountNumber)
+		}
+	}
+	return octo.accountNumbers
+}
+
+// Returns the numbers of the accounts to track. These are the configured
+// accounts if there are any, otherwise the accounts chosen with
+// [Octopus.ChooseAccounts]. Failing that, the accounts visible to the API
+// key are discovered; if there is only one it is used, but if there are
+// several the returned error wraps [ErrNotConfigured].
+func (octo *Octopus) AccountNumbers(ctx context.Context) ([]string, error) {
+	if accountNumbers := octo.configuredAccountNumbers(); len(accountNumbers) > 0 {
+		return accountNumbers, nil
+	}
+
+	octo.loadCachedState()
+
+	octo.lock.Lock()
+	chosen := octo.chosenAccountNumbers
+	octo.lock.Unlock()
+
+	if len(chosen) > 0 {
+		return chosen, nil
+	}
+
+	accounts, err := octo.Accounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	accountNumbers := []string{}
+	for _, account := range accounts {
+		accountNumbers = append(accountNumbers, account.Number)
+	}
+
+	if len(accountNumbers) > 1 {
+		return nil, fmt.Errorf("%w: %v accounts are visible to the API key (%v); choose which to track with the setup command or the OCTOPUS_ACCOUNT_NUMBER environment variable", ErrNotConfigured, len(accountNumbers), strings.Join(accountNumbers, ", "))
+	}
+	return accountNumbers, nil
+}
+
+// Tracks the given accounts from now on, unless others are configured. The
+// choice is saved with the cached state, so it only needs to be made once.
+// Each account must be visible to the API key.
+func (octo *Octopus) ChooseAccounts(ctx context.Context, accountNumbers []string) error {
+	accounts, err := octo.Accounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, accountNumber := range accountNumbers {
+		visible := slices.ContainsFunc(accounts, func(a Account) bool {
+			return a.Number == accountNumber
+		})
+		if !visible {
+			return fmt.Errorf("%w: account %v is not visible to the API key", ErrAccountNotFound, accountNumber)
+		}
+	}
+
+	octo.lock.Lock()
+	octo.chosenAccountNumbers = slices.Clone(accountNumbers)
+	octo.lock.Unlock()
+	octo.saveCachedState()
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+)
+
+// Creates a client for the fake server that isn't told which account to
+// track.
+func newDiscoveringClient(t *testing.T, server *octopustest.Server, cache StateCache) *Octopus {
+	t.Helper()
+	t.Setenv("OCTOPUS_ACCOUNT_NUMBER", "")
+
+	return New(
+		WithBaseUrl(server.URL),
+		WithApiKey(server.ApiKey),
+		WithStateCache(cache),
+	)
+}
+
+func TestSingleAccountIsDiscovered(t *testing.T) {
+	_, server, _ := newTestClient(t)
+	octo := newDiscoveringClient(t, server, &memoryCache{})
+
+	devices, err := octo.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+	if len(devices) == 0 || devices[0].AccountNumber != server.AccountNumber {
+		t.Errorf("Devices() = %+v, want the meters on %v", devices, server.AccountNumber)
+	}
+
+	accounts, err := octo.Accounts(context.Background())
+	if err != nil {
+		t.Fatalf("Accounts: %v", err)
+	}
+	if len(accounts) != 1 || len(accounts[0].Properties) != 1 || accounts[0].Properties[0].Postcode != "TE1 1ST" {
+		t.Errorf("Accounts() = %+v, want the one account and its property", accounts)
+	}
+	if c := server.RequestCount("Viewer"); c != 1 {
+		t.Errorf("Expected 1 Viewer request, got %v", c)
+	}
+}
+
+func TestSeveralAccountsMustBeChosen(t *testing.T) {
+	_, server, _ := newTestClient(t)
+	server.AddAccount("A-87654321", octopustest.Device{Id: "second-import", Fuel: "electricity", MeterPoint: "2000000000001"})
+
+	cache := &memoryCache{}
+	octo := newDiscoveringClient(t, server, cache)
+
+	_, err := octo.Devices(context.Background())
+	if !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("Devices() error = %v, want %v", err, ErrNotConfigured)
+	}
+
+	err = octo.ChooseAccounts(context.Background(), []string{"A-99999999"})
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ChooseAccounts() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	err = octo.ChooseAccounts(context.Background(), []string{"A-87654321"})
+	if err != nil {
+		t.Fatalf("ChooseAccounts: %v", err)
+	}
+
+	// The choice is remembered after a restart
+	restarted := newDiscoveringClient(t, server, cache)
+
+	devices, err := restarted.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+	if len(devices) != 1 || devices[0].Id != "second-import" {
+		t.Errorf("Devices() = %+v, want the meter on the chosen account", devices)
+	}
+	if c := server.RequestCount("Viewer"); c != 1 {
+		t.Errorf("Expected 1 Viewer request, got %v", c)
+	}
+}
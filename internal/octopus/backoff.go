@@ -0,0 +1,110 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// The backoff after the first transient failure. It doubles with each
+// consecutive failure.
+const baseBackoff = 5 * time.Second
+
+// The longest we back off for, unless the server asks for longer.
+const maxBackoff = 10 * time.Minute
+
+// The shortest we back off for after a Kraken "Too many requests" error,
+// which doesn't say how long to wait.
+const minRateLimitBackoff = 5 * time.Minute
+
+// Describes whether the client is backing off from the API.
+type BackoffState struct {
+	// The number of consecutive transient failures. Reset to zero by a
+	// successful request.
+	Failures int
+	// API requests are skipped until this time. Zero if not backing off.
+	Until time.Time
+	// The failure that caused the current backoff.
+	LastError error
+}
+
+// Returns the client's current backoff state.
+func (octo *Octopus) BackoffState() BackoffState {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	return octo.backoff
+}
+
+// Returns the time until which API requests are paused. Zero if requests
+// are not paused.
+func (octo *Octopus) RetryAfter() time.Time {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	return octo.backoff.Until
+}
+
+// Checks if requests should currently be skipped.
+func (octo *Octopus) isBackingOff() bool {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	return octo.now().Before(octo.backoff.Until)
+}
+
+// Records a transient failure and pauses requests. The pause grows
+// exponentially with consecutive failures, with jitter so that several
+// clients don't retry in lockstep, up to [maxBackoff]. It is never shorter
+// than minDelay, which is used for delays requested by the server.
+func (octo *Octopus) backOff(minDelay time.Duration, cause error) {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	octo.backoff.Failures++
+
+	delay := baseBackoff << min(octo.backoff.Failures-1, 16)
+	delay = min(delay, maxBackoff)
+	// Equal jitter: wait between half and all of the delay
+	delay = delay/2 + rand.N(delay/2+1)
+	delay = max(delay, minDelay)
+
+	octo.backoff.Until = octo.now().Add(delay)
+	octo.backoff.LastError = cause
+}
+
+// Clears the backoff after a successful request.
+func (octo *Octopus) resetBackoff() {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	octo.backoff = BackoffState{}
+}
+
+// Classifies a failed request. Transient failures (rate limiting, server
+// errors, timeouts and network errors) start a backoff and are wrapped with
+// [ErrTooManyRequests] or [ErrTemporary]. Other errors are returned as they
+// are.
+func (octo *Octopus) classifyFailure(err error) error {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		octo.backOff(statusErr.RetryAfter, err)
+
+		if statusErr.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("%w: %w", ErrTooManyRequests, err)
+		}
+		return fmt.Errorf("%w: %w", ErrTemporary, err)
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		octo.backOff(0, err)
+		return fmt.Errorf("%w: %w", ErrTemporary, err)
+	}
+
+	return err
+}
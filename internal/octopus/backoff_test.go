@@ -0,0 +1,149 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterHeaderIsHonoured(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	server.FailNextHTTP("ObtainKrakenToken", http.StatusTooManyRequests, "900")
+
+	_, err := octo.LiveConsumption(context.Background())
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("LiveConsumption() error = %v, want %v", err, ErrTooManyRequests)
+	}
+
+	expected := clock.Now().Add(900 * time.Second)
+	if !octo.RetryAfter().Equal(expected) {
+		t.Errorf("RetryAfter() = %v, want %v", octo.RetryAfter(), expected)
+	}
+}
+
+func TestRetryAfterDateUsesClock(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	// Far enough from the real time to tell the clocks apart
+	clock.Advance(24 * time.Hour)
+	expected := clock.Now().Add(10 * time.Minute)
+	server.FailNextHTTP("ObtainKrakenToken", http.StatusTooManyRequests, expected.UTC().Format(http.TimeFormat))
+
+	_, err := octo.LiveConsumption(context.Background())
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("LiveConsumption() error = %v, want %v", err, ErrTooManyRequests)
+	}
+	if !octo.RetryAfter().Equal(expected) {
+		t.Errorf("RetryAfter() = %v, want %v", octo.RetryAfter(), expected)
+	}
+}
+
+func TestRateLimitErrorsBackOffExponentially(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	// Authenticate first, so that only the rate limited query is retried
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	var delay time.Duration
+
+	for i := range 9 {
+		// Kraken returns rate limiting errors with a 200 status
+		server.FailNext("SmartMeterTelemetry", octopustest.ErrCodeTooManyRequests, "Too many requests.")
+
+		_, err := octo.LiveConsumption(context.Background())
+		if !errors.Is(err, ErrTooManyRequests) {
+			t.Fatalf("LiveConsumption() error = %v, want %v", err, ErrTooManyRequests)
+		}
+
+		state := octo.BackoffState()
+		if state.Failures != i+1 {
+			t.Errorf("Failures = %v, want %v", state.Failures, i+1)
+		}
+
+		delay = state.Until.Sub(clock.Now())
+		clock.Advance(delay)
+		addCurrentReading(server, clock)
+	}
+
+	// The exponential backoff has outgrown the minimum for rate limiting
+	if delay <= minRateLimitBackoff {
+		t.Errorf("Backoff %v did not grow past %v", delay, minRateLimitBackoff)
+	}
+}
+
+func TestServerErrorsBackOffExponentially(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	var previous time.Duration
+
+	for i := range 4 {
+		server.FailNextHTTP("ObtainKrakenToken", http.StatusBadGateway, "")
+
+		_, err := octo.LiveConsumption(context.Background())
+		if !errors.Is(err, ErrTemporary) || !IsTemporary(err) {
+			t.Fatalf("LiveConsumption() error = %v, want %v", err, ErrTemporary)
+		}
+
+		state := octo.BackoffState()
+		if state.Failures != i+1 {
+			t.Errorf("Failures = %v, want %v", state.Failures, i+1)
+		}
+
+		delay := state.Until.Sub(clock.Now())
+		if delay > maxBackoff || delay < baseBackoff/2 {
+			t.Errorf("Backoff %v is outside the allowed range", delay)
+		}
+		if i > 0 && delay <= previous/2 {
+			t.Errorf("Backoff %v did not grow from %v", delay, previous)
+		}
+		previous = delay
+
+		clock.Advance(delay)
+	}
+
+	addCurrentReading(server, clock)
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption after recovering: %v", err)
+	}
+	if state := octo.BackoffState(); state.Failures != 0 || !state.Until.IsZero() {
+		t.Errorf("Expected backoff to be reset after success, got %+v", state)
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	octo := New()
+
+	for range 30 {
+		octo.backOff(0, nil)
+	}
+
+	if delay := time.Until(octo.RetryAfter()); delay > maxBackoff {
+		t.Errorf("Backoff %v is longer than the cap %v", delay, maxBackoff)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2025 12:05:00 GMT": 5 * time.Minute,
+		"soon":                          0,
+	}
+
+	for header, expected := range tests {
+		if d := parseRetryAfter(header, now); d != expected {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", header, d, expected)
+		}
+	}
+}
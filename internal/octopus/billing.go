@@ -0,0 +1,219 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// How many of the latest bills and payments are fetched.
+const billingHistoryLength = 24
+
+// The kind of a bill.
+type BillType string
+
+const (
+	// A periodic statement of the charges and credits on an account.
+	BillStatement BillType = "STATEMENT"
+	// A one-off invoice for charges outside a statement.
+	BillInvoice BillType = "INVOICE"
+	// A one-off credit to the account.
+	BillCreditNote BillType = "CREDIT_NOTE"
+)
+
+// The status of a payment.
+type PaymentStatus string
+
+const (
+	// The payment has cleared.
+	PaymentSucceeded PaymentStatus = "SUCCEEDED"
+	// The payment has been requested but hasn't cleared yet.
+	PaymentPending PaymentStatus = "PENDING"
+	// The payment was declined or reversed.
+	PaymentFailed PaymentStatus = "FAILED"
+)
+
+// A bill or statement issued on an account. Amounts are in pence, including
+// VAT. Balances are positive when the account is in credit.
+type Bill struct {
+	// The Kraken bill ID.
+	Id            string   `json:"id"`
+	AccountNumber string   `json:"accountNumber"`
+	Type          BillType `json:"type"`
+	// The day the bill was issued, at midnight local time.
+	IssuedAt time.Time `json:"issuedAt"`
+	// The start of the first day billed (inclusive).
+	From time.Time `json:"from"`
+	// The end of the last day billed (exclusive).
+	To time.Time `json:"to"`
+	// The balance before the billed period. Only set for statements.
+	OpeningBalance *int `json:"openingBalance"`
+	// The balance after the billed period. Only set for statements.
+	ClosingBalance *int `json:"closingBalance"`
+	// The total charged in the period, e.g. for energy and standing charges.
+	Charges int `json:"charges"`
+	// The total credited in the period, e.g. payments and refunds.
+	Credits int `json:"credits"`
+}
+
+// A payment made to or from an account.
+type Payment struct {
+	// The Kraken payment ID.
+	Id            string `json:"id"`
+	AccountNumber string `json:"accountNumber"`
+	// The day the payment was made, at midnight local time.
+	Date time.Time `json:"date"`
+	// The amount paid, in pence. Positive for payments into the account.
+	Amount int           `json:"amount"`
+	Status PaymentStatus `json:"status"`
+}
+
+// The balance of an account, with its latest bills and payments.
+type Billing struct {
+	AccountNumber string `json:"accountNumber"`
+	// The current balance in pence. Positive when the account is in credit.
+	Balance int `json:"balance"`
+	// The latest bills, newest first.
+	Bills []*Bill `json:"bills"`
+	// The latest payments, newest first.
+	Payments []*Payment `json:"payments"`
+}
+
+// A Kraken bill of any type. The balances and totals are only set for
+// statements.
+type krakenBill struct {
+	Id             string `json:"id"`
+	BillType       string `json:"billType"`
+	IssuedDate     string `json:"issuedDate"`
+	FromDate       string `json:"fromDate"`
+	ToDate         string `json:"toDate"`
+	OpeningBalance *int   `json:"openingBalance"`
+	ClosingBalance *int   `json:"closingBalance"`
+	TotalCharges   *struct {
+		GrossTotal int `json:"grossTotal"`
+	} `json:"totalCharges"`
+	TotalCredits *struct {
+		GrossTotal int `json:"grossTotal"`
+	} `json:"totalCredits"`
+}
+
+type krakenPayment struct {
+	Id          string `json:"id"`
+	Amount      int    `json:"amount"`
+	PaymentDate string `json:"paymentDate"`
+	Status      string `json:"status"`
+}
+
+// Parses a Kraken date, e.g. 2025-01-31, as midnight local time.
+func parseDate(date string) (time.Time, error) {
+	return time.ParseInLocation(time.DateOnly, date, time.Local)
+}
+
+// Converts a Kraken bill to a [Bill].
+func (b *krakenBill) bill(accountNumber string) (*Bill, error) {
+	bill := &Bill{
+		Id:             b.Id,
+		AccountNumber:  accountNumber,
+		Type:           BillType(b.BillType),
+		OpeningBalance: b.OpeningBalance,
+		ClosingBalance: b.ClosingBalance,
+	}
+
+	var err error
+	bill.IssuedAt, err = parseDate(b.IssuedDate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid issue date of bill %v: %w", b.Id, err)
+	}
+	bill.From, err = parseDate(b.FromDate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid start date of bill %v: %w", b.Id, err)
+	}
+	// Kraken gives the last day billed
+	bill.To, err = parseDate(b.ToDate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid end date of bill %v: %w", b.Id, err)
+	}
+	bill.To = bill.To.AddDate(0, 0, 1)
+
+	if b.TotalCharges != nil {
+		bill.Charges = b.TotalCharges.GrossTotal
+	}
+	if b.TotalCredits != nil {
+		bill.Credits = b.TotalCredits.GrossTotal
+	}
+
+	return bill, nil
+}
+
+// Returns the current balance of every tracked account, with its latest
+// bills and payments. Billing details are not cached, since they change
+// whenever a payment is made.
+func (octo *Octopus) Billing(ctx context.Context) ([]*Billing, error) {
+	accountNumbers, err := octo.AccountNumbers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get billing: %w", err)
+	}
+
+	billings := []*Billing{}
+
+	for _, accountNumber := range accountNumbers {
+		data, err := Do[struct {
+			Account *struct {
+				Balance int `json:"balance"`
+				Bills   struct {
+					Edges []struct {
+						Node krakenBill `json:"node"`
+					} `json:"edges"`
+				} `json:"bills"`
+				Payments struct {
+					Edges []struct {
+						Node krakenPayment `json:"node"`
+					} `json:"edges"`
+				} `json:"payments"`
+			} `json:"account"`
+		}](ctx, octo, "Billing", map[string]any{
+			"accountNumber": accountNumber,
+			"first":         billingHistoryLength,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Get billing of account %v: %w", accountNumber, err)
+		}
+		if data.Account == nil {
+			return nil, fmt.Errorf("%w: account %v", ErrAccountNotFound, accountNumber)
+		}
+
+		billing := &Billing{
+			AccountNumber: accountNumber,
+			Balance:       data.Account.Balance,
+			Bills:         []*Bill{},
+			Payments:      []*Payment{},
+		}
+
+		for _, edge := range data.Account.Bills.Edges {
+			bill, err := edge.Node.bill(accountNumber)
+			if err != nil {
+				return nil, fmt.Errorf("Get billing of account %v: %w", accountNumber, err)
+			}
+			billing.Bills = append(billing.Bills, bill)
+		}
+
+		for _, edge := range data.Account.Payments.Edges {
+			p := edge.Node
+			date, err := parseDate(p.PaymentDate)
+			if err != nil {
+				return nil, fmt.Errorf("Get billing of account %v: invalid date of payment %v: %w", accountNumber, p.Id, err)
+			}
+			billing.Payments = append(billing.Payments, &Payment{
+				Id:            p.Id,
+				AccountNumber: accountNumber,
+				Date:          date,
+				Amount:        p.Amount,
+				Status:        PaymentStatus(p.Status),
+			})
+		}
+
+		billings = append(billings, billing)
+	}
+
+	return billings, nil
+}
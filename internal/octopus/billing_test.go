@@ -0,0 +1,87 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+	"time"
+)
+
+func TestBilling(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	date := func(day int) time.Time {
+		return time.Date(2025, 1, day, 0, 0, 0, 0, time.Local)
+	}
+
+	server.SetBilling(server.AccountNumber, octopustest.Billing{
+		Balance: -1250,
+		Bills: []octopustest.Bill{
+			{
+				Id:             "2",
+				Type:           "STATEMENT",
+				Issued:         date(31).AddDate(0, 0, 2),
+				From:           date(1),
+				To:             date(31),
+				OpeningBalance: 3000,
+				ClosingBalance: 1500,
+				Charges:        9500,
+				Credits:        8000,
+			},
+			{Id: "1", Type: "INVOICE", Issued: date(10), From: date(10), To: date(10)},
+		},
+		Payments: []octopustest.Payment{
+			{Id: "10", Date: date(15), Amount: 8000, Status: "SUCCEEDED"},
+		},
+	})
+
+	billings, err := octo.Billing(context.Background())
+	if err != nil {
+		t.Fatalf("Billing: %v", err)
+	}
+	if len(billings) != 1 {
+		t.Fatalf("Billing() returned %v accounts, want 1", len(billings))
+	}
+
+	billing := billings[0]
+	if billing.AccountNumber != server.AccountNumber || billing.Balance != -1250 {
+		t.Errorf("Billing() = %+v, want a balance of -1250 on %v", billing, server.AccountNumber)
+	}
+	if len(billing.Bills) != 2 || len(billing.Payments) != 1 {
+		t.Fatalf("Billing() = %+v, want 2 bills and 1 payment", billing)
+	}
+
+	statement := billing.Bills[0]
+	if statement.Type != BillStatement || statement.Charges != 9500 || statement.Credits != 8000 {
+		t.Errorf("Bills[0] = %+v, want a statement charging 9500 and crediting 8000", statement)
+	}
+	if statement.ClosingBalance == nil || *statement.ClosingBalance != 1500 {
+		t.Errorf("ClosingBalance = %v, want 1500", statement.ClosingBalance)
+	}
+	// The end is the day after the last day billed
+	if !statement.From.Equal(date(1)) || !statement.To.Equal(date(31).AddDate(0, 0, 1)) {
+		t.Errorf("Statement covers %v to %v, want January", statement.From, statement.To)
+	}
+
+	invoice := billing.Bills[1]
+	if invoice.Type != BillInvoice || invoice.ClosingBalance != nil {
+		t.Errorf("Bills[1] = %+v, want an invoice without balances", invoice)
+	}
+
+	payment := billing.Payments[0]
+	if payment.Amount != 8000 || payment.Status != PaymentSucceeded || !payment.Date.Equal(date(15)) {
+		t.Errorf("Payments[0] = %+v, want 8000 paid on %v", payment, date(15))
+	}
+}
+
+func TestBillingAccountNotFound(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	server.FailNext("Billing", octopustest.ErrCodeAccountNotFound, "Unauthorized.")
+
+	_, err := octo.Billing(context.Background())
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Billing() error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
@@ -0,0 +1,105 @@
+package octopus
+
+import (
+	"log"
+)
+
+// Credentials and account metadata that can be saved between runs, so that
+// a restart doesn't need to re-authenticate or rediscover the meters.
+type CachedState struct {
+	Token                 string `json:"token"`
+	TokenExpiresAt        int64  `json:"tokenExpiresAt"`
+	RefreshToken          string `json:"refreshToken"`
+	RefreshTokenExpiresAt int64  `json:"refreshTokenExpiresAt"`
+	// The accounts visible to the API key.
+	Accounts []Account `json:"accounts"`
+	// The accounts chosen to be tracked, if there are several.
+	ChosenAccountNumbers []string `json:"chosenAccountNumbers"`
+	// The accounts that the devices below belong to.
+	AccountNumbers []string `json:"accountNumbers"`
+	Devices        []Device `json:"devices"`
+}
+
+// Somewhere to persist [CachedState]. Implementations must be safe to use
+// from several [Octopus] clients at once.
+type StateCache interface {
+	// Returns the saved state, or nil if nothing has been saved.
+	Load() (*CachedState, error)
+	// Replaces the saved state.
+	Save(state *CachedState) error
+}
+
+// Loads credentials and account metadata from cache on first use, and saves
+// them whenever they change.
+func WithStateCache(cache StateCache) Option {
+	return func(octo *Octopus) {
+		octo.cache = cache
+	}
+}
+
+// Restores the cached state, if there is a cache and we haven't already
+// loaded it. Cache failures are logged rather than returned, since we can
+// always fall back to asking the API.
+func (octo *Octopus) loadCachedState() {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	if octo.cache == nil || octo.cacheLoaded {
+		return
+	}
+	octo.cacheLoaded = true
+
+	state, err := octo.cache.Load()
+	if err != nil {
+		log.Printf("Failed to load cached Octopus credentials: %v", err)
+		return
+	}
+	if state == nil {
+		return
+	}
+
+	if octo.Token == "" && octo.RefreshToken == "" {
+		octo.Token = state.Token
+		octo.TokenExpiresAt = state.TokenExpiresAt
+		octo.RefreshToken = state.RefreshToken
+		octo.RefreshTokenExpiresAt = state.RefreshTokenExpiresAt
+	}
+
+	if len(octo.accounts) == 0 && len(octo.chosenAccountNumbers) == 0 {
+		octo.accounts = state.Accounts
+		octo.chosenAccountNumbers = state.ChosenAccountNumbers
+	}
+
+	// The devices are only reused if they are for the accounts we're
+	// tracking; see [Octopus.obtainAccountDetails]
+	if len(octo.devices) == 0 {
+		octo.devices = state.Devices
+		octo.devicesAccountNumbers = state.AccountNumbers
+	}
+}
+
+// Saves the current state to the cache, if there is one.
+func (octo *Octopus) saveCachedState() {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	if octo.cache == nil {
+		return
+	}
+
+	state := &CachedState{
+		Token:                 octo.Token,
+		TokenExpiresAt:        octo.TokenExpiresAt,
+		RefreshToken:          octo.RefreshToken,
+		RefreshTokenExpiresAt: octo.RefreshTokenExpiresAt,
+		Accounts:              octo.accounts,
+		ChosenAccountNumbers:  octo.chosenAccountNumbers,
+		AccountNumbers:        octo.devicesAccountNumbers,
+		Devices:               octo.devices,
+	}
+
+	err := octo.cache.Save(state)
+	if err != nil {
+		log.Printf("Failed to save Octopus credentials to cache: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A clock for tests that only moves when told to.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Starts a fake server with a minute of telemetry ending at the clock's
+// current time, and returns a client pointed at it.
+func newTestClient(t *testing.T) (*Octopus, *octopustest.Server, *fakeClock) {
+	t.Helper()
+
+	server := octopustest.NewServer()
+	t.Cleanup(server.Close)
+
+	clock := &fakeClock{now: time.Now().Truncate(time.Second)}
+	server.SetClock(clock.Now)
+
+	for i := range 6 {
+		server.AddTelemetry(octopustest.TelemetryReading{
+			ReadAt:      clock.now.Add(time.Duration(i-5) * 10 * time.Second),
+			Consumption: float64(1000 + i),
+			Demand:      float64(200 + i),
+		})
+	}
+
+	octo := New(
+		WithBaseUrl(server.URL),
+		WithRestBaseUrl(server.URL),
+		WithHTTPClient(server.Client()),
+		WithClock(clock.Now),
+		WithApiKey(server.ApiKey),
+		WithAccountNumbers(server.AccountNumber),
+	)
+
+	return octo, server, clock
+}
+
+func TestLiveConsumption(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	reading, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	if reading.TotalConsumption != 1005 || reading.Demand != 205 {
+		t.Errorf("LiveConsumption() = %+v, want consumption 1005 and demand 205", reading)
+	}
+
+	// The token and account details should be reused
+	_, err = octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+	if c := server.RequestCount("ObtainKrakenToken"); c != 1 {
+		t.Errorf("Expected 1 ObtainKrakenToken request, got %v", c)
+	}
+	if c := server.RequestCount("Account"); c != 1 {
+		t.Errorf("Expected 1 Account request, got %v", c)
+	}
+}
+
+func TestConcurrentRequestsShareToken(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := octo.LiveConsumption(context.Background())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("LiveConsumption: %v", err)
+		}
+	}
+	if c := server.RequestCount("ObtainKrakenToken"); c != 1 {
+		t.Errorf("Expected 1 ObtainKrakenToken request, got %v", c)
+	}
+	if c := server.RequestCount("Account"); c != 1 {
+		t.Errorf("Expected 1 Account request, got %v", c)
+	}
+}
+
+func TestTooManyRequestsPausesRequests(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	server.FailNext("SmartMeterTelemetry", octopustest.ErrCodeTooManyRequests, "Too many requests.")
+
+	_, err := octo.LiveConsumption(context.Background())
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("LiveConsumption() error = %v, want %v", err, ErrTooManyRequests)
+	}
+
+	_, err = octo.LiveConsumption(context.Background())
+	if !errors.Is(err, ErrSkippingRequest) {
+		t.Fatalf("LiveConsumption() error = %v, want %v", err, ErrSkippingRequest)
+	}
+	if c := server.RequestCount("SmartMeterTelemetry"); c != 1 {
+		t.Errorf("Expected no request while paused, got %v requests", c)
+	}
+
+	clock.Advance(6 * time.Minute)
+	server.AddTelemetry(octopustest.TelemetryReading{
+		ReadAt:      clock.Now(),
+		Consumption: 1100,
+		Demand:      300,
+	})
+
+	_, err = octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Errorf("LiveConsumption() after pause: %v", err)
+	}
+}
+
+func TestInvalidApiKey(t *testing.T) {
+	server := octopustest.NewServer()
+	defer server.Close()
+
+	octo := New(
+		WithBaseUrl(server.URL),
+		WithApiKey("sk_wrong_key"),
+		WithAccountNumbers(server.AccountNumber),
+	)
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err == nil {
+		t.Fatalf("Expected an error with an invalid API key")
+	}
+	if c := server.RequestCount("Account"); c != 0 {
+		t.Errorf("Expected no Account requests without a token, got %v", c)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := octo.LiveConsumption(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("LiveConsumption() error = %v, want %v", err, context.Canceled)
+	}
+	if c := len(server.Requests()); c != 0 {
+		t.Errorf("Expected no requests to reach the server, got %v", c)
+	}
+}
@@ -0,0 +1,123 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+)
+
+// How the REST API aggregates settled consumption.
+type ConsumptionGrouping string
+
+const (
+	// The half-hourly intervals that are billed, without aggregating.
+	GroupByHalfHour ConsumptionGrouping = ""
+	GroupByHour     ConsumptionGrouping = "hour"
+	GroupByDay      ConsumptionGrouping = "day"
+	GroupByWeek     ConsumptionGrouping = "week"
+	GroupByMonth    ConsumptionGrouping = "month"
+	GroupByQuarter  ConsumptionGrouping = "quarter"
+)
+
+// Parses a grouping name such as "day". An empty string is treated as
+// [GroupByHalfHour].
+func ParseConsumptionGrouping(s string) (ConsumptionGrouping, error) {
+	switch g := ConsumptionGrouping(s); g {
+	case GroupByHalfHour, GroupByHour, GroupByDay, GroupByWeek, GroupByMonth, GroupByQuarter:
+		return g, nil
+	}
+	return "", fmt.Errorf("Unknown consumption grouping %q", s)
+}
+
+// The most results the REST API returns in one page.
+const consumptionPageSize = 25000
+
+// The consumption of a meter over one interval, as settled by the industry
+// for billing. This is usually available a day or so after the interval.
+type ConsumptionInterval struct {
+	Meter Meter `json:"meter"`
+	// The MPAN (electricity) or MPRN (gas) of the meter point.
+	MeterPoint   string `json:"meterPoint"`
+	SerialNumber string `json:"serialNumber"`
+	// The start of the interval (inclusive).
+	IntervalStart time.Time `json:"intervalStart"`
+	// The end of the interval (exclusive).
+	IntervalEnd time.Time `json:"intervalEnd"`
+	// The energy used, or exported, in Wh.
+	Consumption int `json:"consumption"`
+}
+
+// Returns the REST API path of the consumption of the given meter.
+func consumptionPath(device Device) string {
+	meterPoints := "electricity-meter-points"
+	if device.Meter.Fuel == FuelGas {
+		meterPoints = "gas-meter-points"
+	}
+	return fmt.Sprintf("%v/%v/meters/%v/consumption/",
+		meterPoints, url.PathEscape(device.MeterPoint), url.PathEscape(device.SerialNumber))
+}
+
+// Returns the settled consumption of the given meter between from and to,
+// oldest first, from the REST API. Every page of results is fetched. If a
+// request fails, the intervals fetched so far are returned along with the
+// error.
+//
+// Gas consumption is assumed to be in m³, as it is for SMETS2 meters, and
+// is converted to Wh.
+func (octo *Octopus) SettledConsumption(ctx context.Context, device Device, from, to time.Time, grouping ConsumptionGrouping) ([]*ConsumptionInterval, error) {
+	if device.MeterPoint == "" || device.SerialNumber == "" {
+		return nil, fmt.Errorf("%w: meter %v has no meter point or serial number", ErrMeterNotFound, device.Id)
+	}
+
+	query := url.Values{}
+	query.Set("period_from", from.UTC().Format(time.RFC3339))
+	query.Set("period_to", to.UTC().Format(time.RFC3339))
+	query.Set("page_size", fmt.Sprint(consumptionPageSize))
+	query.Set("order_by", "period")
+	if grouping != GroupByHalfHour {
+		query.Set("group_by", string(grouping))
+	}
+
+	intervals := []*ConsumptionInterval{}
+
+	next := octo.restEndpoint(consumptionPath(device)) + "?" + query.Encode()
+	for next != "" {
+		page, err := getRest[struct {
+			Next    *string `json:"next"`
+			Results []struct {
+				// kWh for electricity, m³ for gas
+				Consumption   float64   `json:"consumption"`
+				IntervalStart time.Time `json:"interval_start"`
+				IntervalEnd   time.Time `json:"interval_end"`
+			} `json:"results"`
+		}](ctx, octo, "Consumption", next)
+		if err != nil {
+			return intervals, fmt.Errorf("Get %v consumption from %v to %v: %w", device.Meter, from, to, err)
+		}
+
+		for _, r := range page.Results {
+			wh := r.Consumption * 1000
+			if device.Meter.Fuel == FuelGas {
+				wh = octo.gasEnergy(r.Consumption)
+			}
+
+			intervals = append(intervals, &ConsumptionInterval{
+				Meter:         device.Meter,
+				MeterPoint:    device.MeterPoint,
+				SerialNumber:  device.SerialNumber,
+				IntervalStart: r.IntervalStart,
+				IntervalEnd:   r.IntervalEnd,
+				Consumption:   int(math.Round(wh)),
+			})
+		}
+
+		next = ""
+		if page.Next != nil {
+			next = *page.Next
+		}
+	}
+
+	return intervals, nil
+}
@@ -0,0 +1,116 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var consumptionStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// The electricity meter of the fake server's default account.
+var consumptionDevice = Device{
+	Id:           "00-00-00-00-00-00-00-01",
+	Meter:        ElectricityImport,
+	MeterPoint:   "1000000000001",
+	SerialNumber: "21E0000001",
+}
+
+// Adds a day of half-hourly consumption to the default electricity meter,
+// using 0.1kWh more in each half hour than the one before.
+func addConsumption(server *octopustest.Server) {
+	for i := range 48 {
+		start := consumptionStart.Add(time.Duration(i) * 30 * time.Minute)
+		server.AddConsumption(consumptionDevice.MeterPoint, consumptionDevice.SerialNumber, octopustest.ConsumptionInterval{
+			IntervalStart: start,
+			IntervalEnd:   start.Add(30 * time.Minute),
+			Consumption:   0.1 * float64(i+1),
+		})
+	}
+}
+
+func TestSettledConsumption(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+	addConsumption(server)
+	server.MaxPageSize = 10
+
+	intervals, err := octo.SettledConsumption(context.Background(), consumptionDevice, consumptionStart.Add(time.Hour), consumptionStart.Add(24*time.Hour), GroupByHalfHour)
+	if err != nil {
+		t.Fatalf("SettledConsumption: %v", err)
+	}
+
+	// The first hour is outside the period
+	if len(intervals) != 46 {
+		t.Fatalf("SettledConsumption() returned %v intervals, want 46", len(intervals))
+	}
+	first := intervals[0]
+	if !first.IntervalStart.Equal(consumptionStart.Add(time.Hour)) || first.Consumption != 300 || first.Meter != ElectricityImport || first.SerialNumber != "21E0000001" {
+		t.Errorf("First interval = %+v, want 300Wh from 01:00", first)
+	}
+	if last := intervals[45]; last.Consumption != 4800 {
+		t.Errorf("Last interval = %+v, want 4800Wh", last)
+	}
+
+	// Every page was fetched
+	if count := server.RequestCount("Consumption"); count != 5 {
+		t.Errorf("Sent %v consumption requests, want 5 pages", count)
+	}
+	request := server.Requests()[len(server.Requests())-1]
+	if request.Variables["period_from"] != "2025-01-01T01:00:00Z" || request.Variables["order_by"] != "period" {
+		t.Errorf("Request variables = %v, want period_from and order_by", request.Variables)
+	}
+}
+
+func TestSettledConsumptionGroupBy(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+	addConsumption(server)
+
+	intervals, err := octo.SettledConsumption(context.Background(), consumptionDevice, consumptionStart, consumptionStart.Add(24*time.Hour), GroupByDay)
+	if err != nil {
+		t.Fatalf("SettledConsumption: %v", err)
+	}
+
+	// 0.1 + 0.2 + ... + 4.8 kWh
+	if len(intervals) != 1 || intervals[0].Consumption != 117600 || !intervals[0].IntervalEnd.Equal(consumptionStart.Add(24*time.Hour)) {
+		t.Errorf("SettledConsumption() = %+v, want one day of 117600Wh", intervals)
+	}
+	request := server.Requests()[len(server.Requests())-1]
+	if request.Variables["group_by"] != "day" {
+		t.Errorf("group_by = %v, want day", request.Variables["group_by"])
+	}
+}
+
+func TestSettledConsumptionErrors(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	// No consumption for the meter
+	_, err := octo.SettledConsumption(context.Background(), consumptionDevice, consumptionStart, consumptionStart.Add(time.Hour), GroupByHalfHour)
+	if !errors.Is(err, ErrMeterNotFound) {
+		t.Errorf("SettledConsumption() of unknown meter = %v, want ErrMeterNotFound", err)
+	}
+
+	addConsumption(server)
+	server.FailNextHTTP("Consumption", http.StatusServiceUnavailable, "")
+	_, err = octo.SettledConsumption(context.Background(), consumptionDevice, consumptionStart, consumptionStart.Add(time.Hour), GroupByHalfHour)
+	if !errors.Is(err, ErrTemporary) || !octo.isBackingOff() {
+		t.Errorf("SettledConsumption() after server error = %v, want ErrTemporary and a backoff", err)
+	}
+
+	wrongKey := New(WithRestBaseUrl(server.URL), WithApiKey("sk_wrong_key"))
+	_, err = wrongKey.SettledConsumption(context.Background(), consumptionDevice, consumptionStart, consumptionStart.Add(time.Hour), GroupByHalfHour)
+	if !errors.Is(err, ErrInvalidApiKey) {
+		t.Errorf("SettledConsumption() with wrong key = %v, want ErrInvalidApiKey", err)
+	}
+}
+
+func TestParseConsumptionGrouping(t *testing.T) {
+	if g, err := ParseConsumptionGrouping("week"); g != GroupByWeek || err != nil {
+		t.Errorf(`ParseConsumptionGrouping("week") = %q, %v`, g, err)
+	}
+	if _, err := ParseConsumptionGrouping("fortnight"); err == nil {
+		t.Error(`ParseConsumptionGrouping("fortnight") succeeded, want an error`)
+	}
+}
@@ -0,0 +1,114 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// A window in which Intelligent Octopus charges a smart-charging device,
+// such as an electric car, and bills the whole household's consumption at
+// the off-peak rate.
+type Dispatch struct {
+	AccountNumber string `json:"accountNumber"`
+	// When the dispatch starts (inclusive).
+	Start time.Time `json:"start"`
+	// When the dispatch ends (exclusive).
+	End time.Time `json:"end"`
+	// The energy the device is expected to charge, or charged, in Wh.
+	Energy int `json:"energy"`
+	// Why the dispatch was made, e.g. "smart-charge" or "bump-charge".
+	Source string `json:"source"`
+	// Where the device was, if known, e.g. "AT_HOME".
+	Location string `json:"location"`
+	// Whether the dispatch has finished. Otherwise it is planned or in
+	// progress, and may still change.
+	Completed bool `json:"completed"`
+}
+
+// A Kraken dispatch, planned or completed.
+type krakenDispatch struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// The change in the device's charge in kWh, as a decimal string.
+	// Negative while charging, since the energy is taken from the grid.
+	Delta string `json:"delta"`
+	Meta  *struct {
+		Source   string `json:"source"`
+		Location string `json:"location"`
+	} `json:"meta"`
+}
+
+// Converts a Kraken dispatch to a [Dispatch].
+func (d *krakenDispatch) dispatch(accountNumber string, completed bool) (*Dispatch, error) {
+	dispatch := &Dispatch{
+		AccountNumber: accountNumber,
+		Start:         d.Start,
+		End:           d.End,
+		Completed:     completed,
+	}
+
+	if d.Delta != "" {
+		delta, err := strconv.ParseFloat(d.Delta, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid delta of dispatch at %v: %w", d.Start, err)
+		}
+		dispatch.Energy = int(math.Round(math.Abs(delta) * 1000))
+	}
+
+	if d.Meta != nil {
+		dispatch.Source = d.Meta.Source
+		dispatch.Location = d.Meta.Location
+	}
+
+	return dispatch, nil
+}
+
+// Returns the planned and completed Intelligent Octopus dispatches of every
+// tracked account, oldest first. Accounts without a smart-charging device
+// have none. Kraken only returns recently completed dispatches, so they
+// should be stored as they are fetched.
+func (octo *Octopus) Dispatches(ctx context.Context) ([]*Dispatch, error) {
+	accountNumbers, err := octo.AccountNumbers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get dispatches: %w", err)
+	}
+
+	dispatches := []*Dispatch{}
+
+	for _, accountNumber := range accountNumbers {
+		data, err := Do[struct {
+			PlannedDispatches   []krakenDispatch `json:"plannedDispatches"`
+			CompletedDispatches []krakenDispatch `json:"completedDispatches"`
+		}](ctx, octo, "Dispatches", map[string]any{
+			"accountNumber": accountNumber,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Get dispatches of account %v: %w", accountNumber, err)
+		}
+
+		for _, d := range data.CompletedDispatches {
+			dispatch, err := d.dispatch(accountNumber, true)
+			if err != nil {
+				return nil, fmt.Errorf("Get dispatches of account %v: %w", accountNumber, err)
+			}
+			dispatches = append(dispatches, dispatch)
+		}
+		for _, d := range data.PlannedDispatches {
+			dispatch, err := d.dispatch(accountNumber, false)
+			if err != nil {
+				return nil, fmt.Errorf("Get dispatches of account %v: %w", accountNumber, err)
+			}
+			dispatches = append(dispatches, dispatch)
+		}
+	}
+
+	slices.SortStableFunc(dispatches, func(a, b *Dispatch) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	return dispatches, nil
+}
@@ -0,0 +1,62 @@
+package octopus
+
+import (
+	"context"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+	"time"
+)
+
+func TestDispatches(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	start := time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC)
+
+	server.AddCompletedDispatches(server.AccountNumber, octopustest.Dispatch{
+		Start:    start,
+		End:      start.Add(time.Hour),
+		Energy:   7.2,
+		Source:   "smart-charge",
+		Location: "AT_HOME",
+	})
+	server.AddPlannedDispatches(server.AccountNumber, octopustest.Dispatch{
+		Start:  start.Add(24 * time.Hour),
+		End:    start.Add(25*time.Hour + 30*time.Minute),
+		Energy: 10.5,
+		Source: "bump-charge",
+	})
+
+	dispatches, err := octo.Dispatches(context.Background())
+	if err != nil {
+		t.Fatalf("Dispatches: %v", err)
+	}
+	if len(dispatches) != 2 {
+		t.Fatalf("Dispatches() returned %v dispatches, want 2", len(dispatches))
+	}
+
+	completed := dispatches[0]
+	if !completed.Completed || !completed.Start.Equal(start) || !completed.End.Equal(start.Add(time.Hour)) {
+		t.Errorf("Dispatches()[0] = %+v, want the completed dispatch", completed)
+	}
+	// The delta is served as negative kWh
+	if completed.Energy != 7200 || completed.Source != "smart-charge" || completed.Location != "AT_HOME" {
+		t.Errorf("Dispatches()[0] = %+v, want 7200Wh of smart charging at home", completed)
+	}
+
+	planned := dispatches[1]
+	if planned.Completed || planned.Energy != 10500 || planned.AccountNumber != server.AccountNumber {
+		t.Errorf("Dispatches()[1] = %+v, want the planned dispatch of 10500Wh", planned)
+	}
+}
+
+func TestDispatchesNone(t *testing.T) {
+	octo, _, _ := newTestClient(t)
+
+	dispatches, err := octo.Dispatches(context.Background())
+	if err != nil {
+		t.Fatalf("Dispatches: %v", err)
+	}
+	if len(dispatches) != 0 {
+		t.Errorf("Dispatches() = %+v, want none without a smart-charging device", dispatches)
+	}
+}
@@ -1,9 +1,156 @@
 package octopus
 
-import "errors"
+import (
+	"errors"
+	"strings"
+	"time"
+)
 
 // This error is returned if we have received a "Too many requests" response from the API.
 var ErrTooManyRequests = errors.New("Too many requests")
+
 // This error is returned if we did not make an API request, because we previously
-// got a "Too many requests" response.
-var ErrSkippingRequest = errors.New("Skipping API request because too many requests")
+// got a "Too many requests" response or another transient failure.
+var ErrSkippingRequest = errors.New("Skipping API request while backing off")
+
+// This error is returned if the API rejected our API key or refresh token.
+var ErrAuthFailed = errors.New("Authentication failed")
+
+// This error is returned if a request failed for a reason that is likely to
+// go away by itself, e.g. a network error or an Octopus server error.
+var ErrTemporary = errors.New("Temporary Octopus API failure")
+
+// This error is returned if the API key is invalid.
+var ErrInvalidApiKey = errors.New("Invalid API key")
+
+// This error is returned if the Kraken token has expired. The client
+// obtains a new token on the next request.
+var ErrTokenExpired = errors.New("Kraken token has expired")
+
+// This error is returned if the request was not authorised, e.g. because
+// the token doesn't have access to the requested data.
+var ErrUnauthorized = errors.New("Unauthorized")
+
+// This error is returned if the account number doesn't exist or isn't
+// visible with our API key.
+var ErrAccountNotFound = errors.New("Account not found")
+
+// This error is returned if the account doesn't have the smart meter we
+// need.
+var ErrMeterNotFound = errors.New("Smart meter not found")
+
+// This error is returned if the API rejected the request's input.
+var ErrValidation = errors.New("Invalid request")
+
+// This error is returned if the meter didn't return any readings for the
+// requested period.
+var ErrNoReadings = errors.New("No electricity meter readings found")
+
+// This error is returned if required configuration, such as the API key,
+// is missing.
+var ErrNotConfigured = errors.New("Octopus client is not configured")
+
+// The sentinel errors that Kraken error codes correspond to.
+var krakenErrorCodes = map[string]error{
+	"KT-CT-1111": ErrUnauthorized,
+	"KT-CT-1124": ErrTokenExpired,
+	"KT-CT-1139": ErrInvalidApiKey,
+	"KT-CT-1199": ErrTooManyRequests,
+	"KT-CT-4123": ErrAccountNotFound,
+	"KT-CT-4301": ErrMeterNotFound,
+}
+
+// The sentinel errors that Kraken error types correspond to, for codes
+// that aren't in [krakenErrorCodes].
+var krakenErrorTypes = map[string]error{
+	"AUTHORIZATION": ErrUnauthorized,
+	"VALIDATION":    ErrValidation,
+}
+
+// Checks if err is a transient failure that is worth retrying later, rather
+// than a problem that needs fixing.
+func IsTemporary(err error) bool {
+	return errors.Is(err, ErrTemporary) ||
+		errors.Is(err, ErrTooManyRequests) ||
+		errors.Is(err, ErrSkippingRequest)
+}
+
+// An error returned in the "errors" list of a Kraken GraphQL response.
+// Use [errors.Is] with the sentinel errors in this package to check what
+// kind of error it is, or [errors.As] to get the details.
+type KrakenError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		ErrorCode        string `json:"errorCode"`
+		ErrorType        string `json:"errorType"`
+		ErrorDescription string `json:"errorDescription"`
+	} `json:"extensions"`
+}
+
+func (e *KrakenError) Error() string {
+	return e.Extensions.ErrorCode + " " + e.Message
+}
+
+// Matches the sentinel error for this error's code or type.
+func (e *KrakenError) Is(target error) bool {
+	if sentinel, ok := krakenErrorCodes[e.Extensions.ErrorCode]; ok {
+		return sentinel == target
+	}
+	if sentinel, ok := krakenErrorTypes[e.Extensions.ErrorType]; ok {
+		return sentinel == target
+	}
+	return false
+}
+
+// All of the errors returned in one Kraken GraphQL response.
+type KrakenErrors []*KrakenError
+
+func (errs KrakenErrors) Error() string {
+	var sb strings.Builder
+
+	for i, e := range errs {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(e.Error())
+	}
+
+	return sb.String()
+}
+
+// Allows [errors.Is] and [errors.As] to match any of the errors.
+func (errs KrakenErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, e := range errs {
+		unwrapped[i] = e
+	}
+	return unwrapped
+}
+
+// Handles any errors returned by the Octopus API. Performs any rectifying actions
+// if possible, e.g. pausing API requests for a small while if we get a "Too Many Requests"
+// error. Returns the errors as [KrakenErrors], or nil if there are none.
+func (octo *Octopus) handleErrors(errs *[]KrakenError) error {
+	if errs == nil || len(*errs) == 0 {
+		return nil
+	}
+
+	krakenErrs := KrakenErrors{}
+	for i := range *errs {
+		krakenErrs = append(krakenErrs, &(*errs)[i])
+	}
+
+	if errors.Is(krakenErrs, ErrTooManyRequests) {
+		// Stop sending API requests for at least a few minutes
+		octo.backOff(minRateLimitBackoff, krakenErrs)
+	}
+
+	if errors.Is(krakenErrs, ErrTokenExpired) {
+		// Forget the token so that the next request gets a new one
+		octo.lock.Lock()
+		octo.TokenExpiresAt = octo.now().Add(-time.Second).Unix()
+		octo.lock.Unlock()
+	}
+
+	return krakenErrs
+}
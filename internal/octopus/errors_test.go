@@ -0,0 +1,88 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+)
+
+func TestKrakenErrorsMatchSentinels(t *testing.T) {
+	newError := func(code string, errorType string) KrakenError {
+		e := KrakenError{Message: "Something went wrong."}
+		e.Extensions.ErrorCode = code
+		e.Extensions.ErrorType = errorType
+		return e
+	}
+
+	octo := New()
+	err := octo.handleErrors(&[]KrakenError{
+		newError("KT-CT-9999", "VALIDATION"),
+		newError("KT-CT-4123", "APPLICATION"),
+	})
+
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected %v to match %v", err, ErrValidation)
+	}
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Expected %v to match %v", err, ErrAccountNotFound)
+	}
+	if errors.Is(err, ErrTooManyRequests) {
+		t.Errorf("Expected %v not to match %v", err, ErrTooManyRequests)
+	}
+
+	var krakenErr *KrakenError
+	if !errors.As(err, &krakenErr) || krakenErr.Extensions.ErrorCode != "KT-CT-9999" {
+		t.Errorf("errors.As() = %v, want the first Kraken error", krakenErr)
+	}
+
+	expected := "KT-CT-9999 Something went wrong.; KT-CT-4123 Something went wrong."
+	if err.Error() != expected {
+		t.Errorf("Error() = %q, want %q", err.Error(), expected)
+	}
+}
+
+func TestClientErrorsAreTyped(t *testing.T) {
+	tests := []struct {
+		operation string
+		code      string
+		expected  error
+	}{
+		{"ObtainKrakenToken", octopustest.ErrCodeAuthenticationFailed, ErrInvalidApiKey},
+		{"Account", octopustest.ErrCodeAccountNotFound, ErrAccountNotFound},
+		{"SmartMeterTelemetry", octopustest.ErrCodeTokenExpired, ErrTokenExpired},
+	}
+
+	for _, test := range tests {
+		octo, server, _ := newTestClient(t)
+		server.FailNext(test.operation, test.code, "Failed.")
+
+		_, err := octo.LiveConsumption(context.Background())
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%v failing with %v: error = %v, want %v", test.operation, test.code, err, test.expected)
+		}
+	}
+}
+
+func TestExpiredTokenIsReplaced(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	// The server says the token has expired before we expected it to
+	server.ExpireTokens()
+	server.FailNext("SmartMeterTelemetry", octopustest.ErrCodeTokenExpired, "Signature of the JWT has expired.")
+
+	_, err = octo.LiveConsumption(context.Background())
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("LiveConsumption() error = %v, want %v", err, ErrTokenExpired)
+	}
+
+	_, err = octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Errorf("LiveConsumption after token expired: %v", err)
+	}
+}
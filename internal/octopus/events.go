@@ -0,0 +1,141 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// The kind of a demand flexibility event.
+type EventType string
+
+const (
+	// A Saving Session, in which Octoplus members earn Octopoints for each
+	// kWh they use below their baseline.
+	EventSavingSession EventType = "SAVING_SESSION"
+	// A free electricity session, in which the electricity used is free.
+	EventFreeElectricity EventType = "FREE_ELECTRICITY"
+)
+
+// A demand flexibility event run by Octopus, such as a Saving Session or a
+// free electricity session.
+type FlexibilityEvent struct {
+	AccountNumber string    `json:"accountNumber"`
+	Type          EventType `json:"type"`
+	// The Kraken code of the event.
+	Code string `json:"code"`
+	// When the event starts (inclusive).
+	Start time.Time `json:"start"`
+	// When the event ends (exclusive).
+	End time.Time `json:"end"`
+	// The Octopoints earned for each kWh used below the baseline. Zero for
+	// free electricity sessions.
+	RewardPerKwh int `json:"rewardPerKwh"`
+	// Whether the account has joined the event. Accounts are always joined
+	// to free electricity sessions they are told about.
+	Joined bool `json:"joined"`
+	// The Octopoints awarded by Octopus for the event. Nil until they have
+	// been awarded.
+	PointsAwarded *int `json:"pointsAwarded"`
+}
+
+// A Saving Session, as listed for every customer.
+type krakenSavingSession struct {
+	Id           int       `json:"id"`
+	Code         string    `json:"code"`
+	StartAt      time.Time `json:"startAt"`
+	EndAt        time.Time `json:"endAt"`
+	RewardPerKwh int       `json:"rewardPerKwhInOctoPoints"`
+}
+
+// A Saving Session that an account has joined.
+type krakenJoinedEvent struct {
+	EventId int `json:"eventId"`
+	// Null or zero until the session has been settled.
+	RewardGiven *int `json:"rewardGivenInOctoPoints"`
+}
+
+// Returns the Saving Sessions and free electricity sessions of every
+// tracked account, past and upcoming, oldest first. Saving Sessions that an
+// account hasn't joined are included, so that upcoming ones can be shown.
+func (octo *Octopus) FlexibilityEvents(ctx context.Context) ([]*FlexibilityEvent, error) {
+	accountNumbers, err := octo.AccountNumbers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get flexibility events: %w", err)
+	}
+
+	events := []*FlexibilityEvent{}
+
+	for _, accountNumber := range accountNumbers {
+		data, err := Do[struct {
+			SavingSessions *struct {
+				Events  []krakenSavingSession `json:"events"`
+				Account *struct {
+					HasJoinedCampaign bool                `json:"hasJoinedCampaign"`
+					JoinedEvents      []krakenJoinedEvent `json:"joinedEvents"`
+				} `json:"account"`
+			} `json:"savingSessions"`
+			FreeElectricity *struct {
+				Edges []struct {
+					Node struct {
+						Code    string    `json:"code"`
+						StartAt time.Time `json:"startAt"`
+						EndAt   time.Time `json:"endAt"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"customerFlexibilityCampaignEvents"`
+		}](ctx, octo, "FlexibilityEvents", map[string]any{
+			"accountNumber": accountNumber,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Get flexibility events of account %v: %w", accountNumber, err)
+		}
+
+		if sessions := data.SavingSessions; sessions != nil {
+			joined := map[int]krakenJoinedEvent{}
+			if sessions.Account != nil {
+				for _, e := range sessions.Account.JoinedEvents {
+					joined[e.EventId] = e
+				}
+			}
+
+			for _, e := range sessions.Events {
+				event := &FlexibilityEvent{
+					AccountNumber: accountNumber,
+					Type:          EventSavingSession,
+					Code:          e.Code,
+					Start:         e.StartAt,
+					End:           e.EndAt,
+					RewardPerKwh:  e.RewardPerKwh,
+				}
+				if j, ok := joined[e.Id]; ok {
+					event.Joined = true
+					if j.RewardGiven != nil && *j.RewardGiven > 0 {
+						event.PointsAwarded = j.RewardGiven
+					}
+				}
+				events = append(events, event)
+			}
+		}
+
+		if free := data.FreeElectricity; free != nil {
+			for _, edge := range free.Edges {
+				events = append(events, &FlexibilityEvent{
+					AccountNumber: accountNumber,
+					Type:          EventFreeElectricity,
+					Code:          edge.Node.Code,
+					Start:         edge.Node.StartAt,
+					End:           edge.Node.EndAt,
+					Joined:        true,
+				})
+			}
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b *FlexibilityEvent) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	return events, nil
+}
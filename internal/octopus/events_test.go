@@ -0,0 +1,51 @@
+package octopus
+
+import (
+	"context"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+	"time"
+)
+
+func TestFlexibilityEvents(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	start := time.Date(2025, 1, 1, 17, 0, 0, 0, time.UTC)
+
+	server.AddSavingSessions(
+		octopustest.SavingSession{Id: 1, Code: "EVENT_1", Start: start, End: start.Add(time.Hour), RewardPerKwh: 1800},
+		octopustest.SavingSession{Id: 2, Code: "EVENT_2", Start: start.Add(48 * time.Hour), End: start.Add(49 * time.Hour), RewardPerKwh: 2400},
+	)
+	server.JoinSavingSession(server.AccountNumber, 1, 540)
+	server.AddFreeElectricitySessions(server.AccountNumber, octopustest.FreeElectricitySession{
+		Code:  "FREE_1",
+		Start: start.Add(24*time.Hour - 4*time.Hour),
+		End:   start.Add(24*time.Hour - 3*time.Hour),
+	})
+
+	events, err := octo.FlexibilityEvents(context.Background())
+	if err != nil {
+		t.Fatalf("FlexibilityEvents: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("FlexibilityEvents() returned %v events, want 3", len(events))
+	}
+
+	joined := events[0]
+	if joined.Type != EventSavingSession || joined.Code != "EVENT_1" || !joined.Start.Equal(start) || !joined.End.Equal(start.Add(time.Hour)) {
+		t.Errorf("FlexibilityEvents()[0] = %+v, want the joined Saving Session", joined)
+	}
+	if !joined.Joined || joined.RewardPerKwh != 1800 || joined.PointsAwarded == nil || *joined.PointsAwarded != 540 {
+		t.Errorf("FlexibilityEvents()[0] = %+v, want joined at 1800 points/kWh with 540 points awarded", joined)
+	}
+
+	free := events[1]
+	if free.Type != EventFreeElectricity || free.Code != "FREE_1" || !free.Joined || free.AccountNumber != server.AccountNumber {
+		t.Errorf("FlexibilityEvents()[1] = %+v, want the free electricity session", free)
+	}
+
+	upcoming := events[2]
+	if upcoming.Joined || upcoming.PointsAwarded != nil || upcoming.RewardPerKwh != 2400 {
+		t.Errorf("FlexibilityEvents()[2] = %+v, want the upcoming session not joined", upcoming)
+	}
+}
@@ -0,0 +1,61 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The kind of energy a meter measures.
+type Fuel string
+
+const (
+	FuelElectricity Fuel = "electricity"
+	FuelGas         Fuel = "gas"
+)
+
+// Parses a fuel name. An empty string is treated as [FuelElectricity].
+func ParseFuel(s string) (Fuel, error) {
+	switch Fuel(s) {
+	case "", FuelElectricity:
+		return FuelElectricity, nil
+	case FuelGas:
+		return FuelGas, nil
+	}
+	return "", fmt.Errorf("Unknown fuel %q", s)
+}
+
+// The calorific value of gas used if no other is given, in MJ/m³. The real
+// value varies slightly by region and day, and is shown on gas bills.
+const DefaultCalorificValue = 39.5
+
+// The standard correction for the temperature and pressure of the gas
+// supply, used by UK suppliers when converting volume to energy.
+const gasVolumeCorrection = 1.02264
+
+// Converts gas volumes to energy using the given calorific value, in MJ/m³,
+// rather than [DefaultCalorificValue].
+func WithCalorificValue(calorificValue float64) Option {
+	return func(octo *Octopus) {
+		octo.calorificValue = calorificValue
+	}
+}
+
+// Converts a volume of gas in m³ to energy in Wh.
+func (octo *Octopus) gasEnergy(volume float64) float64 {
+	calorificValue := octo.calorificValue
+	if calorificValue == 0 {
+		calorificValue = DefaultCalorificValue
+	}
+
+	// 1 kWh = 3.6 MJ
+	kwh := volume * gasVolumeCorrection * calorificValue / 3.6
+	return kwh * 1000
+}
+
+// Returns the half-hourly consumption of the given gas meter between from
+// and to, oldest first. Gas meters only report every half hour, so this is
+// the finest detail available for gas.
+func (octo *Octopus) GasConsumption(ctx context.Context, device Device, from, to time.Time) ([]*ConsumptionReading, error) {
+	return octo.Telemetry(ctx, device, from, to, GroupingThirtyMinutes)
+}
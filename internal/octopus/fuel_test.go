@@ -0,0 +1,80 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+	"time"
+)
+
+func TestGasConsumption(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+	octo.calorificValue = 40
+
+	start := clock.Now().Truncate(30 * time.Minute).Add(-time.Hour)
+	server.AddGasTelemetry(
+		octopustest.TelemetryReading{ReadAt: start, Consumption: 1000},
+		octopustest.TelemetryReading{ReadAt: start.Add(30 * time.Minute), Consumption: 1000.5},
+	)
+
+	device, err := octo.FirstDevice(context.Background(), GasImport)
+	if err != nil {
+		t.Fatalf("FirstDevice: %v", err)
+	}
+
+	readings, err := octo.GasConsumption(context.Background(), device, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GasConsumption: %v", err)
+	}
+	if len(readings) != 2 {
+		t.Fatalf("GasConsumption() returned %v readings, want 2", len(readings))
+	}
+
+	// 1 m³ at 40 MJ/m³ is 11363 Wh, and 0.5 m³ was used over half an hour
+	last := readings[1]
+	if last.Fuel != FuelGas {
+		t.Errorf("Fuel = %v, want %v", last.Fuel, FuelGas)
+	}
+	if last.TotalConsumption != 11368348 {
+		t.Errorf("TotalConsumption = %v, want 11368348", last.TotalConsumption)
+	}
+	if last.Demand != 11362 {
+		t.Errorf("Demand = %v, want 11362", last.Demand)
+	}
+}
+
+func TestMissingGasMeter(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+	server.GasDeviceId = ""
+
+	_, err := octo.FirstDevice(context.Background(), GasImport)
+	if !errors.Is(err, ErrMeterNotFound) {
+		t.Errorf("FirstDevice() error = %v, want %v", err, ErrMeterNotFound)
+	}
+
+	// Electricity still works
+	_, err = octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Errorf("LiveConsumption: %v", err)
+	}
+}
+
+func TestParseFuel(t *testing.T) {
+	tests := map[string]Fuel{
+		"":            FuelElectricity,
+		"electricity": FuelElectricity,
+		"gas":         FuelGas,
+	}
+	for s, expected := range tests {
+		fuel, err := ParseFuel(s)
+		if err != nil || fuel != expected {
+			t.Errorf("ParseFuel(%q) = %v, %v, want %v", s, fuel, err, expected)
+		}
+	}
+
+	_, err := ParseFuel("oil")
+	if err == nil {
+		t.Errorf("ParseFuel(\"oil\") succeeded")
+	}
+}
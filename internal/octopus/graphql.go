@@ -2,16 +2,16 @@ package octopus
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-const octopusBaseUrl = "https://api.octopus.energy/v1/graphql/"
-
-var httpClient = &http.Client{}
-
 type QueryBody struct {
 	// The name of the query, used for informative log outputs.
 	name      string
@@ -19,13 +19,16 @@ type QueryBody struct {
 	Variables map[string]any `json:"variables"`
 }
 
-func Query(q QueryBody, headers map[string]string) ([]byte, error) {
+// Sends a GraphQL query to the given URL using client, and returns the raw
+// response body. The request is cancelled if ctx is done. now gives the
+// current time, to work out how long a Retry-After date asks us to wait.
+func Query(ctx context.Context, client *http.Client, url string, q QueryBody, headers map[string]string, now func() time.Time) ([]byte, error) {
 	body, err := json.Marshal(q)
 	if err != nil {
 		return nil, err
 	}
 
-	request, err := http.NewRequest(http.MethodPost, octopusBaseUrl, bytes.NewBuffer(body))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +38,7 @@ func Query(q QueryBody, headers map[string]string) ([]byte, error) {
 		request.Header.Add(k, v)
 	}
 
-	response, err := httpClient.Do(request)
+	response, err := client.Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -48,5 +51,49 @@ func Query(q QueryBody, headers map[string]string) ([]byte, error) {
 		return nil, err
 	}
 
+	// Other error statuses still have a GraphQL errors body for the caller
+	// to handle
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return nil, &HTTPStatusError{
+			StatusCode: response.StatusCode,
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), now()),
+			Body:       string(responseBytes),
+		}
+	}
+
 	return responseBytes, nil
 }
+
+// Returned by [Query] if the API responds with a "Too many requests" or
+// server error status.
+type HTTPStatusError struct {
+	StatusCode int
+	// How long the server asked us to wait before retrying, from the
+	// Retry-After header. Zero if it didn't say.
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("Octopus API returned status %v", e.StatusCode)
+}
+
+// Parses a Retry-After header, which is either a number of seconds or an
+// HTTP date. Returns zero if the header is missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(header)
+	if err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+
+	date, err := http.ParseTime(header)
+	if err == nil {
+		return max(date.Sub(now), 0)
+	}
+
+	return 0
+}
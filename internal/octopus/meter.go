@@ -0,0 +1,110 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Whether a meter measures energy taken from the grid or sent to it.
+type Direction string
+
+const (
+	DirectionImport Direction = "import"
+	// Energy sent to the grid, e.g. from solar panels or a battery.
+	DirectionExport Direction = "export"
+)
+
+// Parses a direction name. An empty string is treated as
+// [DirectionImport].
+func ParseDirection(s string) (Direction, error) {
+	switch Direction(s) {
+	case "", DirectionImport:
+		return DirectionImport, nil
+	case DirectionExport:
+		return DirectionExport, nil
+	}
+	return "", fmt.Errorf("Unknown direction %q", s)
+}
+
+// Identifies one of the account's smart meters by what it measures.
+type Meter struct {
+	Fuel      Fuel      `json:"fuel"`
+	Direction Direction `json:"direction"`
+}
+
+var (
+	ElectricityImport = Meter{Fuel: FuelElectricity, Direction: DirectionImport}
+	ElectricityExport = Meter{Fuel: FuelElectricity, Direction: DirectionExport}
+	GasImport         = Meter{Fuel: FuelGas, Direction: DirectionImport}
+)
+
+func (m Meter) String() string {
+	if m.Direction == DirectionExport {
+		return fmt.Sprintf("%v export", m.Fuel)
+	}
+	return string(m.Fuel)
+}
+
+// Fills in a missing fuel or direction. Readings without them are from the
+// electricity import meter, which was the only meter before others were
+// supported.
+func (m Meter) OrDefault() Meter {
+	if m.Fuel == "" {
+		m.Fuel = FuelElectricity
+	}
+	if m.Direction == "" {
+		m.Direction = DirectionImport
+	}
+	return m
+}
+
+// Returns the meter that made the reading.
+func (r *ConsumptionReading) Meter() Meter {
+	return Meter{Fuel: r.Fuel, Direction: r.Direction}.OrDefault()
+}
+
+// A smart meter on an account.
+type Device struct {
+	// The Kraken device ID of the smart meter. Readings are labelled with
+	// this, so that the meters can be told apart.
+	Id string `json:"id"`
+	// The account the meter is on.
+	AccountNumber string `json:"accountNumber"`
+	// What the meter measures.
+	Meter Meter `json:"meter"`
+	// The MPAN (electricity) or MPRN (gas) of the meter point.
+	MeterPoint string `json:"meterPoint"`
+	// The serial number of the physical meter.
+	SerialNumber string `json:"serialNumber"`
+}
+
+// Returns the smart meters on the account, discovering them if necessary.
+func (octo *Octopus) Devices(ctx context.Context) ([]Device, error) {
+	err := octo.obtainAccountDetails(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get devices: %w", err)
+	}
+
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	return slices.Clone(octo.devices), nil
+}
+
+// Returns the first of the account's meters of the given kind, or an error
+// wrapping [ErrMeterNotFound] if the account doesn't have one.
+func (octo *Octopus) FirstDevice(ctx context.Context, meter Meter) (Device, error) {
+	devices, err := octo.Devices(ctx)
+	if err != nil {
+		return Device{}, err
+	}
+
+	for _, device := range devices {
+		if device.Meter == meter {
+			return device, nil
+		}
+	}
+
+	return Device{}, fmt.Errorf("%w: no %v smart meter found", ErrMeterNotFound, meter)
+}
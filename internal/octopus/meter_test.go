@@ -0,0 +1,131 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+)
+
+func TestLiveExport(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+	server.ExportDeviceId = "00-00-00-00-00-00-00-03"
+
+	server.AddExportTelemetry(octopustest.TelemetryReading{
+		ReadAt:      clock.Now(),
+		Consumption: 5000,
+		Demand:      1500,
+	})
+
+	device, err := octo.FirstDevice(context.Background(), ElectricityExport)
+	if err != nil {
+		t.Fatalf("FirstDevice: %v", err)
+	}
+
+	reading, err := octo.LiveReading(context.Background(), device)
+	if err != nil {
+		t.Fatalf("LiveReading: %v", err)
+	}
+	if reading.Meter() != ElectricityExport || reading.Demand != 1500 || reading.MeterId != server.ExportDeviceId {
+		t.Errorf("LiveReading() = %+v, want 1500W from the export meter", reading)
+	}
+
+	// The import meter is read separately
+	reading, err = octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+	if reading.Meter() != ElectricityImport || reading.Demand != 205 {
+		t.Errorf("LiveConsumption() = %+v, want 205W from the import meter", reading)
+	}
+}
+
+func TestExportMeterPoint(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	server.AddAccount("A-87654321",
+		octopustest.Device{Id: "import", Fuel: "electricity", MeterPoint: "2000000000001", SerialNumber: "E2"},
+		octopustest.Device{Id: "export", Fuel: "electricity", Export: true, MeterPoint: "2000000000002", SerialNumber: "E2"},
+	)
+
+	devices, err := octo.AccountDevices(context.Background(), "A-87654321")
+	if err != nil {
+		t.Fatalf("AccountDevices: %v", err)
+	}
+
+	// The meter is listed under both meter points, but each device is only
+	// on the meter point in its direction
+	if len(devices) != 2 {
+		t.Fatalf("AccountDevices() = %+v, want 2 devices", devices)
+	}
+	for _, device := range devices {
+		switch device.Meter {
+		case ElectricityImport:
+			if device.Id != "import" || device.MeterPoint != "2000000000001" {
+				t.Errorf("Import device = %+v, want the import MPAN", device)
+			}
+		case ElectricityExport:
+			if device.Id != "export" || device.MeterPoint != "2000000000002" {
+				t.Errorf("Export device = %+v, want the export MPAN", device)
+			}
+		}
+	}
+}
+
+func TestMissingExportMeter(t *testing.T) {
+	octo, _, _ := newTestClient(t)
+
+	_, err := octo.FirstDevice(context.Background(), ElectricityExport)
+	if !errors.Is(err, ErrMeterNotFound) {
+		t.Errorf("FirstDevice() error = %v, want %v", err, ErrMeterNotFound)
+	}
+}
+
+func TestDevicesOnSeveralAccounts(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	server.AddAccount("A-87654321",
+		octopustest.Device{Id: "second-import", Fuel: "electricity", MeterPoint: "2000000000001", SerialNumber: "E2"},
+		octopustest.Device{Id: "third-import", Fuel: "electricity", MeterPoint: "2000000000002", SerialNumber: "E3"},
+	)
+	server.AddDeviceTelemetry("third-import", octopustest.TelemetryReading{
+		ReadAt:      clock.Now(),
+		Consumption: 7000,
+		Demand:      900,
+	})
+	octo.accountNumbers = []string{server.AccountNumber, "A-87654321"}
+
+	devices, err := octo.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+
+	// The default account has an electricity and a gas meter
+	if len(devices) != 4 {
+		t.Fatalf("Devices() = %+v, want 4 devices", devices)
+	}
+
+	third := devices[3]
+	if third.Id != "third-import" || third.AccountNumber != "A-87654321" || third.MeterPoint != "2000000000002" || third.Meter != ElectricityImport {
+		t.Errorf("Devices()[3] = %+v, want the third import meter", third)
+	}
+
+	reading, err := octo.LiveReading(context.Background(), third)
+	if err != nil {
+		t.Fatalf("LiveReading: %v", err)
+	}
+	if reading.MeterId != "third-import" || reading.Demand != 900 {
+		t.Errorf("LiveReading() = %+v, want 900W from the third meter", reading)
+	}
+
+	if c := server.RequestCount("Account"); c != 2 {
+		t.Errorf("Expected 1 Account request per account, got %v", c)
+	}
+}
+
+func TestReadingMeterDefaultsToElectricityImport(t *testing.T) {
+	r := &ConsumptionReading{}
+	if r.Meter() != ElectricityImport {
+		t.Errorf("Meter() = %v, want %v", r.Meter(), ElectricityImport)
+	}
+}
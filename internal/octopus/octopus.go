@@ -1,19 +1,31 @@
 package octopus
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"os"
-	"strconv"
-	"strings"
+	"net/http"
+	"slices"
+	"sync"
 	"time"
 )
 
-// Encapsulates all methods for interacting with the Octopus API.
+// Encapsulates all methods for interacting with the Octopus API. It is safe
+// to use from several goroutines, and should be shared, so that they use
+// the same token and all back off together when the API is struggling.
 type Octopus struct {
+	// Guards the token, backoff, stats and account details below. It is
+	// never held while a request is being sent.
+	lock sync.Mutex
+	// Held while a token is obtained or refreshed, so that concurrent
+	// requests wait for one new token rather than each getting their own.
+	authLock sync.Mutex
+	// Held while the meters are discovered, so that they are only
+	// discovered once.
+	discoverLock sync.Mutex
+
 	// The kraken authentication token to use on API requests. Valid for
-	// one hour.
+	// one hour. See token.go for how tokens are obtained and refreshed.
+	// The token fields must not be changed once the client is in use.
 	Token string
 	// Unix timestamp when the token will expire.
 	TokenExpiresAt int64
@@ -21,394 +33,264 @@ type Octopus struct {
 	RefreshToken string
 	// Unix timestamp when the refresh token will expire.
 	RefreshTokenExpiresAt int64
-	// The Octopus account number (A-xxxxxxxx).
-	// Use [Octopus.AccountNumber()] to retrieve and cache the value.
-	accountNumber string
-	// The device ID of the electricity smart meter.
-	ElectricityMeterDeviceId string
-	// If we have received a "Too Many Requests" error from the API, this will be
-	// a non-zero Unix timestamp indicating when we can send API requests again.
-	retryAfter int64
-}
-
-// Checks if we have a valid auth token that has not expired.
-func (octo *Octopus) hasValidToken() bool {
-	if octo == nil || octo.Token == "" {
-		return false
-	}
-	return time.Now().Unix() < octo.TokenExpiresAt
-}
-
-// Checks if we have a valid refresh token that has not expired.
-func (octo *Octopus) hasValidRefreshToken() bool {
-	if octo == nil || octo.RefreshToken == "" {
-		return false
-	}
-	return time.Now().Unix() < octo.RefreshTokenExpiresAt
+	// Counts of token lifecycle events, for monitoring.
+	tokenStats TokenStats
+	// Request counts and timings for each operation, for monitoring.
+	operationStats map[string]OperationStats
+	// The Octopus account numbers (A-xxxxxxxx) to track, if they were
+	// configured. Use [Octopus.AccountNumbers()] to retrieve the value.
+	accountNumbers []string
+	// The accounts chosen with [Octopus.ChooseAccounts].
+	chosenAccountNumbers []string
+	// The accounts visible to the API key. Use [Octopus.Accounts] to
+	// discover and cache them.
+	accounts []Account
+	// The smart meters on the tracked accounts. Use [Octopus.Devices] to
+	// discover and cache them.
+	devices []Device
+	// The accounts that the devices above were found on.
+	devicesAccountNumbers []string
+	// Whether we are backing off from the API after failures, and for how
+	// long. See backoff.go.
+	backoff BackoffState
+
+	// Settings configured through [New]. The zero values use the defaults.
+	baseUrl     string
+	restBaseUrl string
+	httpClient  *http.Client
+	clock       func() time.Time
+	timeout     time.Duration
+	apiKey      string
+	// The calorific value of gas, in MJ/m³. See [WithCalorificValue].
+	calorificValue float64
+	// Called on every token lifecycle event.
+	onTokenEvent func(TokenEvent)
+	// Where credentials and account details are persisted between runs.
+	cache StateCache
+	// Whether we have loaded the cached state yet.
+	cacheLoaded bool
 }
 
-// Sends an API request to obtain a kraken auth token. The input can be
-// either an API key or a refresh token. Callers should use
-// [Octopus.authWithApiKey] or [Octopus.authWithRefreshToken] rather than
-// this method directly; they provide the necessary input arguments.
-func (octo *Octopus) obtainKrakenToken(input any) error {
+// Make a query to the Octopus API, ensuring we are authenticated first.
+func (octo *Octopus) query(ctx context.Context, q QueryBody) ([]byte, error) {
 	// If we are supposed to be waiting before API requests, do nothing
-	if time.Now().Unix() < octo.retryAfter {
-		return ErrSkippingRequest
-	}
-
-	q := QueryBody{
-		name: "ObtainKrakenToken",
-		Query: `mutation ObtainKrakenToken($input: ObtainJSONWebTokenInput!) {
-			obtainKrakenToken(input: $input) {
-				token
-				refreshToken
-				refreshExpiresIn
-			}
-		}`,
-		Variables: map[string]any{
-			"input": input,
-		},
+	if octo.isBackingOff() {
+		return nil, ErrSkippingRequest
 	}
 
-	responseBytes, err := Query(q, nil)
+	err := octo.auth(ctx)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("Failed to make Octopus request: %w", err)
 	}
 
-	response := struct {
-		Data struct {
-			ObtainKrakenToken *struct {
-				Token            string `json:"token"`
-				RefreshToken     string `json:"refreshToken"`
-				RefreshExpiresIn int    `json:"refreshExpiresIn"`
-			} `json:"obtainKrakenToken"`
-		} `json:"data"`
-		Errors *[]KrakenError `json:"errors"`
-	}{}
-
-	err = json.Unmarshal(responseBytes, &response)
-	if err != nil {
-		return err
+	// Refreshing the token may have been rate limited, even if we can carry
+	// on using the current token
+	if octo.isBackingOff() {
+		return nil, ErrSkippingRequest
 	}
 
-	if response.Data.ObtainKrakenToken == nil {
-		err = octo.handleErrors(response.Errors)
-		return fmt.Errorf("Failed to obtain Kraken token: %w", err)
+	headers := map[string]string{
+		"Authorization": octo.token(),
 	}
 
-	octo.Token = response.Data.ObtainKrakenToken.Token
-	// Auth token is valid for one hour
-	octo.TokenExpiresAt = time.Now().Add(time.Hour).Unix()
-	octo.RefreshToken = response.Data.ObtainKrakenToken.RefreshToken
-	octo.RefreshTokenExpiresAt = int64(response.Data.ObtainKrakenToken.RefreshExpiresIn)
-
-	return nil
+	return octo.send(ctx, q, headers)
 }
 
-// Wraps around [Octopus.obtainKrakenToken] to authenticate with the user's
-// API key. Expects the API key to be provided via the OCTOPUS_API_KEY
-// environment variable.
-func (octo *Octopus) authWithApiKey() error {
-	apiKey := os.Getenv("OCTOPUS_API_KEY")
+// Sends a query to the API, giving up if it takes longer than the
+// configured request timeout. Transient failures start or extend the
+// backoff. The backoff isn't reset here, since a response can still hold
+// rate limiting errors; see execute.
+func (octo *Octopus) send(ctx context.Context, q QueryBody, headers map[string]string) ([]byte, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, octo.requestTimeout())
+	defer cancel()
 
-	if apiKey == "" {
-		return errors.New("No API key available; OCTOPUS_API_KEY environment variable is not set")
-	}
-
-	return octo.obtainKrakenToken(struct {
-		APIKey string
-	}{
-		APIKey: apiKey,
-	})
-}
-
-// Wraps around [Octopus.obtainKrakenToken] to authenticate with the stored
-// refresh token. It is an error to call this method with an invalid refresh
-// token. Use [Octopus.hasValidRefreshToken] to check the validity of the
-// token before calling this method.
-func (octo *Octopus) authWithRefreshToken() error {
-	if octo.RefreshToken == "" {
-		return errors.New("No refresh token available")
+	responseBytes, err := Query(requestCtx, octo.client(), octo.endpoint(), q, headers, octo.now)
+	if err != nil {
+		// Don't back off if the caller gave up
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, octo.classifyFailure(err)
 	}
 
-	return octo.obtainKrakenToken(struct {
-		refreshToken string
-	}{
-		refreshToken: octo.RefreshToken,
-	})
+	return responseBytes, nil
 }
 
-// Authenticates to the Octopus API, obtaining a Kraken token if necessary.
-// This method should be called before making any API calls that require
-// authentication.
-func (octo *Octopus) auth() error {
-	if octo.hasValidToken() {
-		// We have a token; nothing to do here
-		return nil
-	}
+// Sends API requests to obtain the details of each account, finding every
+// smart meter on the accounts' active agreements. The result is cached to
+// avoid multiple requests.
+func (octo *Octopus) obtainAccountDetails(ctx context.Context) error {
+	octo.discoverLock.Lock()
+	defer octo.discoverLock.Unlock()
 
-	if octo.hasValidRefreshToken() {
-		// Token has expired but refresh token is still valid
-		// Authenticate with refresh token
-		err := octo.authWithRefreshToken()
-		if err != nil {
-			return fmt.Errorf("Failed to get kraken token: %w", err)
-		}
+	octo.loadCachedState()
 
-		return nil
-	}
-
-	// No valid token or refresh token
-	// authenticate fresh
-	err := octo.authWithApiKey()
+	accountNumbers, err := octo.AccountNumbers(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to get kraken token: %w", err)
-	}
-
-	return nil
-}
-
-// Make a query to the Octopus API, ensuring we are authenticated first.
-func (octo *Octopus) query(q QueryBody) ([]byte, error) {
-	// If we are supposed to be waiting before API requests, do nothing
-	if time.Now().Unix() < octo.retryAfter {
-		return nil, ErrSkippingRequest
+		return err
 	}
 
-	err := octo.auth()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to make Octopus request: %w", err)
+	// Check if we have cached account details
+	octo.lock.Lock()
+	cached := len(octo.devices) > 0 && slices.Equal(accountNumbers, octo.devicesAccountNumbers)
+	octo.lock.Unlock()
+	if cached {
+		return nil
 	}
 
-	// log.Println(octo.Token)
+	devices := []Device{}
 
-	headers := map[string]string{
-		"Authorization": octo.Token,
+	for _, accountNumber := range accountNumbers {
+		accountDevices, err := octo.AccountDevices(ctx, accountNumber)
+		if err != nil {
+			return err
+		}
+		devices = append(devices, accountDevices...)
 	}
 
-	return Query(q, headers)
-}
-
-// Returns the Octopus account number from the environment variable and caches it.
-func (octo *Octopus) AccountNumber() (string, error) {
-	if octo.accountNumber != "" {
-		return octo.accountNumber, nil
+	if len(devices) == 0 {
+		return fmt.Errorf("%w: no electricity or gas smart meters found", ErrMeterNotFound)
 	}
 
-	octo.accountNumber = os.Getenv("OCTOPUS_ACCOUNT_NUMBER")
+	octo.lock.Lock()
+	octo.devices = devices
+	octo.devicesAccountNumbers = accountNumbers
+	octo.lock.Unlock()
+	octo.saveCachedState()
 
-	if octo.accountNumber == "" {
-		return "", errors.New("No account number available; OCTOPUS_ACCOUNT_NUMBER environment variable is not set")
-	}
-	return octo.accountNumber, nil
+	return nil
 }
 
-// Sends an API request to obtain the Octopus account details. The result is cached
-// to avoid multiple requests.
-func (octo *Octopus) obtainAccountDetails() error {
-	// Check if we have cached account details
-	if octo.ElectricityMeterDeviceId != "" {
-		return nil
-	}
-
-	accountNumber, err := octo.AccountNumber()
+// Sends an API request to obtain the details of one account, and returns
+// the smart meters on its active agreements. The result isn't cached; use
+// [Octopus.Devices] for the meters on the tracked accounts.
+func (octo *Octopus) AccountDevices(ctx context.Context, accountNumber string) ([]Device, error) {
+	type smartDevice *struct {
+		DeviceId string `json:"deviceId"`
+	}
+
+	data, err := Do[struct {
+		Account *struct {
+			ElectricityAgreements []struct {
+				Tariff *struct {
+					IsExport bool `json:"isExport"`
+				} `json:"tariff"`
+				MeterPoint struct {
+					Mpan   string `json:"mpan"`
+					Meters []struct {
+						SerialNumber                string      `json:"serialNumber"`
+						SmartImportElectricityMeter smartDevice `json:"smartImportElectricityMeter"`
+						SmartExportElectricityMeter smartDevice `json:"smartExportElectricityMeter"`
+					} `json:"meters"`
+				} `json:"meterPoint"`
+			} `json:"electricityAgreements"`
+			GasAgreements []struct {
+				MeterPoint struct {
+					Mprn   string `json:"mprn"`
+					Meters []struct {
+						SerialNumber  string      `json:"serialNumber"`
+						SmartGasMeter smartDevice `json:"smartGasMeter"`
+					} `json:"meters"`
+				} `json:"meterPoint"`
+			} `json:"gasAgreements"`
+		} `json:"account"`
+	}](ctx, octo, "Account", map[string]any{
+		"accountNumber": accountNumber,
+	})
 	if err != nil {
-		return err
-	}
-
-	q := QueryBody{
-		name: "Account",
-		Query: `query Account($accountNumber: String!) {
-			account(accountNumber: $accountNumber) {
-				electricityAgreements(active: true) {
-					meterPoint {
-						meters(includeInactive: false) {
-							smartImportElectricityMeter {
-								deviceId
-							}
-						}
-					}
-				}
-			}
-		}`,
-		Variables: map[string]any{
-			"accountNumber": accountNumber,
-		},
+		return nil, fmt.Errorf("Failed to obtain data for account %v: %w", accountNumber, err)
 	}
-
-	responseBytes, err := octo.query(q)
-	if err != nil {
-		return fmt.Errorf("Get smart meter ID: %w", err)
+	if data.Account == nil {
+		return nil, fmt.Errorf("%w: account %v", ErrAccountNotFound, accountNumber)
 	}
 
-	response := struct {
-		Data struct {
-			Account *struct {
-				ElectricityAgreements []struct {
-					MeterPoint struct {
-						Meters []struct {
-							SmartImportElectricityMeter struct {
-								DeviceId string `json:"deviceId"`
-							} `json:"smartImportElectricityMeter"`
-						} `json:"meters"`
-					} `json:"meterPoint"`
-				} `json:"electricityAgreements"`
-			} `json:"account"`
-		} `json:"data"`
-		Errors *[]KrakenError `json:"errors"`
-	}{}
-
-	err = json.Unmarshal(responseBytes, &response)
-	if err != nil {
-		return fmt.Errorf("Deserialise accounts response: %w", err)
-	}
+	devices := []Device{}
 
-	if response.Errors != nil {
-		err = octo.handleErrors(response.Errors)
-		return fmt.Errorf("Failed to obtain account data: %w", err)
+	addDevice := func(smart smartDevice, meter Meter, meterPoint string, serialNumber string) {
+		if smart == nil || smart.DeviceId == "" {
+			return
+		}
+		devices = append(devices, Device{
+			Id:            smart.DeviceId,
+			AccountNumber: accountNumber,
+			Meter:         meter,
+			MeterPoint:    meterPoint,
+			SerialNumber:  serialNumber,
+		})
+	}
+
+	// A meter that exports is listed under both its import and its export
+	// meter point, so each device takes the MPAN of the agreement in its
+	// direction. Without an export agreement, the export device shares the
+	// import MPAN.
+	hasExportAgreement := false
+	for _, agreement := range data.Account.ElectricityAgreements {
+		if agreement.Tariff != nil && agreement.Tariff.IsExport {
+			hasExportAgreement = true
+		}
 	}
 
-	electricityAgreements := response.Data.Account.ElectricityAgreements
-	if len(electricityAgreements) == 0 {
-		return errors.New("No electricity agreements found")
+	for _, agreement := range data.Account.ElectricityAgreements {
+		export := agreement.Tariff != nil && agreement.Tariff.IsExport
+		for _, m := range agreement.MeterPoint.Meters {
+			if !export {
+				addDevice(m.SmartImportElectricityMeter, ElectricityImport, agreement.MeterPoint.Mpan, m.SerialNumber)
+			}
+			if export || !hasExportAgreement {
+				addDevice(m.SmartExportElectricityMeter, ElectricityExport, agreement.MeterPoint.Mpan, m.SerialNumber)
+			}
+		}
 	}
 
-	meters := electricityAgreements[0].MeterPoint.Meters
-	if len(meters) == 0 {
-		return errors.New("No electricity meters found")
+	for _, agreement := range data.Account.GasAgreements {
+		for _, m := range agreement.MeterPoint.Meters {
+			addDevice(m.SmartGasMeter, GasImport, agreement.MeterPoint.Mprn, m.SerialNumber)
+		}
 	}
 
-	octo.ElectricityMeterDeviceId = meters[0].SmartImportElectricityMeter.DeviceId
-
-	return nil
+	return devices, nil
 }
 
 type ConsumptionReading struct {
 	// The point in time that this reading was made.
 	Timestamp time.Time `json:"timestamp"`
-	// The total energy consumption of the meter, in Wh.
+	// The fuel and direction of the meter that made the reading. See
+	// [ConsumptionReading.Meter].
+	Fuel      Fuel      `json:"fuel"`
+	Direction Direction `json:"direction"`
+	// The device ID of the meter that made the reading. See [Device].
+	MeterId string `json:"meterId"`
+	// The total energy consumption of the meter, in Wh. For export meters,
+	// this is the total energy exported.
 	TotalConsumption int `json:"totalConsumption"`
-	// The current demand at the given timestamp, in W.
+	// The current demand at the given timestamp, in W. For gas, this is
+	// the average power over the half hour before the reading. For export
+	// meters, this is the power being exported.
 	Demand int `json:"demand"`
 }
 
-func (octo *Octopus) LiveConsumption() (*ConsumptionReading, error) {
-	err := octo.obtainAccountDetails()
+// Returns the most recent reading from the first electricity import meter.
+func (octo *Octopus) LiveConsumption(ctx context.Context) (*ConsumptionReading, error) {
+	device, err := octo.FirstDevice(ctx, ElectricityImport)
 	if err != nil {
 		return nil, fmt.Errorf("Get live consumption: %w", err)
 	}
 
-	q := QueryBody{
-		name: "SmartMeterTelemetry",
-		Query: `query SmartMeterTelemetry(
-			$deviceId: String!
-			$start: DateTime!
-			$end: DateTime!
-		) {
-			smartMeterTelemetry(
-				deviceId: $deviceId
-				grouping: TEN_SECONDS
-				start: $start
-				end: $end
-			) {
-				readAt
-				consumption
-				demand
-			}
-		}`,
-		Variables: map[string]any{
-			"deviceId": octo.ElectricityMeterDeviceId,
-			"start":    time.Now().Add(-20 * time.Second).Format(time.RFC3339),
-			"end":      time.Now().Format(time.RFC3339),
-		},
-	}
-
-	responseBytes, err := octo.query(q)
-	if err != nil {
-		return nil, fmt.Errorf("Get live consumption: %w", err)
-	}
+	return octo.LiveReading(ctx, device)
+}
 
-	response := struct {
-		Data struct {
-			SmartMeterTelemetry *[]struct {
-				ReadAt time.Time `json:"readAt"`
-				// String containing a float that is always to the nearest integer
-				Consumption string `json:"consumption"`
-				// String containing a float that is always to the nearest integer
-				Demand string `json:"demand"`
-			} `json:"smartMeterTelemetry"`
-		} `json:"data"`
-		Errors *[]KrakenError `json:"errors"`
-	}{}
-
-	err = json.Unmarshal(responseBytes, &response)
+// Returns the most recent reading from the given electricity meter. Gas
+// meters only report every half hour; use [Octopus.GasConsumption] instead.
+func (octo *Octopus) LiveReading(ctx context.Context, device Device) (*ConsumptionReading, error) {
+	end := octo.now()
+	readings, err := octo.smartMeterTelemetry(ctx, device, end.Add(-20*time.Second), end, GroupingTenSeconds)
 	if err != nil {
-		return nil, fmt.Errorf("Deserialise live consumption: %w", err)
-	}
-
-	if response.Errors != nil {
-		err = octo.handleErrors(response.Errors)
-		return nil, fmt.Errorf("Failed to obtain live consumption: %w", err)
+		return nil, fmt.Errorf("Get live %v reading: %w", device.Meter, err)
 	}
 
-	readings := *response.Data.SmartMeterTelemetry
 	if len(readings) == 0 {
-		return nil, errors.New("No electricity meter readings found")
-	}
-
-	latestReading := readings[len(readings)-1]
-
-	consumption, err := strconv.ParseFloat(latestReading.Consumption, 64)
-	if err != nil {
-		return nil, fmt.Errorf("Deserialise live consumption: %w", err)
-	}
-	demand, err := strconv.ParseFloat(latestReading.Demand, 64)
-	if err != nil {
-		return nil, fmt.Errorf("Deserialise live consumption: %w", err)
-	}
-
-	return &ConsumptionReading{
-		Timestamp:        latestReading.ReadAt,
-		TotalConsumption: int(consumption),
-		Demand:           int(demand),
-	}, nil
-}
-
-type KrakenError struct {
-	Message    string `json:"message"`
-	Extensions struct {
-		ErrorCode        string `json:"errorCode"`
-		ErrorType        string `json:"errorType"`
-		ErrorDescription string `json:"errorDescription"`
-	} `json:"extensions"`
-}
-
-// Handles any errors returned by the Octopus API. Performs any rectifying actions
-// if possible, e.g. pausing API requests for a small while if we get a "Too Many Requests"
-// error. Aggregates multiple error messages into a single message.
-func (octo *Octopus) handleErrors(errs *[]KrakenError) error {
-	if errs == nil || len(*errs) == 0 {
-		return nil
-	}
-
-	var sb strings.Builder
-
-	for i, e := range *errs {
-		// "Too Many Requests" error
-		if e.Extensions.ErrorCode == "KT-CT-1199" {
-			// Stop sending API requests for a few minutes
-			octo.retryAfter = time.Now().Add(5 * time.Minute).Unix()
-			return ErrTooManyRequests
-		}
-
-		if i > 0 {
-			sb.WriteString("; ")
-		}
-		sb.WriteString(e.Extensions.ErrorCode)
-		sb.WriteString(" ")
-		sb.WriteString(e.Message)
+		return nil, ErrNoReadings
 	}
 
-	return errors.New(sb.String())
+	return readings[len(readings)-1], nil
 }
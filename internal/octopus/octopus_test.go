@@ -89,3 +89,18 @@ func TestHasValidRefreshToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTelemetryGroupingChunks(t *testing.T) {
+	g, err := ParseTelemetryGrouping("TEN_SECONDS")
+	if err != nil {
+		t.Fatalf("ParseTelemetryGrouping: %v", err)
+	}
+	if g.chunkDuration() != time.Hour {
+		t.Errorf("chunkDuration() = %v, want %v", g.chunkDuration(), time.Hour)
+	}
+
+	_, err = ParseTelemetryGrouping("TEN_YEARS")
+	if err == nil {
+		t.Errorf("Expected error for unknown grouping")
+	}
+}
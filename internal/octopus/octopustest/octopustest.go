@@ -0,0 +1,1064 @@
+// This package provides an in-process fake of the Octopus Kraken GraphQL
+// API, for testing the octopus package without network access.
+//
+// The fake understands the operations that the octopus package sends,
+// identified by their operation name, and answers them from scripted data.
+// Errors can be queued for any operation to exercise error handling. GET
+// requests are answered as the REST API instead; see rest.go.
+package octopustest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Kraken error codes returned by the fake server.
+const (
+	// Too many requests.
+	ErrCodeTooManyRequests = "KT-CT-1199"
+	// The JWT has expired.
+	ErrCodeTokenExpired = "KT-CT-1124"
+	// The request was not authenticated.
+	ErrCodeUnauthorized = "KT-CT-1111"
+	// Authentication with an API key or refresh token failed.
+	ErrCodeAuthenticationFailed = "KT-CT-1139"
+	// The account does not exist or is not visible to the user.
+	ErrCodeAccountNotFound = "KT-CT-4123"
+	// The smart meter device does not exist.
+	ErrCodeDeviceNotFound = "KT-CT-4301"
+)
+
+// Matches the operation name of a GraphQL document, e.g.
+// "query Account(...)".
+var operationNameRegex = regexp.MustCompile(`(?:query|mutation)\s+(\w+)`)
+
+// An error returned in the "errors" list of a GraphQL response, or an HTTP
+// error status if Status is set.
+type Error struct {
+	Code    string
+	Message string
+	// If non-zero, respond with this HTTP status instead of a GraphQL error.
+	Status int
+	// The Retry-After header to send with an HTTP error status.
+	RetryAfter string
+}
+
+// A smart meter telemetry reading served by the fake.
+type TelemetryReading struct {
+	ReadAt time.Time
+	// The meter's total consumption, in Wh for electricity or m³ for gas.
+	Consumption float64
+	// The demand, in W. Gas meters don't report demand, so this is ignored
+	// for gas readings.
+	Demand float64
+}
+
+// A smart meter on an account served by the fake.
+type Device struct {
+	Id string
+	// "electricity" or "gas".
+	Fuel string
+	// Whether this is an electricity export meter.
+	Export bool
+	// The MPAN or MPRN of the meter point the meter is on.
+	MeterPoint   string
+	SerialNumber string
+}
+
+// A unit rate of a half-hourly tariff served by the fake.
+type UnitRate struct {
+	ValidFrom time.Time
+	ValidTo   time.Time
+	// The rate in pence per kWh, including VAT.
+	Value float64
+}
+
+// A supply agreement served by the fake's Agreements query. Which rates are
+// set decides the type of tariff: UnitRate for a flat tariff, DayRate and
+// NightRate for a day/night tariff, and UnitRates for a half-hourly tariff.
+type Agreement struct {
+	Id int
+	// "electricity" or "gas".
+	Fuel string
+	// The MPAN or MPRN of the meter point supplied.
+	MeterPoint string
+	ValidFrom  time.Time
+	// Nil if the agreement has no end.
+	ValidTo     *time.Time
+	TariffCode  string
+	ProductCode string
+	DisplayName string
+	// Rates are in pence (per day or per kWh), including VAT. Pre-VAT rates
+	// are derived from them at 5%.
+	StandingCharge float64
+	UnitRate       float64
+	DayRate        float64
+	NightRate      float64
+	UnitRates      []UnitRate
+}
+
+// A bill served by the fake's Billing query. Amounts are in pence.
+type Bill struct {
+	Id string
+	// e.g. "STATEMENT".
+	Type   string
+	Issued time.Time
+	// The first and last days billed. Only the dates are used.
+	From time.Time
+	To   time.Time
+	// Only statements have balances and totals.
+	OpeningBalance int
+	ClosingBalance int
+	Charges        int
+	Credits        int
+}
+
+// A payment served by the fake's Billing query.
+type Payment struct {
+	Id     string
+	Date   time.Time
+	Amount int
+	// e.g. "SUCCEEDED".
+	Status string
+}
+
+// The balance, bills and payments of an account served by the fake's
+// Billing query. Bills and payments are served in the order given.
+type Billing struct {
+	Balance  int
+	Bills    []Bill
+	Payments []Payment
+}
+
+// An Intelligent Octopus dispatch served by the fake's Dispatches query.
+type Dispatch struct {
+	Start time.Time
+	End   time.Time
+	// The energy charged, in kWh. It is served as a negative delta.
+	Energy   float64
+	Source   string
+	Location string
+}
+
+// A Saving Session served by the fake's FlexibilityEvents query to every
+// account.
+type SavingSession struct {
+	Id    int
+	Code  string
+	Start time.Time
+	End   time.Time
+	// Octopoints per kWh used below the baseline.
+	RewardPerKwh int
+}
+
+// A free electricity session served by the fake's FlexibilityEvents query.
+type FreeElectricitySession struct {
+	Code  string
+	Start time.Time
+	End   time.Time
+}
+
+// A request received by the fake server.
+type Request struct {
+	Operation     string
+	Variables     map[string]any
+	Authorization string
+}
+
+// A fake Kraken GraphQL server. Create one with [NewServer] and point the
+// octopus client at its URL.
+type Server struct {
+	*httptest.Server
+
+	lock sync.Mutex
+
+	// The API key that is accepted by ObtainKrakenToken.
+	ApiKey string
+	// The number of the default account, which has the meters below.
+	// Other accounts can be added with [Server.AddAccount].
+	AccountNumber string
+	// The device ID of the electricity smart meter on the account.
+	DeviceId string
+	// The device ID of the electricity export meter on the account. If
+	// empty, the account has no export meter.
+	ExportDeviceId string
+	// The device ID of the gas smart meter on the account. If empty, the
+	// account has no gas agreement.
+	GasDeviceId string
+	// The most results in one page of a REST response. If zero, pages are
+	// as large as the client asks for.
+	MaxPageSize int
+	// The smart meters on other accounts, by account number. Add to this
+	// with [Server.AddAccount].
+	accounts map[string][]Device
+	// The supply agreements of each account, by account number. Add to
+	// this with [Server.AddAgreements].
+	agreements map[string][]Agreement
+	// The billing details of each account, by account number. Set these
+	// with [Server.SetBilling].
+	billing map[string]Billing
+	// The planned and completed dispatches of each account, by account
+	// number. Add to these with [Server.AddPlannedDispatches] and
+	// [Server.AddCompletedDispatches].
+	plannedDispatches   map[string][]Dispatch
+	completedDispatches map[string][]Dispatch
+	// The Saving Sessions listed for every account. Add to this with
+	// [Server.AddSavingSessions].
+	savingSessions []SavingSession
+	// The Octopoints awarded for each Saving Session joined by each
+	// account, by account number then session ID. Zero until awarded.
+	// Join sessions with [Server.JoinSavingSession].
+	joinedSessions map[string]map[int]int
+	// The free electricity sessions of each account, by account number.
+	// Add to this with [Server.AddFreeElectricitySessions].
+	freeElectricity map[string][]FreeElectricitySession
+	// The readings returned by SmartMeterTelemetry, by device ID. Add to
+	// this with [Server.AddTelemetry] and friends.
+	telemetry map[string][]TelemetryReading
+	// The settled consumption returned by the REST API, by meter point and
+	// serial number. Add to this with [Server.AddConsumption].
+	consumption map[meterKey][]ConsumptionInterval
+	// Errors to return from upcoming requests, by operation name.
+	queuedErrors map[string][]Error
+	// Every request received, in order.
+	requests []Request
+	// How long issued tokens are valid for.
+	tokenLifetime time.Duration
+	// How long issued refresh tokens are valid for.
+	refreshTokenLifetime time.Duration
+	// Returns the current time, used for token expiry.
+	clock func() time.Time
+	// Tokens that have been issued, and when they expire.
+	tokens map[string]time.Time
+	// Refresh tokens that have been issued, and when they expire.
+	refreshTokens map[string]time.Time
+	// Used to make issued tokens unique.
+	tokenCount int
+}
+
+// Starts a new fake server. It should be closed with [Server.Close] when the
+// test finishes.
+func NewServer() *Server {
+	s := &Server{
+		ApiKey:               "sk_test_key",
+		AccountNumber:        "A-12345678",
+		DeviceId:             "00-00-00-00-00-00-00-01",
+		GasDeviceId:          "00-00-00-00-00-00-00-02",
+		accounts:             map[string][]Device{},
+		agreements:           map[string][]Agreement{},
+		billing:              map[string]Billing{},
+		plannedDispatches:    map[string][]Dispatch{},
+		completedDispatches:  map[string][]Dispatch{},
+		joinedSessions:       map[string]map[int]int{},
+		freeElectricity:      map[string][]FreeElectricitySession{},
+		telemetry:            map[string][]TelemetryReading{},
+		consumption:          map[meterKey][]ConsumptionInterval{},
+		queuedErrors:         map[string][]Error{},
+		tokenLifetime:        time.Hour,
+		refreshTokenLifetime: 7 * 24 * time.Hour,
+		clock:                time.Now,
+		tokens:               map[string]time.Time{},
+		refreshTokens:        map[string]time.Time{},
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// Queues an error to be returned by the next request for the given
+// operation, e.g. "SmartMeterTelemetry". Errors are returned in the order
+// they were queued, one per request.
+func (s *Server) FailNext(operation string, code string, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queuedErrors[operation] = append(s.queuedErrors[operation], Error{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// Queues an HTTP error status to be returned by the next request for the
+// given operation, with an optional Retry-After header.
+func (s *Server) FailNextHTTP(operation string, status int, retryAfter string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queuedErrors[operation] = append(s.queuedErrors[operation], Error{
+		Status:     status,
+		RetryAfter: retryAfter,
+	})
+}
+
+// Adds readings to be returned by SmartMeterTelemetry for the electricity
+// meter. Readings must be added in order, since the consumption delta of
+// each is calculated from the one before.
+func (s *Server) AddTelemetry(readings ...TelemetryReading) {
+	s.addTelemetry(s.DeviceId, readings)
+}
+
+// Adds readings to be returned by SmartMeterTelemetry for the export meter.
+func (s *Server) AddExportTelemetry(readings ...TelemetryReading) {
+	s.addTelemetry(s.ExportDeviceId, readings)
+}
+
+// Adds readings to be returned by SmartMeterTelemetry for the gas meter.
+func (s *Server) AddGasTelemetry(readings ...TelemetryReading) {
+	s.addTelemetry(s.GasDeviceId, readings)
+}
+
+// Adds readings to be returned by SmartMeterTelemetry for the given
+// device.
+func (s *Server) AddDeviceTelemetry(deviceId string, readings ...TelemetryReading) {
+	s.addTelemetry(deviceId, readings)
+}
+
+// Adds another account with the given smart meters, alongside the
+// default account.
+func (s *Server) AddAccount(accountNumber string, devices ...Device) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.accounts[accountNumber] = devices
+}
+
+// Adds supply agreements to the given account, returned by the
+// Agreements query.
+func (s *Server) AddAgreements(accountNumber string, agreements ...Agreement) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.agreements[accountNumber] = append(s.agreements[accountNumber], agreements...)
+}
+
+// Sets the balance, bills and payments of the given account, returned by
+// the Billing query.
+func (s *Server) SetBilling(accountNumber string, billing Billing) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.billing[accountNumber] = billing
+}
+
+// Adds planned dispatches to the given account, returned by the
+// Dispatches query.
+func (s *Server) AddPlannedDispatches(accountNumber string, dispatches ...Dispatch) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.plannedDispatches[accountNumber] = append(s.plannedDispatches[accountNumber], dispatches...)
+}
+
+// Adds completed dispatches to the given account, returned by the
+// Dispatches query.
+func (s *Server) AddCompletedDispatches(accountNumber string, dispatches ...Dispatch) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.completedDispatches[accountNumber] = append(s.completedDispatches[accountNumber], dispatches...)
+}
+
+// Adds Saving Sessions, returned to every account by the FlexibilityEvents
+// query.
+func (s *Server) AddSavingSessions(sessions ...SavingSession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.savingSessions = append(s.savingSessions, sessions...)
+}
+
+// Joins the given account to the Saving Session with the given ID, with the
+// Octopoints it was awarded, or zero if none have been awarded yet.
+func (s *Server) JoinSavingSession(accountNumber string, id int, pointsAwarded int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.joinedSessions[accountNumber] == nil {
+		s.joinedSessions[accountNumber] = map[int]int{}
+	}
+	s.joinedSessions[accountNumber][id] = pointsAwarded
+}
+
+// Adds free electricity sessions to the given account, returned by the
+// FlexibilityEvents query.
+func (s *Server) AddFreeElectricitySessions(accountNumber string, sessions ...FreeElectricitySession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.freeElectricity[accountNumber] = append(s.freeElectricity[accountNumber], sessions...)
+}
+
+func (s *Server) addTelemetry(deviceId string, readings []TelemetryReading) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.telemetry[deviceId] = append(s.telemetry[deviceId], readings...)
+}
+
+// Returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Request{}, s.requests...)
+}
+
+// Returns the number of requests received for the given operation.
+func (s *Server) RequestCount(operation string) int {
+	count := 0
+	for _, r := range s.Requests() {
+		if r.Operation == operation {
+			count++
+		}
+	}
+	return count
+}
+
+// Invalidates every token issued so far, as if they had expired.
+func (s *Server) ExpireTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tokens = map[string]time.Time{}
+}
+
+// Invalidates every refresh token issued so far.
+func (s *Server) RevokeRefreshTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.refreshTokens = map[string]time.Time{}
+}
+
+// Uses the given function to get the current time when issuing and
+// checking tokens, rather than [time.Now].
+func (s *Server) SetClock(now func() time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.clock = now
+}
+
+// Sets how long newly issued tokens are valid for.
+func (s *Server) SetTokenLifetime(lifetime time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tokenLifetime = lifetime
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.handleRest(w, r)
+		return
+	}
+
+	body := struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	match := operationNameRegex.FindStringSubmatch(body.Query)
+	if match == nil {
+		http.Error(w, "missing operation name", http.StatusBadRequest)
+		return
+	}
+	operation := match[1]
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.requests = append(s.requests, Request{
+		Operation:     operation,
+		Variables:     body.Variables,
+		Authorization: r.Header.Get("Authorization"),
+	})
+
+	if queued := s.queuedErrors[operation]; len(queued) > 0 {
+		s.queuedErrors[operation] = queued[1:]
+
+		if e := queued[0]; e.Status != 0 {
+			if e.RetryAfter != "" {
+				w.Header().Set("Retry-After", e.RetryAfter)
+			}
+			http.Error(w, http.StatusText(e.Status), e.Status)
+			return
+		}
+
+		writeErrors(w, queued[0])
+		return
+	}
+
+	if operation == "ObtainKrakenToken" {
+		s.obtainKrakenToken(w, body.Variables)
+		return
+	}
+
+	// Everything else requires authentication
+	token := r.Header.Get("Authorization")
+	expiresAt, ok := s.tokens[token]
+	if !ok {
+		writeErrors(w, Error{Code: ErrCodeUnauthorized, Message: "Unauthorized."})
+		return
+	}
+	if !s.clock().Before(expiresAt) {
+		writeErrors(w, Error{Code: ErrCodeTokenExpired, Message: "Signature of the JWT has expired."})
+		return
+	}
+
+	switch operation {
+	case "Viewer":
+		s.viewer(w)
+	case "Account":
+		s.account(w, body.Variables)
+	case "Agreements":
+		s.agreementsOf(w, body.Variables)
+	case "Billing":
+		s.billingOf(w, body.Variables)
+	case "Dispatches":
+		s.dispatchesOf(w, body.Variables)
+	case "FlexibilityEvents":
+		s.flexibilityEventsOf(w, body.Variables)
+	case "SmartMeterTelemetry":
+		s.smartMeterTelemetry(w, body.Variables)
+	default:
+		http.Error(w, fmt.Sprintf("unknown operation %q", operation), http.StatusBadRequest)
+	}
+}
+
+// Writes a GraphQL response with the given data.
+func writeData(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"data": data,
+	})
+}
+
+// Writes a GraphQL response with the given errors and no data.
+func writeErrors(w http.ResponseWriter, errs ...Error) {
+	errors := []any{}
+	for _, e := range errs {
+		errors = append(errors, map[string]any{
+			"message": e.Message,
+			"extensions": map[string]any{
+				"errorCode":        e.Code,
+				"errorType":        "APPLICATION",
+				"errorDescription": e.Message,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"data":   nil,
+		"errors": errors,
+	})
+}
+
+func (s *Server) obtainKrakenToken(w http.ResponseWriter, variables map[string]any) {
+	input, _ := variables["input"].(map[string]any)
+
+	apiKey, _ := input["APIKey"].(string)
+	refreshToken, _ := input["refreshToken"].(string)
+
+	now := s.clock()
+
+	refreshExpiresAt, ok := s.refreshTokens[refreshToken]
+	validRefreshToken := ok && now.Before(refreshExpiresAt)
+
+	if (apiKey == "" || apiKey != s.ApiKey) && !validRefreshToken {
+		writeErrors(w, Error{Code: ErrCodeAuthenticationFailed, Message: "Authentication failed."})
+		return
+	}
+
+	// Refresh tokens can only be used once
+	delete(s.refreshTokens, refreshToken)
+
+	s.tokenCount++
+	expiresAt := now.Add(s.tokenLifetime)
+	token := newJwt(s.tokenCount, now, expiresAt)
+	newRefreshToken := fmt.Sprintf("refresh-%v", s.tokenCount)
+	newRefreshExpiresAt := now.Add(s.refreshTokenLifetime)
+
+	s.tokens[token] = expiresAt
+	s.refreshTokens[newRefreshToken] = newRefreshExpiresAt
+
+	writeData(w, map[string]any{
+		"obtainKrakenToken": map[string]any{
+			"token":        token,
+			"refreshToken": newRefreshToken,
+			// Despite the name, this is a Unix timestamp
+			"refreshExpiresIn": newRefreshExpiresAt.Unix(),
+		},
+	})
+}
+
+// Creates an unsigned JWT with the given issue and expiry times. The ID
+// makes each token unique.
+func newJwt(id int, issuedAt time.Time, expiresAt time.Time) string {
+	encode := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	header := encode(map[string]any{"alg": "none", "typ": "JWT"})
+	payload := encode(map[string]any{
+		"jti": id,
+		"iat": issuedAt.Unix(),
+		"exp": expiresAt.Unix(),
+	})
+
+	return header + "." + payload + ".signature"
+}
+
+// Returns the smart meters on the given account, or false if the account
+// doesn't exist.
+func (s *Server) accountDevices(accountNumber string) ([]Device, bool) {
+	if accountNumber != s.AccountNumber {
+		devices, ok := s.accounts[accountNumber]
+		return devices, ok
+	}
+
+	devices := []Device{}
+	if s.DeviceId != "" {
+		devices = append(devices, Device{Id: s.DeviceId, Fuel: "electricity", MeterPoint: "1000000000001", SerialNumber: "21E0000001"})
+	}
+	if s.ExportDeviceId != "" {
+		devices = append(devices, Device{Id: s.ExportDeviceId, Fuel: "electricity", Export: true, MeterPoint: "1000000000001", SerialNumber: "21E0000001"})
+	}
+	if s.GasDeviceId != "" {
+		devices = append(devices, Device{Id: s.GasDeviceId, Fuel: "gas", MeterPoint: "1000000001", SerialNumber: "G4A0000001"})
+	}
+	return devices, true
+}
+
+// Returns the numbers of every account, the default account first.
+func (s *Server) accountNumbers() []string {
+	others := []string{}
+	for accountNumber := range s.accounts {
+		others = append(others, accountNumber)
+	}
+	slices.Sort(others)
+
+	return append([]string{s.AccountNumber}, others...)
+}
+
+// Returns the device with the given ID on any account.
+func (s *Server) findDevice(deviceId string) (Device, bool) {
+	for _, accountNumber := range s.accountNumbers() {
+		devices, _ := s.accountDevices(accountNumber)
+		for _, device := range devices {
+			if device.Id == deviceId {
+				return device, true
+			}
+		}
+	}
+	return Device{}, false
+}
+
+func (s *Server) viewer(w http.ResponseWriter) {
+	accounts := []any{}
+	for _, accountNumber := range s.accountNumbers() {
+		properties := []any{}
+		if accountNumber == s.AccountNumber {
+			properties = append(properties, map[string]any{
+				"id":       "1000001",
+				"address":  "1 Test Street, Testville",
+				"postcode": "TE1 1ST",
+			})
+		}
+
+		accounts = append(accounts, map[string]any{
+			"number":     accountNumber,
+			"properties": properties,
+		})
+	}
+
+	writeData(w, map[string]any{
+		"viewer": map[string]any{
+			"accounts": accounts,
+		},
+	})
+}
+
+func (s *Server) account(w http.ResponseWriter, variables map[string]any) {
+	accountNumber, _ := variables["accountNumber"].(string)
+	devices, ok := s.accountDevices(accountNumber)
+	if !ok {
+		writeErrors(w, Error{Code: ErrCodeAccountNotFound, Message: "Unauthorized."})
+		return
+	}
+
+	// Meters are grouped by meter point, and devices with the same serial
+	// number are the same physical meter. Like the real API, a meter with its
+	// export device on a separate meter point is listed with both devices
+	// under both meter points.
+	type meter struct {
+		serialNumber string
+		fields       map[string]any
+	}
+	type meterPoint struct {
+		id     string
+		fuel   string
+		meters []*meter
+		// Whether the meter point only has export devices.
+		export bool
+	}
+	meterPoints := []*meterPoint{}
+	meters := map[string]*meter{}
+
+	for _, device := range devices {
+		var mp *meterPoint
+		for _, existing := range meterPoints {
+			if existing.id == device.MeterPoint && existing.fuel == device.Fuel {
+				mp = existing
+			}
+		}
+		if mp == nil {
+			mp = &meterPoint{id: device.MeterPoint, fuel: device.Fuel, export: true}
+			meterPoints = append(meterPoints, mp)
+		}
+		mp.export = mp.export && device.Export
+
+		m, ok := meters[device.Fuel+"/"+device.SerialNumber]
+		if !ok {
+			m = &meter{
+				serialNumber: device.SerialNumber,
+				fields:       map[string]any{"serialNumber": device.SerialNumber},
+			}
+			meters[device.Fuel+"/"+device.SerialNumber] = m
+		}
+		if !slices.Contains(mp.meters, m) {
+			mp.meters = append(mp.meters, m)
+		}
+
+		field := "smartImportElectricityMeter"
+		switch {
+		case device.Fuel == "gas":
+			field = "smartGasMeter"
+		case device.Export:
+			field = "smartExportElectricityMeter"
+		}
+		m.fields[field] = map[string]any{"deviceId": device.Id}
+	}
+
+	electricityAgreements := []any{}
+	gasAgreements := []any{}
+
+	for _, mp := range meterPoints {
+		fields := []any{}
+		for _, m := range mp.meters {
+			fields = append(fields, m.fields)
+		}
+
+		if mp.fuel == "gas" {
+			gasAgreements = append(gasAgreements, map[string]any{
+				"meterPoint": map[string]any{"mprn": mp.id, "meters": fields},
+			})
+		} else {
+			electricityAgreements = append(electricityAgreements, map[string]any{
+				"tariff":     map[string]any{"isExport": mp.export},
+				"meterPoint": map[string]any{"mpan": mp.id, "meters": fields},
+			})
+		}
+	}
+
+	writeData(w, map[string]any{
+		"account": map[string]any{
+			"electricityAgreements": electricityAgreements,
+			"gasAgreements":         gasAgreements,
+		},
+	})
+}
+
+func (s *Server) agreementsOf(w http.ResponseWriter, variables map[string]any) {
+	accountNumber, _ := variables["accountNumber"].(string)
+	if _, ok := s.accountDevices(accountNumber); !ok {
+		writeErrors(w, Error{Code: ErrCodeAccountNotFound, Message: "Unauthorized."})
+		return
+	}
+
+	preVat := func(value float64) float64 {
+		return value / 1.05
+	}
+	formatTime := func(t *time.Time) any {
+		if t == nil {
+			return nil
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	electricityAgreements := []any{}
+	gasAgreements := []any{}
+
+	for _, a := range s.agreements[accountNumber] {
+		tariff := map[string]any{
+			"displayName":          a.DisplayName,
+			"productCode":          a.ProductCode,
+			"tariffCode":           a.TariffCode,
+			"standingCharge":       a.StandingCharge,
+			"preVatStandingCharge": preVat(a.StandingCharge),
+		}
+		switch {
+		case a.UnitRates != nil:
+			tariff["__typename"] = "HalfHourlyTariff"
+			unitRates := []any{}
+			for _, r := range a.UnitRates {
+				unitRates = append(unitRates, map[string]any{
+					"validFrom":   formatTime(&r.ValidFrom),
+					"validTo":     formatTime(&r.ValidTo),
+					"value":       r.Value,
+					"preVatValue": preVat(r.Value),
+				})
+			}
+			tariff["unitRates"] = unitRates
+		case a.DayRate != 0 || a.NightRate != 0:
+			tariff["__typename"] = "DayNightTariff"
+			tariff["dayRate"] = a.DayRate
+			tariff["preVatDayRate"] = preVat(a.DayRate)
+			tariff["nightRate"] = a.NightRate
+			tariff["preVatNightRate"] = preVat(a.NightRate)
+		default:
+			tariff["__typename"] = "StandardTariff"
+			if a.Fuel == "gas" {
+				tariff["__typename"] = "GasTariffType"
+			}
+			tariff["unitRate"] = a.UnitRate
+			tariff["preVatUnitRate"] = preVat(a.UnitRate)
+		}
+
+		agreement := map[string]any{
+			"id":        a.Id,
+			"validFrom": formatTime(&a.ValidFrom),
+			"validTo":   formatTime(a.ValidTo),
+			"tariff":    tariff,
+		}
+
+		if a.Fuel == "gas" {
+			agreement["meterPoint"] = map[string]any{"mprn": a.MeterPoint}
+			gasAgreements = append(gasAgreements, agreement)
+		} else {
+			agreement["meterPoint"] = map[string]any{"mpan": a.MeterPoint}
+			electricityAgreements = append(electricityAgreements, agreement)
+		}
+	}
+
+	writeData(w, map[string]any{
+		"account": map[string]any{
+			"electricityAgreements": electricityAgreements,
+			"gasAgreements":         gasAgreements,
+		},
+	})
+}
+
+func (s *Server) billingOf(w http.ResponseWriter, variables map[string]any) {
+	accountNumber, _ := variables["accountNumber"].(string)
+	if _, ok := s.accountDevices(accountNumber); !ok {
+		writeErrors(w, Error{Code: ErrCodeAccountNotFound, Message: "Unauthorized."})
+		return
+	}
+
+	// JSON numbers are decoded as floats
+	first := -1
+	if f, ok := variables["first"].(float64); ok {
+		first = int(f)
+	}
+
+	billing := s.billing[accountNumber]
+
+	bills := []any{}
+	for i, b := range billing.Bills {
+		if i == first {
+			break
+		}
+		node := map[string]any{
+			"id":         b.Id,
+			"billType":   b.Type,
+			"issuedDate": b.Issued.Format(time.DateOnly),
+			"fromDate":   b.From.Format(time.DateOnly),
+			"toDate":     b.To.Format(time.DateOnly),
+		}
+		if b.Type == "STATEMENT" {
+			node["openingBalance"] = b.OpeningBalance
+			node["closingBalance"] = b.ClosingBalance
+			node["totalCharges"] = map[string]any{"grossTotal": b.Charges}
+			node["totalCredits"] = map[string]any{"grossTotal": b.Credits}
+		}
+		bills = append(bills, map[string]any{"node": node})
+	}
+
+	payments := []any{}
+	for i, p := range billing.Payments {
+		if i == first {
+			break
+		}
+		payments = append(payments, map[string]any{"node": map[string]any{
+			"id":          p.Id,
+			"amount":      p.Amount,
+			"paymentDate": p.Date.Format(time.DateOnly),
+			"status":      p.Status,
+		}})
+	}
+
+	writeData(w, map[string]any{
+		"account": map[string]any{
+			"balance":  billing.Balance,
+			"bills":    map[string]any{"edges": bills},
+			"payments": map[string]any{"edges": payments},
+		},
+	})
+}
+
+func (s *Server) dispatchesOf(w http.ResponseWriter, variables map[string]any) {
+	accountNumber, _ := variables["accountNumber"].(string)
+	if _, ok := s.accountDevices(accountNumber); !ok {
+		writeErrors(w, Error{Code: ErrCodeAccountNotFound, Message: "Unauthorized."})
+		return
+	}
+
+	format := func(dispatches []Dispatch) []any {
+		formatted := []any{}
+		for _, d := range dispatches {
+			formatted = append(formatted, map[string]any{
+				"start": d.Start.Format(time.RFC3339),
+				"end":   d.End.Format(time.RFC3339),
+				"delta": fmt.Sprintf("%.4f", -d.Energy),
+				"meta": map[string]any{
+					"source":   d.Source,
+					"location": d.Location,
+				},
+			})
+		}
+		return formatted
+	}
+
+	writeData(w, map[string]any{
+		"plannedDispatches":   format(s.plannedDispatches[accountNumber]),
+		"completedDispatches": format(s.completedDispatches[accountNumber]),
+	})
+}
+
+func (s *Server) flexibilityEventsOf(w http.ResponseWriter, variables map[string]any) {
+	accountNumber, _ := variables["accountNumber"].(string)
+	if _, ok := s.accountDevices(accountNumber); !ok {
+		writeErrors(w, Error{Code: ErrCodeAccountNotFound, Message: "Unauthorized."})
+		return
+	}
+
+	events := []any{}
+	joinedEvents := []any{}
+	for _, e := range s.savingSessions {
+		events = append(events, map[string]any{
+			"id":                       e.Id,
+			"code":                     e.Code,
+			"startAt":                  e.Start.Format(time.RFC3339),
+			"endAt":                    e.End.Format(time.RFC3339),
+			"rewardPerKwhInOctoPoints": e.RewardPerKwh,
+		})
+
+		if points, ok := s.joinedSessions[accountNumber][e.Id]; ok {
+			joinedEvents = append(joinedEvents, map[string]any{
+				"eventId":                 e.Id,
+				"startAt":                 e.Start.Format(time.RFC3339),
+				"endAt":                   e.End.Format(time.RFC3339),
+				"rewardGivenInOctoPoints": points,
+			})
+		}
+	}
+
+	freeElectricity := []any{}
+	for _, e := range s.freeElectricity[accountNumber] {
+		freeElectricity = append(freeElectricity, map[string]any{"node": map[string]any{
+			"code":    e.Code,
+			"startAt": e.Start.Format(time.RFC3339),
+			"endAt":   e.End.Format(time.RFC3339),
+		}})
+	}
+
+	writeData(w, map[string]any{
+		"savingSessions": map[string]any{
+			"events": events,
+			"account": map[string]any{
+				"hasJoinedCampaign": len(s.joinedSessions[accountNumber]) > 0,
+				"joinedEvents":      joinedEvents,
+			},
+		},
+		"customerFlexibilityCampaignEvents": map[string]any{"edges": freeElectricity},
+	})
+}
+
+func (s *Server) smartMeterTelemetry(w http.ResponseWriter, variables map[string]any) {
+	deviceId, _ := variables["deviceId"].(string)
+	device, ok := s.findDevice(deviceId)
+	if deviceId == "" || !ok {
+		writeErrors(w, Error{Code: ErrCodeDeviceNotFound, Message: "Unable to find device."})
+		return
+	}
+
+	telemetry := s.telemetry[deviceId]
+	gas := device.Fuel == "gas"
+
+	start, err := parseTimeVariable(variables, "start")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	end, err := parseTimeVariable(variables, "end")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	readings := []any{}
+	for i, r := range telemetry {
+		if r.ReadAt.Before(start) || r.ReadAt.After(end) {
+			continue
+		}
+
+		delta := 0.0
+		if i > 0 {
+			delta = r.Consumption - telemetry[i-1].Consumption
+		}
+
+		reading := map[string]any{
+			"readAt":           r.ReadAt.Format(time.RFC3339),
+			"consumption":      fmt.Sprintf("%.1f", r.Consumption),
+			"consumptionDelta": fmt.Sprintf("%.1f", delta),
+			"demand":           fmt.Sprintf("%.1f", r.Demand),
+		}
+		if gas {
+			reading["consumption"] = fmt.Sprintf("%.3f", r.Consumption)
+			reading["consumptionDelta"] = fmt.Sprintf("%.3f", delta)
+			reading["demand"] = nil
+		}
+
+		readings = append(readings, reading)
+	}
+
+	writeData(w, map[string]any{
+		"smartMeterTelemetry": readings,
+	})
+}
+
+// Parses an RFC3339 timestamp from the request variables.
+func parseTimeVariable(variables map[string]any, name string) (time.Time, error) {
+	value, ok := variables[name].(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("missing variable %q", name)
+	}
+	return time.Parse(time.RFC3339, value)
+}
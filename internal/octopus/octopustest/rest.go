@@ -0,0 +1,190 @@
+package octopustest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Matches the REST consumption endpoint of a meter, e.g.
+// "/electricity-meter-points/{mpan}/meters/{serial}/consumption/".
+var consumptionPathRegex = regexp.MustCompile(`/(electricity|gas)-meter-points/([^/]+)/meters/([^/]+)/consumption/?$`)
+
+// Identifies a physical meter in the REST API.
+type meterKey struct {
+	meterPoint   string
+	serialNumber string
+}
+
+// An interval of settled consumption served by the fake's REST API.
+type ConsumptionInterval struct {
+	IntervalStart time.Time
+	IntervalEnd   time.Time
+	// The energy used, in kWh for electricity or m³ for gas.
+	Consumption float64
+}
+
+// Adds settled consumption to be returned by the REST API for the meter
+// with the given meter point and serial number. Intervals must be added in
+// order.
+func (s *Server) AddConsumption(meterPoint string, serialNumber string, intervals ...ConsumptionInterval) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := meterKey{meterPoint: meterPoint, serialNumber: serialNumber}
+	s.consumption[key] = append(s.consumption[key], intervals...)
+}
+
+// Answers a GET request as the REST API. Requests are recorded with the
+// query parameters as their variables, and errors can be queued for them
+// under the "Consumption" operation.
+func (s *Server) handleRest(w http.ResponseWriter, r *http.Request) {
+	match := consumptionPathRegex.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		writeRestError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	operation := "Consumption"
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	variables := map[string]any{}
+	for name := range r.URL.Query() {
+		variables[name] = r.URL.Query().Get(name)
+	}
+	s.requests = append(s.requests, Request{
+		Operation:     operation,
+		Variables:     variables,
+		Authorization: r.Header.Get("Authorization"),
+	})
+
+	if queued := s.queuedErrors[operation]; len(queued) > 0 {
+		s.queuedErrors[operation] = queued[1:]
+
+		e := queued[0]
+		if e.RetryAfter != "" {
+			w.Header().Set("Retry-After", e.RetryAfter)
+		}
+		writeRestError(w, max(e.Status, http.StatusBadRequest), e.Message)
+		return
+	}
+
+	apiKey, _, ok := r.BasicAuth()
+	if !ok || apiKey != s.ApiKey {
+		writeRestError(w, http.StatusUnauthorized, "Invalid API key.")
+		return
+	}
+
+	key := meterKey{meterPoint: match[2], serialNumber: match[3]}
+	intervals, ok := s.consumption[key]
+	if !ok {
+		writeRestError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	results, err := filterConsumption(intervals, r)
+	if err != nil {
+		writeRestError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Page through the results
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	if s.MaxPageSize > 0 {
+		pageSize = min(pageSize, s.MaxPageSize)
+	}
+
+	start := min((page-1)*pageSize, len(results))
+	end := min(start+pageSize, len(results))
+
+	var next any
+	if end < len(results) {
+		query := r.URL.Query()
+		query.Set("page", fmt.Sprint(page+1))
+		next = fmt.Sprintf("http://%v%v?%v", r.Host, r.URL.Path, query.Encode())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"count":    len(results),
+		"next":     next,
+		"previous": nil,
+		"results":  results[start:end],
+	})
+}
+
+// Returns the intervals within the request's period, grouped as asked, in
+// the form the REST API returns them.
+func filterConsumption(intervals []ConsumptionInterval, r *http.Request) ([]map[string]any, error) {
+	query := r.URL.Query()
+
+	var from, to time.Time
+	var err error
+	if value := query.Get("period_from"); value != "" {
+		from, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value := query.Get("period_to"); value != "" {
+		to, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the groupings with a fixed length are supported
+	var grouping time.Duration
+	switch query.Get("group_by") {
+	case "":
+	case "hour":
+		grouping = time.Hour
+	case "day":
+		grouping = 24 * time.Hour
+	default:
+		return nil, fmt.Errorf("unsupported group_by %q", query.Get("group_by"))
+	}
+
+	results := []map[string]any{}
+	for _, interval := range intervals {
+		if interval.IntervalStart.Before(from) || (!to.IsZero() && !interval.IntervalStart.Before(to)) {
+			continue
+		}
+
+		start, end := interval.IntervalStart, interval.IntervalEnd
+		if grouping > 0 {
+			start = start.Truncate(grouping)
+			end = start.Add(grouping)
+
+			if n := len(results); n > 0 && results[n-1]["interval_start"] == start.Format(time.RFC3339) {
+				results[n-1]["consumption"] = results[n-1]["consumption"].(float64) + interval.Consumption
+				continue
+			}
+		}
+
+		results = append(results, map[string]any{
+			"consumption":    interval.Consumption,
+			"interval_start": start.Format(time.RFC3339),
+			"interval_end":   end.Format(time.RFC3339),
+		})
+	}
+	return results, nil
+}
+
+// Writes a REST API error response.
+func writeRestError(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"detail": detail,
+	})
+}
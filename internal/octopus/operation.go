@@ -0,0 +1,129 @@
+package octopus
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The GraphQL documents for each operation, one per file, named after the
+// operation. To add a new Kraken query, add a file to queries/ and call [Do]
+// with its name.
+//
+//go:embed queries/*.graphql
+var queries embed.FS
+
+// Returns the GraphQL document for the named operation.
+func loadQuery(operation string) (string, error) {
+	query, err := queries.ReadFile("queries/" + operation + ".graphql")
+	if err != nil {
+		return "", fmt.Errorf("Unknown Octopus operation %q", operation)
+	}
+	return string(query), nil
+}
+
+// Request counts and timings for one GraphQL operation.
+type OperationStats struct {
+	Requests int
+	// Requests that returned an error, including Kraken errors in an
+	// otherwise successful response.
+	Failures int
+	// The total time spent on requests, including authentication.
+	Duration time.Duration
+}
+
+// Returns the request counts and timings so far, keyed by operation name.
+func (octo *Octopus) OperationStats() map[string]OperationStats {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	stats := make(map[string]OperationStats, len(octo.operationStats))
+	for operation, s := range octo.operationStats {
+		stats[operation] = s
+	}
+	return stats
+}
+
+// Records the outcome of a request for [Octopus.OperationStats].
+func (octo *Octopus) recordOperation(operation string, duration time.Duration, err error) {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	if octo.operationStats == nil {
+		octo.operationStats = map[string]OperationStats{}
+	}
+
+	s := octo.operationStats[operation]
+	s.Requests++
+	s.Duration += duration
+	if err != nil {
+		s.Failures++
+	}
+	octo.operationStats[operation] = s
+}
+
+// Sends the named operation with the given variables, authenticating first,
+// and decodes the response's data into T. The query is read from
+// queries/<operation>.graphql. If the response has errors, they are
+// returned as [KrakenErrors].
+func Do[T any](ctx context.Context, octo *Octopus, operation string, variables map[string]any) (*T, error) {
+	return do[T](ctx, octo, operation, variables, true)
+}
+
+// Like [Do], but only authenticates if authenticated is true. Used to
+// obtain the token in the first place.
+func do[T any](ctx context.Context, octo *Octopus, operation string, variables map[string]any, authenticated bool) (*T, error) {
+	start := time.Now()
+	data, err := execute[T](ctx, octo, operation, variables, authenticated)
+	octo.recordOperation(operation, time.Since(start), err)
+	return data, err
+}
+
+func execute[T any](ctx context.Context, octo *Octopus, operation string, variables map[string]any, authenticated bool) (*T, error) {
+	query, err := loadQuery(operation)
+	if err != nil {
+		return nil, err
+	}
+
+	q := QueryBody{
+		name:      operation,
+		Query:     query,
+		Variables: variables,
+	}
+
+	var responseBytes []byte
+	if authenticated {
+		responseBytes, err = octo.query(ctx, q)
+	} else {
+		responseBytes, err = octo.send(ctx, q, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := struct {
+		Data   *T             `json:"data"`
+		Errors *[]KrakenError `json:"errors"`
+	}{}
+
+	err = json.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return nil, fmt.Errorf("Deserialise %v response: %w", operation, err)
+	}
+
+	err = octo.handleErrors(response.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only a response without errors shows that the API has recovered
+	octo.resetBackoff()
+
+	if response.Data == nil {
+		return nil, fmt.Errorf("Octopus %v response has no data", operation)
+	}
+
+	return response.Data, nil
+}
@@ -0,0 +1,74 @@
+package octopus
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestQueryFilesAreNamedAfterOperation(t *testing.T) {
+	operationName := regexp.MustCompile(`^(?:query|mutation)\s+(\w+)`)
+
+	files, err := fs.Glob(queries, "queries/*.graphql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No embedded queries: %v", err)
+	}
+
+	for _, file := range files {
+		operation := strings.TrimSuffix(strings.TrimPrefix(file, "queries/"), ".graphql")
+
+		query, err := loadQuery(operation)
+		if err != nil {
+			t.Fatalf("loadQuery(%q): %v", operation, err)
+		}
+
+		match := operationName.FindStringSubmatch(query)
+		if match == nil || match[1] != operation {
+			t.Errorf("%v defines operation %v, want %v", file, match, operation)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	data, err := Do[struct {
+		Account *struct {
+			ElectricityAgreements []struct{} `json:"electricityAgreements"`
+		} `json:"account"`
+	}](context.Background(), octo, "Account", map[string]any{
+		"accountNumber": server.AccountNumber,
+	})
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if data.Account == nil || len(data.Account.ElectricityAgreements) != 1 {
+		t.Errorf("Do() = %+v, want one electricity agreement", data)
+	}
+
+	server.FailNext("Account", octopustest.ErrCodeAccountNotFound, "Account not found.")
+
+	_, err = Do[struct{}](context.Background(), octo, "Account", map[string]any{
+		"accountNumber": server.AccountNumber,
+	})
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Do() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	_, err = Do[struct{}](context.Background(), octo, "NoSuchOperation", nil)
+	if err == nil {
+		t.Errorf("Do() with an unknown operation succeeded")
+	}
+
+	stats := octo.OperationStats()
+	if stats["Account"].Requests != 2 || stats["Account"].Failures != 1 {
+		t.Errorf("Account stats = %+v, want 2 requests and 1 failure", stats["Account"])
+	}
+	if stats["ObtainKrakenToken"].Requests != 1 {
+		t.Errorf("ObtainKrakenToken stats = %+v, want 1 request", stats["ObtainKrakenToken"])
+	}
+}
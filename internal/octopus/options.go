@@ -0,0 +1,131 @@
+package octopus
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The Kraken GraphQL endpoint used if no other is given.
+const defaultBaseUrl = "https://api.octopus.energy/v1/graphql/"
+
+// The REST API used if no other is given.
+const defaultRestBaseUrl = "https://api.octopus.energy/v1/"
+
+// How long a single API request may take before it is cancelled, if no
+// other timeout is given.
+const defaultRequestTimeout = 30 * time.Second
+
+// Configures an [Octopus] created with [New].
+type Option func(*Octopus)
+
+// Creates a new [Octopus] client. Without any options, it talks to the real
+// Octopus API, reads credentials from the environment and discovers the
+// accounts visible to the API key.
+func New(opts ...Option) *Octopus {
+	octo := &Octopus{}
+	for _, opt := range opts {
+		opt(octo)
+	}
+	return octo
+}
+
+// Sends GraphQL requests to the given URL instead of the Octopus API.
+func WithBaseUrl(url string) Option {
+	return func(octo *Octopus) {
+		octo.baseUrl = url
+	}
+}
+
+// Sends REST requests, such as for settled consumption, to the given URL
+// instead of the Octopus API.
+func WithRestBaseUrl(url string) Option {
+	return func(octo *Octopus) {
+		octo.restBaseUrl = url
+	}
+}
+
+// Sends requests using the given HTTP client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(octo *Octopus) {
+		octo.httpClient = client
+	}
+}
+
+// Sends requests using an HTTP client with the given transport.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(octo *Octopus) {
+		octo.httpClient = &http.Client{Transport: transport}
+	}
+}
+
+// Uses the given function to get the current time, rather than [time.Now].
+func WithClock(now func() time.Time) Option {
+	return func(octo *Octopus) {
+		octo.clock = now
+	}
+}
+
+// Cancels each API request if it takes longer than the given duration.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(octo *Octopus) {
+		octo.timeout = timeout
+	}
+}
+
+// Authenticates with the given API key, rather than reading it from the
+// OCTOPUS_API_KEY environment variable.
+func WithApiKey(apiKey string) Option {
+	return func(octo *Octopus) {
+		octo.apiKey = apiKey
+	}
+}
+
+// Tracks the given accounts, rather than reading the account numbers from
+// the OCTOPUS_ACCOUNT_NUMBER environment variable or discovering them.
+func WithAccountNumbers(accountNumbers ...string) Option {
+	return func(octo *Octopus) {
+		octo.accountNumbers = accountNumbers
+	}
+}
+
+// The current time, according to the configured clock.
+func (octo *Octopus) now() time.Time {
+	if octo.clock == nil {
+		return time.Now()
+	}
+	return octo.clock()
+}
+
+// The GraphQL endpoint to send requests to.
+func (octo *Octopus) endpoint() string {
+	if octo.baseUrl == "" {
+		return defaultBaseUrl
+	}
+	return octo.baseUrl
+}
+
+// The URL of the given REST API path, e.g. "electricity-meter-points/".
+func (octo *Octopus) restEndpoint(path string) string {
+	baseUrl := octo.restBaseUrl
+	if baseUrl == "" {
+		baseUrl = defaultRestBaseUrl
+	}
+	return strings.TrimSuffix(baseUrl, "/") + "/" + path
+}
+
+// The HTTP client to send requests with.
+func (octo *Octopus) client() *http.Client {
+	if octo.httpClient == nil {
+		return http.DefaultClient
+	}
+	return octo.httpClient
+}
+
+// How long a single API request may take.
+func (octo *Octopus) requestTimeout() time.Duration {
+	if octo.timeout == 0 {
+		return defaultRequestTimeout
+	}
+	return octo.timeout
+}
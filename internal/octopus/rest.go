@@ -0,0 +1,98 @@
+package octopus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Sends a GET request to the REST API, authenticated with the API key, and
+// decodes the JSON response into T. The operation names the request in
+// logs and [Octopus.OperationStats]. Rate limits and server errors start a
+// backoff, as they do for GraphQL requests.
+func getRest[T any](ctx context.Context, octo *Octopus, operation string, url string) (*T, error) {
+	start := time.Now()
+	data, err := fetchRest[T](ctx, octo, operation, url)
+	octo.recordOperation(operation, time.Since(start), err)
+	return data, err
+}
+
+func fetchRest[T any](ctx context.Context, octo *Octopus, operation string, url string) (*T, error) {
+	if octo.isBackingOff() {
+		return nil, ErrSkippingRequest
+	}
+
+	apiKey, err := octo.resolveApiKey()
+	if err != nil {
+		return nil, err
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, octo.requestTimeout())
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(requestCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The REST API takes the API key as the basic auth username
+	request.SetBasicAuth(apiKey, "")
+
+	response, err := octo.client().Do(request)
+	if err != nil {
+		// Don't back off if the caller gave up
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, octo.classifyFailure(err)
+	}
+	defer response.Body.Close()
+
+	log.Printf("Octopus request '%s' returned status %v", operation, response.StatusCode)
+
+	responseBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return nil, octo.classifyFailure(&HTTPStatusError{
+			StatusCode: response.StatusCode,
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), octo.now()),
+			Body:       string(responseBytes),
+		})
+	case response.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidApiKey, restErrorDetail(responseBytes))
+	case response.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, restErrorDetail(responseBytes))
+	case response.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %v", ErrMeterNotFound, restErrorDetail(responseBytes))
+	case response.StatusCode >= 400:
+		return nil, fmt.Errorf("%w: %v", ErrValidation, restErrorDetail(responseBytes))
+	}
+
+	var data T
+	err = json.Unmarshal(responseBytes, &data)
+	if err != nil {
+		return nil, fmt.Errorf("Deserialise %v response: %w", operation, err)
+	}
+
+	octo.resetBackoff()
+	return &data, nil
+}
+
+// Returns the message of a REST API error response, which is usually in a
+// "detail" field.
+func restErrorDetail(body []byte) string {
+	response := struct {
+		Detail string `json:"detail"`
+	}{}
+	if json.Unmarshal(body, &response) == nil && response.Detail != "" {
+		return response.Detail
+	}
+	return string(body)
+}
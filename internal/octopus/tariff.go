@@ -0,0 +1,209 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The time-of-use band that a unit rate applies in.
+type RateBand string
+
+const (
+	// The rate of a flat tariff, or one slot of a half-hourly tariff such as
+	// Agile.
+	BandStandard RateBand = "standard"
+	// The day rate of a day/night (e.g. Economy 7) or three-rate tariff.
+	BandDay RateBand = "day"
+	// The night rate of a day/night or three-rate tariff.
+	BandNight RateBand = "night"
+	// The off-peak rate of a three-rate tariff.
+	BandOffPeak RateBand = "off_peak"
+)
+
+// A unit rate, in force between ValidFrom and ValidTo.
+type Rate struct {
+	Band RateBand `json:"band"`
+	// When the rate comes into force (inclusive).
+	ValidFrom time.Time `json:"validFrom"`
+	// When the rate stops being in force (exclusive). Nil if it has no end.
+	ValidTo *time.Time `json:"validTo"`
+	// The rate in pence per kWh, including VAT.
+	Value float64 `json:"value"`
+	// The rate in pence per kWh, excluding VAT.
+	PreVatValue float64 `json:"preVatValue"`
+}
+
+// An agreement to supply a meter point on a tariff for a period.
+type Agreement struct {
+	// The Kraken agreement ID.
+	Id            int    `json:"id"`
+	AccountNumber string `json:"accountNumber"`
+	Fuel          Fuel   `json:"fuel"`
+	// The MPAN (electricity) or MPRN (gas) of the meter point supplied.
+	MeterPoint string `json:"meterPoint"`
+	// When the agreement starts (inclusive).
+	ValidFrom time.Time `json:"validFrom"`
+	// When the agreement ends (exclusive). Nil if it has no end.
+	ValidTo *time.Time `json:"validTo"`
+	// The tariff code, e.g. E-1R-AGILE-24-10-01-C.
+	TariffCode string `json:"tariffCode"`
+	// The code of the product the tariff belongs to, e.g. AGILE-24-10-01.
+	ProductCode string `json:"productCode"`
+	// The tariff's name, e.g. "Agile Octopus".
+	DisplayName string `json:"displayName"`
+	// The standing charge in pence per day, including VAT.
+	StandingCharge float64 `json:"standingCharge"`
+	// The standing charge in pence per day, excluding VAT.
+	PreVatStandingCharge float64 `json:"preVatStandingCharge"`
+	// The unit rates of the tariff. Flat and time-of-use tariffs have one
+	// rate per band for the whole agreement; half-hourly tariffs have one
+	// rate per slot, for as far as they are known.
+	UnitRates []Rate `json:"unitRates"`
+}
+
+// Checks if the agreement is in force at the given time.
+func (a *Agreement) ActiveAt(t time.Time) bool {
+	return !t.Before(a.ValidFrom) && (a.ValidTo == nil || t.Before(*a.ValidTo))
+}
+
+// The fields of a Kraken tariff. Which are set depends on the type of
+// tariff, given by Typename.
+type krakenTariff struct {
+	Typename             string   `json:"__typename"`
+	DisplayName          string   `json:"displayName"`
+	ProductCode          string   `json:"productCode"`
+	TariffCode           string   `json:"tariffCode"`
+	StandingCharge       float64  `json:"standingCharge"`
+	PreVatStandingCharge float64  `json:"preVatStandingCharge"`
+	UnitRate             *float64 `json:"unitRate"`
+	PreVatUnitRate       *float64 `json:"preVatUnitRate"`
+	DayRate              *float64 `json:"dayRate"`
+	PreVatDayRate        *float64 `json:"preVatDayRate"`
+	NightRate            *float64 `json:"nightRate"`
+	PreVatNightRate      *float64 `json:"preVatNightRate"`
+	OffPeakRate          *float64 `json:"offPeakRate"`
+	PreVatOffPeakRate    *float64 `json:"preVatOffPeakRate"`
+	UnitRates            []struct {
+		ValidFrom   time.Time  `json:"validFrom"`
+		ValidTo     *time.Time `json:"validTo"`
+		Value       float64    `json:"value"`
+		PreVatValue float64    `json:"preVatValue"`
+	} `json:"unitRates"`
+}
+
+// A Kraken agreement, with the meter point of either fuel.
+type krakenAgreement struct {
+	Id         int        `json:"id"`
+	ValidFrom  time.Time  `json:"validFrom"`
+	ValidTo    *time.Time `json:"validTo"`
+	MeterPoint struct {
+		Mpan string `json:"mpan"`
+		Mprn string `json:"mprn"`
+	} `json:"meterPoint"`
+	Tariff *krakenTariff `json:"tariff"`
+}
+
+// Converts a Kraken agreement to an [Agreement]. Returns nil if it has no
+// tariff.
+func (a *krakenAgreement) agreement(accountNumber string, fuel Fuel) *Agreement {
+	if a.Tariff == nil {
+		return nil
+	}
+	t := a.Tariff
+
+	agreement := &Agreement{
+		Id:                   a.Id,
+		AccountNumber:        accountNumber,
+		Fuel:                 fuel,
+		MeterPoint:           a.MeterPoint.Mpan,
+		ValidFrom:            a.ValidFrom,
+		ValidTo:              a.ValidTo,
+		TariffCode:           t.TariffCode,
+		ProductCode:          t.ProductCode,
+		DisplayName:          t.DisplayName,
+		StandingCharge:       t.StandingCharge,
+		PreVatStandingCharge: t.PreVatStandingCharge,
+		UnitRates:            []Rate{},
+	}
+	if fuel == FuelGas {
+		agreement.MeterPoint = a.MeterPoint.Mprn
+	}
+
+	// Fixed rates apply for the whole agreement
+	addRate := func(band RateBand, value *float64, preVatValue *float64) {
+		if value == nil {
+			return
+		}
+		rate := Rate{
+			Band:      band,
+			ValidFrom: a.ValidFrom,
+			ValidTo:   a.ValidTo,
+			Value:     *value,
+		}
+		if preVatValue != nil {
+			rate.PreVatValue = *preVatValue
+		}
+		agreement.UnitRates = append(agreement.UnitRates, rate)
+	}
+
+	addRate(BandStandard, t.UnitRate, t.PreVatUnitRate)
+	addRate(BandDay, t.DayRate, t.PreVatDayRate)
+	addRate(BandNight, t.NightRate, t.PreVatNightRate)
+	addRate(BandOffPeak, t.OffPeakRate, t.PreVatOffPeakRate)
+
+	for _, r := range t.UnitRates {
+		agreement.UnitRates = append(agreement.UnitRates, Rate{
+			Band:        BandStandard,
+			ValidFrom:   r.ValidFrom,
+			ValidTo:     r.ValidTo,
+			Value:       r.Value,
+			PreVatValue: r.PreVatValue,
+		})
+	}
+
+	return agreement
+}
+
+// Returns the active and past agreements of every tracked account, with
+// their tariffs and unit rates. Agreements are not cached, since rates
+// change; half-hourly tariffs only include the rates that have been
+// published so far.
+func (octo *Octopus) Agreements(ctx context.Context) ([]*Agreement, error) {
+	accountNumbers, err := octo.AccountNumbers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get agreements: %w", err)
+	}
+
+	agreements := []*Agreement{}
+
+	for _, accountNumber := range accountNumbers {
+		data, err := Do[struct {
+			Account *struct {
+				ElectricityAgreements []krakenAgreement `json:"electricityAgreements"`
+				GasAgreements         []krakenAgreement `json:"gasAgreements"`
+			} `json:"account"`
+		}](ctx, octo, "Agreements", map[string]any{
+			"accountNumber": accountNumber,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Get agreements of account %v: %w", accountNumber, err)
+		}
+		if data.Account == nil {
+			return nil, fmt.Errorf("%w: account %v", ErrAccountNotFound, accountNumber)
+		}
+
+		for _, a := range data.Account.ElectricityAgreements {
+			if agreement := a.agreement(accountNumber, FuelElectricity); agreement != nil {
+				agreements = append(agreements, agreement)
+			}
+		}
+		for _, a := range data.Account.GasAgreements {
+			if agreement := a.agreement(accountNumber, FuelGas); agreement != nil {
+				agreements = append(agreements, agreement)
+			}
+		}
+	}
+
+	return agreements, nil
+}
@@ -0,0 +1,129 @@
+package octopus
+
+import (
+	"context"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+	"time"
+)
+
+func TestAgreements(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	switchedAt := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	slot := time.Date(2024, 11, 1, 16, 0, 0, 0, time.UTC)
+
+	server.AddAgreements(server.AccountNumber,
+		octopustest.Agreement{
+			Id:             1,
+			Fuel:           "electricity",
+			MeterPoint:     "1000000000001",
+			ValidFrom:      time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+			ValidTo:        &switchedAt,
+			TariffCode:     "E-1R-VAR-22-11-01-C",
+			ProductCode:    "VAR-22-11-01",
+			DisplayName:    "Flexible Octopus",
+			StandingCharge: 47.85,
+			UnitRate:       24.5,
+		},
+		octopustest.Agreement{
+			Id:             2,
+			Fuel:           "electricity",
+			MeterPoint:     "1000000000001",
+			ValidFrom:      switchedAt,
+			TariffCode:     "E-1R-AGILE-24-10-01-C",
+			ProductCode:    "AGILE-24-10-01",
+			DisplayName:    "Agile Octopus",
+			StandingCharge: 48.07,
+			UnitRates: []octopustest.UnitRate{
+				{ValidFrom: slot, ValidTo: slot.Add(30 * time.Minute), Value: 21},
+				{ValidFrom: slot.Add(30 * time.Minute), ValidTo: slot.Add(time.Hour), Value: 31.5},
+			},
+		},
+		octopustest.Agreement{
+			Id:             3,
+			Fuel:           "gas",
+			MeterPoint:     "1000000001",
+			ValidFrom:      switchedAt,
+			TariffCode:     "G-1R-VAR-22-11-01-C",
+			ProductCode:    "VAR-22-11-01",
+			DisplayName:    "Flexible Octopus",
+			StandingCharge: 31.43,
+			UnitRate:       6.3,
+		},
+	)
+
+	agreements, err := octo.Agreements(context.Background())
+	if err != nil {
+		t.Fatalf("Agreements: %v", err)
+	}
+	if len(agreements) != 3 {
+		t.Fatalf("Agreements() returned %v agreements, want 3", len(agreements))
+	}
+
+	flexible := agreements[0]
+	if flexible.TariffCode != "E-1R-VAR-22-11-01-C" || flexible.AccountNumber != server.AccountNumber {
+		t.Errorf("first agreement = %+v, want the Flexible tariff on %v", flexible, server.AccountNumber)
+	}
+	if flexible.ValidTo == nil || !flexible.ValidTo.Equal(switchedAt) {
+		t.Errorf("ValidTo = %v, want %v", flexible.ValidTo, switchedAt)
+	}
+	if len(flexible.UnitRates) != 1 || flexible.UnitRates[0].Band != BandStandard || flexible.UnitRates[0].Value != 24.5 {
+		t.Errorf("UnitRates = %+v, want a single standard rate of 24.5", flexible.UnitRates)
+	}
+	if !flexible.UnitRates[0].ValidFrom.Equal(flexible.ValidFrom) {
+		t.Errorf("flat rate is valid from %v, want the start of the agreement", flexible.UnitRates[0].ValidFrom)
+	}
+
+	agile := agreements[1]
+	if agile.ValidTo != nil {
+		t.Errorf("ValidTo = %v, want nil for an open-ended agreement", agile.ValidTo)
+	}
+	if len(agile.UnitRates) != 2 {
+		t.Fatalf("Agile has %v unit rates, want 2", len(agile.UnitRates))
+	}
+	second := agile.UnitRates[1]
+	if !second.ValidFrom.Equal(slot.Add(30*time.Minute)) || second.Value != 31.5 || second.PreVatValue != 30 {
+		t.Errorf("second Agile rate = %+v, want 31.5p (30p before VAT) from %v", second, slot.Add(30*time.Minute))
+	}
+
+	gas := agreements[2]
+	if gas.Fuel != FuelGas || gas.MeterPoint != "1000000001" {
+		t.Errorf("gas agreement = %+v, want fuel gas on meter point 1000000001", gas)
+	}
+
+	if !agile.ActiveAt(slot) || flexible.ActiveAt(slot) {
+		t.Errorf("only the Agile agreement should be active at %v", slot)
+	}
+}
+
+func TestDayNightAgreement(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+
+	server.AddAgreements(server.AccountNumber, octopustest.Agreement{
+		Id:          1,
+		Fuel:        "electricity",
+		MeterPoint:  "1000000000001",
+		ValidFrom:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		TariffCode:  "E-2R-VAR-22-11-01-C",
+		ProductCode: "VAR-22-11-01",
+		DayRate:     28,
+		NightRate:   14,
+	})
+
+	agreements, err := octo.Agreements(context.Background())
+	if err != nil {
+		t.Fatalf("Agreements: %v", err)
+	}
+	if len(agreements) != 1 {
+		t.Fatalf("Agreements() returned %v agreements, want 1", len(agreements))
+	}
+
+	rates := map[RateBand]float64{}
+	for _, r := range agreements[0].UnitRates {
+		rates[r.Band] = r.Value
+	}
+	if len(rates) != 2 || rates[BandDay] != 28 || rates[BandNight] != 14 {
+		t.Errorf("unit rates = %v, want day 28 and night 14", rates)
+	}
+}
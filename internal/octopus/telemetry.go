@@ -0,0 +1,164 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The interval that smart meter telemetry is aggregated into.
+type TelemetryGrouping string
+
+const (
+	GroupingTenSeconds     TelemetryGrouping = "TEN_SECONDS"
+	GroupingOneMinute      TelemetryGrouping = "ONE_MINUTE"
+	GroupingFiveMinutes    TelemetryGrouping = "FIVE_MINUTES"
+	GroupingFifteenMinutes TelemetryGrouping = "FIFTEEN_MINUTES"
+	GroupingThirtyMinutes  TelemetryGrouping = "THIRTY_MINUTES"
+	GroupingOneHour        TelemetryGrouping = "ONE_HOUR"
+	GroupingOneDay         TelemetryGrouping = "ONE_DAY"
+)
+
+var groupingDurations = map[TelemetryGrouping]time.Duration{
+	GroupingTenSeconds:     10 * time.Second,
+	GroupingOneMinute:      time.Minute,
+	GroupingFiveMinutes:    5 * time.Minute,
+	GroupingFifteenMinutes: 15 * time.Minute,
+	GroupingThirtyMinutes:  30 * time.Minute,
+	GroupingOneHour:        time.Hour,
+	GroupingOneDay:         24 * time.Hour,
+}
+
+// Parses a grouping name such as "ONE_MINUTE".
+func ParseTelemetryGrouping(s string) (TelemetryGrouping, error) {
+	g := TelemetryGrouping(s)
+	if _, ok := groupingDurations[g]; !ok {
+		return "", fmt.Errorf("Unknown telemetry grouping %q", s)
+	}
+	return g, nil
+}
+
+// The length of time covered by each reading with this grouping.
+func (g TelemetryGrouping) Duration() time.Duration {
+	return groupingDurations[g]
+}
+
+// The maximum number of readings we ask for in a single telemetry request.
+// Larger windows are split into several requests.
+const maxTelemetryReadingsPerRequest = 360
+
+// The length of the window requested in a single telemetry request.
+func (g TelemetryGrouping) chunkDuration() time.Duration {
+	return g.Duration() * maxTelemetryReadingsPerRequest
+}
+
+// Returns the readings of the given smart meter between from and to, oldest
+// first. Long windows are fetched in several requests. If a request
+// fails, the readings fetched so far are returned along with the error.
+func (octo *Octopus) Telemetry(ctx context.Context, device Device, from, to time.Time, grouping TelemetryGrouping) ([]*ConsumptionReading, error) {
+	if grouping.Duration() == 0 {
+		return nil, fmt.Errorf("Get telemetry: unknown grouping %q", grouping)
+	}
+
+	readings := []*ConsumptionReading{}
+
+	for start := from; start.Before(to); start = start.Add(grouping.chunkDuration()) {
+		end := start.Add(grouping.chunkDuration())
+		if end.After(to) {
+			end = to
+		}
+
+		chunk, err := octo.smartMeterTelemetry(ctx, device, start, end, grouping)
+		if err != nil {
+			return readings, fmt.Errorf("Get %v telemetry from %v to %v: %w", device.Meter, start, end, err)
+		}
+
+		readings = append(readings, chunk...)
+	}
+
+	return readings, nil
+}
+
+// Sends a single SmartMeterTelemetry request for the given meter.
+//
+// Gas meters report volumes in m³, which are converted to Wh. They don't
+// report demand, so the demand of a gas reading is the average power over
+// its interval.
+func (octo *Octopus) smartMeterTelemetry(ctx context.Context, device Device, start, end time.Time, grouping TelemetryGrouping) ([]*ConsumptionReading, error) {
+	data, err := Do[struct {
+		SmartMeterTelemetry *[]struct {
+			ReadAt time.Time `json:"readAt"`
+			// String containing a float. For electricity this is always
+			// to the nearest integer.
+			Consumption *string `json:"consumption"`
+			// String containing a float; the consumption since the
+			// previous reading.
+			ConsumptionDelta *string `json:"consumptionDelta"`
+			// String containing a float that is always to the nearest integer
+			Demand *string `json:"demand"`
+		} `json:"smartMeterTelemetry"`
+	}](ctx, octo, "SmartMeterTelemetry", map[string]any{
+		"deviceId": device.Id,
+		"grouping": grouping,
+		"start":    start.Format(time.RFC3339),
+		"end":      end.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to obtain telemetry: %w", err)
+	}
+
+	readings := []*ConsumptionReading{}
+
+	if data.SmartMeterTelemetry == nil {
+		return readings, nil
+	}
+
+	for _, r := range *data.SmartMeterTelemetry {
+		// The meter sometimes reports a timestamp without any values
+		if r.Consumption == nil {
+			continue
+		}
+
+		consumption, err := strconv.ParseFloat(*r.Consumption, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Deserialise telemetry: %w", err)
+		}
+
+		reading := &ConsumptionReading{
+			Timestamp: r.ReadAt,
+			Fuel:      device.Meter.Fuel,
+			Direction: device.Meter.Direction,
+			MeterId:   device.Id,
+		}
+
+		switch device.Meter.Fuel {
+		case FuelGas:
+			reading.TotalConsumption = int(octo.gasEnergy(consumption))
+
+			if r.ConsumptionDelta != nil {
+				delta, err := strconv.ParseFloat(*r.ConsumptionDelta, 64)
+				if err != nil {
+					return nil, fmt.Errorf("Deserialise telemetry: %w", err)
+				}
+				reading.Demand = int(octo.gasEnergy(delta) / grouping.Duration().Hours())
+			}
+
+		default:
+			if r.Demand == nil {
+				continue
+			}
+			demand, err := strconv.ParseFloat(*r.Demand, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Deserialise telemetry: %w", err)
+			}
+
+			reading.TotalConsumption = int(consumption)
+			reading.Demand = int(demand)
+		}
+
+		readings = append(readings, reading)
+	}
+
+	return readings, nil
+}
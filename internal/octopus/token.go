@@ -0,0 +1,363 @@
+package octopus
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// How long a token is assumed to be valid for if its expiry can't be read.
+const defaultTokenLifetime = time.Hour
+
+// How long before the token expires that we refresh it. Refreshing early
+// means requests never race against the expiry.
+const tokenRefreshMargin = 5 * time.Minute
+
+// What happened to the Kraken token.
+type TokenEventKind string
+
+const (
+	// A new token was obtained with the API key.
+	TokenObtained TokenEventKind = "obtained"
+	// The token was refreshed with the refresh token.
+	TokenRefreshed TokenEventKind = "refreshed"
+	// The API rejected the refresh token, so the API key will be used.
+	TokenRefreshRejected TokenEventKind = "refresh_rejected"
+	// Refreshing the token failed for another reason, e.g. a network error.
+	TokenRefreshFailed TokenEventKind = "refresh_failed"
+	// Obtaining a token with the API key failed.
+	TokenAuthFailed TokenEventKind = "auth_failed"
+)
+
+// Describes a change in the Kraken token's lifecycle.
+type TokenEvent struct {
+	Kind TokenEventKind
+	// When the event happened.
+	At time.Time
+	// When the current token expires. Zero if there is no token.
+	ExpiresAt time.Time
+	// The error that caused the event, for failure events.
+	Err error
+}
+
+// Counts of token lifecycle events since the client was created.
+type TokenStats struct {
+	Obtained        int
+	Refreshed       int
+	RefreshRejected int
+	RefreshFailed   int
+	AuthFailed      int
+}
+
+// Calls the given function on every token lifecycle event. The function is
+// called synchronously, so it should return quickly.
+func WithTokenEvents(onEvent func(TokenEvent)) Option {
+	return func(octo *Octopus) {
+		octo.onTokenEvent = onEvent
+	}
+}
+
+// Returns counts of the token lifecycle events so far.
+func (octo *Octopus) TokenStats() TokenStats {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	return octo.tokenStats
+}
+
+// Returns the current auth token.
+func (octo *Octopus) token() string {
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	return octo.Token
+}
+
+// Records a token lifecycle event.
+func (octo *Octopus) tokenEvent(kind TokenEventKind, err error) {
+	octo.lock.Lock()
+
+	switch kind {
+	case TokenObtained:
+		octo.tokenStats.Obtained++
+	case TokenRefreshed:
+		octo.tokenStats.Refreshed++
+	case TokenRefreshRejected:
+		octo.tokenStats.RefreshRejected++
+	case TokenRefreshFailed:
+		octo.tokenStats.RefreshFailed++
+	case TokenAuthFailed:
+		octo.tokenStats.AuthFailed++
+	}
+
+	event := TokenEvent{
+		Kind: kind,
+		At:   octo.now(),
+		Err:  err,
+	}
+	if octo.Token != "" {
+		event.ExpiresAt = time.Unix(octo.TokenExpiresAt, 0)
+	}
+
+	octo.lock.Unlock()
+
+	if err != nil {
+		log.Printf("Kraken token %s: %v", kind, err)
+	} else {
+		log.Printf("Kraken token %s, expires at %v", kind, event.ExpiresAt)
+	}
+
+	if octo.onTokenEvent != nil {
+		octo.onTokenEvent(event)
+	}
+}
+
+// Checks if we have a valid auth token that has not expired.
+func (octo *Octopus) hasValidToken() bool {
+	return octo.tokenValidFor(0)
+}
+
+// Checks if the auth token is valid and isn't about to expire.
+func (octo *Octopus) hasFreshToken() bool {
+	return octo.tokenValidFor(tokenRefreshMargin)
+}
+
+// Checks if we have an auth token that will still be valid after the given
+// duration.
+func (octo *Octopus) tokenValidFor(d time.Duration) bool {
+	if octo == nil {
+		return false
+	}
+
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	if octo.Token == "" {
+		return false
+	}
+	return octo.now().Add(d).Unix() < octo.TokenExpiresAt
+}
+
+// Checks if we have a valid refresh token that has not expired.
+func (octo *Octopus) hasValidRefreshToken() bool {
+	if octo == nil {
+		return false
+	}
+
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	if octo.RefreshToken == "" {
+		return false
+	}
+	return octo.now().Unix() < octo.RefreshTokenExpiresAt
+}
+
+// Reads the expiry time from a JWT's "exp" claim. The signature is not
+// verified; we only use this to know when to refresh.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("Token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Decode JWT payload: %w", err)
+	}
+
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Decode JWT claims: %w", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, errors.New("JWT has no expiry")
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// Converts the refreshExpiresIn value from ObtainKrakenToken to a Unix
+// timestamp. The API returns a Unix timestamp, but we also accept a number
+// of seconds from now in case that changes.
+func (octo *Octopus) refreshExpiry(refreshExpiresIn int64) int64 {
+	// Anything before 2001 can't be a timestamp
+	if refreshExpiresIn > 1_000_000_000 {
+		return refreshExpiresIn
+	}
+	return octo.now().Unix() + refreshExpiresIn
+}
+
+// Sends an API request to obtain a kraken auth token. The input can be
+// either an API key or a refresh token. Callers should use
+// [Octopus.authWithApiKey] or [Octopus.authWithRefreshToken] rather than
+// this method directly; they provide the necessary input arguments.
+//
+// If the API rejects the input, the returned error wraps [ErrAuthFailed].
+func (octo *Octopus) obtainKrakenToken(ctx context.Context, input map[string]string) error {
+	// If we are supposed to be waiting before API requests, do nothing
+	if octo.isBackingOff() {
+		return ErrSkippingRequest
+	}
+
+	data, err := do[struct {
+		ObtainKrakenToken *struct {
+			Token            string `json:"token"`
+			RefreshToken     string `json:"refreshToken"`
+			RefreshExpiresIn int64  `json:"refreshExpiresIn"`
+		} `json:"obtainKrakenToken"`
+	}](ctx, octo, "ObtainKrakenToken", map[string]any{
+		"input": input,
+	}, false)
+
+	// Kraken errors mean the API rejected the input, unless we were just
+	// rate limited
+	var krakenErrs KrakenErrors
+	if errors.As(err, &krakenErrs) && !errors.Is(err, ErrTooManyRequests) {
+		return fmt.Errorf("Failed to obtain Kraken token: %w: %w", ErrAuthFailed, err)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to obtain Kraken token: %w", err)
+	}
+	if data.ObtainKrakenToken == nil {
+		return fmt.Errorf("Failed to obtain Kraken token: %w: no token returned", ErrAuthFailed)
+	}
+
+	result := data.ObtainKrakenToken
+
+	expiresAt, err := jwtExpiry(result.Token)
+	if err != nil {
+		log.Printf("Failed to read Kraken token expiry, assuming %v: %v", defaultTokenLifetime, err)
+		expiresAt = octo.now().Add(defaultTokenLifetime)
+	}
+
+	octo.lock.Lock()
+	defer octo.lock.Unlock()
+
+	octo.Token = result.Token
+	octo.TokenExpiresAt = expiresAt.Unix()
+	octo.RefreshToken = result.RefreshToken
+	octo.RefreshTokenExpiresAt = octo.refreshExpiry(result.RefreshExpiresIn)
+
+	return nil
+}
+
+// Wraps around [Octopus.obtainKrakenToken] to authenticate with the user's
+// API key. Uses the key given to [WithApiKey] if there is one, otherwise
+// expects the API key to be provided via the OCTOPUS_API_KEY environment
+// variable.
+func (octo *Octopus) authWithApiKey(ctx context.Context) error {
+	apiKey, err := octo.resolveApiKey()
+	if err != nil {
+		return err
+	}
+
+	return octo.obtainKrakenToken(ctx, map[string]string{
+		"APIKey": apiKey,
+	})
+}
+
+// Returns the key given to [WithApiKey], or the OCTOPUS_API_KEY environment
+// variable if there isn't one.
+func (octo *Octopus) resolveApiKey() (string, error) {
+	apiKey := octo.apiKey
+	if apiKey == "" {
+		apiKey = os.Getenv("OCTOPUS_API_KEY")
+	}
+
+	if apiKey == "" {
+		return "", fmt.Errorf("%w: no API key available; OCTOPUS_API_KEY environment variable is not set", ErrNotConfigured)
+	}
+	return apiKey, nil
+}
+
+// Wraps around [Octopus.obtainKrakenToken] to authenticate with the stored
+// refresh token. It is an error to call this method with an invalid refresh
+// token. Use [Octopus.hasValidRefreshToken] to check the validity of the
+// token before calling this method.
+func (octo *Octopus) authWithRefreshToken(ctx context.Context) error {
+	octo.lock.Lock()
+	refreshToken := octo.RefreshToken
+	octo.lock.Unlock()
+
+	if refreshToken == "" {
+		return errors.New("No refresh token available")
+	}
+
+	return octo.obtainKrakenToken(ctx, map[string]string{
+		"refreshToken": refreshToken,
+	})
+}
+
+// Authenticates to the Octopus API, obtaining a Kraken token if necessary.
+// This method should be called before making any API calls that require
+// authentication.
+//
+// The token is refreshed shortly before it expires. If the refresh token is
+// rejected we fall back to the API key; if refreshing fails for another
+// reason, we keep using the current token for as long as it is valid. Only
+// one goroutine authenticates at a time; the others wait and then use the
+// token it obtained.
+func (octo *Octopus) auth(ctx context.Context) error {
+	octo.authLock.Lock()
+	defer octo.authLock.Unlock()
+
+	octo.loadCachedState()
+
+	if octo.hasFreshToken() {
+		// We have a token; nothing to do here
+		return nil
+	}
+
+	if octo.hasValidRefreshToken() {
+		err := octo.authWithRefreshToken(ctx)
+		if err == nil {
+			octo.tokenEvent(TokenRefreshed, nil)
+			octo.saveCachedState()
+			return nil
+		}
+
+		if !errors.Is(err, ErrAuthFailed) {
+			octo.tokenEvent(TokenRefreshFailed, err)
+
+			if octo.hasValidToken() {
+				// Try again on the next request
+				return nil
+			}
+			return fmt.Errorf("Failed to get kraken token: %w", err)
+		}
+
+		// The refresh token is no good; forget it and start again
+		octo.lock.Lock()
+		octo.RefreshToken = ""
+		octo.RefreshTokenExpiresAt = 0
+		octo.lock.Unlock()
+		octo.tokenEvent(TokenRefreshRejected, err)
+	}
+
+	// No valid token or refresh token
+	// authenticate fresh
+	err := octo.authWithApiKey(ctx)
+	if err != nil {
+		octo.tokenEvent(TokenAuthFailed, err)
+
+		if octo.hasValidToken() {
+			return nil
+		}
+		return fmt.Errorf("Failed to get kraken token: %w", err)
+	}
+
+	octo.tokenEvent(TokenObtained, nil)
+	octo.saveCachedState()
+	return nil
+}
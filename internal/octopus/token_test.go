@@ -0,0 +1,205 @@
+package octopus
+
+import (
+	"context"
+	"martin-walls/octopus-energy-tracker/internal/octopus/octopustest"
+	"testing"
+	"time"
+)
+
+// Collects token events for assertions.
+type tokenEventRecorder struct {
+	kinds []TokenEventKind
+}
+
+func (r *tokenEventRecorder) record(e TokenEvent) {
+	r.kinds = append(r.kinds, e.Kind)
+}
+
+// Adds a telemetry reading at the current time so that LiveConsumption
+// succeeds.
+func addCurrentReading(server *octopustest.Server, clock *fakeClock) {
+	server.AddTelemetry(octopustest.TelemetryReading{
+		ReadAt:      clock.Now().Truncate(time.Second),
+		Consumption: 2000,
+		Demand:      500,
+	})
+}
+
+func TestTokenExpiryIsReadFromJwt(t *testing.T) {
+	octo, _, clock := newTestClient(t)
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	expected := clock.Now().Add(time.Hour).Unix()
+	if octo.TokenExpiresAt != expected {
+		t.Errorf("TokenExpiresAt = %v, want %v", octo.TokenExpiresAt, expected)
+	}
+
+	expectedRefresh := clock.Now().Add(7 * 24 * time.Hour).Unix()
+	if octo.RefreshTokenExpiresAt != expectedRefresh {
+		t.Errorf("RefreshTokenExpiresAt = %v, want %v", octo.RefreshTokenExpiresAt, expectedRefresh)
+	}
+}
+
+func TestTokenIsRefreshedBeforeExpiry(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+	events := &tokenEventRecorder{}
+	octo.onTokenEvent = events.record
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	// Within the refresh margin, but before the token actually expires
+	clock.Advance(time.Hour - 2*time.Minute)
+	addCurrentReading(server, clock)
+
+	_, err = octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption after refresh: %v", err)
+	}
+
+	requests := server.Requests()
+	var tokenRequests []octopustest.Request
+	for _, r := range requests {
+		if r.Operation == "ObtainKrakenToken" {
+			tokenRequests = append(tokenRequests, r)
+		}
+	}
+
+	if len(tokenRequests) != 2 {
+		t.Fatalf("Expected 2 ObtainKrakenToken requests, got %v", len(tokenRequests))
+	}
+	input := tokenRequests[1].Variables["input"].(map[string]any)
+	if _, ok := input["refreshToken"]; !ok {
+		t.Errorf("Expected second token request to use the refresh token, got input %v", input)
+	}
+
+	expected := []TokenEventKind{TokenObtained, TokenRefreshed}
+	if len(events.kinds) != 2 || events.kinds[0] != expected[0] || events.kinds[1] != expected[1] {
+		t.Errorf("Token events = %v, want %v", events.kinds, expected)
+	}
+}
+
+func TestRejectedRefreshFallsBackToApiKey(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	server.RevokeRefreshTokens()
+	clock.Advance(2 * time.Hour)
+	addCurrentReading(server, clock)
+
+	_, err = octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption after rejected refresh: %v", err)
+	}
+
+	stats := octo.TokenStats()
+	if stats.Obtained != 2 || stats.RefreshRejected != 1 || stats.Refreshed != 0 {
+		t.Errorf("TokenStats() = %+v, want 2 obtained and 1 refresh rejected", stats)
+	}
+}
+
+func TestFailedRefreshKeepsValidToken(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+	token := octo.Token
+
+	// Rate limited while trying to refresh, but the token is still valid
+	clock.Advance(time.Hour - 2*time.Minute)
+	addCurrentReading(server, clock)
+	server.FailNext("ObtainKrakenToken", octopustest.ErrCodeTooManyRequests, "Too many requests.")
+
+	_, err = octo.LiveConsumption(context.Background())
+	if err == nil {
+		t.Fatalf("Expected the rate limit to pause requests")
+	}
+	if octo.Token != token {
+		t.Errorf("Expected the token to be kept after a failed refresh")
+	}
+	if stats := octo.TokenStats(); stats.RefreshFailed != 1 || stats.Obtained != 1 {
+		t.Errorf("TokenStats() = %+v, want 1 obtained and 1 refresh failed", stats)
+	}
+}
+
+// A [StateCache] that keeps the state in memory.
+type memoryCache struct {
+	state *CachedState
+}
+
+func (c *memoryCache) Load() (*CachedState, error) {
+	return c.state, nil
+}
+
+func (c *memoryCache) Save(state *CachedState) error {
+	c.state = state
+	return nil
+}
+
+func TestCachedStateIsReusedAfterRestart(t *testing.T) {
+	octo, server, clock := newTestClient(t)
+	cache := &memoryCache{}
+	octo.cache = cache
+
+	_, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	if cache.state == nil || len(cache.state.Devices) == 0 || cache.state.Devices[0].Id != server.DeviceId {
+		t.Fatalf("Expected the devices to be cached, got %+v", cache.state)
+	}
+
+	// A new client, as if the process restarted
+	restarted := New(
+		WithBaseUrl(server.URL),
+		WithClock(clock.Now),
+		WithApiKey(server.ApiKey),
+		WithAccountNumbers(server.AccountNumber),
+		WithStateCache(cache),
+	)
+
+	_, err = restarted.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption after restart: %v", err)
+	}
+
+	if c := server.RequestCount("ObtainKrakenToken"); c != 1 {
+		t.Errorf("Expected 1 ObtainKrakenToken request, got %v", c)
+	}
+	if c := server.RequestCount("Account"); c != 1 {
+		t.Errorf("Expected 1 Account request, got %v", c)
+	}
+}
+
+func TestCachedMeterIsIgnoredForOtherAccount(t *testing.T) {
+	octo, server, _ := newTestClient(t)
+	octo.cache = &memoryCache{
+		state: &CachedState{
+			AccountNumbers: []string{"A-OTHER"},
+			Devices:        []Device{{Id: "other-device", Meter: ElectricityImport}},
+		},
+	}
+
+	reading, err := octo.LiveConsumption(context.Background())
+	if err != nil {
+		t.Fatalf("LiveConsumption: %v", err)
+	}
+
+	if reading.MeterId != server.DeviceId {
+		t.Errorf("MeterId = %v, want %v", reading.MeterId, server.DeviceId)
+	}
+}
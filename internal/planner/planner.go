@@ -0,0 +1,228 @@
+// This package plans when to run appliances, such as a washing machine or
+// an EV charger, to pay the least for their energy. It works from the unit
+// rates of the coming half hours, which for Agile tariffs are published a
+// day ahead.
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"time"
+)
+
+// Every Octopus tariff changes rate on the half hour.
+const slotLength = 30 * time.Minute
+
+// Runs whose costs differ by less than this, in pence, cost the same. This
+// stops rounding errors from favouring later runs.
+const costTolerance = 1e-6
+
+// Returned when no run of a load fits in the time available with known
+// unit rates.
+var ErrNoWindow = errors.New("No window with known unit rates")
+
+// The unit rate of a half hour.
+type Slot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// The unit rate in pence per kWh, including VAT.
+	Rate float64 `json:"rate"`
+}
+
+// Returns the half-hourly slots between from and to, with the unit rate
+// that the meter point pays in each. The first slot is the one containing
+// from. Slots whose rate isn't known yet are left out.
+func Slots(tariffs *cost.Tariffs, meterPoint string, from, to time.Time) []Slot {
+	slots := []Slot{}
+	for start := from.Truncate(slotLength); start.Before(to); start = start.Add(slotLength) {
+		rate, ok := tariffs.UnitRate(meterPoint, start)
+		if !ok {
+			continue
+		}
+		slots = append(slots, Slot{
+			Start: start,
+			End:   start.Add(slotLength),
+			Rate:  rate.Value,
+		})
+	}
+	return slots
+}
+
+// One part of a load profile, with a constant demand.
+type Step struct {
+	// How long the step lasts.
+	Duration time.Duration `json:"duration"`
+	// The demand during the step, in W.
+	Power int `json:"power"`
+}
+
+// How an appliance uses energy over one run, as a series of steps.
+type Profile struct {
+	Name  string `json:"name"`
+	Steps []Step `json:"steps"`
+}
+
+// Typical profiles of household appliances, by name.
+var Profiles = map[string]Profile{
+	"washing-machine": {
+		Name: "washing-machine",
+		Steps: []Step{
+			// Heating the water, then washing and spinning
+			{Duration: 20 * time.Minute, Power: 2000},
+			{Duration: 70 * time.Minute, Power: 250},
+			{Duration: 15 * time.Minute, Power: 500},
+		},
+	},
+	"dishwasher": {
+		Name: "dishwasher",
+		Steps: []Step{
+			{Duration: 20 * time.Minute, Power: 1800},
+			{Duration: 60 * time.Minute, Power: 150},
+			{Duration: 20 * time.Minute, Power: 1800},
+		},
+	},
+	"tumble-dryer": {
+		Name: "tumble-dryer",
+		Steps: []Step{
+			{Duration: 90 * time.Minute, Power: 2400},
+		},
+	},
+}
+
+// Returns a profile with a constant demand, such as an EV charging.
+func FlatProfile(power int, duration time.Duration) Profile {
+	return Profile{
+		Name:  fmt.Sprintf("%vW for %v", power, duration),
+		Steps: []Step{{Duration: duration, Power: power}},
+	}
+}
+
+// How long one run of the profile takes.
+func (p Profile) Duration() time.Duration {
+	var d time.Duration
+	for _, step := range p.Steps {
+		d += step.Duration
+	}
+	return d
+}
+
+// One run of a load, and what it would cost.
+type Run struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// The energy used, in Wh.
+	Energy float64 `json:"energy"`
+	// The cost of the energy, in pence including VAT.
+	Cost float64 `json:"cost"`
+	// The cost divided by the energy, in pence per kWh.
+	AverageRate float64 `json:"averageRate"`
+}
+
+// Returns the cost of running the profile from the given time, or false if
+// the run isn't covered by the slots. Slots must be in order.
+func (p Profile) RunAt(slots []Slot, start time.Time) (Run, bool) {
+	run := Run{Start: start, End: start.Add(p.Duration())}
+
+	stepStart := start
+	i := 0
+	for _, step := range p.Steps {
+		stepEnd := stepStart.Add(step.Duration)
+
+		// Walk the slots that the step overlaps
+		for t := stepStart; t.Before(stepEnd); {
+			for i < len(slots) && !slots[i].End.After(t) {
+				i++
+			}
+			if i == len(slots) || slots[i].Start.After(t) {
+				// No rate is known for this time
+				return Run{}, false
+			}
+
+			end := slots[i].End
+			if stepEnd.Before(end) {
+				end = stepEnd
+			}
+			hours := end.Sub(t).Hours()
+			energy := float64(step.Power) * hours
+
+			run.Energy += energy
+			run.Cost += energy / 1000 * slots[i].Rate
+			t = end
+		}
+
+		stepStart = stepEnd
+	}
+
+	if run.Energy > 0 {
+		run.AverageRate = run.Cost / (run.Energy / 1000)
+	}
+	return run, true
+}
+
+// Returns the cheapest run of the profile that starts at or after from and
+// ends by before. Runs may start at from or at the start of any slot. If
+// several runs cost the same, the earliest is returned.
+func (p Profile) Cheapest(slots []Slot, from, before time.Time) (Run, error) {
+	starts := []time.Time{from}
+	for _, slot := range slots {
+		if slot.Start.After(from) {
+			starts = append(starts, slot.Start)
+		}
+	}
+
+	var cheapest *Run
+	for _, start := range starts {
+		if start.Add(p.Duration()).After(before) {
+			continue
+		}
+
+		run, ok := p.RunAt(slots, start)
+		if ok && (cheapest == nil || run.Cost < cheapest.Cost-costTolerance) {
+			cheapest = &run
+		}
+	}
+
+	if cheapest == nil {
+		return Run{}, fmt.Errorf("%w: %v between %v and %v", ErrNoWindow, p.Name, from.Format(time.RFC3339), before.Format(time.RFC3339))
+	}
+	return *cheapest, nil
+}
+
+// Returns the cheapest window of the given length that starts at or after
+// from and ends by before, with the average unit rate over it. The cost is
+// for using 1 kW throughout.
+func CheapestWindow(slots []Slot, length time.Duration, from, before time.Time) (Run, error) {
+	return FlatProfile(1000, length).Cheapest(slots, from, before)
+}
+
+// Compares running a load now with running it at the cheapest time.
+type Plan struct {
+	Profile Profile `json:"profile"`
+	// The run starting now. Nil if the rates for it aren't known.
+	Now *Run `json:"now"`
+	// The cheapest run that ends in time.
+	Cheapest Run `json:"cheapest"`
+	// How much cheaper the cheapest run is than running now, in pence.
+	// Zero if the cost of running now isn't known.
+	Saving float64 `json:"saving"`
+}
+
+// Plans when to run the profile, starting at or after now and ending by
+// before.
+func NewPlan(slots []Slot, profile Profile, now, before time.Time) (*Plan, error) {
+	cheapest, err := profile.Cheapest(slots, now, before)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Profile:  profile,
+		Cheapest: cheapest,
+	}
+	if run, ok := profile.RunAt(slots, now); ok {
+		plan.Now = &run
+		plan.Saving = run.Cost - cheapest.Cost
+	}
+	return plan, nil
+}
@@ -0,0 +1,147 @@
+package planner
+
+import (
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/store/storetest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC)
+
+// Returns consecutive half-hourly slots from testStart with the given
+// rates.
+func testSlots(rates ...float64) []Slot {
+	slots := []Slot{}
+	for i, rate := range rates {
+		start := testStart.Add(time.Duration(i) * slotLength)
+		slots = append(slots, Slot{Start: start, End: start.Add(slotLength), Rate: rate})
+	}
+	return slots
+}
+
+func TestCheapestWindow(t *testing.T) {
+	slots := testSlots(30, 40, 20, 10, 12, 25, 5)
+
+	run, err := CheapestWindow(slots, time.Hour, testStart, testStart.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("CheapestWindow: %v", err)
+	}
+	if !run.Start.Equal(testStart.Add(90 * time.Minute)) {
+		t.Errorf("Start = %v, want %v", run.Start, testStart.Add(90*time.Minute))
+	}
+	if !storetest.ApproxEqual(run.AverageRate, 11) || !storetest.ApproxEqual(run.Cost, 11) {
+		t.Errorf("Run = %+v, want an average of 11p/kWh", run)
+	}
+
+	// The cheapest slot is too late
+	if run.End.After(testStart.Add(3 * time.Hour)) {
+		t.Errorf("Run ends at %v, after the deadline", run.End)
+	}
+}
+
+func TestCheapestWindowNeedsKnownRates(t *testing.T) {
+	slots := testSlots(30, 40)
+
+	_, err := CheapestWindow(slots, 2*time.Hour, testStart, testStart.Add(24*time.Hour))
+	if !errors.Is(err, ErrNoWindow) {
+		t.Errorf("CheapestWindow() error = %v, want %v", err, ErrNoWindow)
+	}
+
+	// A gap in the slots breaks up windows
+	slots = append(testSlots(10), testSlots(0, 0, 10)[2:]...)
+	_, err = CheapestWindow(slots, time.Hour, testStart, testStart.Add(24*time.Hour))
+	if !errors.Is(err, ErrNoWindow) {
+		t.Errorf("CheapestWindow() across a gap error = %v, want %v", err, ErrNoWindow)
+	}
+}
+
+func TestRunAtSplitsSlots(t *testing.T) {
+	slots := testSlots(10, 20, 30)
+	profile := Profile{Name: "test", Steps: []Step{
+		{Duration: 15 * time.Minute, Power: 4000},
+		{Duration: 30 * time.Minute, Power: 2000},
+	}}
+
+	// Starting a quarter of an hour in: 1kWh at 10p, then 1kWh at 20p
+	run, ok := profile.RunAt(slots, testStart.Add(15*time.Minute))
+	if !ok {
+		t.Fatalf("RunAt() = false, want a run")
+	}
+	if !storetest.ApproxEqual(run.Energy, 2000) || !storetest.ApproxEqual(run.Cost, 30) || !storetest.ApproxEqual(run.AverageRate, 15) {
+		t.Errorf("Run = %+v, want 2kWh costing 30p", run)
+	}
+
+	// Starting 10 minutes in: 1kWh at 10p, then 1/6kWh at 10p and 5/6kWh
+	// at 20p
+	run, ok = profile.RunAt(slots, testStart.Add(10*time.Minute))
+	if !ok {
+		t.Fatalf("RunAt() = false, want a run")
+	}
+	if !storetest.ApproxEqual(run.Cost, 10+110.0/6) {
+		t.Errorf("Cost = %v, want %v", run.Cost, 10+110.0/6)
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	slots := testSlots(30, 30, 30, 30, 5, 5, 5, 5)
+	now := testStart.Add(10 * time.Minute)
+
+	plan, err := NewPlan(slots, Profiles["washing-machine"], now, testStart.Add(4*time.Hour))
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+	if plan.Now == nil {
+		t.Fatalf("Expected the cost of running now")
+	}
+	if !plan.Cheapest.Start.Equal(testStart.Add(2 * time.Hour)) {
+		t.Errorf("Cheapest start = %v, want %v", plan.Cheapest.Start, testStart.Add(2*time.Hour))
+	}
+	if !storetest.ApproxEqual(plan.Saving, plan.Now.Cost-plan.Cheapest.Cost) || plan.Saving <= 0 {
+		t.Errorf("Saving = %v, want the difference between %v and %v", plan.Saving, plan.Now.Cost, plan.Cheapest.Cost)
+	}
+}
+
+func TestLoadRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(path, []byte(`{
+		"count": 2,
+		"results": [
+			{"value_exc_vat": 20, "value_inc_vat": 21, "valid_from": "2025-01-01T16:30:00Z", "valid_to": "2025-01-01T17:00:00Z"},
+			{"value_exc_vat": 10, "value_inc_vat": 10.5, "valid_from": "2025-01-01T16:00:00Z", "valid_to": "2025-01-01T16:30:00Z"}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	rates, err := LoadRates(path)
+	if err != nil {
+		t.Fatalf("LoadRates: %v", err)
+	}
+	if len(rates) != 2 || rates[0].Value != 10.5 || rates[1].PreVatValue != 20 {
+		t.Fatalf("LoadRates() = %+v, want two rates, oldest first", rates)
+	}
+
+	slots := SlotsFromRates(rates, testStart, testStart.Add(2*time.Hour))
+	if len(slots) != 2 || slots[1].Rate != 21 {
+		t.Errorf("SlotsFromRates() = %+v, want the two known slots", slots)
+	}
+
+	// A plain list of rates
+	err = os.WriteFile(path, []byte(`[{"band": "standard", "validFrom": "2025-01-01T16:00:00Z", "validTo": null, "value": 24.5}]`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	rates, err = LoadRates(path)
+	if err != nil {
+		t.Fatalf("LoadRates: %v", err)
+	}
+	slots = SlotsFromRates(rates, testStart, testStart.Add(2*time.Hour))
+	if len(slots) != 4 || slots[3].Rate != 24.5 {
+		t.Errorf("SlotsFromRates() = %+v, want 4 slots at the open-ended rate", slots)
+	}
+}
@@ -0,0 +1,82 @@
+package planner
+
+import (
+	"encoding/json"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"os"
+	"slices"
+	"time"
+)
+
+// A unit rate as returned by the Octopus REST API's standard-unit-rates
+// endpoint.
+type restRate struct {
+	ValueExcVat float64    `json:"value_exc_vat"`
+	ValueIncVat float64    `json:"value_inc_vat"`
+	ValidFrom   time.Time  `json:"valid_from"`
+	ValidTo     *time.Time `json:"valid_to"`
+}
+
+// Loads unit rates from a JSON file, for planning offline. The file is
+// either a list of [octopus.Rate] objects, or a response from the Octopus
+// REST API's standard-unit-rates endpoint, with the rates under "results".
+func LoadRates(path string) ([]octopus.Rate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read rates: %w", err)
+	}
+
+	rates := []octopus.Rate{}
+
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &rates)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse rates from %v: %w", path, err)
+		}
+	} else {
+		response := struct {
+			Results []restRate `json:"results"`
+		}{}
+		err = json.Unmarshal(data, &response)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse rates from %v: %w", path, err)
+		}
+
+		for _, r := range response.Results {
+			rates = append(rates, octopus.Rate{
+				Band:        octopus.BandStandard,
+				ValidFrom:   r.ValidFrom,
+				ValidTo:     r.ValidTo,
+				Value:       r.ValueIncVat,
+				PreVatValue: r.ValueExcVat,
+			})
+		}
+	}
+
+	// The REST API lists the latest rates first
+	slices.SortFunc(rates, func(a, b octopus.Rate) int {
+		return a.ValidFrom.Compare(b.ValidFrom)
+	})
+
+	return rates, nil
+}
+
+// Returns the half-hourly slots between from and to covered by the given
+// rates. Rates without an end apply until to.
+func SlotsFromRates(rates []octopus.Rate, from, to time.Time) []Slot {
+	slots := []Slot{}
+	for start := from.Truncate(slotLength); start.Before(to); start = start.Add(slotLength) {
+		for _, r := range rates {
+			if !start.Before(r.ValidFrom) && (r.ValidTo == nil || start.Before(*r.ValidTo)) {
+				slots = append(slots, Slot{
+					Start: start,
+					End:   start.Add(slotLength),
+					Rate:  r.Value,
+				})
+				break
+			}
+		}
+	}
+	return slots
+}
@@ -0,0 +1,236 @@
+// This package checks the stored telemetry of each meter against the
+// settled half-hourly consumption that Octopus bills on, to find when the
+// telemetry feed was wrong or dropped data.
+package reconcile
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"math"
+	"time"
+)
+
+// Consumption is settled in half hours.
+const slotLength = 30 * time.Minute
+
+// Differences of up to this many Wh in a half hour are expected, since the
+// energy of a reading just after the half hour ends is counted in the next
+// one.
+const slotTolerance = 25
+
+// Half hours whose telemetry differs from the settled consumption by more
+// than this percentage, and by more than slotTolerance, are flagged.
+const slotDriftThreshold = 10.0
+
+// Days whose telemetry differs from the settled consumption by more than
+// this percentage in total are flagged.
+const dayDriftThreshold = 2.0
+
+// How a meter's telemetry compares with its settled consumption over one
+// half hour.
+type Slot struct {
+	// The device ID of the meter.
+	MeterId string    `json:"meterId"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// The number of telemetry readings in the half hour. Zero if the
+	// telemetry feed dropped the half hour entirely.
+	Readings int `json:"readings"`
+	// The energy measured by the telemetry, in Wh.
+	Telemetry int `json:"telemetry"`
+	// The energy settled for billing, in Wh.
+	Settled int `json:"settled"`
+	// Telemetry minus settled, in Wh.
+	Difference int `json:"difference"`
+	// The difference as a percentage of the settled energy. Zero if nothing
+	// was settled.
+	Drift float64 `json:"drift"`
+	// Whether the telemetry is missing, or differs by too much to put down
+	// to the timing of readings.
+	Flagged bool `json:"flagged"`
+}
+
+// Returns the slot as it is stored.
+func (slot *Slot) stored() *store.ReconciledSlot {
+	return &store.ReconciledSlot{
+		MeterId:    slot.MeterId,
+		Start:      slot.Start,
+		End:        slot.End,
+		Readings:   slot.Readings,
+		Telemetry:  slot.Telemetry,
+		Settled:    slot.Settled,
+		Difference: slot.Difference,
+		Drift:      slot.Drift,
+		Flagged:    slot.Flagged,
+	}
+}
+
+// Returns the slot of a stored reconciliation.
+func storedSlot(s *store.ReconciledSlot) *Slot {
+	return &Slot{
+		MeterId:    s.MeterId,
+		Start:      s.Start,
+		End:        s.End,
+		Readings:   s.Readings,
+		Telemetry:  s.Telemetry,
+		Settled:    s.Settled,
+		Difference: s.Difference,
+		Drift:      s.Drift,
+		Flagged:    s.Flagged,
+	}
+}
+
+// Returns the difference between a and b as a percentage of b, or zero if
+// b is zero.
+func drift(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a-b) / float64(b) * 100
+}
+
+// Creates a slot from the telemetry and settled consumption of a half hour,
+// working out the difference and whether to flag it.
+func newSlot(meterId string, start time.Time, readings int, telemetry int, settled int) *Slot {
+	slot := &Slot{
+		MeterId:    meterId,
+		Start:      start,
+		End:        start.Add(slotLength),
+		Readings:   readings,
+		Telemetry:  telemetry,
+		Settled:    settled,
+		Difference: telemetry - settled,
+		Drift:      drift(telemetry, settled),
+	}
+
+	difference := math.Abs(float64(slot.Difference))
+	slot.Flagged = (readings == 0 && settled > 0) ||
+		(difference > slotTolerance && difference > float64(settled)*slotDriftThreshold/100)
+
+	return slot
+}
+
+// Compares the device's telemetry with its settled consumption in every
+// half hour between from and to that has been settled, and saves the
+// result to s. Returns the reconciled slots, oldest first.
+func Reconcile(s *store.Store, device octopus.Device, from, to time.Time) ([]*Slot, error) {
+	settled, err := s.SettledConsumption(device.Meter, device.MeterPoint, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load settled consumption: %w", err)
+	}
+
+	buckets, err := s.ReadingBuckets(store.ReadingsQuery{
+		Meter:      device.Meter,
+		MeterId:    device.Id,
+		From:       from,
+		To:         to,
+		Resolution: store.ResolutionHalfHour,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load telemetry: %w", err)
+	}
+
+	telemetry := map[time.Time]*store.ReadingBucket{}
+	for _, b := range buckets {
+		telemetry[b.Start] = b
+	}
+
+	slots := []*Slot{}
+	for _, interval := range settled {
+		// Only the device's own meter, and only whole half hours
+		if interval.SerialNumber != device.SerialNumber || interval.IntervalEnd.Sub(interval.IntervalStart) != slotLength {
+			continue
+		}
+
+		start := interval.IntervalStart.UTC()
+		readings, consumption := 0, 0
+		if b, ok := telemetry[start]; ok {
+			readings, consumption = b.Count, b.Consumption
+		}
+
+		slots = append(slots, newSlot(device.Id, start, readings, consumption, interval.Consumption))
+	}
+
+	stored := make([]*store.ReconciledSlot, len(slots))
+	for i, slot := range slots {
+		stored[i] = slot.stored()
+	}
+	err = s.SaveReconciliation(stored)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to save reconciliation: %w", err)
+	}
+
+	return slots, nil
+}
+
+// Returns the stored reconciliation of the given meter for the half hours
+// between from and to, oldest first.
+func Load(s *store.Store, meterId string, from, to time.Time) ([]*Slot, error) {
+	stored, err := s.Reconciliation(meterId, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load reconciliation: %w", err)
+	}
+
+	slots := make([]*Slot, len(stored))
+	for i, slot := range stored {
+		slots[i] = storedSlot(slot)
+	}
+	return slots, nil
+}
+
+// A summary of the reconciled half hours of one meter on one day.
+type Day struct {
+	// The day, as YYYY-MM-DD.
+	Date    string `json:"date"`
+	MeterId string `json:"meterId"`
+	// The number of settled half hours that were reconciled.
+	Slots int `json:"slots"`
+	// The number of those half hours with no telemetry at all.
+	MissingSlots int `json:"missingSlots"`
+	// The number of those half hours that were flagged.
+	FlaggedSlots int `json:"flaggedSlots"`
+	// The energy measured by the telemetry, in Wh.
+	Telemetry int `json:"telemetry"`
+	// The energy settled for billing, in Wh.
+	Settled int `json:"settled"`
+	// Telemetry minus settled, in Wh.
+	Difference int `json:"difference"`
+	// The difference as a percentage of the settled energy.
+	Drift float64 `json:"drift"`
+	// Whether any half hour was flagged, or the day's total drifted too far.
+	Flagged bool `json:"flagged"`
+}
+
+// Sums reconciled slots into days in the given time zone, oldest first.
+// Slots must be in order.
+func Days(slots []*Slot, location *time.Location) []*Day {
+	days := []*Day{}
+
+	var day *Day
+	for _, slot := range slots {
+		date := slot.Start.In(location).Format(time.DateOnly)
+		if day == nil || day.Date != date || day.MeterId != slot.MeterId {
+			day = &Day{Date: date, MeterId: slot.MeterId}
+			days = append(days, day)
+		}
+
+		day.Slots++
+		if slot.Readings == 0 {
+			day.MissingSlots++
+		}
+		if slot.Flagged {
+			day.FlaggedSlots++
+		}
+		day.Telemetry += slot.Telemetry
+		day.Settled += slot.Settled
+	}
+
+	for _, day := range days {
+		day.Difference = day.Telemetry - day.Settled
+		day.Drift = drift(day.Telemetry, day.Settled)
+		day.Flagged = day.FlaggedSlots > 0 || math.Abs(day.Drift) > dayDriftThreshold
+	}
+
+	return days
+}
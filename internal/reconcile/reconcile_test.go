@@ -0,0 +1,142 @@
+package reconcile
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"martin-walls/octopus-energy-tracker/internal/store/storetest"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var testDevice = octopus.Device{
+	Id:           "00-00-00-00-00-00-00-01",
+	Meter:        octopus.ElectricityImport,
+	MeterPoint:   "1000000000001",
+	SerialNumber: "21E0000001",
+}
+
+// Opens a fresh store with one reading every 10 seconds for the given
+// duration from testStart, using 10Wh per reading, and the given settled
+// consumption of each half hour from testStart.
+func newTestStore(t *testing.T, d time.Duration, settled ...int) *store.Store {
+	t.Helper()
+
+	s := storetest.Open(t)
+	storetest.AddReadings(t, s, testDevice.Id, testStart, d, 10)
+
+	intervals := []*octopus.ConsumptionInterval{}
+	for i, consumption := range settled {
+		start := testStart.Add(time.Duration(i) * slotLength)
+		intervals = append(intervals, &octopus.ConsumptionInterval{
+			Meter:         testDevice.Meter,
+			MeterPoint:    testDevice.MeterPoint,
+			SerialNumber:  testDevice.SerialNumber,
+			IntervalStart: start,
+			IntervalEnd:   start.Add(slotLength),
+			Consumption:   consumption,
+		})
+	}
+	err := s.SaveSettledConsumption(intervals)
+	if err != nil {
+		t.Fatalf("SaveSettledConsumption: %v", err)
+	}
+
+	return s
+}
+
+func TestReconcile(t *testing.T) {
+	// Telemetry of 1800Wh per half hour for 90 minutes, except that the
+	// first reading has nothing to count from
+	s := newTestStore(t, 90*time.Minute, 1800, 1800, 2400, 1800)
+
+	slots, err := Reconcile(s, testDevice, testStart, testStart.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(slots) != 4 {
+		t.Fatalf("Reconcile() returned %v slots, want 4", len(slots))
+	}
+
+	tests := []struct {
+		telemetry  int
+		difference int
+		flagged    bool
+	}{
+		// Within the tolerance for readings at the boundaries
+		{1790, -10, false},
+		{1800, 0, false},
+		// The telemetry under-read
+		{1800, -600, true},
+		// The reading at the end of the telemetry counts towards the last
+		// half hour, which otherwise has no telemetry
+		{10, -1790, true},
+	}
+	for i, test := range tests {
+		slot := slots[i]
+		if slot.Telemetry != test.telemetry || slot.Difference != test.difference || slot.Flagged != test.flagged {
+			t.Errorf("Slot %v = %+v, want telemetry %v, difference %v, flagged %v", i, slot, test.telemetry, test.difference, test.flagged)
+		}
+	}
+	if slots[2].Drift != -25 {
+		t.Errorf("Slot 2 drift = %v, want -25%%", slots[2].Drift)
+	}
+
+	// The result is saved
+	loaded, err := Load(s, testDevice.Id, testStart, testStart.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded) != 4 || *loaded[2] != *slots[2] {
+		t.Errorf("Load() = %+v, want the reconciled slots", loaded)
+	}
+}
+
+func TestReconcileMissingTelemetry(t *testing.T) {
+	s := newTestStore(t, 30*time.Minute, 1800, 1800, 0)
+
+	slots, err := Reconcile(s, testDevice, testStart, testStart.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(slots) != 3 {
+		t.Fatalf("Reconcile() returned %v slots, want 3", len(slots))
+	}
+
+	// Nothing was used in the last half hour, so no telemetry is expected
+	if slots[2].Readings != 0 || slots[2].Flagged {
+		t.Errorf("Slot 2 = %+v, want no readings and not flagged", slots[2])
+	}
+
+	days := Days(slots, time.UTC)
+	if len(days) != 1 {
+		t.Fatalf("Days() returned %v days, want 1", len(days))
+	}
+	day := days[0]
+	if day.Date != "2025-01-01" || day.Slots != 3 || day.FlaggedSlots != 1 || day.Settled != 3600 || !day.Flagged {
+		t.Errorf("Days()[0] = %+v, want 3 slots with 1 flagged", day)
+	}
+}
+
+func TestDays(t *testing.T) {
+	slots := []*Slot{
+		newSlot(testDevice.Id, testStart, 180, 1000, 1000),
+		newSlot(testDevice.Id, testStart.Add(slotLength), 180, 1020, 1000),
+		newSlot(testDevice.Id, testStart.Add(24*time.Hour), 180, 1030, 1000),
+		newSlot(testDevice.Id, testStart.Add(24*time.Hour+slotLength), 180, 1030, 1000),
+	}
+
+	days := Days(slots, time.UTC)
+	if len(days) != 2 {
+		t.Fatalf("Days() returned %v days, want 2", len(days))
+	}
+
+	// No half hour is flagged, but the second day drifts 3% in total
+	if days[0].Flagged || days[0].Drift != 1 {
+		t.Errorf("Days()[0] = %+v, want 1%% drift and not flagged", days[0])
+	}
+	if !days[1].Flagged || days[1].Drift != 3 || days[1].FlaggedSlots != 0 {
+		t.Errorf("Days()[1] = %+v, want 3%% drift and flagged", days[1])
+	}
+}
@@ -0,0 +1,146 @@
+// This package provides the [Recorder] type which persists consumption
+// readings to the database as they are received from the live poller.
+package recorder
+
+import (
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"sync/atomic"
+	"time"
+)
+
+// The number of readings to collect before writing them to the database.
+const defaultBatchSize = 10
+
+// The number of submitted readings that can wait to be collected while a
+// write is in progress. Beyond this, new readings are dropped.
+const defaultQueueSize = 100
+
+// The maximum time a reading will wait in the batch before being written.
+const defaultFlushInterval = time.Minute
+
+// The maximum number of unwritten readings to hold on to while the database
+// is failing. Beyond this, the oldest readings are dropped.
+const defaultMaxPending = 10000
+
+// Anything that readings can be written to. This is satisfied by
+// [store.Store].
+type ReadingsWriter interface {
+	InsertReadings(rs []*octopus.ConsumptionReading) error
+}
+
+// A [Recorder] batches consumption readings and writes them to a
+// [ReadingsWriter]. Failed writes are retried on the next tick of the flush
+// interval, and submitting a reading never blocks, so a slow or failing
+// database does not interrupt the live stream.
+type Recorder struct {
+	writer ReadingsWriter
+	// Channel used for submitting readings to be recorded.
+	readingsChan chan *octopus.ConsumptionReading
+	// Channel used to stop the recorder.
+	stopChan chan struct{}
+	// Closed once the recorder has flushed and stopped.
+	doneChan chan struct{}
+	// The number of readings dropped because the queue was full.
+	dropped atomic.Int64
+
+	batchSize     int
+	flushInterval time.Duration
+	maxPending    int
+}
+
+// Creates a new [Recorder] that writes readings to w.
+func NewRecorder(w ReadingsWriter) *Recorder {
+	return &Recorder{
+		writer:        w,
+		readingsChan:  make(chan *octopus.ConsumptionReading, defaultQueueSize),
+		stopChan:      make(chan struct{}),
+		doneChan:      make(chan struct{}),
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		maxPending:    defaultMaxPending,
+	}
+}
+
+// Start the [Recorder], collecting readings and writing them in batches.
+// Any pending readings are flushed when the recorder is stopped.
+func (r *Recorder) Start() {
+	defer close(r.doneChan)
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	pending := []*octopus.ConsumptionReading{}
+	// Whether the last write failed. Full batches aren't written until the
+	// next tick, so that a failing database isn't retried on every reading.
+	failing := false
+
+	for {
+		select {
+		case <-r.stopChan:
+			// Drain anything that was submitted before we were stopped
+			for {
+				select {
+				case reading := <-r.readingsChan:
+					pending = append(pending, reading)
+				default:
+					r.flush(pending)
+					return
+				}
+			}
+		case reading := <-r.readingsChan:
+			pending = append(pending, reading)
+			if len(pending) >= r.batchSize && !failing {
+				pending, failing = r.flush(pending)
+			}
+		case <-ticker.C:
+			pending, failing = r.flush(pending)
+		}
+	}
+}
+
+// Stop the [Recorder], waiting for any pending readings to be written.
+func (r *Recorder) Stop() {
+	close(r.stopChan)
+	<-r.doneChan
+}
+
+// Submit a reading to be recorded. If the recorder has fallen behind, e.g.
+// because a write is hanging, the reading is dropped instead of waiting.
+func (r *Recorder) Record(reading *octopus.ConsumptionReading) {
+	select {
+	case r.readingsChan <- reading:
+	default:
+		dropped := r.dropped.Add(1)
+		log.Printf("Recorder is falling behind; dropped reading at %v (%v dropped so far)", reading.Timestamp, dropped)
+	}
+}
+
+// Returns the number of readings dropped because the recorder had fallen
+// behind.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Writes the pending readings to the database. Returns the readings that
+// are still pending, which is empty if the write succeeded, and whether
+// the write failed.
+func (r *Recorder) flush(pending []*octopus.ConsumptionReading) ([]*octopus.ConsumptionReading, bool) {
+	if len(pending) == 0 {
+		return pending, false
+	}
+
+	err := r.writer.InsertReadings(pending)
+	if err != nil {
+		log.Printf("Failed to record %v readings, will retry: %v", len(pending), err)
+
+		if len(pending) > r.maxPending {
+			dropped := len(pending) - r.maxPending
+			log.Printf("Dropping %v oldest unrecorded readings", dropped)
+			pending = pending[dropped:]
+		}
+		return pending, true
+	}
+
+	return []*octopus.ConsumptionReading{}, false
+}
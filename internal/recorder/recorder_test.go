@@ -0,0 +1,171 @@
+package recorder
+
+import (
+	"errors"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A [ReadingsWriter] that stores readings in memory, and can be made to fail.
+type fakeWriter struct {
+	lock     sync.Mutex
+	readings []*octopus.ConsumptionReading
+	fail     bool
+	// The number of writes attempted.
+	attempts int
+	// If set, writes wait until this is closed.
+	block chan struct{}
+}
+
+func (w *fakeWriter) InsertReadings(rs []*octopus.ConsumptionReading) error {
+	if w.block != nil {
+		<-w.block
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.attempts++
+	if w.fail {
+		return errors.New("database is locked")
+	}
+	w.readings = append(w.readings, rs...)
+	return nil
+}
+
+func (w *fakeWriter) attempted() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.attempts
+}
+
+func (w *fakeWriter) count() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.readings)
+}
+
+func newTestRecorder(w ReadingsWriter) *Recorder {
+	r := NewRecorder(w)
+	r.batchSize = 3
+	r.flushInterval = time.Hour
+	return r
+}
+
+func reading(i int) *octopus.ConsumptionReading {
+	return &octopus.ConsumptionReading{
+		Timestamp:        time.Unix(int64(i*10), 0),
+		TotalConsumption: i,
+		Demand:           100,
+	}
+}
+
+func TestRecorderWritesFullBatches(t *testing.T) {
+	w := &fakeWriter{}
+	r := newTestRecorder(w)
+	go r.Start()
+
+	for i := range 3 {
+		r.Record(reading(i))
+	}
+
+	// Give time for the batch to be written
+	time.Sleep(50 * time.Millisecond)
+
+	if c := w.count(); c != 3 {
+		t.Errorf("Expected 3 readings to be written, got %v", c)
+	}
+
+	r.Stop()
+}
+
+func TestRecorderFlushesOnStop(t *testing.T) {
+	w := &fakeWriter{}
+	r := newTestRecorder(w)
+	go r.Start()
+
+	r.Record(reading(0))
+	r.Stop()
+
+	if c := w.count(); c != 1 {
+		t.Errorf("Expected 1 reading to be written on stop, got %v", c)
+	}
+}
+
+func TestRecorderRetriesFailedWrites(t *testing.T) {
+	w := &fakeWriter{fail: true}
+	r := newTestRecorder(w)
+	go r.Start()
+
+	for i := range 3 {
+		r.Record(reading(i))
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if c := w.count(); c != 0 {
+		t.Errorf("Expected no readings to be written while failing, got %v", c)
+	}
+
+	w.lock.Lock()
+	w.fail = false
+	w.lock.Unlock()
+
+	r.Record(reading(3))
+	r.Stop()
+
+	if c := w.count(); c != 4 {
+		t.Errorf("Expected 4 readings to be written after recovering, got %v", c)
+	}
+}
+
+func TestRecorderWaitsToRetryFailedWrites(t *testing.T) {
+	w := &fakeWriter{fail: true}
+	r := newTestRecorder(w)
+	go r.Start()
+	defer r.Stop()
+
+	for i := range 9 {
+		r.Record(reading(i))
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Only the first full batch is tried until the next tick
+	if c := w.attempted(); c != 1 {
+		t.Errorf("Expected 1 write to be attempted, got %v", c)
+	}
+}
+
+func TestRecorderDropsReadingsWhenBehind(t *testing.T) {
+	w := &fakeWriter{block: make(chan struct{})}
+	r := newTestRecorder(w)
+	go r.Start()
+
+	// The first batch hangs, and the queue fills up behind it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 3 + defaultQueueSize + 5 {
+			r.Record(reading(i))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Record blocked while a write was hanging")
+	}
+	if d := r.Dropped(); d == 0 {
+		t.Errorf("Expected readings to be dropped")
+	}
+
+	close(w.block)
+	r.Stop()
+
+	if c := w.count(); int64(c)+r.Dropped() != 3+defaultQueueSize+5 {
+		t.Errorf("Expected every reading to be written or dropped, got %v written and %v dropped", c, r.Dropped())
+	}
+}
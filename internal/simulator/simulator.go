@@ -0,0 +1,228 @@
+// This package works out what the stored electricity consumption would
+// have cost on other tariffs, such as Flexible, Agile, Go or Cosy. The
+// tariffs are described in a file, so comparisons run entirely offline.
+//
+// All amounts are in pence and include VAT.
+package simulator
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"time"
+)
+
+// Consumption is replayed in half hours, since every tariff changes rate on
+// the half hour.
+const slotLength = 30 * time.Minute
+
+const day = 24 * time.Hour
+
+// Replays stored consumption against a set of tariffs.
+type Simulator struct {
+	// The tariffs to compare, with the baseline first.
+	Tariffs []*Tariff
+	// The time zone that bands and months are in.
+	location *time.Location
+}
+
+// The cost of one month's consumption on a tariff. The first and last
+// months may be partial.
+type Month struct {
+	// The month, as YYYY-MM.
+	Month string `json:"month"`
+	// The energy used, in Wh.
+	Consumption int `json:"consumption"`
+	// The cost of the energy used, in pence.
+	Energy float64 `json:"energy"`
+	// The standing charges, in pence.
+	StandingCharge float64 `json:"standingCharge"`
+	// The energy cost plus standing charges, in pence.
+	Total float64 `json:"total"`
+}
+
+// What the consumption would have cost on one tariff.
+type Result struct {
+	Tariff string `json:"tariff"`
+	// The energy used, in Wh.
+	Consumption int `json:"consumption"`
+	// The energy used at times the tariff has no unit rate for, in Wh. It
+	// isn't included in Energy.
+	Unpriced int `json:"unpriced"`
+	// The cost of the energy used, in pence.
+	Energy float64 `json:"energy"`
+	// The standing charges, in pence.
+	StandingCharge float64 `json:"standingCharge"`
+	// The energy cost plus standing charges, in pence.
+	Total float64 `json:"total"`
+	// The energy cost divided by the priced energy, in pence per kWh.
+	AverageRate float64 `json:"averageRate"`
+	// The total minus the baseline tariff's total, in pence. Negative if
+	// this tariff is cheaper.
+	Difference float64 `json:"difference"`
+	// The average daily consumption, in kWh, at which this tariff and the
+	// baseline would cost the same, if energy were used at the same times
+	// of day. Nil if one is cheaper at any level of use.
+	BreakEven *float64 `json:"breakEven"`
+	Months    []*Month `json:"months"`
+}
+
+// The outcome of a simulation.
+type Report struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// The length of the period, in days.
+	Days float64 `json:"days"`
+	// The energy used, in Wh.
+	Consumption int `json:"consumption"`
+	// One result per tariff, in the order they were defined.
+	Results []*Result `json:"results"`
+}
+
+// The average energy used per day, in kWh.
+func (r *Report) DailyConsumption() float64 {
+	if r.Days == 0 {
+		return 0
+	}
+	return float64(r.Consumption) / 1000 / r.Days
+}
+
+// A calendar month, clipped to the simulated period.
+type monthSpan struct {
+	label string
+	start time.Time
+	end   time.Time
+}
+
+// Returns the calendar months between from and to, in the simulator's time
+// zone.
+func (sim *Simulator) months(from, to time.Time) []monthSpan {
+	spans := []monthSpan{}
+
+	local := from.In(sim.location)
+	monthStart := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, sim.location)
+	for monthStart.Before(to) {
+		next := monthStart.AddDate(0, 1, 0)
+
+		span := monthSpan{label: monthStart.Format("2006-01"), start: monthStart, end: next}
+		if from.After(span.start) {
+			span.start = from
+		}
+		if to.Before(span.end) {
+			span.end = to
+		}
+		spans = append(spans, span)
+
+		monthStart = next
+	}
+
+	return spans
+}
+
+// Prices the electricity imported between from and to on every tariff. If
+// meterId is empty, the consumption of every electricity import meter is
+// summed, and charged one standing charge.
+func (sim *Simulator) Run(s *store.Store, meterId string, from, to time.Time) (*Report, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("Invalid period: %v is not before %v", from, to)
+	}
+
+	buckets, err := s.ReadingBuckets(store.ReadingsQuery{
+		Meter:      octopus.ElectricityImport,
+		MeterId:    meterId,
+		From:       from,
+		To:         to,
+		Resolution: store.ResolutionHalfHour,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load consumption: %w", err)
+	}
+
+	report := &Report{
+		From: from,
+		To:   to,
+		Days: float64(to.Sub(from)) / float64(day),
+	}
+
+	spans := sim.months(from, to)
+	monthIndex := map[string]int{}
+	for i, span := range spans {
+		monthIndex[span.label] = i
+	}
+
+	for _, tariff := range sim.Tariffs {
+		result := &Result{Tariff: tariff.Name}
+		for _, span := range spans {
+			days := float64(span.end.Sub(span.start)) / float64(day)
+			result.Months = append(result.Months, &Month{
+				Month:          span.label,
+				StandingCharge: days * tariff.StandingCharge,
+			})
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	for _, b := range buckets {
+		report.Consumption += b.Consumption
+
+		// The first bucket may start before the period if from isn't on the
+		// half hour
+		at := b.Start.In(sim.location)
+		if at.Before(from) {
+			at = from.In(sim.location)
+		}
+		month := monthIndex[at.Format("2006-01")]
+
+		for i, tariff := range sim.Tariffs {
+			result := report.Results[i]
+			result.Consumption += b.Consumption
+			result.Months[month].Consumption += b.Consumption
+
+			rate, ok := tariff.UnitRate(at)
+			if !ok {
+				result.Unpriced += b.Consumption
+				continue
+			}
+
+			energy := float64(b.Consumption) / 1000 * rate
+			result.Energy += energy
+			result.Months[month].Energy += energy
+		}
+	}
+
+	for _, result := range report.Results {
+		for _, month := range result.Months {
+			month.Total = month.Energy + month.StandingCharge
+			result.StandingCharge += month.StandingCharge
+		}
+		result.Total = result.Energy + result.StandingCharge
+
+		if priced := result.Consumption - result.Unpriced; priced > 0 {
+			result.AverageRate = result.Energy / (float64(priced) / 1000)
+		}
+	}
+
+	baseline := report.Results[0]
+	for i, result := range report.Results[1:] {
+		result.Difference = result.Total - baseline.Total
+		result.BreakEven = breakEven(sim.Tariffs[0], baseline, sim.Tariffs[i+1], result)
+	}
+
+	return report, nil
+}
+
+// Returns the daily consumption in kWh at which the two tariffs cost the
+// same, or nil if there is none. Each tariff's cost per day is its
+// standing charge plus its average rate times the consumption.
+func breakEven(baseline *Tariff, baselineResult *Result, other *Tariff, otherResult *Result) *float64 {
+	rateDifference := baselineResult.AverageRate - otherResult.AverageRate
+	if rateDifference == 0 {
+		return nil
+	}
+
+	kWh := (other.StandingCharge - baseline.StandingCharge) / rateDifference
+	if kWh <= 0 {
+		return nil
+	}
+	return &kWh
+}
@@ -0,0 +1,192 @@
+package simulator
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"martin-walls/octopus-energy-tracker/internal/store/storetest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The day before a month ends, so that simulations span two months.
+var testStart = time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+
+// Opens a fresh store with one electricity reading every 10 seconds for the
+// given duration from testStart, using 10Wh per reading.
+func newTestStore(t *testing.T, d time.Duration) *store.Store {
+	t.Helper()
+
+	s := storetest.Open(t)
+	storetest.AddReadings(t, s, "00-00-00-00-00-00-00-01", testStart, d, 10)
+	return s
+}
+
+// Writes a file into dir.
+func writeFile(t *testing.T, dir string, name string, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(contents), 0o644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func rate(value float64) *float64 {
+	return &value
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "prices.json", `{
+		"results": [
+			{"value_exc_vat": 14.0, "value_inc_vat": 14.7, "valid_from": "2025-01-31T00:00:00Z", "valid_to": "2025-01-31T00:30:00Z"}
+		]
+	}`)
+	path := writeFile(t, dir, "tariffs.json", `{
+		"timeZone": "UTC",
+		"tariffs": [
+			{"name": "Flexible", "standingCharge": 50, "unitRate": 24.5},
+			{"name": "Agile", "standingCharge": 45, "unitRate": 25, "prices": "prices.json", "bands": [{"hours": "00:00-01:00", "rate": 5}]},
+			{"name": "Go", "standingCharge": 45, "bands": [{"hours": "00:30-05:30", "rate": 8.5}]}
+		]
+	}`)
+
+	sim, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(sim.Tariffs) != 3 || sim.Tariffs[0].Name != "Flexible" || sim.Tariffs[1].StandingCharge != 45 {
+		t.Fatalf("Load() tariffs = %+v, want Flexible, Agile and Go", sim.Tariffs)
+	}
+
+	agile := sim.Tariffs[1]
+	tests := []struct {
+		tariff *Tariff
+		at     time.Time
+		want   float64
+		ok     bool
+	}{
+		// The price file comes first, then the bands, then the unit rate
+		{agile, testStart, 14.7, true},
+		{agile, testStart.Add(30 * time.Minute), 5, true},
+		{agile, testStart.Add(2 * time.Hour), 25, true},
+		{sim.Tariffs[2], testStart.Add(time.Hour), 8.5, true},
+		{sim.Tariffs[2], testStart.Add(12 * time.Hour), 0, false},
+	}
+	for _, test := range tests {
+		got, ok := test.tariff.UnitRate(test.at)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%v UnitRate(%v) = %v, %v, want %v, %v", test.tariff.Name, test.at, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		"no tariffs": `{"tariffs": []}`,
+		"no rates":   `{"tariffs": [{"name": "Flexible", "standingCharge": 50}]}`,
+		"duplicate":  `{"tariffs": [{"name": "Go", "unitRate": 25}, {"name": "Go", "unitRate": 25}]}`,
+		"bad hours":  `{"tariffs": [{"name": "Go", "bands": [{"hours": "night", "rate": 8.5}]}]}`,
+		"no prices":  `{"tariffs": [{"name": "Agile", "prices": "missing.json"}]}`,
+	}
+	for name, contents := range tests {
+		_, err := Load(writeFile(t, dir, "tariffs.json", contents))
+		if err == nil {
+			t.Errorf("Load(%v) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := newTestStore(t, 2*day)
+
+	sim, err := New(Definitions{
+		TimeZone: "UTC",
+		Tariffs: []TariffDefinition{
+			{Name: "Flexible", StandingCharge: 50, UnitRate: rate(20)},
+			{Name: "Go", StandingCharge: 60, UnitRate: rate(21), Bands: []BandDefinition{{Hours: "00:30-05:30", Rate: 5}}},
+		},
+	}, "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	report, err := sim.Run(s, "", testStart, testStart.Add(2*day))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// The first reading has nothing to count from
+	if report.Consumption != 172790 || report.Days != 2 {
+		t.Fatalf("Run() consumption = %v over %v days, want 172790 over 2", report.Consumption, report.Days)
+	}
+
+	flexible := report.Results[0]
+	if !storetest.ApproxEqual(flexible.Energy, 172.79*20) || !storetest.ApproxEqual(flexible.StandingCharge, 100) || !storetest.ApproxEqual(flexible.Total, 172.79*20+100) {
+		t.Errorf("Flexible = %+v, want 20p/kWh and 50p/day", flexible)
+	}
+	if flexible.Difference != 0 || flexible.BreakEven != nil {
+		t.Errorf("Flexible compared with itself: difference %v, break-even %v", flexible.Difference, flexible.BreakEven)
+	}
+
+	// Five hours a day at the cheap rate
+	goResult := report.Results[1]
+	wantEnergy := 36*5 + 136.79*21
+	if !storetest.ApproxEqual(goResult.Energy, wantEnergy) || !storetest.ApproxEqual(goResult.StandingCharge, 120) || goResult.Unpriced != 0 {
+		t.Errorf("Go = %+v, want energy %v and standing charge 120", goResult, wantEnergy)
+	}
+	if !storetest.ApproxEqual(goResult.Difference, goResult.Total-flexible.Total) {
+		t.Errorf("Go difference = %v, want %v", goResult.Difference, goResult.Total-flexible.Total)
+	}
+
+	// At the break-even point, the extra standing charge cancels out the
+	// saving on the energy
+	if goResult.BreakEven == nil {
+		t.Fatalf("Go break-even = nil, want a daily consumption")
+	}
+	saving := *goResult.BreakEven * (flexible.AverageRate - goResult.AverageRate)
+	if !storetest.ApproxEqual(saving, 10) {
+		t.Errorf("Go saves %vp/day at its break-even point, want 10p to match the standing charge", saving)
+	}
+
+	// Each month has one day of the period
+	if len(flexible.Months) != 2 {
+		t.Fatalf("Flexible months = %v, want 2", len(flexible.Months))
+	}
+	january, february := flexible.Months[0], flexible.Months[1]
+	if january.Month != "2025-01" || january.Consumption != 86390 || !storetest.ApproxEqual(january.Total, 86.39*20+50) {
+		t.Errorf("January = %+v, want 86390Wh costing %v", january, 86.39*20+50)
+	}
+	if february.Month != "2025-02" || february.Consumption != 86400 || !storetest.ApproxEqual(february.Total, 86.4*20+50) {
+		t.Errorf("February = %+v, want 86400Wh costing %v", february, 86.4*20+50)
+	}
+}
+
+func TestRunUnpriced(t *testing.T) {
+	s := newTestStore(t, day)
+
+	sim, err := New(Definitions{
+		TimeZone: "UTC",
+		Tariffs: []TariffDefinition{
+			{Name: "Night only", Bands: []BandDefinition{{Hours: "00:00-12:00", Rate: 10}}},
+		},
+	}, "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	report, err := sim.Run(s, "", testStart, testStart.Add(day))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	result := report.Results[0]
+	if result.Unpriced != 43200 || !storetest.ApproxEqual(result.Energy, 43.19*10) || !storetest.ApproxEqual(result.AverageRate, 10) {
+		t.Errorf("Run() = %+v, want half the day unpriced at an average of 10p/kWh", result)
+	}
+}
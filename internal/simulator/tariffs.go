@@ -0,0 +1,178 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/planner"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A time-of-use band of a tariff definition, such as the cheap overnight
+// hours of Octopus Go.
+type BandDefinition struct {
+	// When the band applies each day, e.g. "00:30-05:30".
+	Hours string `json:"hours"`
+	// The unit rate in pence per kWh, including VAT.
+	Rate float64 `json:"rate"`
+}
+
+// A tariff to simulate, as written in a tariffs file.
+type TariffDefinition struct {
+	Name string `json:"name"`
+	// The standing charge in pence per day, including VAT.
+	StandingCharge float64 `json:"standingCharge"`
+	// The unit rate in pence per kWh, including VAT, at times not covered
+	// by the bands or the price file. Energy used at those times is
+	// unpriced if this isn't set.
+	UnitRate *float64 `json:"unitRate"`
+	// Time-of-use bands. If bands overlap, the first one applies.
+	Bands []BandDefinition `json:"bands"`
+	// A JSON file of half-hourly unit rates, such as Agile prices, in any
+	// format accepted by [planner.LoadRates]. A relative path is relative to
+	// the tariffs file. The prices take precedence over the bands.
+	Prices string `json:"prices"`
+}
+
+// The contents of a tariffs file.
+type Definitions struct {
+	// The IANA time zone that bands and months are in. Defaults to the
+	// local time zone.
+	TimeZone string `json:"timeZone"`
+	// The tariffs to compare. The first is the baseline that the others are
+	// compared against.
+	Tariffs []TariffDefinition `json:"tariffs"`
+}
+
+type band struct {
+	hours cost.Hours
+	rate  float64
+}
+
+// A tariff loaded from a [TariffDefinition].
+type Tariff struct {
+	Name string
+	// The standing charge in pence per day, including VAT.
+	StandingCharge float64
+	unitRate       *float64
+	bands          []band
+	// Half-hourly unit rates, oldest first.
+	prices []octopus.Rate
+}
+
+// Returns the unit rate in pence per kWh at the given time, or false if the
+// tariff doesn't say. The time must be in the time zone of the bands.
+func (t *Tariff) UnitRate(at time.Time) (float64, bool) {
+	// The last price starting at or before the time
+	i := sort.Search(len(t.prices), func(i int) bool {
+		return t.prices[i].ValidFrom.After(at)
+	}) - 1
+	if i >= 0 && (t.prices[i].ValidTo == nil || at.Before(*t.prices[i].ValidTo)) {
+		return t.prices[i].Value, true
+	}
+
+	for _, b := range t.bands {
+		if b.hours.Contains(at) {
+			return b.rate, true
+		}
+	}
+
+	if t.unitRate != nil {
+		return *t.unitRate, true
+	}
+	return 0, false
+}
+
+// Loads a tariff from its definition, reading any price file relative to
+// dir.
+func newTariff(def TariffDefinition, dir string) (*Tariff, error) {
+	if def.Name == "" {
+		return nil, errors.New("Tariff has no name")
+	}
+	if def.UnitRate == nil && len(def.Bands) == 0 && def.Prices == "" {
+		return nil, fmt.Errorf("Tariff %q has no unit rate, bands or prices", def.Name)
+	}
+
+	t := &Tariff{
+		Name:           def.Name,
+		StandingCharge: def.StandingCharge,
+		unitRate:       def.UnitRate,
+	}
+
+	for _, b := range def.Bands {
+		hours, err := cost.ParseHours(b.Hours)
+		if err != nil {
+			return nil, fmt.Errorf("Tariff %q: %w", def.Name, err)
+		}
+		t.bands = append(t.bands, band{hours: hours, rate: b.Rate})
+	}
+
+	if def.Prices != "" {
+		path := def.Prices
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		prices, err := planner.LoadRates(path)
+		if err != nil {
+			return nil, fmt.Errorf("Tariff %q: %w", def.Name, err)
+		}
+		t.prices = prices
+	}
+
+	return t, nil
+}
+
+// Loads the tariffs to compare from a JSON file of [Definitions].
+func Load(path string) (*Simulator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read tariffs: %w", err)
+	}
+
+	defs := Definitions{}
+	err = json.Unmarshal(data, &defs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse tariffs from %v: %w", path, err)
+	}
+
+	return New(defs, filepath.Dir(path))
+}
+
+// Creates a [Simulator] for the given tariffs, reading any price files
+// relative to dir.
+func New(defs Definitions, dir string) (*Simulator, error) {
+	if len(defs.Tariffs) == 0 {
+		return nil, errors.New("No tariffs to compare")
+	}
+
+	sim := &Simulator{location: time.Local}
+	if defs.TimeZone != "" {
+		location, err := time.LoadLocation(defs.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time zone: %w", err)
+		}
+		sim.location = location
+	}
+
+	names := map[string]bool{}
+	for _, def := range defs.Tariffs {
+		if names[def.Name] {
+			return nil, fmt.Errorf("Tariff %q is defined twice", def.Name)
+		}
+		names[def.Name] = true
+
+		t, err := newTariff(def, dir)
+		if err != nil {
+			return nil, err
+		}
+		sim.Tariffs = append(sim.Tariffs, t)
+	}
+
+	return sim, nil
+}
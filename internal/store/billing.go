@@ -0,0 +1,246 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"time"
+)
+
+// The balance of an account when it was fetched.
+type BalanceRecord struct {
+	AccountNumber string
+	RecordedAt    time.Time
+	// The balance in pence. Positive when the account is in credit.
+	Balance int
+}
+
+// Saves the balance of an account as recorded at the given time, along with
+// its bills and payments. Bills and payments that are already stored are
+// replaced, since pending payments change status.
+func (s *Store) SaveBilling(billing *octopus.Billing, recordedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SaveBilling: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO balances (account_number, recorded_at, balance)
+		VALUES (?, ?, ?)
+		ON CONFLICT (account_number, recorded_at) DO UPDATE SET
+			balance = excluded.balance
+	`, billing.AccountNumber, formatTimestamp(recordedAt), billing.Balance)
+	if err != nil {
+		return fmt.Errorf("SaveBilling: %v", err)
+	}
+
+	for _, b := range billing.Bills {
+		_, err = tx.Exec(`
+			INSERT INTO bills (
+				account_number, id, bill_type, issued_at, period_from, period_to,
+				opening_balance, closing_balance, charges, credits
+			)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (account_number, id) DO UPDATE SET
+				bill_type = excluded.bill_type,
+				issued_at = excluded.issued_at,
+				period_from = excluded.period_from,
+				period_to = excluded.period_to,
+				opening_balance = excluded.opening_balance,
+				closing_balance = excluded.closing_balance,
+				charges = excluded.charges,
+				credits = excluded.credits
+		`,
+			billing.AccountNumber, b.Id, b.Type, formatTimestamp(b.IssuedAt),
+			formatTimestamp(b.From), formatTimestamp(b.To),
+			b.OpeningBalance, b.ClosingBalance, b.Charges, b.Credits)
+		if err != nil {
+			return fmt.Errorf("SaveBilling: %v", err)
+		}
+	}
+
+	for _, p := range billing.Payments {
+		_, err = tx.Exec(`
+			INSERT INTO payments (account_number, id, payment_date, amount, status)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (account_number, id) DO UPDATE SET
+				payment_date = excluded.payment_date,
+				amount = excluded.amount,
+				status = excluded.status
+		`, billing.AccountNumber, p.Id, formatTimestamp(p.Date), p.Amount, p.Status)
+		if err != nil {
+			return fmt.Errorf("SaveBilling: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("SaveBilling: %v", err)
+	}
+	return nil
+}
+
+// Returns the stored bills of the given account, newest first.
+func (s *Store) Bills(accountNumber string) ([]*octopus.Bill, error) {
+	rows, err := s.db.Query(`
+		SELECT id, bill_type, issued_at, period_from, period_to,
+			opening_balance, closing_balance, charges, credits
+		FROM bills
+		WHERE account_number = ?
+		ORDER BY period_to DESC, issued_at DESC, id DESC
+	`, accountNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Bills: %v", err)
+	}
+	defer rows.Close()
+
+	bills := []*octopus.Bill{}
+
+	for rows.Next() {
+		b := &octopus.Bill{AccountNumber: accountNumber}
+		var issuedAt, from, to string
+		var openingBalance, closingBalance sql.NullInt64
+
+		err = rows.Scan(&b.Id, &b.Type, &issuedAt, &from, &to,
+			&openingBalance, &closingBalance, &b.Charges, &b.Credits)
+		if err != nil {
+			return nil, fmt.Errorf("Bills: %v", err)
+		}
+
+		b.IssuedAt, err = time.Parse(time.RFC3339, issuedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Bills: %v", err)
+		}
+		b.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, fmt.Errorf("Bills: %v", err)
+		}
+		b.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, fmt.Errorf("Bills: %v", err)
+		}
+		b.OpeningBalance = parseNullInt(openingBalance)
+		b.ClosingBalance = parseNullInt(closingBalance)
+
+		bills = append(bills, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Bills: %v", err)
+	}
+
+	return bills, nil
+}
+
+// Returns the stored payments of the given account, newest first.
+func (s *Store) Payments(accountNumber string) ([]*octopus.Payment, error) {
+	rows, err := s.db.Query(`
+		SELECT id, payment_date, amount, status
+		FROM payments
+		WHERE account_number = ?
+		ORDER BY payment_date DESC, id DESC
+	`, accountNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Payments: %v", err)
+	}
+	defer rows.Close()
+
+	payments := []*octopus.Payment{}
+
+	for rows.Next() {
+		p := &octopus.Payment{AccountNumber: accountNumber}
+		var date string
+
+		err = rows.Scan(&p.Id, &date, &p.Amount, &p.Status)
+		if err != nil {
+			return nil, fmt.Errorf("Payments: %v", err)
+		}
+
+		p.Date, err = time.Parse(time.RFC3339, date)
+		if err != nil {
+			return nil, fmt.Errorf("Payments: %v", err)
+		}
+
+		payments = append(payments, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Payments: %v", err)
+	}
+
+	return payments, nil
+}
+
+// Returns the balances of the given account recorded between from and to,
+// oldest first.
+func (s *Store) Balances(accountNumber string, from, to time.Time) ([]*BalanceRecord, error) {
+	rows, err := s.db.Query(`
+		SELECT recorded_at, balance
+		FROM balances
+		WHERE account_number = ? AND recorded_at >= ? AND recorded_at < ?
+		ORDER BY recorded_at
+	`, accountNumber, formatTimestamp(from), formatTimestamp(to))
+	if err != nil {
+		return nil, fmt.Errorf("Balances: %v", err)
+	}
+	defer rows.Close()
+
+	balances := []*BalanceRecord{}
+
+	for rows.Next() {
+		b := &BalanceRecord{AccountNumber: accountNumber}
+		var recordedAt string
+
+		err = rows.Scan(&recordedAt, &b.Balance)
+		if err != nil {
+			return nil, fmt.Errorf("Balances: %v", err)
+		}
+
+		b.RecordedAt, err = time.Parse(time.RFC3339, recordedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Balances: %v", err)
+		}
+
+		balances = append(balances, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Balances: %v", err)
+	}
+
+	return balances, nil
+}
+
+// Returns the latest recorded balance of the given account, or nil if none
+// has been recorded.
+func (s *Store) LatestBalance(accountNumber string) (*BalanceRecord, error) {
+	b := &BalanceRecord{AccountNumber: accountNumber}
+	var recordedAt string
+
+	err := s.db.QueryRow(`
+		SELECT recorded_at, balance
+		FROM balances
+		WHERE account_number = ?
+		ORDER BY recorded_at DESC
+		LIMIT 1
+	`, accountNumber).Scan(&recordedAt, &b.Balance)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LatestBalance: %v", err)
+	}
+
+	b.RecordedAt, err = time.Parse(time.RFC3339, recordedAt)
+	if err != nil {
+		return nil, fmt.Errorf("LatestBalance: %v", err)
+	}
+	return b, nil
+}
+
+// Converts an optional stored integer, returning nil if it is NULL.
+func parseNullInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"time"
+)
+
+// Saves the given dispatches, replacing any stored dispatch of the same
+// account that starts at the same time. Every stored planned dispatch is
+// removed first, since planned dispatches can be moved or cancelled, so
+// the dispatches should be all of those fetched for every account.
+func (s *Store) SaveDispatches(dispatches []*octopus.Dispatch) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SaveDispatches: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM dispatches WHERE completed = 0`)
+	if err != nil {
+		return fmt.Errorf("SaveDispatches: %v", err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO dispatches (
+			account_number, dispatch_start, dispatch_end, energy, source, location, completed
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (account_number, dispatch_start) DO UPDATE SET
+			dispatch_end = excluded.dispatch_end,
+			energy = excluded.energy,
+			source = excluded.source,
+			location = excluded.location,
+			completed = excluded.completed
+	`)
+	if err != nil {
+		return fmt.Errorf("SaveDispatches: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, d := range dispatches {
+		_, err = stmt.Exec(
+			d.AccountNumber, formatTimestamp(d.Start), formatTimestamp(d.End),
+			d.Energy, d.Source, d.Location, d.Completed)
+		if err != nil {
+			return fmt.Errorf("SaveDispatches: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("SaveDispatches: %v", err)
+	}
+	return nil
+}
+
+// Returns the stored dispatches of the given account, or of every account
+// if it is empty, that overlap the window between from and to, oldest
+// first. The window is unbounded at either end if from or to is zero.
+func (s *Store) Dispatches(accountNumber string, from, to time.Time) ([]*octopus.Dispatch, error) {
+	lower, upper := minTimestamp, maxTimestamp
+	if !from.IsZero() {
+		lower = formatTimestamp(from)
+	}
+	if !to.IsZero() {
+		upper = formatTimestamp(to)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT account_number, dispatch_start, dispatch_end, energy, source, location, completed
+		FROM dispatches
+		WHERE (?1 = '' OR account_number = ?1) AND dispatch_end > ?2 AND dispatch_start < ?3
+		ORDER BY dispatch_start, account_number
+	`, accountNumber, lower, upper)
+	if err != nil {
+		return nil, fmt.Errorf("Dispatches: %v", err)
+	}
+	defer rows.Close()
+
+	dispatches := []*octopus.Dispatch{}
+
+	for rows.Next() {
+		d := &octopus.Dispatch{}
+		var start, end string
+
+		err = rows.Scan(&d.AccountNumber, &start, &end, &d.Energy, &d.Source, &d.Location, &d.Completed)
+		if err != nil {
+			return nil, fmt.Errorf("Dispatches: %v", err)
+		}
+
+		d.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("Dispatches: %v", err)
+		}
+		d.End, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, fmt.Errorf("Dispatches: %v", err)
+		}
+
+		dispatches = append(dispatches, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Dispatches: %v", err)
+	}
+
+	return dispatches, nil
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"time"
+)
+
+// The consumption recorded during a flexibility event, and what it earned.
+type EventResult struct {
+	// When the result was calculated.
+	EvaluatedAt time.Time
+	// The energy expected to be used during the event, in Wh.
+	Baseline int
+	// The energy used during the event, in Wh.
+	Actual int
+	// The energy used below the baseline, in Wh.
+	Saved int
+	// The Octopoints earned.
+	Points int
+	// The money earned, in pence.
+	Savings float64
+}
+
+// A stored flexibility event and its result.
+type EventRecord struct {
+	Event *octopus.FlexibilityEvent
+	// Nil until the event has been evaluated.
+	Result *EventResult
+}
+
+// Saves the given flexibility events, replacing any stored event of the
+// same account and type that starts at the same time. The results of
+// stored events are kept.
+func (s *Store) SaveFlexibilityEvents(events []*octopus.FlexibilityEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SaveFlexibilityEvents: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO flexibility_events (
+			account_number, event_type, code, event_start, event_end,
+			reward_per_kwh, joined, points_awarded
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (account_number, event_type, event_start) DO UPDATE SET
+			code = excluded.code,
+			event_end = excluded.event_end,
+			reward_per_kwh = excluded.reward_per_kwh,
+			joined = excluded.joined,
+			points_awarded = excluded.points_awarded
+	`)
+	if err != nil {
+		return fmt.Errorf("SaveFlexibilityEvents: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		_, err = stmt.Exec(
+			e.AccountNumber, e.Type, e.Code, formatTimestamp(e.Start), formatTimestamp(e.End),
+			e.RewardPerKwh, e.Joined, e.PointsAwarded)
+		if err != nil {
+			return fmt.Errorf("SaveFlexibilityEvents: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("SaveFlexibilityEvents: %v", err)
+	}
+	return nil
+}
+
+// Saves the result of a stored flexibility event, replacing any previous
+// result.
+func (s *Store) SaveEventResult(event *octopus.FlexibilityEvent, result *EventResult) error {
+	_, err := s.db.Exec(`
+		UPDATE flexibility_events SET
+			evaluated_at = ?, baseline = ?, actual = ?, saved = ?, points = ?, savings = ?
+		WHERE account_number = ? AND event_type = ? AND event_start = ?
+	`,
+		formatTimestamp(result.EvaluatedAt), result.Baseline, result.Actual, result.Saved,
+		result.Points, result.Savings,
+		event.AccountNumber, event.Type, formatTimestamp(event.Start))
+	if err != nil {
+		return fmt.Errorf("SaveEventResult: %v", err)
+	}
+	return nil
+}
+
+// Returns the stored flexibility events of the given account, or of every
+// account if it is empty, that overlap the window between from and to,
+// oldest first. The window is unbounded at either end if from or to is
+// zero.
+func (s *Store) FlexibilityEvents(accountNumber string, from, to time.Time) ([]*EventRecord, error) {
+	lower, upper := minTimestamp, maxTimestamp
+	if !from.IsZero() {
+		lower = formatTimestamp(from)
+	}
+	if !to.IsZero() {
+		upper = formatTimestamp(to)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT account_number, event_type, code, event_start, event_end,
+			reward_per_kwh, joined, points_awarded,
+			evaluated_at, baseline, actual, saved, points, savings
+		FROM flexibility_events
+		WHERE (?1 = '' OR account_number = ?1) AND event_end > ?2 AND event_start < ?3
+		ORDER BY event_start, account_number, event_type
+	`, accountNumber, lower, upper)
+	if err != nil {
+		return nil, fmt.Errorf("FlexibilityEvents: %v", err)
+	}
+	defer rows.Close()
+
+	records := []*EventRecord{}
+
+	for rows.Next() {
+		e := &octopus.FlexibilityEvent{}
+		var start, end string
+		var pointsAwarded sql.NullInt64
+		var evaluatedAt sql.NullString
+		var baseline, actual, saved, points sql.NullInt64
+		var savings sql.NullFloat64
+
+		err = rows.Scan(&e.AccountNumber, &e.Type, &e.Code, &start, &end,
+			&e.RewardPerKwh, &e.Joined, &pointsAwarded,
+			&evaluatedAt, &baseline, &actual, &saved, &points, &savings)
+		if err != nil {
+			return nil, fmt.Errorf("FlexibilityEvents: %v", err)
+		}
+
+		e.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("FlexibilityEvents: %v", err)
+		}
+		e.End, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, fmt.Errorf("FlexibilityEvents: %v", err)
+		}
+		e.PointsAwarded = parseNullInt(pointsAwarded)
+
+		record := &EventRecord{Event: e}
+		if evaluatedAt.Valid {
+			record.Result = &EventResult{
+				Baseline: int(baseline.Int64),
+				Actual:   int(actual.Int64),
+				Saved:    int(saved.Int64),
+				Points:   int(points.Int64),
+				Savings:  savings.Float64,
+			}
+			record.Result.EvaluatedAt, err = time.Parse(time.RFC3339, evaluatedAt.String)
+			if err != nil {
+				return nil, fmt.Errorf("FlexibilityEvents: %v", err)
+			}
+		}
+
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("FlexibilityEvents: %v", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"time"
+)
+
+// A period with no readings.
+type Gap struct {
+	// The time of the last reading before the gap, or the start of the
+	// searched window.
+	From time.Time
+	// The time of the first reading after the gap, or the end of the
+	// searched window.
+	To time.Time
+}
+
+func (g Gap) Duration() time.Duration {
+	return g.To.Sub(g.From)
+}
+
+// Finds the periods between from and to that are longer than minGap and
+// contain no readings from the given device, oldest first. The start and
+// end of the window are treated as readings, so a window with no readings
+// at all is returned as a single gap.
+func (s *Store) Gaps(device octopus.Device, from, to time.Time, minGap time.Duration) ([]Gap, error) {
+	// Every reading in the window along with the one before it. The window
+	// bounds are added as extra rows so that gaps at either end are found.
+	rows, err := s.db.Query(`
+		SELECT previous, timestamp
+		FROM (
+			SELECT
+				LAG(timestamp) OVER (ORDER BY timestamp) AS previous,
+				timestamp
+			FROM (
+				SELECT timestamp FROM readings
+				WHERE fuel = ?4 AND direction = ?5 AND meter_id = ?6 AND timestamp > ?1 AND timestamp < ?2
+				UNION ALL SELECT ?1
+				UNION ALL SELECT ?2
+			)
+		)
+		WHERE previous IS NOT NULL
+		AND strftime('%s', timestamp) - strftime('%s', previous) > ?3
+		ORDER BY timestamp
+	`, formatTimestamp(from), formatTimestamp(to), int64(minGap.Seconds()), device.Meter.Fuel, device.Meter.Direction, device.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Gaps: %v", err)
+	}
+	defer rows.Close()
+
+	gaps := []Gap{}
+
+	for rows.Next() {
+		var previous, timestamp string
+
+		err = rows.Scan(&previous, &timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("Gaps: %v", err)
+		}
+
+		gapFrom, err := time.Parse(time.RFC3339, previous)
+		if err != nil {
+			return nil, fmt.Errorf("Gaps: %v", err)
+		}
+		gapTo, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("Gaps: %v", err)
+		}
+
+		gaps = append(gaps, Gap{From: gapFrom, To: gapTo})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Gaps: %v", err)
+	}
+
+	return gaps, nil
+}
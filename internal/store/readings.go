@@ -0,0 +1,311 @@
+package store
+
+import (
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"time"
+)
+
+// Sorts before any stored timestamp; used when a query has no lower bound.
+const minTimestamp = ""
+
+// Sorts after any stored timestamp; used when a query has no upper bound.
+const maxTimestamp = "9999-12-31T23:59:59Z"
+
+// The resolution to aggregate readings at.
+type Resolution string
+
+const (
+	// Individual readings, without any aggregation.
+	ResolutionRaw        Resolution = "raw"
+	ResolutionOneMinute  Resolution = "1m"
+	ResolutionFiveMinute Resolution = "5m"
+	ResolutionHalfHour   Resolution = "30m"
+	ResolutionOneHour    Resolution = "1h"
+	ResolutionOneDay     Resolution = "1d"
+)
+
+var resolutionDurations = map[Resolution]time.Duration{
+	ResolutionRaw:        0,
+	ResolutionOneMinute:  time.Minute,
+	ResolutionFiveMinute: 5 * time.Minute,
+	ResolutionHalfHour:   30 * time.Minute,
+	ResolutionOneHour:    time.Hour,
+	ResolutionOneDay:     24 * time.Hour,
+}
+
+// Parses a resolution string such as "5m". An empty string is treated as
+// [ResolutionRaw].
+func ParseResolution(s string) (Resolution, error) {
+	if s == "" {
+		return ResolutionRaw, nil
+	}
+
+	r := Resolution(s)
+	if _, ok := resolutionDurations[r]; !ok {
+		return "", fmt.Errorf("Unknown resolution %q", s)
+	}
+	return r, nil
+}
+
+// The length of each bucket at this resolution. Zero for [ResolutionRaw].
+func (r Resolution) Duration() time.Duration {
+	return resolutionDurations[r]
+}
+
+// Describes which readings to fetch from the DB.
+type ReadingsQuery struct {
+	// Only include readings from this kind of meter. Electricity import
+	// if zero.
+	Meter octopus.Meter
+	// Only include readings from the device with this ID. If empty, the
+	// readings of every device of the kind above are included, and summed
+	// when bucketed.
+	MeterId string
+	// Only include readings at or after this time. Unbounded if zero.
+	From time.Time
+	// Only include readings before this time. Unbounded if zero.
+	To time.Time
+	// Pagination cursor: only include results strictly after this time.
+	// Pass the timestamp of the last result of the previous page.
+	After time.Time
+	// With After, the meter ID of the last raw reading of the previous
+	// page. Readings from several meters at the same time are ordered by
+	// meter ID, so the page continues with the readings at After from
+	// later meters. Ignored when bucketing.
+	AfterMeterId string
+	// The maximum number of results to return. Unlimited if zero.
+	Limit int
+	// The bucket size to aggregate readings into.
+	Resolution Resolution
+}
+
+// Returns the [from, to) timestamp bounds of the query's window, ignoring
+// the cursor.
+func (q ReadingsQuery) window() (string, string) {
+	from := minTimestamp
+	to := maxTimestamp
+
+	if !q.From.IsZero() {
+		from = formatTimestamp(q.From)
+	}
+	if !q.To.IsZero() {
+		to = formatTimestamp(q.To)
+	}
+
+	return from, to
+}
+
+// Returns the [from, to) timestamp bounds of the buckets to fetch, starting
+// after the bucket containing the cursor.
+func (q ReadingsQuery) bounds() (string, string) {
+	from, to := q.window()
+
+	if !q.After.IsZero() {
+		// Skip past the bucket containing the cursor
+		after := q.After.Truncate(q.Resolution.Duration()).Add(q.Resolution.Duration())
+		if formatted := formatTimestamp(after); formatted > from {
+			from = formatted
+		}
+	}
+
+	return from, to
+}
+
+// Returns the timestamp and meter ID of the cursor that raw readings must
+// come strictly after, or empty strings if there is no cursor.
+func (q ReadingsQuery) cursor() (string, string) {
+	if q.After.IsZero() {
+		return "", ""
+	}
+	return formatTimestamp(q.After), q.AfterMeterId
+}
+
+// The meter to fetch readings from.
+func (q ReadingsQuery) meter() octopus.Meter {
+	return q.Meter.OrDefault()
+}
+
+// The SQLite LIMIT to use; a negative limit means no limit.
+func (q ReadingsQuery) limit() int {
+	if q.Limit <= 0 {
+		return -1
+	}
+	return q.Limit
+}
+
+// A summary of the readings within a time bucket. When several meters are
+// summed, each figure is the sum of the meters' figures.
+type ReadingBucket struct {
+	// The start of the bucket (inclusive).
+	Start time.Time
+	// The end of the bucket (exclusive).
+	End time.Time
+	// The number of readings in the bucket.
+	Count int
+	// The lowest demand in the bucket, in W.
+	MinDemand int
+	// The mean demand in the bucket, in W.
+	AvgDemand float64
+	// The highest demand in the bucket, in W.
+	MaxDemand int
+	// The meter's total consumption at the end of the bucket, in Wh.
+	TotalConsumption int
+	// The energy consumed during the bucket, in Wh. This is the increase in
+	// the meter's total consumption since the previous reading.
+	Consumption int
+}
+
+// Returns the individual readings matching the query, oldest first. The
+// query's resolution is ignored. Readings from different devices at the
+// same time are returned separately, ordered by meter ID.
+func (s *Store) Readings(q ReadingsQuery) ([]*octopus.ConsumptionReading, error) {
+	var readings []*octopus.ConsumptionReading
+
+	from, to := q.window()
+	afterTimestamp, afterMeterId := q.cursor()
+
+	rows, err := s.db.Query(`
+		SELECT fuel, direction, meter_id, timestamp, total_consumption, demand
+		FROM readings
+		WHERE fuel = ?1 AND direction = ?2 AND (?3 = '' OR meter_id = ?3)
+		AND timestamp >= ?4 AND timestamp < ?5
+		AND (?7 = '' OR timestamp > ?7 OR (timestamp = ?7 AND meter_id > ?8))
+		ORDER BY timestamp, meter_id
+		LIMIT ?6
+	`, q.meter().Fuel, q.meter().Direction, q.MeterId, from, to, q.limit(), afterTimestamp, afterMeterId)
+	if err != nil {
+		return nil, fmt.Errorf("Readings: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r reading
+
+		err = rows.Scan(&r.fuel, &r.direction, &r.meterId, &r.timestamp, &r.totalConsumption, &r.demand)
+		if err != nil {
+			return nil, fmt.Errorf("Readings: %v", err)
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, r.timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("Readings: %v", err)
+		}
+
+		readings = append(readings, &octopus.ConsumptionReading{
+			Timestamp:        timestamp,
+			Fuel:             octopus.Fuel(r.fuel),
+			Direction:        octopus.Direction(r.direction),
+			MeterId:          r.meterId,
+			TotalConsumption: r.totalConsumption,
+			Demand:           r.demand,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Readings: %v", err)
+	}
+
+	return readings, nil
+}
+
+// Returns the readings matching the query aggregated into buckets of the
+// query's resolution, oldest first. Buckets with no readings are omitted.
+// Buckets are aligned to the Unix epoch, so daily buckets are UTC days.
+func (s *Store) ReadingBuckets(q ReadingsQuery) ([]*ReadingBucket, error) {
+	bucketSize := q.Resolution.Duration()
+	if bucketSize == 0 {
+		return nil, fmt.Errorf("ReadingBuckets: resolution %q cannot be bucketed", q.Resolution)
+	}
+	bucketSeconds := int64(bucketSize.Seconds())
+
+	from, to := q.bounds()
+
+	// Include the last reading of each meter before the window so that the
+	// consumption of the first bucket can be calculated. It is looked up by
+	// primary key for each meter with readings in the window, so that the
+	// window itself is a range search. Each meter is bucketed separately,
+	// then the meters are summed.
+	rows, err := s.db.Query(`
+		WITH in_window AS (
+			SELECT meter_id, timestamp, total_consumption, demand
+			FROM readings
+			WHERE fuel = ?5 AND direction = ?6 AND (?7 = '' OR meter_id = ?7)
+			AND timestamp >= ?1 AND timestamp < ?2
+		),
+		windowed AS (
+			SELECT * FROM in_window
+			UNION ALL
+			SELECT r.meter_id, r.timestamp, r.total_consumption, r.demand
+			FROM (SELECT DISTINCT meter_id FROM in_window) AS m
+			JOIN readings AS r
+			ON r.fuel = ?5 AND r.direction = ?6 AND r.meter_id = m.meter_id
+			AND r.timestamp = (
+				SELECT MAX(timestamp) FROM readings
+				WHERE fuel = ?5 AND direction = ?6 AND meter_id = m.meter_id AND timestamp < ?1
+			)
+		),
+		deltas AS (
+			SELECT
+				meter_id,
+				timestamp,
+				total_consumption,
+				demand,
+				total_consumption - LAG(total_consumption) OVER (PARTITION BY meter_id ORDER BY timestamp) AS delta
+			FROM windowed
+		),
+		meter_buckets AS (
+			SELECT
+				(CAST(strftime('%s', timestamp) AS INTEGER) / ?3) * ?3 AS bucket,
+				COUNT(*) AS count,
+				MIN(demand) AS min_demand,
+				AVG(demand) AS avg_demand,
+				MAX(demand) AS max_demand,
+				MAX(total_consumption) AS total_consumption,
+				-- Ignore the meter counter going backwards
+				COALESCE(SUM(MAX(delta, 0)), 0) AS consumption
+			FROM deltas
+			WHERE timestamp >= ?1
+			GROUP BY meter_id, bucket
+		)
+		SELECT
+			bucket,
+			SUM(count),
+			SUM(min_demand),
+			SUM(avg_demand),
+			SUM(max_demand),
+			SUM(total_consumption),
+			SUM(consumption)
+		FROM meter_buckets
+		GROUP BY bucket
+		ORDER BY bucket
+		LIMIT ?4
+	`, from, to, bucketSeconds, q.limit(), q.meter().Fuel, q.meter().Direction, q.MeterId)
+	if err != nil {
+		return nil, fmt.Errorf("ReadingBuckets: %v", err)
+	}
+	defer rows.Close()
+
+	var buckets []*ReadingBucket
+
+	for rows.Next() {
+		var start int64
+		b := ReadingBucket{}
+
+		err = rows.Scan(&start, &b.Count, &b.MinDemand, &b.AvgDemand, &b.MaxDemand, &b.TotalConsumption, &b.Consumption)
+		if err != nil {
+			return nil, fmt.Errorf("ReadingBuckets: %v", err)
+		}
+
+		b.Start = time.Unix(start, 0).UTC()
+		b.End = b.Start.Add(bucketSize)
+
+		buckets = append(buckets, &b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ReadingBuckets: %v", err)
+	}
+
+	return buckets, nil
+}
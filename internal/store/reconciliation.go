@@ -0,0 +1,121 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// How a meter's telemetry compares with its settled consumption over one
+// half hour.
+type ReconciledSlot struct {
+	// The device ID of the meter.
+	MeterId string
+	Start   time.Time
+	End     time.Time
+	// The number of telemetry readings in the half hour. Zero if the
+	// telemetry feed dropped the half hour entirely.
+	Readings int
+	// The energy measured by the telemetry, in Wh.
+	Telemetry int
+	// The energy settled for billing, in Wh.
+	Settled int
+	// Telemetry minus settled, in Wh.
+	Difference int
+	// The difference as a percentage of the settled energy. Zero if nothing
+	// was settled.
+	Drift float64
+	// Whether the difference is too big to put down to rounding and the
+	// timing of readings.
+	Flagged bool
+}
+
+// Saves the given reconciled slots, replacing any earlier reconciliation of
+// the same meters and half hours.
+func (s *Store) SaveReconciliation(slots []*ReconciledSlot) error {
+	if len(slots) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SaveReconciliation: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO reconciliation (
+			meter_id, slot_start, slot_end, readings, telemetry, settled, difference, drift, flagged
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (meter_id, slot_start) DO UPDATE SET
+			slot_end = excluded.slot_end,
+			readings = excluded.readings,
+			telemetry = excluded.telemetry,
+			settled = excluded.settled,
+			difference = excluded.difference,
+			drift = excluded.drift,
+			flagged = excluded.flagged
+	`)
+	if err != nil {
+		return fmt.Errorf("SaveReconciliation: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, slot := range slots {
+		_, err = stmt.Exec(
+			slot.MeterId, formatTimestamp(slot.Start), formatTimestamp(slot.End),
+			slot.Readings, slot.Telemetry, slot.Settled, slot.Difference, slot.Drift, slot.Flagged)
+		if err != nil {
+			return fmt.Errorf("SaveReconciliation: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("SaveReconciliation: %v", err)
+	}
+	return nil
+}
+
+// Returns the reconciled slots of the given meter that start between from
+// and to, oldest first.
+func (s *Store) Reconciliation(meterId string, from, to time.Time) ([]*ReconciledSlot, error) {
+	rows, err := s.db.Query(`
+		SELECT slot_start, slot_end, readings, telemetry, settled, difference, drift, flagged
+		FROM reconciliation
+		WHERE meter_id = ? AND slot_start >= ? AND slot_start < ?
+		ORDER BY slot_start
+	`, meterId, formatTimestamp(from), formatTimestamp(to))
+	if err != nil {
+		return nil, fmt.Errorf("Reconciliation: %v", err)
+	}
+	defer rows.Close()
+
+	slots := []*ReconciledSlot{}
+
+	for rows.Next() {
+		slot := &ReconciledSlot{MeterId: meterId}
+		var start, end string
+
+		err = rows.Scan(&start, &end, &slot.Readings, &slot.Telemetry, &slot.Settled, &slot.Difference, &slot.Drift, &slot.Flagged)
+		if err != nil {
+			return nil, fmt.Errorf("Reconciliation: %v", err)
+		}
+
+		slot.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("Reconciliation: %v", err)
+		}
+		slot.End, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, fmt.Errorf("Reconciliation: %v", err)
+		}
+
+		slots = append(slots, slot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Reconciliation: %v", err)
+	}
+
+	return slots, nil
+}
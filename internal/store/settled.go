@@ -0,0 +1,128 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"time"
+)
+
+// Saves the given half-hourly settled consumption, replacing any stored
+// consumption of the same meters and intervals. Settled figures can be
+// revised, so the latest fetched are kept.
+func (s *Store) SaveSettledConsumption(intervals []*octopus.ConsumptionInterval) error {
+	if len(intervals) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SaveSettledConsumption: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO settled_consumption (
+			fuel, direction, meter_point, serial_number, interval_start, interval_end, consumption
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (meter_point, serial_number, direction, interval_start) DO UPDATE SET
+			interval_end = excluded.interval_end,
+			consumption = excluded.consumption
+	`)
+	if err != nil {
+		return fmt.Errorf("SaveSettledConsumption: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, i := range intervals {
+		meter := i.Meter.OrDefault()
+		_, err = stmt.Exec(
+			meter.Fuel, meter.Direction, i.MeterPoint, i.SerialNumber,
+			formatTimestamp(i.IntervalStart), formatTimestamp(i.IntervalEnd), i.Consumption)
+		if err != nil {
+			return fmt.Errorf("SaveSettledConsumption: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("SaveSettledConsumption: %v", err)
+	}
+
+	log.Printf("Saved %v settled consumption intervals into DB", len(intervals))
+	return nil
+}
+
+// Returns the stored settled consumption of the given kind of meter that
+// starts between from and to, oldest first. If meterPoint is empty, the
+// consumption of every meter point is returned.
+func (s *Store) SettledConsumption(meter octopus.Meter, meterPoint string, from, to time.Time) ([]*octopus.ConsumptionInterval, error) {
+	meter = meter.OrDefault()
+
+	rows, err := s.db.Query(`
+		SELECT meter_point, serial_number, interval_start, interval_end, consumption
+		FROM settled_consumption
+		WHERE fuel = ?1 AND direction = ?2
+			AND (?3 = '' OR meter_point = ?3)
+			AND interval_start >= ?4 AND interval_start < ?5
+		ORDER BY interval_start, meter_point, serial_number
+	`, meter.Fuel, meter.Direction, meterPoint, formatTimestamp(from), formatTimestamp(to))
+	if err != nil {
+		return nil, fmt.Errorf("SettledConsumption: %v", err)
+	}
+	defer rows.Close()
+
+	intervals := []*octopus.ConsumptionInterval{}
+
+	for rows.Next() {
+		i := &octopus.ConsumptionInterval{Meter: meter}
+		var start, end string
+
+		err = rows.Scan(&i.MeterPoint, &i.SerialNumber, &start, &end, &i.Consumption)
+		if err != nil {
+			return nil, fmt.Errorf("SettledConsumption: %v", err)
+		}
+
+		i.IntervalStart, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("SettledConsumption: %v", err)
+		}
+		i.IntervalEnd, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, fmt.Errorf("SettledConsumption: %v", err)
+		}
+
+		intervals = append(intervals, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("SettledConsumption: %v", err)
+	}
+
+	return intervals, nil
+}
+
+// Returns the end of the latest stored settled interval of the given
+// meter, or the zero time if none are stored.
+func (s *Store) SettledUntil(device octopus.Device) (time.Time, error) {
+	var end sql.NullString
+
+	err := s.db.QueryRow(`
+		SELECT MAX(interval_end)
+		FROM settled_consumption
+		WHERE meter_point = ? AND serial_number = ? AND direction = ?
+	`, device.MeterPoint, device.SerialNumber, device.Meter.OrDefault().Direction).Scan(&end)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SettledUntil: %v", err)
+	}
+
+	if !end.Valid {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, end.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SettledUntil: %v", err)
+	}
+	return t, nil
+}
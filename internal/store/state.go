@@ -0,0 +1,218 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// The scrypt parameters used to derive the encryption key from the
+// passphrase, as recommended for interactive logins in 2017. Deriving a key
+// takes tens of milliseconds, which is only done once per salt.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// The length of the random salt the key is derived with, in bytes.
+const saltSize = 16
+
+// An [octopus.StateCache] that keeps the Octopus credentials and account
+// metadata in the DB. If a passphrase is given, the state is encrypted with
+// AES-GCM using a key derived from it with scrypt and a random salt, which
+// is stored with the state.
+type StateCache struct {
+	store      *Store
+	passphrase string
+
+	lock sync.Mutex
+	// The salt of the stored state, or nil if none has been loaded or
+	// saved yet.
+	salt []byte
+	// The AES-256 key derived from the passphrase and salt.
+	key []byte
+}
+
+// Returns an [octopus.StateCache] backed by this store. If passphrase is
+// empty, the state is stored unencrypted.
+func (s *Store) StateCache(passphrase string) *StateCache {
+	return &StateCache{
+		store:      s,
+		passphrase: passphrase,
+	}
+}
+
+func (c *StateCache) Load() (*octopus.CachedState, error) {
+	var encrypted bool
+	var data, salt []byte
+
+	err := c.store.db.QueryRow(
+		"SELECT encrypted, data, salt FROM octopus_state WHERE id = 1",
+	).Scan(&encrypted, &data, &salt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Load state: %v", err)
+	}
+
+	if encrypted {
+		if c.passphrase == "" {
+			return nil, errors.New("Load state: cached state is encrypted but no passphrase was given")
+		}
+
+		key, err := c.keyFor(salt)
+		if err != nil {
+			return nil, fmt.Errorf("Load state: %v", err)
+		}
+		data, err = decrypt(key, data)
+		if err != nil {
+			return nil, fmt.Errorf("Load state: %v", err)
+		}
+	}
+
+	var state octopus.CachedState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("Load state: %v", err)
+	}
+
+	return &state, nil
+}
+
+func (c *StateCache) Save(state *octopus.CachedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Save state: %v", err)
+	}
+
+	encrypted := c.passphrase != ""
+	var salt []byte
+	if encrypted {
+		salt, err = c.saltForSave()
+		if err != nil {
+			return fmt.Errorf("Save state: %v", err)
+		}
+		key, err := c.keyFor(salt)
+		if err != nil {
+			return fmt.Errorf("Save state: %v", err)
+		}
+		data, err = encrypt(key, data)
+		if err != nil {
+			return fmt.Errorf("Save state: %v", err)
+		}
+	}
+
+	_, err = c.store.db.Exec(`
+		INSERT INTO octopus_state (id, encrypted, data, salt)
+		VALUES (1, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			encrypted = excluded.encrypted,
+			data = excluded.data,
+			salt = excluded.salt
+	`, encrypted, data, salt)
+	if err != nil {
+		return fmt.Errorf("Save state: %v", err)
+	}
+
+	return nil
+}
+
+// Returns the salt to encrypt saved state with: the salt of the key
+// already derived, or a new random one. State encrypted before keys were
+// salted is re-encrypted with a new salt.
+func (c *StateCache) saltForSave() ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.salt != nil {
+		return c.salt, nil
+	}
+
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// Returns the key derived from the passphrase with the given salt,
+// remembering it so that it is only derived once. A nil salt gives the
+// unsalted SHA-256 key that state used to be encrypted with.
+func (c *StateCache) keyFor(salt []byte) ([]byte, error) {
+	if salt == nil {
+		key := sha256.Sum256([]byte(c.passphrase))
+		return key[:], nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.salt != nil && string(c.salt) == string(salt) {
+		return c.key, nil
+	}
+
+	key, err := scrypt.Key([]byte(c.passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	c.salt = salt
+	c.key = key
+	return key, nil
+}
+
+// Encrypts data with AES-GCM. The random nonce is prepended to the result.
+func encrypt(key []byte, data []byte) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decrypts data produced by [encrypt].
+func decrypt(key []byte, data []byte) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted state is too short")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt state; is the passphrase correct?")
+	}
+
+	return plaintext, nil
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
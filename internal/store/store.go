@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -23,35 +25,58 @@ type Store struct {
 }
 
 type reading struct {
+	fuel             string
+	direction        string
+	meterId          string
 	timestamp        string
 	totalConsumption int
 	demand           int
 }
 
 func NewStore() *Store {
-	db, err := sql.Open("sqlite3", dbPath)
+	s, err := Open(dbPath, migrationsPath)
 	if err != nil {
-		log.Fatal("NewStore: Error connecting to DB: ", err)
+		log.Fatal("NewStore: ", err)
 	}
 
-	err = runMigrations(db)
+	return s
+}
+
+// Opens the SQLite database at the given path, running any migrations from
+// the given directory.
+func Open(path string, migrations string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		log.Fatal("NewStore: ", err)
+		return nil, fmt.Errorf("Error connecting to DB: %v", err)
+	}
+
+	err = runMigrations(db, migrations)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// The DB holds API credentials, so only the owner should be able to
+	// read it
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to set DB permissions: %v", err)
 	}
 
 	return &Store{
 		db: db,
-	}
+	}, nil
 }
 
-func runMigrations(db *sql.DB) error {
+func runMigrations(db *sql.DB, migrations string) error {
 	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
 	if err != nil {
 		return fmt.Errorf("migrate: %v", err)
 	}
 
 	m, err := migrate.NewWithDatabaseInstance(
-		"file://"+migrationsPath,
+		"file://"+migrations,
 		"sqlite3",
 		driver)
 	if err != nil {
@@ -68,30 +93,66 @@ func runMigrations(db *sql.DB) error {
 	return nil
 }
 
-func (s *Store) InsertReadings(rs []*octopus.ConsumptionReading) error {
-	insertStmt := `
-		INSERT INTO readings (timestamp, total_consumption, demand)
-		VALUES 
-	`
-	values := []any{}
+// The most readings inserted by one statement. Each reading takes six bound
+// parameters, so this stays well under SQLite's limit on the number of
+// variables in a statement.
+const insertBatchSize = 500
 
-	for _, reading := range rs {
-		insertStmt += "(?, ?, ?),"
-		values = append(
-			values,
-			reading.Timestamp.Format(time.RFC3339),
-			reading.TotalConsumption,
-			reading.Demand)
+// Inserts the given readings into the DB. Readings from a meter at a
+// timestamp that is already stored are ignored, so it is safe to insert the
+// same reading more than once. Readings without a fuel or direction are
+// stored as from an electricity import meter. The readings are inserted in
+// batches within one transaction, so either all of them are stored or none.
+func (s *Store) InsertReadings(rs []*octopus.ConsumptionReading) error {
+	if len(rs) == 0 {
+		return nil
 	}
 
-	insertStmt = strings.TrimSuffix(insertStmt, ",")
-
-	res, err := s.db.Exec(insertStmt, values...)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("InsertReadings: %v", err)
 	}
+	defer tx.Rollback()
+
+	var rowCount int64
+
+	for batch := range slices.Chunk(rs, insertBatchSize) {
+		insertStmt := `
+			INSERT INTO readings (fuel, direction, meter_id, timestamp, total_consumption, demand)
+			VALUES 
+		`
+		values := []any{}
+
+		for _, reading := range batch {
+			meter := reading.Meter()
+
+			insertStmt += "(?, ?, ?, ?, ?, ?),"
+			values = append(
+				values,
+				meter.Fuel,
+				meter.Direction,
+				reading.MeterId,
+				formatTimestamp(reading.Timestamp),
+				reading.TotalConsumption,
+				reading.Demand)
+		}
+
+		insertStmt = strings.TrimSuffix(insertStmt, ",")
+		insertStmt += " ON CONFLICT (fuel, direction, meter_id, timestamp) DO NOTHING"
+
+		res, err := tx.Exec(insertStmt, values...)
+		if err != nil {
+			return fmt.Errorf("InsertReadings: %v", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("InsertReadings: %v", err)
+		}
+		rowCount += n
+	}
 
-	rowCount, err := res.RowsAffected()
+	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("InsertReadings: %v", err)
 	}
@@ -100,41 +161,49 @@ func (s *Store) InsertReadings(rs []*octopus.ConsumptionReading) error {
 	return nil
 }
 
-// TODO: since timestamp
-func (s *Store) Readings() ([]*octopus.ConsumptionReading, error) {
-	var readings []*octopus.ConsumptionReading
-
-	rows, err := s.db.Query("SELECT * FROM readings")
+// Labels the readings that were stored before readings had meter IDs as
+// being from the given device, which should be the meter of its kind that
+// was tracked before. Unlabelled readings at times that the device already
+// has a reading for are dropped.
+func (s *Store) ClaimUnlabelledReadings(device octopus.Device) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("Readings: %v", err)
+		return fmt.Errorf("ClaimUnlabelledReadings: %v", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var r reading
+	defer tx.Rollback()
 
-		err = rows.Scan(&r.timestamp, &r.totalConsumption, &r.demand)
-		if err != nil {
-			return nil, fmt.Errorf("Readings: %v", err)
-		}
+	res, err := tx.Exec(`
+		UPDATE OR IGNORE readings SET meter_id = ?
+		WHERE meter_id = '' AND fuel = ? AND direction = ?
+	`, device.Id, device.Meter.Fuel, device.Meter.Direction)
+	if err != nil {
+		return fmt.Errorf("ClaimUnlabelledReadings: %v", err)
+	}
 
-		timestamp, err := time.Parse(time.RFC3339, r.timestamp)
-		if err != nil {
-			return nil, fmt.Errorf("Readings: %v", err)
-		}
+	_, err = tx.Exec(`
+		DELETE FROM readings
+		WHERE meter_id = '' AND fuel = ? AND direction = ?
+	`, device.Meter.Fuel, device.Meter.Direction)
+	if err != nil {
+		return fmt.Errorf("ClaimUnlabelledReadings: %v", err)
+	}
 
-		readings = append(readings, &octopus.ConsumptionReading{
-			Timestamp:        timestamp,
-			TotalConsumption: r.totalConsumption,
-			Demand:           r.demand,
-		})
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("ClaimUnlabelledReadings: %v", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("Reading: %v", err)
+	if rowCount, err := res.RowsAffected(); err == nil && rowCount > 0 {
+		log.Printf("Labelled %v existing %v readings as from meter %v", rowCount, device.Meter, device.Id)
 	}
+	return nil
+}
 
-	return readings, nil
+// Formats a timestamp for storage. Timestamps are always stored in UTC so
+// that the same instant always has the same primary key, and so that they
+// sort correctly as text.
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
 }
 
 func (s *Store) Close() {
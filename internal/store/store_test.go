@@ -0,0 +1,856 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Opens a fresh store in a temporary directory.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := Open(filepath.Join(t.TempDir(), "test.sqlite"), "../../migrations")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Inserts one reading every 10 seconds for the given duration, using 1Wh
+// per reading and a demand that cycles between 100W and 400W.
+func insertTestReadings(t *testing.T, s *Store, d time.Duration) {
+	t.Helper()
+
+	rs := []*octopus.ConsumptionReading{}
+	for i := range int(d / (10 * time.Second)) {
+		rs = append(rs, &octopus.ConsumptionReading{
+			Timestamp:        testStart.Add(time.Duration(i) * 10 * time.Second),
+			TotalConsumption: i,
+			Demand:           100 * (i%4 + 1),
+		})
+	}
+
+	err := s.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+}
+
+func TestInsertReadingsIsIdempotent(t *testing.T) {
+	s := newTestStore(t)
+
+	insertTestReadings(t, s, time.Minute)
+	insertTestReadings(t, s, time.Minute)
+
+	rs, err := s.Readings(ReadingsQuery{})
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(rs) != 6 {
+		t.Errorf("Expected 6 readings, got %v", len(rs))
+	}
+}
+
+func TestInsertManyReadings(t *testing.T) {
+	s := newTestStore(t)
+
+	// More readings than fit in one statement at six variables each, as
+	// the recorder may flush after a long outage
+	insertTestReadings(t, s, 10000*10*time.Second)
+
+	rs, err := s.Readings(ReadingsQuery{})
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(rs) != 10000 {
+		t.Errorf("Expected 10000 readings, got %v", len(rs))
+	}
+}
+
+func TestReadingsAreSeparatedByFuel(t *testing.T) {
+	s := newTestStore(t)
+
+	insertTestReadings(t, s, time.Minute)
+
+	// A gas reading at the same time as an electricity reading
+	err := s.InsertReadings([]*octopus.ConsumptionReading{{
+		Timestamp:        testStart,
+		Fuel:             octopus.FuelGas,
+		TotalConsumption: 50000,
+		Demand:           2000,
+	}})
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	gas, err := s.Readings(ReadingsQuery{Meter: octopus.GasImport})
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(gas) != 1 || gas[0].Fuel != octopus.FuelGas || gas[0].TotalConsumption != 50000 {
+		t.Errorf("Gas readings = %v, want the one gas reading", gas)
+	}
+
+	electricity, err := s.Readings(ReadingsQuery{})
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(electricity) != 6 || electricity[0].Fuel != octopus.FuelElectricity {
+		t.Errorf("Expected 6 electricity readings, got %v", electricity)
+	}
+}
+
+func TestReadingsWindowAndPagination(t *testing.T) {
+	s := newTestStore(t)
+	insertTestReadings(t, s, 10*time.Minute)
+
+	q := ReadingsQuery{
+		From:  testStart.Add(time.Minute),
+		To:    testStart.Add(2 * time.Minute),
+		Limit: 4,
+	}
+
+	page, err := s.Readings(q)
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(page) != 4 {
+		t.Fatalf("Expected 4 readings in first page, got %v", len(page))
+	}
+	if !page[0].Timestamp.Equal(q.From) {
+		t.Errorf("Expected first reading at %v, got %v", q.From, page[0].Timestamp)
+	}
+
+	q.After = page[len(page)-1].Timestamp
+	page, err = s.Readings(q)
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(page) != 2 {
+		t.Errorf("Expected 2 readings in second page, got %v", len(page))
+	}
+}
+
+func TestReadingsPaginationAcrossMeters(t *testing.T) {
+	s := newTestStore(t)
+
+	// Two meters with readings at the same times
+	rs := []*octopus.ConsumptionReading{}
+	for i := range 3 {
+		timestamp := testStart.Add(time.Duration(i) * 10 * time.Second)
+		rs = append(rs,
+			&octopus.ConsumptionReading{Timestamp: timestamp, MeterId: "first", TotalConsumption: i},
+			&octopus.ConsumptionReading{Timestamp: timestamp, MeterId: "second", TotalConsumption: i},
+		)
+	}
+	err := s.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	// Each page ends partway through a timestamp
+	q := ReadingsQuery{Limit: 3}
+	got := []*octopus.ConsumptionReading{}
+	for range 3 {
+		page, err := s.Readings(q)
+		if err != nil {
+			t.Fatalf("Readings: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		got = append(got, page...)
+
+		last := page[len(page)-1]
+		q.After = last.Timestamp
+		q.AfterMeterId = last.MeterId
+	}
+
+	if len(got) != 6 {
+		t.Fatalf("Paged through %v readings, want 6", len(got))
+	}
+	for i, r := range got {
+		meterId := []string{"first", "second"}[i%2]
+		if !r.Timestamp.Equal(testStart.Add(time.Duration(i/2)*10*time.Second)) || r.MeterId != meterId {
+			t.Errorf("Reading %v = %v from %v, want the next in order", i, r.Timestamp, r.MeterId)
+		}
+	}
+}
+
+func TestReadingBuckets(t *testing.T) {
+	s := newTestStore(t)
+	insertTestReadings(t, s, 10*time.Minute)
+
+	buckets, err := s.ReadingBuckets(ReadingsQuery{
+		From:       testStart.Add(5 * time.Minute),
+		Resolution: ResolutionFiveMinute,
+	})
+	if err != nil {
+		t.Fatalf("ReadingBuckets: %v", err)
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("Expected 1 bucket, got %v", len(buckets))
+	}
+
+	b := buckets[0]
+	if b.Count != 30 {
+		t.Errorf("Count = %v, want 30", b.Count)
+	}
+	if b.MinDemand != 100 || b.MaxDemand != 400 {
+		t.Errorf("Demand range = %v-%v, want 100-400", b.MinDemand, b.MaxDemand)
+	}
+	// Includes the consumption since the last reading of the previous bucket
+	if b.Consumption != 30 {
+		t.Errorf("Consumption = %v, want 30", b.Consumption)
+	}
+}
+
+func TestReadingBucketsSumsMeters(t *testing.T) {
+	s := newTestStore(t)
+
+	// Two meters, one using 1Wh and the other 2Wh every 10 seconds
+	rs := []*octopus.ConsumptionReading{}
+	for i := range 60 {
+		timestamp := testStart.Add(time.Duration(i) * 10 * time.Second)
+		rs = append(rs,
+			&octopus.ConsumptionReading{Timestamp: timestamp, MeterId: "first", TotalConsumption: i, Demand: 360},
+			&octopus.ConsumptionReading{Timestamp: timestamp.Add(time.Second), MeterId: "second", TotalConsumption: 1000 + 2*i, Demand: 720},
+		)
+	}
+	err := s.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	q := ReadingsQuery{
+		From:       testStart.Add(5 * time.Minute),
+		Resolution: ResolutionFiveMinute,
+	}
+
+	buckets, err := s.ReadingBuckets(q)
+	if err != nil {
+		t.Fatalf("ReadingBuckets: %v", err)
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("Expected 1 bucket, got %v", len(buckets))
+	}
+	if b := buckets[0]; b.Count != 60 || b.Consumption != 90 || b.AvgDemand != 1080 {
+		t.Errorf("Summed bucket = %+v, want 60 readings, 90Wh and 1080W", b)
+	}
+
+	q.MeterId = "second"
+	buckets, err = s.ReadingBuckets(q)
+	if err != nil {
+		t.Fatalf("ReadingBuckets: %v", err)
+	}
+	if len(buckets) != 1 || buckets[0].Consumption != 60 || buckets[0].AvgDemand != 720 {
+		t.Errorf("Second meter buckets = %+v, want 60Wh at 720W", buckets)
+	}
+
+	readings, err := s.Readings(ReadingsQuery{MeterId: "first"})
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(readings) != 60 || readings[0].MeterId != "first" {
+		t.Errorf("Expected 60 readings from the first meter, got %v", len(readings))
+	}
+}
+
+func TestClaimUnlabelledReadings(t *testing.T) {
+	s := newTestStore(t)
+	insertTestReadings(t, s, time.Minute)
+
+	// The device has already stored the last reading itself
+	err := s.InsertReadings([]*octopus.ConsumptionReading{{
+		Timestamp:        testStart.Add(50 * time.Second),
+		MeterId:          "device",
+		TotalConsumption: 5,
+		Demand:           200,
+	}})
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+
+	err = s.ClaimUnlabelledReadings(octopus.Device{Id: "device", Meter: octopus.ElectricityImport})
+	if err != nil {
+		t.Fatalf("ClaimUnlabelledReadings: %v", err)
+	}
+
+	all, err := s.Readings(ReadingsQuery{})
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+	if len(all) != 6 {
+		t.Fatalf("Expected 6 readings, got %v", len(all))
+	}
+	for _, r := range all {
+		if r.MeterId != "device" {
+			t.Errorf("Reading at %v has meter ID %q, want \"device\"", r.Timestamp, r.MeterId)
+		}
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	r, err := ParseResolution("30m")
+	if err != nil || r.Duration() != 30*time.Minute {
+		t.Errorf("ParseResolution(\"30m\") = %v, %v", r, err)
+	}
+
+	_, err = ParseResolution("2h")
+	if err == nil {
+		t.Errorf("Expected error for unknown resolution")
+	}
+}
+
+func TestGaps(t *testing.T) {
+	s := newTestStore(t)
+	insertTestReadings(t, s, 10*time.Minute)
+
+	// Remove two minutes of readings from the middle
+	_, err := s.db.Exec(
+		"DELETE FROM readings WHERE timestamp > ? AND timestamp < ?",
+		formatTimestamp(testStart.Add(3*time.Minute)),
+		formatTimestamp(testStart.Add(5*time.Minute)),
+	)
+	if err != nil {
+		t.Fatalf("Delete readings: %v", err)
+	}
+
+	gaps, err := s.Gaps(octopus.Device{Meter: octopus.ElectricityImport}, testStart.Add(-time.Hour), testStart.Add(20*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("Gaps: %v", err)
+	}
+
+	expected := []Gap{
+		{From: testStart.Add(-time.Hour), To: testStart},
+		{From: testStart.Add(3 * time.Minute), To: testStart.Add(5 * time.Minute)},
+		{From: testStart.Add(10*time.Minute - 10*time.Second), To: testStart.Add(20 * time.Minute)},
+	}
+
+	if len(gaps) != len(expected) {
+		t.Fatalf("Gaps() = %v, want %v", gaps, expected)
+	}
+	for i := range expected {
+		if !gaps[i].From.Equal(expected[i].From) || !gaps[i].To.Equal(expected[i].To) {
+			t.Errorf("Gap %v = %v, want %v", i, gaps[i], expected[i])
+		}
+	}
+}
+
+func TestMigrateOffsetTimestamps(t *testing.T) {
+	// The migrations from before timestamps were normalised to UTC
+	migrations := t.TempDir()
+	entries, err := os.ReadDir("../../migrations")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, e := range entries {
+		var version int
+		fmt.Sscanf(e.Name(), "%d_", &version)
+		if version >= 14 {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("../../migrations", e.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		err = os.WriteFile(filepath.Join(migrations, e.Name()), data, 0o644)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	s, err := Open(path, migrations)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Readings with the offset the API gave them, and one that was fetched
+	// again by a backfill after timestamps were normalised
+	for _, ts := range []string{
+		"2025-06-01T12:30:00+01:00",
+		"2025-06-01T13:00:00+01:00",
+		"2025-06-01T13:30:00+01:00",
+		"2025-06-01T12:00:00Z",
+	} {
+		_, err = s.db.Exec(`
+			INSERT INTO readings (fuel, direction, meter_id, timestamp, total_consumption, demand)
+			VALUES ('electricity', 'import', 'meter', ?, 0, 0)
+		`, ts)
+		if err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	s.Close()
+
+	s, err = Open(path, "../../migrations")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	readings, err := s.Readings(ReadingsQuery{
+		From: time.Date(2025, 6, 1, 11, 15, 0, 0, time.UTC),
+		To:   time.Date(2025, 6, 1, 12, 15, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Readings: %v", err)
+	}
+
+	expected := []time.Time{
+		time.Date(2025, 6, 1, 11, 30, 0, 0, time.UTC),
+		time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if len(readings) != len(expected) {
+		t.Fatalf("Readings() returned %v readings, want %v", len(readings), len(expected))
+	}
+	for i := range expected {
+		if !readings[i].Timestamp.Equal(expected[i]) {
+			t.Errorf("Reading %v at %v, want %v", i, readings[i].Timestamp, expected[i])
+		}
+	}
+}
+
+func TestStateCache(t *testing.T) {
+	s := newTestStore(t)
+
+	state := &octopus.CachedState{
+		Token:          "token",
+		RefreshToken:   "refresh",
+		AccountNumbers: []string{"A-12345678"},
+		Devices: []octopus.Device{{
+			Id:            "device",
+			AccountNumber: "A-12345678",
+			Meter:         octopus.ElectricityImport,
+		}},
+	}
+
+	for _, passphrase := range []string{"", "hunter2"} {
+		cache := s.StateCache(passphrase)
+
+		err := cache.Save(state)
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		loaded, err := cache.Load()
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if !reflect.DeepEqual(loaded, state) {
+			t.Errorf("Load() = %+v, want %+v", loaded, state)
+		}
+	}
+
+	// The state is now encrypted, so a different passphrase can't read it
+	_, err := s.StateCache("wrong").Load()
+	if err == nil {
+		t.Errorf("Expected an error loading with the wrong passphrase")
+	}
+	_, err = s.StateCache("").Load()
+	if err == nil {
+		t.Errorf("Expected an error loading encrypted state without a passphrase")
+	}
+}
+
+func TestStateCacheUnsaltedKey(t *testing.T) {
+	s := newTestStore(t)
+
+	// State encrypted before keys were derived with a salt
+	state := &octopus.CachedState{Token: "token"}
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	key := sha256.Sum256([]byte("hunter2"))
+	data, err = encrypt(key[:], data)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	_, err = s.db.Exec("INSERT INTO octopus_state (id, encrypted, data) VALUES (1, TRUE, ?)", data)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	cache := s.StateCache("hunter2")
+	loaded, err := cache.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("Load() = %+v, want %+v", loaded, state)
+	}
+
+	// Saving it again salts the key
+	err = cache.Save(state)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	var salt []byte
+	err = s.db.QueryRow("SELECT salt FROM octopus_state").Scan(&salt)
+	if err != nil || len(salt) != saltSize {
+		t.Errorf("Salt = %x, %v, want %v random bytes", salt, err, saltSize)
+	}
+
+	loaded, err = s.StateCache("hunter2").Load()
+	if err != nil || !reflect.DeepEqual(loaded, state) {
+		t.Errorf("Load() = %+v, %v, want %+v", loaded, err, state)
+	}
+}
+
+func TestAgreements(t *testing.T) {
+	s := newTestStore(t)
+
+	switchedAt := testStart.AddDate(0, 1, 0)
+	flexible := &octopus.Agreement{
+		Id:             1,
+		AccountNumber:  "A-12345678",
+		Fuel:           octopus.FuelElectricity,
+		MeterPoint:     "1000000000001",
+		ValidFrom:      testStart,
+		ValidTo:        &switchedAt,
+		TariffCode:     "E-1R-VAR-22-11-01-C",
+		ProductCode:    "VAR-22-11-01",
+		DisplayName:    "Flexible Octopus",
+		StandingCharge: 47.85,
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandStandard, ValidFrom: testStart, ValidTo: &switchedAt, Value: 24.5},
+		},
+	}
+	slotEnd := switchedAt.Add(30 * time.Minute)
+	agile := &octopus.Agreement{
+		Id:            2,
+		AccountNumber: "A-12345678",
+		Fuel:          octopus.FuelElectricity,
+		MeterPoint:    "1000000000001",
+		ValidFrom:     switchedAt,
+		TariffCode:    "E-1R-AGILE-24-10-01-C",
+		UnitRates: []octopus.Rate{
+			{Band: octopus.BandStandard, ValidFrom: switchedAt, ValidTo: &slotEnd, Value: 21},
+		},
+	}
+	gas := &octopus.Agreement{
+		Id:         3,
+		Fuel:       octopus.FuelGas,
+		MeterPoint: "1000000001",
+		ValidFrom:  testStart,
+		UnitRates:  []octopus.Rate{},
+	}
+
+	err := s.SaveAgreements([]*octopus.Agreement{agile, flexible, gas})
+	if err != nil {
+		t.Fatalf("SaveAgreements: %v", err)
+	}
+
+	// Later rates are added to the stored ones
+	nextSlotEnd := slotEnd.Add(30 * time.Minute)
+	agile.UnitRates = []octopus.Rate{
+		{Band: octopus.BandStandard, ValidFrom: slotEnd, ValidTo: &nextSlotEnd, Value: 30},
+	}
+	err = s.SaveAgreements([]*octopus.Agreement{agile})
+	if err != nil {
+		t.Fatalf("SaveAgreements: %v", err)
+	}
+
+	agreements, err := s.Agreements("1000000000001")
+	if err != nil {
+		t.Fatalf("Agreements: %v", err)
+	}
+	if len(agreements) != 2 {
+		t.Fatalf("Agreements() returned %v agreements, want 2", len(agreements))
+	}
+	if !reflect.DeepEqual(agreements[0], flexible) {
+		t.Errorf("Agreements()[0] = %+v, want %+v", agreements[0], flexible)
+	}
+	if agreements[1].ValidTo != nil {
+		t.Errorf("ValidTo = %v, want nil", agreements[1].ValidTo)
+	}
+	if len(agreements[1].UnitRates) != 2 || agreements[1].UnitRates[1].Value != 30 {
+		t.Errorf("Agile rates = %+v, want both slots", agreements[1].UnitRates)
+	}
+
+	all, err := s.Agreements("")
+	if err != nil {
+		t.Fatalf("Agreements: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Agreements(\"\") returned %v agreements, want 3", len(all))
+	}
+}
+
+func TestSettledConsumption(t *testing.T) {
+	s := newTestStore(t)
+
+	device := octopus.Device{
+		Meter:        octopus.ElectricityImport,
+		MeterPoint:   "1000000000001",
+		SerialNumber: "21E0000001",
+	}
+
+	until, err := s.SettledUntil(device)
+	if err != nil || !until.IsZero() {
+		t.Fatalf("SettledUntil() with nothing stored = %v, %v, want zero", until, err)
+	}
+
+	interval := func(meter octopus.Meter, i int, consumption int) *octopus.ConsumptionInterval {
+		start := testStart.Add(time.Duration(i) * 30 * time.Minute)
+		return &octopus.ConsumptionInterval{
+			Meter:         meter,
+			MeterPoint:    device.MeterPoint,
+			SerialNumber:  device.SerialNumber,
+			IntervalStart: start,
+			IntervalEnd:   start.Add(30 * time.Minute),
+			Consumption:   consumption,
+		}
+	}
+
+	err = s.SaveSettledConsumption([]*octopus.ConsumptionInterval{
+		interval(octopus.ElectricityImport, 0, 100),
+		interval(octopus.ElectricityImport, 1, 200),
+		interval(octopus.ElectricityExport, 1, 50),
+	})
+	if err != nil {
+		t.Fatalf("SaveSettledConsumption: %v", err)
+	}
+
+	// Revised figures replace the stored ones
+	err = s.SaveSettledConsumption([]*octopus.ConsumptionInterval{
+		interval(octopus.ElectricityImport, 1, 250),
+		interval(octopus.ElectricityImport, 2, 300),
+	})
+	if err != nil {
+		t.Fatalf("SaveSettledConsumption: %v", err)
+	}
+
+	intervals, err := s.SettledConsumption(octopus.ElectricityImport, "", testStart.Add(30*time.Minute), testStart.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("SettledConsumption: %v", err)
+	}
+	if len(intervals) != 2 || intervals[0].Consumption != 250 || intervals[1].Consumption != 300 {
+		t.Errorf("SettledConsumption() = %+v, want the revised 250Wh and 300Wh", intervals)
+	}
+	if !reflect.DeepEqual(intervals[1], interval(octopus.ElectricityImport, 2, 300)) {
+		t.Errorf("SettledConsumption()[1] = %+v, want %+v", intervals[1], interval(octopus.ElectricityImport, 2, 300))
+	}
+
+	export, err := s.SettledConsumption(octopus.ElectricityExport, device.MeterPoint, testStart, testStart.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("SettledConsumption: %v", err)
+	}
+	if len(export) != 1 || export[0].Consumption != 50 {
+		t.Errorf("Export SettledConsumption() = %+v, want 50Wh", export)
+	}
+
+	until, err = s.SettledUntil(device)
+	if err != nil || !until.Equal(testStart.Add(90*time.Minute)) {
+		t.Errorf("SettledUntil() = %v, %v, want 01:30", until, err)
+	}
+}
+
+func TestBilling(t *testing.T) {
+	s := newTestStore(t)
+
+	latest, err := s.LatestBalance("A-12345678")
+	if err != nil || latest != nil {
+		t.Fatalf("LatestBalance() with nothing stored = %v, %v, want nil", latest, err)
+	}
+
+	closingBalance := 1500
+	billing := &octopus.Billing{
+		AccountNumber: "A-12345678",
+		Balance:       1500,
+		Bills: []*octopus.Bill{
+			{
+				Id:             "2",
+				Type:           octopus.BillStatement,
+				IssuedAt:       testStart.AddDate(0, 1, 2),
+				From:           testStart,
+				To:             testStart.AddDate(0, 1, 0),
+				ClosingBalance: &closingBalance,
+				Charges:        9500,
+				Credits:        8000,
+			},
+			{Id: "1", Type: octopus.BillInvoice, IssuedAt: testStart, From: testStart, To: testStart.AddDate(0, 0, 1)},
+		},
+		Payments: []*octopus.Payment{
+			{Id: "10", Date: testStart.AddDate(0, 0, 14), Amount: 8000, Status: octopus.PaymentPending},
+		},
+	}
+
+	err = s.SaveBilling(billing, testStart.AddDate(0, 1, 3))
+	if err != nil {
+		t.Fatalf("SaveBilling: %v", err)
+	}
+
+	// The payment clears and the balance changes
+	billing.Balance = 1000
+	billing.Payments[0].Status = octopus.PaymentSucceeded
+	err = s.SaveBilling(billing, testStart.AddDate(0, 1, 4))
+	if err != nil {
+		t.Fatalf("SaveBilling: %v", err)
+	}
+
+	bills, err := s.Bills("A-12345678")
+	if err != nil {
+		t.Fatalf("Bills: %v", err)
+	}
+	if len(bills) != 2 || bills[0].Id != "2" || bills[1].ClosingBalance != nil {
+		t.Fatalf("Bills() = %+v, want the statement then the invoice", bills)
+	}
+	if *bills[0].ClosingBalance != 1500 || !bills[0].To.Equal(testStart.AddDate(0, 1, 0)) {
+		t.Errorf("Bills()[0] = %+v, want the stored statement", bills[0])
+	}
+
+	payments, err := s.Payments("A-12345678")
+	if err != nil {
+		t.Fatalf("Payments: %v", err)
+	}
+	if len(payments) != 1 || payments[0].Status != octopus.PaymentSucceeded {
+		t.Errorf("Payments() = %+v, want the one payment, succeeded", payments)
+	}
+
+	balances, err := s.Balances("A-12345678", testStart, testStart.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatalf("Balances: %v", err)
+	}
+	if len(balances) != 2 || balances[0].Balance != 1500 || balances[1].Balance != 1000 {
+		t.Errorf("Balances() = %+v, want 1500 then 1000", balances)
+	}
+
+	latest, err = s.LatestBalance("A-12345678")
+	if err != nil || latest == nil || latest.Balance != 1000 {
+		t.Errorf("LatestBalance() = %+v, %v, want 1000", latest, err)
+	}
+}
+
+func TestDispatches(t *testing.T) {
+	s := newTestStore(t)
+
+	dispatch := func(hour int, completed bool) *octopus.Dispatch {
+		start := testStart.Add(time.Duration(hour) * time.Hour)
+		return &octopus.Dispatch{
+			AccountNumber: "A-12345678",
+			Start:         start,
+			End:           start.Add(time.Hour),
+			Energy:        7000,
+			Source:        "smart-charge",
+			Completed:     completed,
+		}
+	}
+
+	err := s.SaveDispatches([]*octopus.Dispatch{dispatch(1, true), dispatch(3, false), dispatch(5, false)})
+	if err != nil {
+		t.Fatalf("SaveDispatches: %v", err)
+	}
+
+	// The dispatch at 3 completes and the one at 5 is cancelled
+	err = s.SaveDispatches([]*octopus.Dispatch{dispatch(3, true)})
+	if err != nil {
+		t.Fatalf("SaveDispatches: %v", err)
+	}
+
+	dispatches, err := s.Dispatches("", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Dispatches: %v", err)
+	}
+	if len(dispatches) != 2 || !dispatches[0].Start.Equal(testStart.Add(time.Hour)) || !dispatches[1].Completed {
+		t.Errorf("Dispatches() = %+v, want the two completed dispatches", dispatches)
+	}
+
+	// Only dispatches overlapping the window
+	dispatches, err = s.Dispatches("A-12345678", testStart.Add(90*time.Minute), testStart.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("Dispatches: %v", err)
+	}
+	if len(dispatches) != 1 || dispatches[0].Energy != 7000 {
+		t.Errorf("Dispatches() = %+v, want the dispatch at 1", dispatches)
+	}
+
+	dispatches, err = s.Dispatches("A-87654321", time.Time{}, time.Time{})
+	if err != nil || len(dispatches) != 0 {
+		t.Errorf("Dispatches() of another account = %+v, %v, want none", dispatches, err)
+	}
+}
+
+func TestFlexibilityEvents(t *testing.T) {
+	s := newTestStore(t)
+
+	points := 540
+	session := &octopus.FlexibilityEvent{
+		AccountNumber: "A-12345678",
+		Type:          octopus.EventSavingSession,
+		Code:          "EVENT_1",
+		Start:         testStart.Add(17 * time.Hour),
+		End:           testStart.Add(18 * time.Hour),
+		RewardPerKwh:  1800,
+		Joined:        true,
+	}
+	free := &octopus.FlexibilityEvent{
+		AccountNumber: "A-12345678",
+		Type:          octopus.EventFreeElectricity,
+		Code:          "FREE_1",
+		Start:         testStart.Add(13 * time.Hour),
+		End:           testStart.Add(14 * time.Hour),
+		Joined:        true,
+	}
+
+	err := s.SaveFlexibilityEvents([]*octopus.FlexibilityEvent{session, free})
+	if err != nil {
+		t.Fatalf("SaveFlexibilityEvents: %v", err)
+	}
+
+	err = s.SaveEventResult(session, &EventResult{
+		EvaluatedAt: testStart.Add(19 * time.Hour),
+		Baseline:    1500,
+		Actual:      1200,
+		Saved:       300,
+		Points:      540,
+		Savings:     67.5,
+	})
+	if err != nil {
+		t.Fatalf("SaveEventResult: %v", err)
+	}
+
+	// Saving again, once points are awarded, keeps the result
+	session.PointsAwarded = &points
+	err = s.SaveFlexibilityEvents([]*octopus.FlexibilityEvent{session})
+	if err != nil {
+		t.Fatalf("SaveFlexibilityEvents: %v", err)
+	}
+
+	records, err := s.FlexibilityEvents("", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("FlexibilityEvents: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("FlexibilityEvents() returned %v events, want 2", len(records))
+	}
+	if records[0].Event.Code != "FREE_1" || records[0].Result != nil {
+		t.Errorf("FlexibilityEvents()[0] = %+v, want the free session without a result", records[0])
+	}
+
+	r := records[1]
+	if r.Event.PointsAwarded == nil || *r.Event.PointsAwarded != 540 || r.Event.RewardPerKwh != 1800 || !r.Event.Joined {
+		t.Errorf("FlexibilityEvents()[1].Event = %+v, want the joined session with 540 points awarded", r.Event)
+	}
+	if r.Result == nil || r.Result.Saved != 300 || r.Result.Savings != 67.5 || !r.Result.EvaluatedAt.Equal(testStart.Add(19*time.Hour)) {
+		t.Errorf("FlexibilityEvents()[1].Result = %+v, want 300Wh saved", r.Result)
+	}
+
+	// Only events overlapping the window
+	records, err = s.FlexibilityEvents("A-12345678", testStart.Add(14*time.Hour), testStart.Add(17*time.Hour))
+	if err != nil || len(records) != 0 {
+		t.Errorf("FlexibilityEvents() between the events = %+v, %v, want none", records, err)
+	}
+}
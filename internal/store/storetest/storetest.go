@@ -0,0 +1,69 @@
+// This package provides fixtures for testing packages that read from the
+// store: a fresh store in a temporary directory, and synthetic readings to
+// fill it with.
+package storetest
+
+import (
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"math"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// How often the readings added by [AddReadings] are taken.
+const ReadingInterval = 10 * time.Second
+
+// Returns the migrations directory at the root of the module.
+func migrationsDir(t testing.TB) string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatalf("Failed to find the migrations directory")
+	}
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "migrations")
+}
+
+// Opens a fresh store in a temporary directory, which is closed when the
+// test ends.
+func Open(t testing.TB) *store.Store {
+	t.Helper()
+
+	s, err := store.Open(filepath.Join(t.TempDir(), "test.sqlite"), migrationsDir(t))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Adds readings of the given meter every [ReadingInterval] from start up to
+// and including start + d, each using wh more than the last, at the steady
+// demand that this works out to. The first reading is 0.
+func AddReadings(t testing.TB, s *store.Store, meterId string, start time.Time, d time.Duration, wh int) {
+	t.Helper()
+
+	demand := wh * int(time.Hour/ReadingInterval)
+
+	rs := []*octopus.ConsumptionReading{}
+	for i := range int(d/ReadingInterval) + 1 {
+		rs = append(rs, &octopus.ConsumptionReading{
+			Timestamp:        start.Add(time.Duration(i) * ReadingInterval),
+			MeterId:          meterId,
+			TotalConsumption: wh * i,
+			Demand:           demand,
+		})
+	}
+
+	err := s.InsertReadings(rs)
+	if err != nil {
+		t.Fatalf("InsertReadings: %v", err)
+	}
+}
+
+// Checks if two amounts are equal to within rounding errors.
+func ApproxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
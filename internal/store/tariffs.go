@@ -0,0 +1,190 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"time"
+)
+
+// Saves the given agreements and their unit rates, replacing any stored
+// details of the same agreements. Rates that are no longer returned by the
+// API, such as past half-hourly rates, are kept.
+func (s *Store) SaveAgreements(agreements []*octopus.Agreement) error {
+	if len(agreements) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SaveAgreements: %v", err)
+	}
+	defer tx.Rollback()
+
+	rateCount := 0
+
+	for _, a := range agreements {
+		_, err = tx.Exec(`
+			INSERT INTO tariffs (
+				agreement_id, account_number, fuel, meter_point, valid_from, valid_to,
+				tariff_code, product_code, display_name, standing_charge, pre_vat_standing_charge
+			)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (agreement_id) DO UPDATE SET
+				account_number = excluded.account_number,
+				fuel = excluded.fuel,
+				meter_point = excluded.meter_point,
+				valid_from = excluded.valid_from,
+				valid_to = excluded.valid_to,
+				tariff_code = excluded.tariff_code,
+				product_code = excluded.product_code,
+				display_name = excluded.display_name,
+				standing_charge = excluded.standing_charge,
+				pre_vat_standing_charge = excluded.pre_vat_standing_charge
+		`,
+			a.Id, a.AccountNumber, a.Fuel, a.MeterPoint,
+			formatTimestamp(a.ValidFrom), formatNullTimestamp(a.ValidTo),
+			a.TariffCode, a.ProductCode, a.DisplayName,
+			a.StandingCharge, a.PreVatStandingCharge)
+		if err != nil {
+			return fmt.Errorf("SaveAgreements: %v", err)
+		}
+
+		for _, r := range a.UnitRates {
+			_, err = tx.Exec(`
+				INSERT INTO rates (agreement_id, band, valid_from, valid_to, value, pre_vat_value)
+				VALUES (?, ?, ?, ?, ?, ?)
+				ON CONFLICT (agreement_id, band, valid_from) DO UPDATE SET
+					valid_to = excluded.valid_to,
+					value = excluded.value,
+					pre_vat_value = excluded.pre_vat_value
+			`,
+				a.Id, r.Band, formatTimestamp(r.ValidFrom), formatNullTimestamp(r.ValidTo),
+				r.Value, r.PreVatValue)
+			if err != nil {
+				return fmt.Errorf("SaveAgreements: %v", err)
+			}
+		}
+		rateCount += len(a.UnitRates)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("SaveAgreements: %v", err)
+	}
+
+	log.Printf("Saved %v agreements with %v unit rates into DB", len(agreements), rateCount)
+	return nil
+}
+
+// Returns the stored agreements for the given meter point (MPAN or MPRN),
+// or for every meter point if it is empty, with their unit rates. Agreements
+// are ordered by when they start, and their rates by band and then when
+// they start.
+func (s *Store) Agreements(meterPoint string) ([]*octopus.Agreement, error) {
+	rows, err := s.db.Query(`
+		SELECT agreement_id, account_number, fuel, meter_point, valid_from, valid_to,
+			tariff_code, product_code, display_name, standing_charge, pre_vat_standing_charge
+		FROM tariffs
+		WHERE ?1 = '' OR meter_point = ?1
+		ORDER BY valid_from, agreement_id
+	`, meterPoint)
+	if err != nil {
+		return nil, fmt.Errorf("Agreements: %v", err)
+	}
+	defer rows.Close()
+
+	agreements := []*octopus.Agreement{}
+	byId := map[int]*octopus.Agreement{}
+
+	for rows.Next() {
+		a := &octopus.Agreement{UnitRates: []octopus.Rate{}}
+		var validFrom string
+		var validTo sql.NullString
+
+		err = rows.Scan(
+			&a.Id, &a.AccountNumber, &a.Fuel, &a.MeterPoint, &validFrom, &validTo,
+			&a.TariffCode, &a.ProductCode, &a.DisplayName,
+			&a.StandingCharge, &a.PreVatStandingCharge)
+		if err != nil {
+			return nil, fmt.Errorf("Agreements: %v", err)
+		}
+
+		a.ValidFrom, err = time.Parse(time.RFC3339, validFrom)
+		if err != nil {
+			return nil, fmt.Errorf("Agreements: %v", err)
+		}
+		a.ValidTo, err = parseNullTimestamp(validTo)
+		if err != nil {
+			return nil, fmt.Errorf("Agreements: %v", err)
+		}
+
+		agreements = append(agreements, a)
+		byId[a.Id] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Agreements: %v", err)
+	}
+
+	rateRows, err := s.db.Query(`
+		SELECT rates.agreement_id, band, rates.valid_from, rates.valid_to, value, pre_vat_value
+		FROM rates JOIN tariffs USING (agreement_id)
+		WHERE ?1 = '' OR meter_point = ?1
+		ORDER BY rates.agreement_id, band, rates.valid_from
+	`, meterPoint)
+	if err != nil {
+		return nil, fmt.Errorf("Agreements: %v", err)
+	}
+	defer rateRows.Close()
+
+	for rateRows.Next() {
+		var agreementId int
+		var r octopus.Rate
+		var validFrom string
+		var validTo sql.NullString
+
+		err = rateRows.Scan(&agreementId, &r.Band, &validFrom, &validTo, &r.Value, &r.PreVatValue)
+		if err != nil {
+			return nil, fmt.Errorf("Agreements: %v", err)
+		}
+
+		r.ValidFrom, err = time.Parse(time.RFC3339, validFrom)
+		if err != nil {
+			return nil, fmt.Errorf("Agreements: %v", err)
+		}
+		r.ValidTo, err = parseNullTimestamp(validTo)
+		if err != nil {
+			return nil, fmt.Errorf("Agreements: %v", err)
+		}
+
+		if a, ok := byId[agreementId]; ok {
+			a.UnitRates = append(a.UnitRates, r)
+		}
+	}
+	if err := rateRows.Err(); err != nil {
+		return nil, fmt.Errorf("Agreements: %v", err)
+	}
+
+	return agreements, nil
+}
+
+// Formats an optional timestamp for storage, as NULL if it is nil.
+func formatNullTimestamp(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return formatTimestamp(*t)
+}
+
+// Parses an optional stored timestamp, returning nil if it is NULL.
+func parseNullTimestamp(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -1,13 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
+	"martin-walls/octopus-energy-tracker/internal/api"
+	"martin-walls/octopus-energy-tracker/internal/backfill"
+	"martin-walls/octopus-energy-tracker/internal/billing"
 	"martin-walls/octopus-energy-tracker/internal/broadcaster"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/flexibility"
+	"martin-walls/octopus-energy-tracker/internal/flow"
 	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/reconcile"
+	"martin-walls/octopus-energy-tracker/internal/recorder"
 	"martin-walls/octopus-energy-tracker/internal/store"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -16,7 +31,7 @@ import (
 )
 
 type WebsocketHandler struct {
-	broadcaster *broadcaster.Broadcaster[*octopus.ConsumptionReading]
+	broadcaster *broadcaster.Broadcaster[*api.LiveMessage]
 }
 
 func (wsHandler *WebsocketHandler) handle(w http.ResponseWriter, r *http.Request) {
@@ -32,7 +47,7 @@ func (wsHandler *WebsocketHandler) handle(w http.ResponseWriter, r *http.Request
 	// Handle this as a write-only websocket
 	ctx := c.CloseRead(r.Context())
 
-	readings := wsHandler.broadcaster.Subscribe()
+	messages := wsHandler.broadcaster.Subscribe()
 
 	for {
 		select {
@@ -40,14 +55,14 @@ func (wsHandler *WebsocketHandler) handle(w http.ResponseWriter, r *http.Request
 			c.Close(websocket.StatusNormalClosure, "")
 			log.Println("Closing websocket")
 			return
-		case reading := <-readings:
-			readingJson, err := json.Marshal(reading)
+		case message := <-messages:
+			messageJson, err := json.Marshal(message)
 			if err != nil {
-				log.Printf("Failed to JSON encode consumption reading: %v", reading)
+				log.Printf("Failed to JSON encode live message: %v", message)
 				continue
 			}
 
-			err = c.Write(ctx, websocket.MessageText, readingJson)
+			err = c.Write(ctx, websocket.MessageText, messageJson)
 
 			if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
 				log.Println("Closing websocket")
@@ -60,82 +75,720 @@ func (wsHandler *WebsocketHandler) handle(w http.ResponseWriter, r *http.Request
 	}
 }
 
-func pollLiveConsumption(b *broadcaster.Broadcaster[*octopus.ConsumptionReading]) {
-	octo := octopus.Octopus{}
+// How often the live consumption is polled.
+const pollInterval = 30 * time.Second
 
+// Gaps in the stored readings longer than this are backfilled. This allows
+// for a few missed polls before we consider readings to be missing.
+const minGap = 4 * pollInterval
+
+// How often to look for gaps in the stored readings.
+const healInterval = time.Hour
+
+// How often gas consumption is polled. Gas meters only report every half
+// hour.
+const gasPollInterval = 30 * time.Minute
+
+// How far back gas consumption is fetched on startup, to catch up on any
+// readings missed while the tracker wasn't running.
+const gasCatchUp = 7 * 24 * time.Hour
+
+// How often tariffs and unit rates are refreshed. Agile rates for the next
+// day are published each afternoon.
+const tariffPollInterval = 6 * time.Hour
+
+// How often settled consumption is fetched. The industry settles each day's
+// half hours a day or so later.
+const settledPollInterval = 12 * time.Hour
+
+// How far back settled consumption is fetched for a meter with none stored.
+const settledCatchUp = 30 * 24 * time.Hour
+
+// How often Intelligent Octopus dispatches are fetched. Dispatches are
+// planned at short notice, and Kraken only keeps recently completed ones.
+const dispatchPollInterval = 10 * time.Minute
+
+// How often the balance, bills and payments of the accounts are fetched.
+const billingPollInterval = 6 * time.Hour
+
+// How often Saving Sessions and free electricity sessions are fetched, and
+// ended events evaluated. Saving Sessions are announced a day or so ahead.
+const eventPollInterval = 30 * time.Minute
+
+// Creates an Octopus client that caches its credentials and account details
+// in the store, encrypted if OCTOPUS_CACHE_PASSPHRASE is set.
+func newOctopus(s *store.Store) *octopus.Octopus {
+	cache := s.StateCache(os.Getenv("OCTOPUS_CACHE_PASSPHRASE"))
+	opts := []octopus.Option{octopus.WithStateCache(cache)}
+
+	if cv := os.Getenv("OCTOPUS_GAS_CALORIFIC_VALUE"); cv != "" {
+		calorificValue, err := strconv.ParseFloat(cv, 64)
+		if err != nil || calorificValue <= 0 {
+			log.Fatalf("Invalid OCTOPUS_GAS_CALORIFIC_VALUE %q: expected a number of MJ/m³, e.g. 39.5", cv)
+		}
+		opts = append(opts, octopus.WithCalorificValue(calorificValue))
+	}
+
+	return octopus.New(opts...)
+}
+
+// Sends live readings, and the figures derived from them, to websocket
+// clients.
+type livePublisher struct {
+	b      *broadcaster.Broadcaster[*api.LiveMessage]
+	flow   *flow.Tracker
+	cost   *cost.Tracker
+	events *flexibility.Tracker
+}
+
+func (p *livePublisher) publish(reading *octopus.ConsumptionReading) {
+	p.b.Publish(&api.LiveMessage{
+		Type:    api.LiveMessageReading,
+		Reading: reading,
+	})
+
+	if netFlow := p.flow.Update(reading); netFlow != nil {
+		p.b.Publish(&api.LiveMessage{
+			Type:    api.LiveMessageNetFlow,
+			NetFlow: netFlow,
+		})
+	}
+
+	if liveCost := p.cost.Update(reading); liveCost != nil {
+		p.b.Publish(&api.LiveMessage{
+			Type: api.LiveMessageCost,
+			Cost: liveCost,
+		})
+	}
+
+	progress, err := p.events.Update(reading)
+	if err != nil {
+		log.Println("Failed to follow flexibility event:", err)
+	} else if progress != nil {
+		p.b.Publish(&api.LiveMessage{
+			Type:  api.LiveMessageEvent,
+			Event: progress,
+		})
+	}
+}
+
+// Returns the export rate from OCTOPUS_EXPORT_RATE, in pence per kWh, or
+// zero if it isn't set.
+func exportRate() float64 {
+	rate := os.Getenv("OCTOPUS_EXPORT_RATE")
+	if rate == "" {
+		return 0
+	}
+
+	exportRate, err := strconv.ParseFloat(rate, 64)
+	if err != nil || exportRate < 0 {
+		log.Fatalf("Invalid OCTOPUS_EXPORT_RATE %q: expected a number of pence per kWh, e.g. 15", rate)
+	}
+	return exportRate
+}
+
+// Returns the options for applying tariffs from OCTOPUS_NIGHT_HOURS,
+// OCTOPUS_OFF_PEAK_HOURS and OCTOPUS_DAILY_CAP.
+func tariffOptions() []cost.Option {
+	opts := []cost.Option{}
+
+	if h := os.Getenv("OCTOPUS_NIGHT_HOURS"); h != "" {
+		hours, err := cost.ParseHours(h)
+		if err != nil {
+			log.Fatalln("Invalid OCTOPUS_NIGHT_HOURS:", err)
+		}
+		opts = append(opts, cost.WithNightHours(hours))
+	}
+
+	if h := os.Getenv("OCTOPUS_OFF_PEAK_HOURS"); h != "" {
+		hours, err := cost.ParseHours(h)
+		if err != nil {
+			log.Fatalln("Invalid OCTOPUS_OFF_PEAK_HOURS:", err)
+		}
+		opts = append(opts, cost.WithOffPeakHours(hours))
+	}
+
+	if c := os.Getenv("OCTOPUS_DAILY_CAP"); c != "" {
+		dailyCap, err := strconv.ParseFloat(c, 64)
+		if err != nil || dailyCap < 0 {
+			log.Fatalf("Invalid OCTOPUS_DAILY_CAP %q: expected a number of pence, e.g. 500", c)
+		}
+		opts = append(opts, cost.WithDailyCap(dailyCap))
+	}
+
+	return opts
+}
+
+// Gives the cost tracker the stored tariffs and dispatches, recalculating
+// today's cost so far.
+func loadTariffs(s *store.Store, tracker *cost.Tracker, opts []cost.Option) {
+	tariffs, err := cost.StoredTariffs(s, opts...)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	err = tracker.SetTariffs(tariffs, time.Now())
+	if err != nil {
+		log.Println("Failed to calculate today's cost:", err)
+	}
+}
+
+// Finds the smart meters on the accounts, retrying transient failures
+// until ctx is cancelled. Returns nil if ctx is cancelled first.
+func discoverDevices(ctx context.Context, octo *octopus.Octopus) []octopus.Device {
 	for {
-		reading, err := octo.LiveConsumption()
+		devices, err := octo.Devices(ctx)
+		if ctx.Err() != nil {
+			// Shutting down
+			return nil
+		}
+
+		switch {
+		case err == nil:
+			for _, device := range devices {
+				log.Printf("Found %v meter %v on account %v", device.Meter, device.Id, device.AccountNumber)
+			}
+			return devices
+		case errors.Is(err, octopus.ErrNotConfigured),
+			errors.Is(err, octopus.ErrInvalidApiKey),
+			errors.Is(err, octopus.ErrAccountNotFound),
+			errors.Is(err, octopus.ErrMeterNotFound):
+			// Trying again won't help until the configuration is fixed
+			log.Fatalln("Check your Octopus configuration:", err)
+		default:
+			log.Println("Failed to find smart meters:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Labels any readings stored before readings had meter IDs as being from
+// the first meter of their kind, which is the one that was tracked then.
+func claimUnlabelledReadings(s *store.Store, devices []octopus.Device) {
+	claimed := map[octopus.Meter]bool{}
+
+	for _, device := range devices {
+		if claimed[device.Meter] {
+			continue
+		}
+		claimed[device.Meter] = true
+
+		err := s.ClaimUnlabelledReadings(device)
 		if err != nil {
-			if errors.Is(err, octopus.ErrSkippingRequest) || errors.Is(err, octopus.ErrTooManyRequests) {
+			log.Println("Failed to label existing readings:", err)
+		}
+	}
+}
+
+// Polls the live readings of an electricity meter until ctx is cancelled,
+// recording and publishing each reading. Polling stops if the meter can't
+// be found.
+func pollLiveReadings(ctx context.Context, octo *octopus.Octopus, device octopus.Device, pub *livePublisher, rec *recorder.Recorder) {
+	for {
+		reading, err := octo.LiveReading(ctx, device)
+		if ctx.Err() != nil {
+			// Shutting down
+			return
+		}
+
+		if err != nil {
+			switch {
+			case octopus.IsTemporary(err):
+				// The client backs off by itself; keep polling
 				log.Println(err)
+			case errors.Is(err, octopus.ErrMeterNotFound):
+				log.Printf("Meter %v not found; not polling it: %v", device.Id, err)
+				return
+			case errors.Is(err, octopus.ErrNotConfigured),
+				errors.Is(err, octopus.ErrInvalidApiKey):
+				// Polling again won't help until the configuration is fixed
+				log.Fatalln("Check your Octopus configuration:", err)
+			default:
+				log.Printf("Failed to get live reading from meter %v: %v", device.Id, err)
+			}
+		} else {
+			if device.Meter == octopus.ElectricityExport {
+				log.Printf("Meter %v exporting %vW", device.Id, reading.Demand)
+			} else {
+				log.Printf("Meter %v using %vW", device.Id, reading.Demand)
+			}
+			rec.Record(reading)
+			pub.publish(reading)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Polls the half-hourly consumption of a gas meter until ctx is cancelled,
+// recording and publishing each new reading. Polling stops if the meter
+// can't be found.
+func pollGasConsumption(ctx context.Context, octo *octopus.Octopus, device octopus.Device, pub *livePublisher, rec *recorder.Recorder) {
+	since := time.Now().Add(-gasCatchUp)
+
+	for {
+		readings, err := octo.GasConsumption(ctx, device, since, time.Now())
+		if ctx.Err() != nil {
+			// Shutting down
+			return
+		}
+
+		if errors.Is(err, octopus.ErrMeterNotFound) {
+			log.Printf("Gas meter %v not found; not polling it: %v", device.Id, err)
+			return
+		}
+		if err != nil {
+			// Keep any readings that were fetched before the failure
+			log.Printf("Failed to get consumption of gas meter %v: %v", device.Id, err)
+		}
+
+		for _, reading := range readings {
+			if !reading.Timestamp.After(since) {
+				continue
+			}
+			rec.Record(reading)
+			pub.publish(reading)
+			since = reading.Timestamp
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(gasPollInterval):
+		}
+	}
+}
+
+// Fetches the settled half-hourly consumption of a meter from the REST API
+// and saves it to the store, picking up after the latest stored interval,
+// then again every settledPollInterval until ctx is cancelled. Polling
+// stops if the meter can't be found.
+func pollSettledConsumption(ctx context.Context, octo *octopus.Octopus, s *store.Store, device octopus.Device) {
+	for {
+		since, err := s.SettledUntil(device)
+		if err != nil {
+			log.Println("Failed to get latest settled consumption:", err)
+		}
+		if since.IsZero() {
+			since = time.Now().Add(-settledCatchUp)
+		}
+
+		intervals, err := octo.SettledConsumption(ctx, device, since, time.Now(), octopus.GroupByHalfHour)
+		if ctx.Err() != nil {
+			// Shutting down
+			return
+		}
+
+		if errors.Is(err, octopus.ErrMeterNotFound) {
+			log.Printf("No settled consumption for meter %v; not polling it: %v", device.Id, err)
+			return
+		}
+		if err != nil {
+			// Keep any intervals that were fetched before the failure
+			log.Printf("Failed to get settled consumption of meter %v: %v", device.Id, err)
+		}
+
+		err = s.SaveSettledConsumption(intervals)
+		if err != nil {
+			log.Println("Failed to save settled consumption:", err)
+		} else if len(intervals) > 0 {
+			reconcileSettled(s, device, intervals[0].IntervalStart, intervals[len(intervals)-1].IntervalEnd)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(settledPollInterval):
+		}
+	}
+}
+
+// Reconciles the telemetry of a meter with its newly settled consumption
+// between from and to, logging any days that are flagged.
+func reconcileSettled(s *store.Store, device octopus.Device, from, to time.Time) {
+	slots, err := reconcile.Reconcile(s, device, from, to)
+	if err != nil {
+		log.Printf("Failed to reconcile meter %v: %v", device.Id, err)
+		return
+	}
+
+	for _, day := range reconcile.Days(slots, time.Local) {
+		if day.Flagged {
+			log.Printf("Telemetry of meter %v on %v differs from settled consumption by %.1f%% (%v of %v half hours flagged)",
+				device.Id, day.Date, day.Drift, day.FlaggedSlots, day.Slots)
+		}
+	}
+}
+
+// Fetches the tariffs and unit rates of the accounts' agreements and saves
+// them to the store, then again every tariffPollInterval until ctx is
+// cancelled. onSaved is called after each save.
+func pollTariffs(ctx context.Context, octo *octopus.Octopus, s *store.Store, onSaved func()) {
+	for {
+		agreements, err := octo.Agreements(ctx)
+		if ctx.Err() != nil {
+			// Shutting down
+			return
+		}
+
+		if err != nil {
+			log.Println("Failed to get tariffs:", err)
+		} else {
+			for _, a := range agreements {
+				if a.ActiveAt(time.Now()) {
+					log.Printf("Meter point %v is on tariff %v (%v)", a.MeterPoint, a.TariffCode, a.DisplayName)
+				}
+			}
+
+			err = s.SaveAgreements(agreements)
+			if err != nil {
+				log.Println("Failed to save tariffs:", err)
 			} else {
-				log.Fatalln(err)
+				onSaved()
 			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tariffPollInterval):
+		}
+	}
+}
+
+// Fetches the planned and completed Intelligent Octopus dispatches of the
+// accounts and saves them to the store, then again every
+// dispatchPollInterval until ctx is cancelled. onSaved is called after each
+// save. Polling stops if the accounts have no smart-charging device.
+func pollDispatches(ctx context.Context, octo *octopus.Octopus, s *store.Store, onSaved func()) {
+	for {
+		dispatches, err := octo.Dispatches(ctx)
+		if ctx.Err() != nil {
+			// Shutting down
+			return
+		}
+
+		if errors.Is(err, octopus.ErrMeterNotFound) {
+			log.Println("No smart-charging device; not polling dispatches:", err)
+			return
+		}
+		if err != nil {
+			log.Println("Failed to get dispatches:", err)
 		} else {
-			log.Printf("Using %vW", reading.Demand)
-			b.Publish(reading)
+			err = s.SaveDispatches(dispatches)
+			if err != nil {
+				log.Println("Failed to save dispatches:", err)
+			} else {
+				onSaved()
+			}
 		}
 
-		time.Sleep(30 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dispatchPollInterval):
+		}
 	}
 }
 
-func main() {
-	s := store.NewStore()
-	defer s.Close()
+// Fetches the balance, bills and payments of the accounts and saves them to
+// the store, then again every billingPollInterval until ctx is cancelled.
+// After each fetch, the balance of each account at its next bill is
+// projected, and an alert is logged and sent to websocket clients when the
+// projection goes negative.
+func pollBilling(ctx context.Context, octo *octopus.Octopus, s *store.Store, devices []octopus.Device, opts []cost.Option, b *broadcaster.Broadcaster[*api.LiveMessage]) {
+	alerts := billing.NewAlerts()
 
-	err := s.InsertReadings([]*octopus.ConsumptionReading{
-		{
-			Timestamp:        time.Now().Add(time.Minute * -1),
-			TotalConsumption: 5,
-			Demand:           48,
-		},
-		{
-			Timestamp:        time.Now(),
-			TotalConsumption: 7,
-			Demand:           56,
-		},
-	})
+	for {
+		billings, err := octo.Billing(ctx)
+		if ctx.Err() != nil {
+			// Shutting down
+			return
+		}
+
+		if err != nil {
+			log.Println("Failed to get billing:", err)
+		} else {
+			now := time.Now()
+			for _, accountBilling := range billings {
+				err = s.SaveBilling(accountBilling, now)
+				if err != nil {
+					log.Println("Failed to save billing:", err)
+				}
+			}
+
+			alertProjectedBalances(s, devices, opts, billings, alerts, b, now)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(billingPollInterval):
+		}
+	}
+}
+
+// Projects the balance of each of the given accounts at its next bill, and
+// alerts any whose projection has gone negative.
+func alertProjectedBalances(s *store.Store, devices []octopus.Device, opts []cost.Option, billings []*octopus.Billing, alerts *billing.Alerts, b *broadcaster.Broadcaster[*api.LiveMessage], now time.Time) {
+	tariffs, err := cost.StoredTariffs(s, opts...)
 	if err != nil {
-		log.Fatal("InsertReadings: ", err)
+		log.Println("Failed to project balances:", err)
+		return
 	}
 
-	readings, err := s.Readings()
+	for _, accountBilling := range billings {
+		projection, err := billing.Project(s, tariffs, devices, accountBilling.AccountNumber, now)
+		if errors.Is(err, billing.ErrNoHistory) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to project balance of account %v: %v", accountBilling.AccountNumber, err)
+			continue
+		}
+
+		if alerts.Check(projection) {
+			log.Printf("ALERT: account %v is projected to be £%.2f in debt at its next bill on %v",
+				projection.AccountNumber, -projection.ProjectedBalance/100, projection.NextBill.Local().Format(time.DateOnly))
+			b.Publish(&api.LiveMessage{
+				Type:    api.LiveMessageBalanceAlert,
+				Balance: projection,
+			})
+		}
+	}
+}
+
+// Fetches the Saving Sessions and free electricity sessions of the accounts
+// and saves them to the store, then again every eventPollInterval until ctx
+// is cancelled. The joined events are followed live by tracker, and each
+// event that has ended is evaluated against its baseline.
+func pollFlexibilityEvents(ctx context.Context, octo *octopus.Octopus, s *store.Store, devices []octopus.Device, opts []cost.Option, tracker *flexibility.Tracker) {
+	for {
+		events, err := octo.FlexibilityEvents(ctx)
+		if ctx.Err() != nil {
+			// Shutting down
+			return
+		}
+
+		if err != nil {
+			log.Println("Failed to get flexibility events:", err)
+		} else {
+			err = s.SaveFlexibilityEvents(events)
+			if err != nil {
+				log.Println("Failed to save flexibility events:", err)
+			}
+			tracker.SetEvents(events)
+		}
+
+		evaluateFlexibilityEvents(s, devices, opts, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventPollInterval):
+		}
+	}
+}
+
+// Evaluates the flexibility events that have ended, logging what each
+// earned.
+func evaluateFlexibilityEvents(s *store.Store, devices []octopus.Device, opts []cost.Option, now time.Time) {
+	tariffs, err := cost.StoredTariffs(s, opts...)
 	if err != nil {
-		log.Fatal("Readings: ", err)
+		log.Println("Failed to evaluate flexibility events:", err)
+		return
 	}
 
-	for _, r := range readings {
-		log.Printf("%v: %vW", r.Timestamp, r.Demand)
+	results, err := flexibility.EvaluateEnded(s, tariffs, devices, now)
+	if err != nil {
+		log.Println("Failed to evaluate flexibility events:", err)
+		return
 	}
 
-	return
+	for _, r := range results {
+		log.Printf("Event %v on account %v: used %.2fkWh against a baseline of %.2fkWh, earning %v points worth £%.2f",
+			r.Event.Code, r.Event.AccountNumber, float64(r.Actual)/1000, float64(r.Baseline)/1000, r.Points, r.Savings/100)
+	}
+}
 
-	/////
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+		case "backfill":
+			runBackfill(os.Args[2:])
+			return
+		case "setup":
+			runSetup(os.Args[2:])
+			return
+		case "plan":
+			runPlan(os.Args[2:])
+			return
+		case "simulate":
+			runSimulate(os.Args[2:])
+			return
+		case "reconcile":
+			runReconcile(os.Args[2:])
+			return
+		default:
+			log.Fatalf("Unknown command %q. Available commands: serve, backfill, setup, plan, simulate, reconcile", os.Args[1])
+		}
+	}
 
-	b := broadcaster.NewBroadcaster[*octopus.ConsumptionReading]()
+	serve()
+}
+
+// Runs the web server and live poller until interrupted.
+func serve() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := store.NewStore()
+	defer s.Close()
+
+	rec := recorder.NewRecorder(s)
+
+	go rec.Start()
+	defer rec.Stop()
+
+	b := broadcaster.NewBroadcaster[*api.LiveMessage]()
 
 	go b.Start()
 	defer b.Stop()
 
-	go pollLiveConsumption(b)
+	exportRate := exportRate()
+	tariffOptions := tariffOptions()
+
+	// Wait for the background workers to finish before the recorder and
+	// store are closed by the deferred calls above.
+	var workers sync.WaitGroup
+	defer workers.Wait()
 
-	http.Handle("/", http.FileServer(http.Dir("static")))
+	// Every worker shares the one client, so that they share its token and
+	// back off together when Octopus is rate limiting or failing.
+	octo := newOctopus(s)
+
+	devices := discoverDevices(ctx, octo)
+	if devices == nil {
+		return
+	}
+	claimUnlabelledReadings(s, devices)
+
+	pub := &livePublisher{
+		b:      b,
+		flow:   flow.NewTracker(exportRate),
+		cost:   cost.NewTracker(s, devices),
+		events: flexibility.NewTracker(s, devices),
+	}
+	loadTariffs(s, pub.cost, tariffOptions)
+
+	// Each meter is polled and healed independently, so that one failing
+	// meter doesn't hold up the others
+	for _, device := range devices {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if device.Meter.Fuel == octopus.FuelGas {
+				pollGasConsumption(ctx, octo, device, pub, rec)
+			} else {
+				pollLiveReadings(ctx, octo, device, pub, rec)
+			}
+		}()
 
-	http.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			pollSettledConsumption(ctx, octo, s, device)
+		}()
+
+		// Gas readings are fetched in half-hourly batches already
+		if device.Meter.Fuel == octopus.FuelGas {
+			continue
+		}
+
+		healer := backfill.NewHealer(octo, s, device, minGap)
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			healer.Start(ctx, healInterval)
+		}()
+	}
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		pollTariffs(ctx, octo, s, func() {
+			loadTariffs(s, pub.cost, tariffOptions)
+		})
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		pollDispatches(ctx, octo, s, func() {
+			loadTariffs(s, pub.cost, tariffOptions)
+		})
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		pollBilling(ctx, octo, s, devices, tariffOptions, b)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		pollFlexibilityEvents(ctx, octo, s, devices, tariffOptions, pub.events)
+	}()
+
+	mux := http.NewServeMux()
+
+	mux.Handle("/", http.FileServer(http.Dir("static")))
+	mux.Handle("/api/", api.NewHandler(s,
+		api.WithExportRate(exportRate),
+		api.WithDevices(devices),
+		api.WithTariffOptions(tariffOptions...)))
+
+	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("foo"))
 	})
 
 	wsHandler := WebsocketHandler{
 		broadcaster: b,
 	}
-	http.HandleFunc("/ws", wsHandler.handle)
+	mux.HandleFunc("/ws", wsHandler.handle)
 
-	addr := "localhost:9090"
+	server := &http.Server{
+		Addr:    "localhost:9090",
+		Handler: mux,
+		// Cancel websocket connections when shutting down
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
 
-	log.Printf("Serving on %s\n", addr)
-	err = http.ListenAndServe(addr, nil)
-	if err != nil {
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving on %s\n", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal("ListenAndServe: ", err)
 	}
 }
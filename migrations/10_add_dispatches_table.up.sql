@@ -0,0 +1,13 @@
+-- Intelligent Octopus dispatches, in which consumption is billed at the
+-- off-peak rate. Planned dispatches are replaced each time they are fetched.
+CREATE TABLE IF NOT EXISTS dispatches (
+    account_number TEXT NOT NULL,
+    dispatch_start TEXT NOT NULL,
+    dispatch_end TEXT NOT NULL,
+    -- Wh
+    energy INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    location TEXT NOT NULL,
+    completed INTEGER NOT NULL,
+    PRIMARY KEY (account_number, dispatch_start)
+);
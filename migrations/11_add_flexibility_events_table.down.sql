@@ -0,0 +1 @@
+DROP TABLE IF EXISTS flexibility_events;
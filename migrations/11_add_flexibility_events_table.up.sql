@@ -0,0 +1,23 @@
+-- Saving Sessions and free electricity sessions of each account, with the
+-- consumption recorded during each once it has ended. Energy is in Wh, and
+-- savings in pence.
+CREATE TABLE IF NOT EXISTS flexibility_events (
+    account_number TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    code TEXT NOT NULL,
+    event_start TEXT NOT NULL,
+    event_end TEXT NOT NULL,
+    -- Octopoints per kWh below the baseline
+    reward_per_kwh INTEGER NOT NULL,
+    joined INTEGER NOT NULL,
+    -- The Octopoints awarded by Octopus, once they have been
+    points_awarded INTEGER,
+    -- The result of the event, once it has been evaluated
+    evaluated_at TEXT,
+    baseline INTEGER,
+    actual INTEGER,
+    saved INTEGER,
+    points INTEGER,
+    savings REAL,
+    PRIMARY KEY (account_number, event_type, event_start)
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS readings_by_time;
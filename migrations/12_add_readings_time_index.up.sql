@@ -0,0 +1,4 @@
+-- Queries over every meter of a kind filter by time alone, which the
+-- primary key can't serve, since the meter ID comes before the timestamp.
+-- This also orders readings the way they are returned.
+CREATE INDEX IF NOT EXISTS readings_by_time ON readings (fuel, direction, timestamp, meter_id);
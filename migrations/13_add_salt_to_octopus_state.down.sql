@@ -0,0 +1,4 @@
+-- State encrypted with a salted key can't be read without the salt, so it is
+-- dropped; it is only a cache
+DELETE FROM octopus_state WHERE salt IS NOT NULL;
+ALTER TABLE octopus_state DROP COLUMN salt;
@@ -0,0 +1,3 @@
+-- The salt that the encryption key was derived from the passphrase with.
+-- Null for state encrypted before keys were salted.
+ALTER TABLE octopus_state ADD COLUMN salt BLOB;
@@ -0,0 +1,2 @@
+-- UTC timestamps are still valid RFC3339, so there is nothing to undo
+SELECT 1;
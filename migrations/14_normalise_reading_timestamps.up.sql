@@ -0,0 +1,8 @@
+-- Readings stored before timestamps were normalised to UTC keep the offset
+-- the API gave them, e.g. +01:00 in summer, so they sort and compare wrongly
+-- as text. Where a reading of the same instant has since been stored in UTC,
+-- the update is skipped and the old copy is deleted below.
+UPDATE OR IGNORE readings
+    SET timestamp = strftime('%Y-%m-%dT%H:%M:%SZ', timestamp)
+    WHERE timestamp NOT LIKE '%Z';
+DELETE FROM readings WHERE timestamp NOT LIKE '%Z';
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS octopus_state (
+    -- There is only ever one row
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    encrypted INTEGER NOT NULL,
+    data BLOB NOT NULL
+);
@@ -0,0 +1,11 @@
+-- Gas readings are lost
+CREATE TABLE readings_without_fuel (
+    timestamp TEXT PRIMARY KEY,
+    total_consumption INTEGER NOT NULL,
+    demand INTEGER NOT NULL
+);
+INSERT INTO readings_without_fuel (timestamp, total_consumption, demand)
+    SELECT timestamp, total_consumption, demand FROM readings
+    WHERE fuel = 'electricity';
+DROP TABLE readings;
+ALTER TABLE readings_without_fuel RENAME TO readings;
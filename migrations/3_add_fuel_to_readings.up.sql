@@ -0,0 +1,12 @@
+-- SQLite can't change a primary key, so the table is rebuilt
+CREATE TABLE readings_with_fuel (
+    fuel TEXT NOT NULL DEFAULT 'electricity',
+    timestamp TEXT NOT NULL,
+    total_consumption INTEGER NOT NULL,
+    demand INTEGER NOT NULL,
+    PRIMARY KEY (fuel, timestamp)
+);
+INSERT INTO readings_with_fuel (fuel, timestamp, total_consumption, demand)
+    SELECT 'electricity', timestamp, total_consumption, demand FROM readings;
+DROP TABLE readings;
+ALTER TABLE readings_with_fuel RENAME TO readings;
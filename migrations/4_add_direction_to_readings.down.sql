@@ -0,0 +1,13 @@
+-- Export readings are lost
+CREATE TABLE readings_without_direction (
+    fuel TEXT NOT NULL DEFAULT 'electricity',
+    timestamp TEXT NOT NULL,
+    total_consumption INTEGER NOT NULL,
+    demand INTEGER NOT NULL,
+    PRIMARY KEY (fuel, timestamp)
+);
+INSERT INTO readings_without_direction (fuel, timestamp, total_consumption, demand)
+    SELECT fuel, timestamp, total_consumption, demand FROM readings
+    WHERE direction = 'import';
+DROP TABLE readings;
+ALTER TABLE readings_without_direction RENAME TO readings;
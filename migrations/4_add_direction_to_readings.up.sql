@@ -0,0 +1,13 @@
+-- SQLite can't change a primary key, so the table is rebuilt
+CREATE TABLE readings_with_direction (
+    fuel TEXT NOT NULL DEFAULT 'electricity',
+    direction TEXT NOT NULL DEFAULT 'import',
+    timestamp TEXT NOT NULL,
+    total_consumption INTEGER NOT NULL,
+    demand INTEGER NOT NULL,
+    PRIMARY KEY (fuel, direction, timestamp)
+);
+INSERT INTO readings_with_direction (fuel, direction, timestamp, total_consumption, demand)
+    SELECT fuel, 'import', timestamp, total_consumption, demand FROM readings;
+DROP TABLE readings;
+ALTER TABLE readings_with_direction RENAME TO readings;
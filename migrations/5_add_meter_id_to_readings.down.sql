@@ -0,0 +1,14 @@
+-- Only the readings of one meter of each kind are kept
+CREATE TABLE readings_without_meter_id (
+    fuel TEXT NOT NULL DEFAULT 'electricity',
+    direction TEXT NOT NULL DEFAULT 'import',
+    timestamp TEXT NOT NULL,
+    total_consumption INTEGER NOT NULL,
+    demand INTEGER NOT NULL,
+    PRIMARY KEY (fuel, direction, timestamp)
+);
+INSERT OR IGNORE INTO readings_without_meter_id (fuel, direction, timestamp, total_consumption, demand)
+    SELECT fuel, direction, timestamp, total_consumption, demand FROM readings
+    ORDER BY meter_id;
+DROP TABLE readings;
+ALTER TABLE readings_without_meter_id RENAME TO readings;
@@ -0,0 +1,16 @@
+-- SQLite can't change a primary key, so the table is rebuilt. Existing
+-- readings have no meter ID until they are claimed by a device; see
+-- Store.ClaimUnlabelledReadings.
+CREATE TABLE readings_with_meter_id (
+    fuel TEXT NOT NULL DEFAULT 'electricity',
+    direction TEXT NOT NULL DEFAULT 'import',
+    meter_id TEXT NOT NULL DEFAULT '',
+    timestamp TEXT NOT NULL,
+    total_consumption INTEGER NOT NULL,
+    demand INTEGER NOT NULL,
+    PRIMARY KEY (fuel, direction, meter_id, timestamp)
+);
+INSERT INTO readings_with_meter_id (fuel, direction, meter_id, timestamp, total_consumption, demand)
+    SELECT fuel, direction, '', timestamp, total_consumption, demand FROM readings;
+DROP TABLE readings;
+ALTER TABLE readings_with_meter_id RENAME TO readings;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS rates;
+DROP INDEX IF EXISTS tariffs_meter_point;
+DROP TABLE IF EXISTS tariffs;
@@ -0,0 +1,30 @@
+-- One row per supply agreement, with the tariff it is on
+CREATE TABLE IF NOT EXISTS tariffs (
+    agreement_id INTEGER PRIMARY KEY,
+    account_number TEXT NOT NULL,
+    fuel TEXT NOT NULL,
+    meter_point TEXT NOT NULL,
+    valid_from TEXT NOT NULL,
+    -- NULL if the agreement has no end
+    valid_to TEXT,
+    tariff_code TEXT NOT NULL,
+    product_code TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    -- Pence per day
+    standing_charge REAL NOT NULL,
+    pre_vat_standing_charge REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tariffs_meter_point ON tariffs (meter_point, valid_from);
+
+-- The unit rates of each agreement. Half-hourly tariffs have a row per slot.
+CREATE TABLE IF NOT EXISTS rates (
+    agreement_id INTEGER NOT NULL,
+    band TEXT NOT NULL,
+    valid_from TEXT NOT NULL,
+    -- NULL if the rate has no end
+    valid_to TEXT,
+    -- Pence per kWh
+    value REAL NOT NULL,
+    pre_vat_value REAL NOT NULL,
+    PRIMARY KEY (agreement_id, band, valid_from)
+);
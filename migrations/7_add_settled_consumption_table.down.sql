@@ -0,0 +1 @@
+DROP TABLE IF EXISTS settled_consumption;
@@ -0,0 +1,13 @@
+-- Half-hourly consumption as settled for billing, from the REST API. This is
+-- kept apart from the readings, which come from the meters' live telemetry.
+CREATE TABLE IF NOT EXISTS settled_consumption (
+    fuel TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    meter_point TEXT NOT NULL,
+    serial_number TEXT NOT NULL,
+    interval_start TEXT NOT NULL,
+    interval_end TEXT NOT NULL,
+    -- Wh
+    consumption INTEGER NOT NULL,
+    PRIMARY KEY (meter_point, serial_number, direction, interval_start)
+);
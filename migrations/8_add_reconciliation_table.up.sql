@@ -0,0 +1,17 @@
+-- How each meter's telemetry compares with its settled consumption, per
+-- half hour. Only half hours that have been settled are reconciled.
+CREATE TABLE IF NOT EXISTS reconciliation (
+    meter_id TEXT NOT NULL,
+    slot_start TEXT NOT NULL,
+    slot_end TEXT NOT NULL,
+    -- The number of telemetry readings in the half hour
+    readings INTEGER NOT NULL,
+    -- Wh
+    telemetry INTEGER NOT NULL,
+    settled INTEGER NOT NULL,
+    -- Telemetry minus settled, in Wh, and as a percentage of settled
+    difference INTEGER NOT NULL,
+    drift REAL NOT NULL,
+    flagged INTEGER NOT NULL,
+    PRIMARY KEY (meter_id, slot_start)
+);
@@ -0,0 +1,35 @@
+-- The bills and statements issued on each account. Amounts are in pence,
+-- and balances are positive when the account is in credit.
+CREATE TABLE IF NOT EXISTS bills (
+    account_number TEXT NOT NULL,
+    id TEXT NOT NULL,
+    bill_type TEXT NOT NULL,
+    issued_at TEXT NOT NULL,
+    -- The start of the first day billed, and the end of the last
+    period_from TEXT NOT NULL,
+    period_to TEXT NOT NULL,
+    -- Only statements have balances
+    opening_balance INTEGER,
+    closing_balance INTEGER,
+    charges INTEGER NOT NULL,
+    credits INTEGER NOT NULL,
+    PRIMARY KEY (account_number, id)
+);
+
+-- Payments made to or from each account, in pence.
+CREATE TABLE IF NOT EXISTS payments (
+    account_number TEXT NOT NULL,
+    id TEXT NOT NULL,
+    payment_date TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    PRIMARY KEY (account_number, id)
+);
+
+-- The balance of each account each time it was fetched, in pence.
+CREATE TABLE IF NOT EXISTS balances (
+    account_number TEXT NOT NULL,
+    recorded_at TEXT NOT NULL,
+    balance INTEGER NOT NULL,
+    PRIMARY KEY (account_number, recorded_at)
+);
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/cost"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/planner"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How far ahead the plan command looks for unit rates by default, and the
+// latest deadline it accepts.
+const planHorizon = 48 * time.Hour
+
+// Parses a plan deadline, which can be a time of day such as 07:00 (the
+// next time it comes round), a date or an RFC3339 timestamp.
+func parseDeadline(value string, now time.Time) time.Time {
+	clock, err := time.ParseInLocation("15:04", value, time.Local)
+	if err != nil {
+		return parseTimeFlag("before", value)
+	}
+
+	year, month, day := now.Date()
+	deadline := time.Date(year, month, day, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+	if !deadline.After(now) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
+	return deadline
+}
+
+// Runs the plan command, which finds the cheapest time to run a load
+// before a deadline from the upcoming unit rates.
+//
+//	plan (--profile washing-machine | --hours 3 [--power 7000]) [--before 07:00] [--rates rates.json] [--meter DEVICE_ID]
+func runPlan(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	profileFlag := flags.String("profile", "", "a typical appliance to plan for: "+strings.Join(profileNames(), ", "))
+	hoursFlag := flags.Float64("hours", 0, "plan for a constant load lasting this many hours, instead of a profile")
+	powerFlag := flags.Int("power", 1000, "the demand of the constant load, in W")
+	beforeFlag := flags.String("before", "", "when the load must finish (HH:MM, YYYY-MM-DD or RFC3339); defaults to the end of the known rates")
+	ratesFlag := flags.String("rates", "", "a JSON file of unit rates to plan with, instead of fetching the tariff")
+	meterFlag := flags.String("meter", "", "device ID of the electricity meter whose tariff to use; defaults to the first one")
+	flags.Parse(args)
+
+	var profile planner.Profile
+	switch {
+	case *profileFlag != "":
+		var ok bool
+		profile, ok = planner.Profiles[*profileFlag]
+		if !ok {
+			log.Fatalf("plan: unknown profile %q; choose from %v", *profileFlag, strings.Join(profileNames(), ", "))
+		}
+	case *hoursFlag > 0 && *powerFlag > 0:
+		profile = planner.FlatProfile(*powerFlag, time.Duration(*hoursFlag*float64(time.Hour)))
+	default:
+		log.Fatalln("plan: either --profile or a positive --hours is required")
+	}
+
+	now := time.Now()
+	before := now.Add(planHorizon)
+	if *beforeFlag != "" {
+		before = parseDeadline(*beforeFlag, now)
+		if before.After(now.Add(planHorizon)) {
+			log.Fatalf("plan: --before must be within the next %v", planHorizon)
+		}
+	}
+
+	var slots []planner.Slot
+	if *ratesFlag != "" {
+		rates, err := planner.LoadRates(*ratesFlag)
+		if err != nil {
+			log.Fatalln("plan:", err)
+		}
+		slots = planner.SlotsFromRates(rates, now, before)
+	} else {
+		slots = fetchSlots(*meterFlag, now, before)
+	}
+
+	plan, err := planner.NewPlan(slots, profile, now, before)
+	if errors.Is(err, planner.ErrNoWindow) {
+		log.Fatalf("plan: %v; rates are known until %v", err, ratesKnownUntil(slots))
+	}
+	if err != nil {
+		log.Fatalln("plan:", err)
+	}
+
+	fmt.Printf("Cheapest time to run %v before %v:\n", profile.Name, before.Format(time.DateTime))
+	printRun(plan.Cheapest)
+
+	if plan.Now == nil {
+		fmt.Println("The cost of running it now isn't known.")
+		return
+	}
+	fmt.Println("Running it now:")
+	printRun(*plan.Now)
+	fmt.Printf("Waiting saves %.1fp\n", plan.Saving)
+}
+
+// Fetches the tariff of the given electricity meter, or the first one, and
+// returns the unit rates of its slots between from and to.
+func fetchSlots(meterId string, from, to time.Time) []planner.Slot {
+	s := store.NewStore()
+	defer s.Close()
+
+	octo := newOctopus(s)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	devices, err := octo.Devices(ctx)
+	if err != nil {
+		log.Fatalln("plan:", err)
+	}
+
+	i := slices.IndexFunc(devices, func(d octopus.Device) bool {
+		return d.Meter == octopus.ElectricityImport && (meterId == "" || d.Id == meterId)
+	})
+	if i == -1 {
+		log.Fatalf("plan: no electricity import meter %q", meterId)
+	}
+	device := devices[i]
+
+	agreements, err := octo.Agreements(ctx)
+	if err != nil {
+		log.Fatalln("plan:", err)
+	}
+
+	// Keep the rates, since they were fetched anyway
+	err = s.SaveAgreements(agreements)
+	if err != nil {
+		log.Println("Failed to save tariffs:", err)
+	}
+
+	tariffs := cost.NewTariffs(agreements, tariffOptions()...)
+	return planner.Slots(tariffs, device.MeterPoint, from, to)
+}
+
+// Returns the end of the last slot, for error messages.
+func ratesKnownUntil(slots []planner.Slot) string {
+	if len(slots) == 0 {
+		return "never"
+	}
+	return slots[len(slots)-1].End.Local().Format(time.DateTime)
+}
+
+func printRun(run planner.Run) {
+	fmt.Printf("  %v to %v: %.2f kWh at an average of %.2fp/kWh, costing %.1fp\n",
+		run.Start.Local().Format(time.DateTime),
+		run.End.Local().Format(time.DateTime),
+		run.Energy/1000,
+		run.AverageRate,
+		run.Cost)
+}
+
+// Returns the names of the built-in profiles, sorted.
+func profileNames() []string {
+	names := []string{}
+	for name := range planner.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
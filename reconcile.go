@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/reconcile"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Runs the reconcile command, which compares the stored telemetry of each
+// meter with its stored settled consumption and reports where they differ.
+//
+//	reconcile --from 2025-01-01 [--to 2025-01-31] [--meter DEVICE_ID] [--all]
+func runReconcile(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fromFlag := flags.String("from", "", "start of the period to reconcile (YYYY-MM-DD or RFC3339)")
+	toFlag := flags.String("to", "", "end of the period to reconcile (YYYY-MM-DD or RFC3339); defaults to now")
+	meterFlag := flags.String("meter", "", "device ID of the meter to reconcile; defaults to every meter")
+	allFlag := flags.Bool("all", false, "list every day, not only the flagged ones")
+	flags.Parse(args)
+
+	if *fromFlag == "" {
+		log.Fatalln("reconcile: --from is required")
+	}
+	from := parseTimeFlag("from", *fromFlag)
+
+	to := time.Now()
+	if *toFlag != "" {
+		to = parseTimeFlag("to", *toFlag)
+	}
+
+	s := store.NewStore()
+	defer s.Close()
+
+	devices, err := newOctopus(s).Devices(context.Background())
+	if err != nil {
+		log.Fatalln("reconcile:", err)
+	}
+
+	found := false
+	for _, device := range devices {
+		if *meterFlag != "" && device.Id != *meterFlag {
+			continue
+		}
+		found = true
+
+		slots, err := reconcile.Reconcile(s, device, from, to)
+		if err != nil {
+			log.Fatalln("reconcile:", err)
+		}
+
+		fmt.Printf("%v meter %v: %v settled half hours\n", device.Meter, device.Id, len(slots))
+		printReconciliation(slots, *allFlag)
+		fmt.Println()
+	}
+
+	if !found {
+		log.Fatalf("reconcile: no meter %q found", *meterFlag)
+	}
+}
+
+// Formats an amount of energy in Wh as kWh.
+func kwh(wh int) string {
+	return fmt.Sprintf("%.3f", float64(wh)/1000)
+}
+
+func printReconciliation(slots []*reconcile.Slot, all bool) {
+	days := []*reconcile.Day{}
+	for _, day := range reconcile.Days(slots, time.Local) {
+		if day.Flagged || all {
+			days = append(days, day)
+		}
+	}
+	if len(days) == 0 {
+		fmt.Println("No days flagged")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "Date\tTelemetry kWh\tSettled kWh\tDifference kWh\tDrift\tMissing\tFlagged\t\n")
+	for _, day := range days {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%.1f%%\t%v\t%v\t\n",
+			day.Date,
+			kwh(day.Telemetry),
+			kwh(day.Settled),
+			kwh(day.Difference),
+			day.Drift,
+			day.MissingSlots,
+			day.FlaggedSlots)
+	}
+	w.Flush()
+
+	if !slices.ContainsFunc(slots, func(slot *reconcile.Slot) bool { return slot.Flagged }) {
+		return
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "Flagged half hour\tReadings\tTelemetry kWh\tSettled kWh\tDifference kWh\t\n")
+	for _, slot := range slots {
+		if !slot.Flagged {
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t\n",
+			slot.Start.Local().Format("2006-01-02 15:04"),
+			slot.Readings,
+			kwh(slot.Telemetry),
+			kwh(slot.Settled),
+			kwh(slot.Difference))
+	}
+	w.Flush()
+}
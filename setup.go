@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/octopus"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Runs the setup command, which lists the accounts visible to the API key
+// with their properties and meters, and asks which accounts to track if
+// there are several. The choice is saved in the DB.
+//
+//	setup [--list]
+func runSetup(args []string) {
+	flags := flag.NewFlagSet("setup", flag.ExitOnError)
+	listFlag := flags.Bool("list", false, "only list the accounts, without choosing which to track")
+	flags.Parse(args)
+
+	s := store.NewStore()
+	defer s.Close()
+
+	octo := newOctopus(s)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Always ask the API, in case accounts have been added since they were
+	// cached
+	accounts, err := octo.DiscoverAccounts(ctx)
+	if err != nil {
+		log.Fatalln("setup:", err)
+	}
+
+	for _, account := range accounts {
+		printAccount(ctx, octo, account)
+	}
+
+	if *listFlag {
+		return
+	}
+
+	if configured := os.Getenv("OCTOPUS_ACCOUNT_NUMBER"); configured != "" {
+		fmt.Printf("\nOCTOPUS_ACCOUNT_NUMBER is set, so %v will be tracked.\n", configured)
+		return
+	}
+
+	if len(accounts) == 1 {
+		fmt.Printf("\nAccount %v will be tracked.\n", accounts[0].Number)
+		return
+	}
+
+	chosen := promptAccounts(accounts)
+
+	err = octo.ChooseAccounts(ctx, chosen)
+	if err != nil {
+		log.Fatalln("setup:", err)
+	}
+
+	fmt.Printf("\n%v will be tracked.\n", strings.Join(chosen, ", "))
+}
+
+// Prints an account with its properties and smart meters.
+func printAccount(ctx context.Context, octo *octopus.Octopus, account octopus.Account) {
+	fmt.Printf("\nAccount %v\n", account.Number)
+
+	for _, property := range account.Properties {
+		fmt.Printf("  Property %v: %v, %v\n", property.Id, property.Address, property.Postcode)
+	}
+
+	devices, err := octo.AccountDevices(ctx, account.Number)
+	if err != nil {
+		fmt.Printf("  Failed to find smart meters: %v\n", err)
+		return
+	}
+	if len(devices) == 0 {
+		fmt.Println("  No smart meters")
+	}
+
+	for _, device := range devices {
+		fmt.Printf("  %v meter %v (meter point %v, serial number %v)\n", device.Meter, device.Id, device.MeterPoint, device.SerialNumber)
+	}
+}
+
+// Asks which of the accounts to track, until a valid answer is given.
+func promptAccounts(accounts []octopus.Account) []string {
+	accountNumbers := []string{}
+	for _, account := range accounts {
+		accountNumbers = append(accountNumbers, account.Number)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Print("\nWhich accounts should be tracked? Enter account numbers separated by commas, or nothing for all: ")
+
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalln("setup: no accounts chosen")
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return accountNumbers
+		}
+
+		chosen := []string{}
+		valid := true
+
+		for _, accountNumber := range strings.Split(line, ",") {
+			accountNumber = strings.TrimSpace(accountNumber)
+			if !slices.Contains(accountNumbers, accountNumber) {
+				fmt.Printf("Account %q is not one of %v\n", accountNumber, strings.Join(accountNumbers, ", "))
+				valid = false
+				break
+			}
+			chosen = append(chosen, accountNumber)
+		}
+
+		if valid {
+			return chosen
+		}
+	}
+}
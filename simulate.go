@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"martin-walls/octopus-energy-tracker/internal/simulator"
+	"martin-walls/octopus-energy-tracker/internal/store"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Runs the simulate command, which compares what the stored electricity
+// consumption would have cost on the tariffs in a tariffs file.
+//
+//	simulate --tariffs tariffs.json --from 2025-01-01 [--to 2025-07-01] [--meter DEVICE_ID]
+func runSimulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	tariffsFlag := flags.String("tariffs", "", "a JSON file of the tariffs to compare; the first is the baseline")
+	fromFlag := flags.String("from", "", "start of the period to simulate (YYYY-MM-DD or RFC3339)")
+	toFlag := flags.String("to", "", "end of the period to simulate (YYYY-MM-DD or RFC3339); defaults to now")
+	meterFlag := flags.String("meter", "", "device ID of the electricity meter to simulate; defaults to every import meter")
+	flags.Parse(args)
+
+	if *tariffsFlag == "" || *fromFlag == "" {
+		log.Fatalln("simulate: --tariffs and --from are required")
+	}
+	from := parseTimeFlag("from", *fromFlag)
+
+	to := time.Now()
+	if *toFlag != "" {
+		to = parseTimeFlag("to", *toFlag)
+	}
+
+	sim, err := simulator.Load(*tariffsFlag)
+	if err != nil {
+		log.Fatalln("simulate:", err)
+	}
+
+	s := store.NewStore()
+	defer s.Close()
+
+	report, err := sim.Run(s, *meterFlag, from, to)
+	if err != nil {
+		log.Fatalln("simulate:", err)
+	}
+
+	printReport(report)
+}
+
+// Formats an amount in pence as pounds.
+func pounds(pence float64) string {
+	if pence < 0 {
+		return fmt.Sprintf("-£%.2f", -pence/100)
+	}
+	return fmt.Sprintf("£%.2f", pence/100)
+}
+
+func printReport(report *simulator.Report) {
+	fmt.Printf("%.1f kWh used from %v to %v, an average of %.2f kWh over %.1f days\n\n",
+		float64(report.Consumption)/1000,
+		report.From.Local().Format(time.DateTime),
+		report.To.Local().Format(time.DateTime),
+		report.DailyConsumption(),
+		report.Days)
+
+	baseline := report.Results[0]
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Tariff\tEnergy\tStanding\tTotal\tAverage p/kWh\tvs %v\tBreak-even kWh/day\t\n", baseline.Tariff)
+	for _, result := range report.Results {
+		breakEven := "-"
+		if result.BreakEven != nil {
+			breakEven = fmt.Sprintf("%.2f", *result.BreakEven)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%.2f\t%v\t%v\t\n",
+			result.Tariff,
+			pounds(result.Energy),
+			pounds(result.StandingCharge),
+			pounds(result.Total),
+			result.AverageRate,
+			pounds(result.Difference),
+			breakEven)
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "Month\tkWh\t")
+	for _, result := range report.Results {
+		fmt.Fprintf(w, "%v\t", result.Tariff)
+	}
+	fmt.Fprintln(w)
+	for i, month := range baseline.Months {
+		fmt.Fprintf(w, "%v\t%.1f\t", month.Month, float64(month.Consumption)/1000)
+		for _, result := range report.Results {
+			fmt.Fprintf(w, "%v\t", pounds(result.Months[i].Total))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	for _, result := range report.Results {
+		if result.Unpriced > 0 {
+			fmt.Printf("\n%v has no unit rate for %.1f kWh, which is left out of its cost.", result.Tariff, float64(result.Unpriced)/1000)
+		}
+	}
+	fmt.Println()
+}
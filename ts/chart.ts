@@ -1,18 +1,42 @@
 import Chart from "chart.js/auto";
 import "chartjs-adapter-date-fns";
-import type { ConsumptionReading } from "./types/octopus";
+import type { ConsumptionReading, Device, Meter } from "./types/octopus";
+import type { DispatchesResponse, MetersResponse, ReadingsResponse } from "./types/api";
 
 const container = document.getElementById("chart") as HTMLCanvasElement;
 
-let chartData: { x: number; y: number }[] = [];
+type Point = { x: number; y: number };
+
+// One series per smart meter, keyed by device ID. Gas demand is the average
+// over each half hour.
+const chartData: Record<string, Point[]> = {};
+
+// Intelligent Octopus dispatch windows, drawn as shaded bands: 1 inside a
+// window and 0 outside.
+const dispatchData: Point[] = [];
+
+function labelOf(meter: Meter, meterId: string): string {
+  if (meter.direction === "export") {
+    return `Export (W), meter ${meterId}`;
+  }
+  if (meter.fuel === "gas") {
+    return `Gas demand (W), meter ${meterId}`;
+  }
+  return `Electricity demand (W), meter ${meterId}`;
+}
 
 const chart = new Chart(container, {
   type: "line",
   data: {
     datasets: [
       {
-        label: "Demand (W)",
-        data: chartData,
+        label: "Smart charging",
+        data: dispatchData,
+        yAxisID: "dispatch",
+        stepped: "after",
+        fill: "origin",
+        pointRadius: 0,
+        borderWidth: 0,
       },
     ],
   },
@@ -30,14 +54,108 @@ const chart = new Chart(container, {
       y: {
         beginAtZero: true,
       },
+      dispatch: {
+        display: false,
+        min: 0,
+        max: 1,
+      },
     },
   },
 });
 
+// Returns the series for the given meter, adding it to the chart if it is
+// new.
+function seriesOf(meter: Meter, meterId: string): Point[] {
+  if (!(meterId in chartData)) {
+    chartData[meterId] = [];
+    chart.data.datasets.push({
+      label: labelOf(meter, meterId),
+      data: chartData[meterId],
+      stepped: meter.fuel === "gas" ? "before" : false,
+    });
+  }
+  return chartData[meterId];
+}
+
 export function updateChart(reading: ConsumptionReading) {
-  chartData.push({
+  seriesOf(reading, reading.meterId).push({
     x: new Date(reading.timestamp).getTime(),
     y: reading.demand,
   });
   chart.update();
 }
+
+// Fill the chart with the readings from the last few hours, so that the
+// page doesn't start empty while waiting for live readings.
+export async function preloadChart(hours: number = 3) {
+  const response = await fetch("/api/meters");
+  if (!response.ok) {
+    console.log("Failed to load meters:", response.status);
+    return;
+  }
+
+  const meters: MetersResponse = await response.json();
+
+  await Promise.all([
+    ...meters.meters.map((device) =>
+      // Gas readings are already half-hourly
+      preloadSeries(device, device.meter.fuel === "gas" ? "raw" : "1m", hours),
+    ),
+    preloadDispatches(hours),
+  ]);
+  chart.update();
+}
+
+async function preloadSeries(device: Device, resolution: string, hours: number) {
+  const to = new Date();
+  const from = new Date(to.getTime() - hours * 60 * 60 * 1000);
+
+  const params = new URLSearchParams({
+    fuel: device.meter.fuel,
+    direction: device.meter.direction,
+    meter: device.id,
+    from: from.toISOString(),
+    to: to.toISOString(),
+    resolution,
+  });
+
+  const response = await fetch(`/api/readings?${params}`);
+  if (!response.ok) {
+    console.log(`Failed to load history of meter ${device.id}:`, response.status);
+    return;
+  }
+
+  const history: ReadingsResponse = await response.json();
+
+  seriesOf(device.meter, device.id).unshift(
+    ...history.readings.map((r) => ({
+      x: new Date(r.timestamp).getTime(),
+      y: r.demand,
+    })),
+  );
+}
+
+async function preloadDispatches(hours: number) {
+  const to = new Date();
+  const from = new Date(to.getTime() - hours * 60 * 60 * 1000);
+
+  const params = new URLSearchParams({
+    from: from.toISOString(),
+    to: to.toISOString(),
+  });
+
+  const response = await fetch(`/api/dispatches?${params}`);
+  if (!response.ok) {
+    console.log("Failed to load dispatches:", response.status);
+    return;
+  }
+
+  const dispatches: DispatchesResponse = await response.json();
+
+  for (const dispatch of dispatches.dispatches) {
+    dispatchData.push(
+      { x: new Date(dispatch.start).getTime(), y: 1 },
+      { x: new Date(dispatch.end).getTime(), y: 0 },
+    );
+  }
+}
@@ -1,4 +1,7 @@
-import { updateChart } from "./chart.js";
+import { preloadChart, updateChart } from "./chart.js";
 import { ws } from "./ws.js";
 
-ws(updateChart);
+// Load the history before connecting, so live readings are appended after it
+preloadChart()
+  .catch((e) => console.log("Failed to load reading history:", e))
+  .finally(() => ws(updateChart));
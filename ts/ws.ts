@@ -1,23 +1,106 @@
 import type { ConsumptionReading } from "./types/octopus";
+import type { LiveMessage } from "./types/api";
+import type { NetFlow } from "./types/flow";
+import type { LiveCost } from "./types/cost";
+import type { Projection } from "./types/billing";
+import type { Progress } from "./types/flexibility";
 
 let socket: WebSocket;
 
+function setText(id: string, text: string) {
+  const span = document.getElementById(id);
+  if (span != null) {
+    span.textContent = text;
+  }
+}
+
+// The latest demand of each meter, by device ID.
+const latestDemand: Record<string, { id: string; demand: number }> = {};
+
+// Returns the total of the latest demand of every meter shown in the given
+// element.
+function totalDemand(id: string): number {
+  return Object.values(latestDemand)
+    .filter((d) => d.id === id)
+    .reduce((total, d) => total + d.demand, 0);
+}
+
+function showReading(data: ConsumptionReading) {
+  let id = "demand-value";
+  if (data.direction === "export") {
+    id = "export-value";
+  } else if (data.fuel === "gas") {
+    id = "gas-demand-value";
+  }
+
+  console.log(`Meter ${data.meterId}: ${data.demand}W`);
+  latestDemand[data.meterId] = { id, demand: data.demand };
+  setText(id, totalDemand(id).toString());
+}
+
+function showNetFlow(netFlow: NetFlow) {
+  setText("net-value", netFlow.netDemand.toString());
+  setText("earnings-value", netFlow.exportEarningsPerHour.toFixed(1));
+}
+
+function showCost(cost: LiveCost) {
+  setText("cost-today-value", (cost.today.total / 100).toFixed(2));
+  setText("cost-per-hour-value", cost.costPerHour.toFixed(1));
+}
+
+function showBalanceAlert(balance: Projection) {
+  setText("balance-alert-value", (-balance.projectedBalance / 100).toFixed(2));
+  setText("balance-alert-date", new Date(balance.nextBill).toLocaleDateString());
+  document.getElementById("balance-alert")?.removeAttribute("hidden");
+}
+
+// Hides the event progress once the event ends.
+let eventProgressTimeout: number | undefined;
+
+function showEventProgress(progress: Progress) {
+  const name =
+    progress.event.type === "FREE_ELECTRICITY"
+      ? "Free electricity"
+      : "Saving Session";
+  setText("event-name", name);
+  setText("event-difference-value", Math.abs(progress.difference).toString());
+  setText(
+    "event-difference-direction",
+    progress.difference >= 0 ? "under" : "over",
+  );
+  setText("event-saved-value", (progress.saved / 1000).toFixed(2));
+  setText("event-points-value", progress.points.toString());
+
+  const element = document.getElementById("event-progress");
+  element?.removeAttribute("hidden");
+
+  clearTimeout(eventProgressTimeout);
+  eventProgressTimeout = setTimeout(
+    () => element?.setAttribute("hidden", ""),
+    new Date(progress.event.end).getTime() - Date.now(),
+  );
+}
+
 export async function ws(onReading: (r: ConsumptionReading) => void) {
   console.log("Connecting to websocket...");
 
   socket = new WebSocket("ws://localhost:9090/ws");
 
   socket.addEventListener("message", (e) => {
-    const data: ConsumptionReading = JSON.parse(e.data);
+    const message: LiveMessage = JSON.parse(e.data);
 
-    console.log(`Using ${data.demand}W`);
-
-    const demandSpan = document.getElementById("demand-value");
-    if (demandSpan != null) {
-      demandSpan.textContent = data.demand.toString();
+    if (message.type === "reading" && message.reading) {
+      showReading(message.reading);
+      onReading(message.reading);
+    } else if (message.type === "netFlow" && message.netFlow) {
+      showNetFlow(message.netFlow);
+    } else if (message.type === "cost" && message.cost) {
+      showCost(message.cost);
+    } else if (message.type === "balanceAlert" && message.balance) {
+      showBalanceAlert(message.balance);
+    } else if (message.type === "event" && message.event) {
+      showEventProgress(message.event);
     }
-
-    onReading(data);
   });
 
   socket.addEventListener("error", (e) => {
@@ -27,6 +110,6 @@ export async function ws(onReading: (r: ConsumptionReading) => void) {
   socket.addEventListener("close", (e) => {
     console.log("Websocket closed:", e);
     console.log("Reconnecting...");
-    setTimeout(ws, 1000);
+    setTimeout(() => ws(onReading), 1000);
   });
 }